@@ -25,6 +25,8 @@ var (
 	decrypt bool
 	useKEK  bool
 	ssCopy  bool
+	resume  bool
+	verify  bool
 	/* Put command flags */
 	encrypt            bool
 	storageClass       string
@@ -34,6 +36,10 @@ var (
 
 	offset uint64
 
+	/* Rotate keys command flags */
+	newKeyPath string
+	reencrypt  bool
+
 	segmentPort uint64
 	segmentNum  uint64
 	confirm     bool
@@ -91,7 +97,14 @@ func catFunc(con net.Conn, instanceCnf *config.Instance, args []string) error {
 func copyFunc(con net.Conn, instanceCnf *config.Instance, args []string) error {
 	ylogger.Zero.Info().Msg("Execute copy command")
 	ylogger.Zero.Info().Str("name", args[0]).Msg("copy")
-	msg := message.NewCopyMessageV2(args[0], oldCfgPath, encrypt, decrypt, confirm, useKEK, ssCopy, segmentPort).Encode()
+	cm := message.NewCopyMessageV2(args[0], oldCfgPath, encrypt, decrypt, confirm, useKEK, ssCopy, resume, verify, segmentPort)
+	cm.Settings = []settings.StorageSettings{
+		{
+			Name:  message.TableSpaceSetting,
+			Value: tableSpace,
+		},
+	}
+	msg := cm.Encode()
 	_, err := con.Write(msg)
 	if err != nil {
 		return err
@@ -102,27 +115,47 @@ func copyFunc(con net.Conn, instanceCnf *config.Instance, args []string) error {
 	client := client.NewYClient(con)
 	protoReader := pio.NewProtoReader(client)
 
-	ansType, body, err := protoReader.ReadPacket()
-	if err != nil {
-		ylogger.Zero.Error().Err(err).Msg("error while reading the answer")
-		return err
+	counts := map[message.CopyMethod]int{}
+	for done := false; !done; {
+		ansType, body, err := protoReader.ReadPacket()
+		if err != nil {
+			ylogger.Zero.Error().Err(err).Msg("error while reading the answer")
+			return err
+		}
+
+		switch ansType {
+		case message.MessageTypeError:
+			msg := &message.ErrorMessage{}
+			msg.Decode(body)
+			return fmt.Errorf("%s: \"%s\"", msg.Message, msg.Error)
+		case message.MessageTypeCopyStatus:
+			msg := &message.CopyStatusMessage{}
+			msg.Decode(body)
+			counts[msg.Method]++
+			/* stdout is reserved for key version */
+			fmt.Fprintf(os.Stderr, "%s: %s (%d bytes)\n", msg.Method, msg.Path, msg.Size)
+		case message.MessageTypeCopyMismatch:
+			msg := &message.CopyMismatchMessage{}
+			msg.Decode(body)
+			fmt.Fprintf(os.Stderr, "mismatch: %s: %s (source %d bytes %s, destination %d bytes %s) %s\n",
+				msg.Reason, msg.Path, msg.SourceSize, msg.SourceDigest, msg.DestSize, msg.DestDigest, msg.Error)
+		case message.MessageTypeCopyComplete:
+			msg := &message.CopyCompleteMessage{}
+			msg.Decode(body)
+			ylogger.Zero.Debug().Int("key-version", int(msg.KeyVersion)).Msg("got copy complete message")
+			fmt.Println(msg.KeyVersion)
+			done = true
+		default:
+			return fmt.Errorf("unexpected message %v", body)
+		}
 	}
 
-	switch ansType {
-	case message.MessageTypeError:
-		msg := &message.ErrorMessage{}
-		msg.Decode(body)
-		return fmt.Errorf("%s: \"%s\"", msg.Message, msg.Error)
-	case message.MessageTypeCopyComplete:
-		msg := &message.CopyCompleteMessage{}
-		msg.Decode(body)
-		ylogger.Zero.Debug().Int("key-version", int(msg.KeyVersion)).Msg("got copy complete message")
-		fmt.Println(msg.KeyVersion)
-	default:
-		return fmt.Errorf("unexpected message %v", body)
+	if ssCopy {
+		fmt.Fprintf(os.Stderr, "server-side: %d, streamed: %d\n",
+			counts[message.CopyMethodServerSide], counts[message.CopyMethodStreamed])
 	}
 
-	ansType, body, err = protoReader.ReadPacket()
+	ansType, body, err := protoReader.ReadPacket()
 	if err != nil {
 		ylogger.Zero.Warn().Err(err).Msg("error while answer")
 		return err
@@ -207,7 +240,7 @@ func putFunc(con net.Conn, instanceCnf *config.Instance, args []string) error {
 	if tp == message.MessageTypePutComplete {
 		msg := message.NewPutCompleteMessage(0)
 		msg.Decode(data)
-		ylogger.Zero.Debug().Int("key-version", int(msg.KeyVersion)).Msg("got put complete")
+		ylogger.Zero.Debug().Int("key-version", int(msg.KeyVersion)).Hex("checksum", msg.Checksum).Msg("got put complete")
 		fmt.Println(msg.KeyVersion)
 	} else {
 		return fmt.Errorf("failed to get rfq")
@@ -265,6 +298,42 @@ func listFunc(con net.Conn, instanceCnf *config.Instance, args []string) error {
 	return nil
 }
 
+func infoFunc(con net.Conn, instanceCnf *config.Instance, args []string) error {
+	msg := message.NewStartupMessage(message.ProtocolVersion, 0).Encode()
+	_, err := con.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	ylogger.Zero.Debug().Bytes("msg", msg).Msg("constructed startup message")
+
+	ycl := client.NewYClient(con)
+	tp, body, err := pio.NewProtoReader(ycl).ReadPacket()
+	if err != nil {
+		return err
+	}
+
+	switch tp {
+	case message.MessageTypeServerInfo:
+		info := message.ServerInfoMessage{}
+		info.Decode(body)
+
+		fmt.Printf("yproxy version: %s\n", info.Version)
+		fmt.Printf("protocol version: %d\n", info.ProtocolVersion)
+		fmt.Printf("capabilities:\n")
+		for _, name := range info.Capabilities.Names() {
+			fmt.Printf("  %s\n", name)
+		}
+	case message.MessageTypeError:
+		errMsg := message.ErrorMessage{}
+		errMsg.Decode(body)
+		return fmt.Errorf("%s: %s", errMsg.Message, errMsg.Error)
+	default:
+		return fmt.Errorf("incorrect message type: %s", tp.String())
+	}
+	return nil
+}
+
 // Request to delete a specific storage object
 func sendDeleteChunkRequest(con net.Conn, instanceCnf *config.Instance, args []string) error {
 	ylogger.Zero.Info().Msg("Execute delete command")
@@ -350,6 +419,54 @@ func untrashifyFunc(con net.Conn, instanceCnf *config.Instance, args []string) e
 	return nil
 }
 
+func rotateKeysFunc(con net.Conn, instanceCnf *config.Instance, args []string) error {
+	ylogger.Zero.Info().Msg("Execute rotate keys command")
+	ylogger.Zero.Info().Str("prefix", args[0]).Msg("rotate keys")
+	msg := message.NewRotateKeysMessage(args[0], newKeyPath, confirm, reencrypt).Encode()
+	_, err := con.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	ylogger.Zero.Debug().Bytes("msg", msg).Msg("constructed rotate keys msg")
+
+	ycl := client.NewYClient(con)
+	r := pio.NewProtoReader(ycl)
+
+	counts := map[message.RotateStatus]int{}
+	for {
+		tp, body, err := r.ReadPacket()
+		if err != nil {
+			return err
+		}
+
+		switch tp {
+		case message.MessageTypeRotateKeysStatus:
+			status := message.RotateKeysStatusMessage{}
+			status.Decode(body)
+			counts[status.Status]++
+
+			if status.Status == message.RotateStatusFailed {
+				fmt.Printf("%s: %s (%s)\n", status.Status, status.Path, status.Error)
+			} else {
+				fmt.Printf("%s: %s\n", status.Status, status.Path)
+			}
+		case message.MessageTypeError:
+			msg := message.ErrorMessage{}
+			msg.Decode(body)
+			return fmt.Errorf("%s: \"%s\"", msg.Message, msg.Error)
+		case message.MessageTypeReadyForQuery:
+			fmt.Printf("rewrapped: %d, reencrypted: %d, skipped: %d\n",
+				counts[message.RotateStatusRewrapped],
+				counts[message.RotateStatusReencrypted],
+				counts[message.RotateStatusSkipped])
+			return nil
+		default:
+			return fmt.Errorf("incorrect message type: %s", tp.String())
+		}
+	}
+}
+
 func goolFunc(con net.Conn, instanceCnf *config.Instance, args []string) error {
 	msg := message.NewGoolMessage(args[0]).Encode()
 	_, err := con.Write(msg)
@@ -434,6 +551,24 @@ var goolCmd = &cobra.Command{
 	RunE:  Runner(goolFunc),
 }
 
+var rotateKeysCmd = &cobra.Command{
+	Use:   "rotate-keys",
+	Short: "rotate-keys",
+	Long: `Moves objects under prefix from key configured in yproxy to --new-key.
+yproxy keeps old key until its gpg_key_path is switched to new key and config
+is reloaded, and rotated objects cannot be read meanwhile. Rotate with reads
+stopped, rerun until no object fails, then switch gpg_key_path.`,
+	Args: cobra.ExactArgs(1), // prefix
+	RunE: Runner(rotateKeysFunc),
+}
+
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "print server version and protocol capabilities",
+	Args:  cobra.NoArgs,
+	RunE:  Runner(infoFunc),
+}
+
 var delete2Cmd = &cobra.Command{
 	Use:   "deleteTrash",
 	Short: "deleteTrash",
@@ -456,7 +591,10 @@ func init() {
 	copyCmd.PersistentFlags().Uint64VarP(&segmentPort, "port", "p", 6000, "port that segment is listening on")
 	copyCmd.PersistentFlags().BoolVarP(&confirm, "confirm", "", false, "confirm copy")
 	copyCmd.PersistentFlags().BoolVarP(&useKEK, "use-kek", "", false, "use key encryption key and data encryption key pair to decrypt data")
-	copyCmd.PersistentFlags().BoolVarP(&ssCopy, "server-side", "", false, "perform server-side copy (requires KEK & DEK encryption)")
+	copyCmd.PersistentFlags().BoolVarP(&ssCopy, "server-side", "", false, "copy objects inside storage, streaming only objects with different keys (requires the same storage endpoint and credentials)")
+	copyCmd.PersistentFlags().BoolVarP(&resume, "resume", "", false, "resume interrupted copy, skipping objects verified by copy journal")
+	copyCmd.PersistentFlags().BoolVarP(&verify, "verify", "", false, "verify copied objects against source after copy")
+	copyCmd.PersistentFlags().StringVarP(&tableSpace, "tablespace", "t", tablespace.DefaultTableSpace, "tablespace of copied objects, selects destination bucket and crypter")
 	rootCmd.AddCommand(copyCmd)
 
 	putCmd.PersistentFlags().BoolVarP(&encrypt, "encrypt", "e", false, "encrypt external object before put")
@@ -469,6 +607,7 @@ func init() {
 
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(goolCmd)
+	rootCmd.AddCommand(infoCmd)
 
 	deleteCmd.PersistentFlags().Uint64VarP(&segmentPort, "port", "p", 6000, "port that segment is listening on")
 	deleteCmd.PersistentFlags().Uint64VarP(&segmentNum, "segnum", "s", 0, "logical number of a segment")
@@ -484,6 +623,12 @@ func init() {
 	delete2Cmd.PersistentFlags().BoolVarP(&confirm, "confirm", "", false, "confirm deletion")
 	delete2Cmd.PersistentFlags().BoolVarP(&garbage, "garbage", "g", false, "delete garbage")
 	rootCmd.AddCommand(delete2Cmd)
+
+	rotateKeysCmd.PersistentFlags().StringVarP(&newKeyPath, "new-key", "", "", "path to new GPG key that data keys are wrapped with")
+	rotateKeysCmd.PersistentFlags().BoolVarP(&confirm, "confirm", "", false, "confirm key rotation")
+	rotateKeysCmd.PersistentFlags().BoolVarP(&reencrypt, "reencrypt", "", false, "re-encrypt whole objects instead of re-wrapping data keys")
+	_ = rotateKeysCmd.MarkPersistentFlagRequired("new-key")
+	rootCmd.AddCommand(rotateKeysCmd)
 }
 
 func main() {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
 
 	"github.com/yezzey-gp/yproxy/config"
@@ -26,6 +28,10 @@ var rootCmd = &cobra.Command{
 
 		instanceCnf := config.InstanceConfig()
 
+		if err := core.CheckStartupConfig(cfgPath, instanceCnf); err != nil {
+			return err
+		}
+
 		instance := core.NewInstance()
 
 		if logLevel == "" {
@@ -41,9 +47,42 @@ var rootCmd = &cobra.Command{
 	Version: pkg.YproxyVersionRevision,
 }
 
+var probeStorage bool
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "inspect yproxy configuration",
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:          "check",
+	Short:        "validate configuration file without starting yproxy",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instanceCnf, err := config.ReadInstanceConfigWithEnv(cfgPath)
+		if err != nil {
+			return err
+		}
+
+		issues := core.CheckConfig(cfgPath, &instanceCnf, probeStorage)
+		for _, i := range issues {
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), i.String())
+		}
+		if err := issues.Err(); err != nil {
+			return fmt.Errorf("configuration %s is invalid", cfgPath)
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "configuration %s is valid\n", cfgPath)
+		return nil
+	},
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfgPath, "config", "c", "/etc/yproxy/yproxy.yaml", "path to yproxy config file")
 	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "", "log level")
+	configCheckCmd.Flags().BoolVarP(&probeStorage, "probe", "", false, "list storage buckets to check connectivity and credentials, nothing is written")
+	configCmd.AddCommand(configCheckCmd)
+	rootCmd.AddCommand(configCmd)
+
 	rootCmd.PersistentFlags().BoolVarP(&testutils.TestMode, "test-mode", "", false, "enable test mode. Use only in regression tests")
 }
 
@@ -0,0 +1,25 @@
+package config
+
+const (
+	ChecksumCRC32C = "crc32c"
+	ChecksumSHA256 = "sha256"
+
+	ChecksumMismatchError = "error"
+	ChecksumMismatchWarn  = "warn"
+)
+
+// Checksum makes PUT store checksum of object content and CAT verify
+// content against it.
+type Checksum struct {
+	// crc32c or sha256, checksums are neither stored nor verified when empty
+	Algorithm string `json:"algorithm" toml:"algorithm" yaml:"algorithm"`
+	// error fails CAT of corrupted object, warn only logs mismatch
+	OnMismatch string `json:"on_mismatch" toml:"on_mismatch" yaml:"on_mismatch"`
+}
+
+func (c *Checksum) GetOnMismatch() string {
+	if c.OnMismatch == "" {
+		return ChecksumMismatchError
+	}
+	return c.OnMismatch
+}
@@ -0,0 +1,236 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvPrefix = "YPROXY_"
+
+	/* separates setting keys in variable name, single underscore is part of key */
+	envSeparator = "__"
+
+	redactedSecret = "<redacted>"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyEnvOverrides sets config fields from YPROXY_ environment variables.
+// Variable name is the path of setting keys joined by double underscore,
+// e.g. YPROXY_LOG_LEVEL, YPROXY_STORAGE__STORAGE_BUCKET or
+// YPROXY_STORAGE__CREDENTIAL_MAP__<bucket>__SECRET_ACCESS_KEY. Setting keys
+// match case-insensitively, map keys exactly.
+func ApplyEnvOverrides(cfg *Instance, environ []string) error {
+	vars := slices.Clone(environ)
+	slices.Sort(vars)
+	for _, kv := range vars {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		path := strings.Split(strings.TrimPrefix(name, EnvPrefix), envSeparator)
+		if err := setPath(reflect.ValueOf(cfg).Elem(), path, value); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setPath(v reflect.Value, path []string, value string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		if len(path) == 0 || path[0] == "" {
+			return fmt.Errorf("setting section can not be set as a whole")
+		}
+		f, ok := fieldByTag(v.Type(), "json", path[0], true)
+		if !ok {
+			return fmt.Errorf("unknown setting %q", strings.ToLower(path[0]))
+		}
+		if err := setPath(v.FieldByIndex(f.Index), path[1:], value); err != nil {
+			return err
+		}
+		clearSecretSibling(v, f)
+		return nil
+	case reflect.Map:
+		if len(path) == 0 || path[0] == "" {
+			return fmt.Errorf("map can not be set as a whole, set its entries")
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.ValueOf(path[0]).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if cur := v.MapIndex(key); cur.IsValid() {
+			elem.Set(cur)
+		}
+		if err := setPath(elem, path[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	}
+
+	if len(path) != 0 {
+		return fmt.Errorf("%q is not a setting section", strings.ToLower(path[0]))
+	}
+	return setScalar(v, value)
+}
+
+func setScalar(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	default:
+		return fmt.Errorf("settings of type %s can not be set from environment", v.Type())
+	}
+	return nil
+}
+
+// clearSecretSibling drops file of secret set from environment and secret
+// of file set from environment, so environment always takes precedence.
+func clearSecretSibling(v reflect.Value, f reflect.StructField) {
+	if f.Tag.Get("secret") == "true" {
+		if file := v.FieldByName(f.Name + "File"); file.IsValid() {
+			file.SetString("")
+		}
+		return
+	}
+	if name, ok := strings.CutSuffix(f.Name, "File"); ok {
+		if sf, ok := v.Type().FieldByName(name); ok && sf.Tag.Get("secret") == "true" {
+			v.FieldByIndex(sf.Index).SetString("")
+		}
+	}
+}
+
+// ResolveSecretFiles reads secrets given by *_file settings.
+func ResolveSecretFiles(cfg *Instance) error {
+	return resolveSecretFiles(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func resolveSecretFiles(v reflect.Value, prefix string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if f.Tag.Get("secret") == "true" {
+				if err := resolveSecretFile(v, f, prefix+name); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := resolveSecretFiles(v.Field(i), prefix+name+"."); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := resolveSecretFiles(elem, fmt.Sprintf("%s%v.", prefix, iter.Key())); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return nil
+}
+
+func resolveSecretFile(v reflect.Value, f reflect.StructField, field string) error {
+	file := v.FieldByName(f.Name + "File")
+	if !file.IsValid() || file.String() == "" {
+		return nil
+	}
+	secret := v.FieldByIndex(f.Index)
+	if secret.String() != "" {
+		return fmt.Errorf("both %s and %s_file are set", field, field)
+	}
+	data, err := os.ReadFile(file.String())
+	if err != nil {
+		return fmt.Errorf("%s_file: %w", field, err)
+	}
+	secret.SetString(strings.TrimRight(string(data), "\r\n"))
+	return nil
+}
+
+// Redacted returns copy of config with secrets replaced, safe for logging.
+func (i *Instance) Redacted() *Instance {
+	cp := *i
+	redact(reflect.ValueOf(&cp).Elem())
+	return &cp
+}
+
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			f := v.Field(i)
+			if !f.CanSet() {
+				continue
+			}
+			if t.Field(i).Tag.Get("secret") == "true" {
+				if f.String() != "" {
+					f.SetString(redactedSecret)
+				}
+				continue
+			}
+			redact(f)
+		}
+	case reflect.Map:
+		if v.IsNil() || v.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		/* map is shared with original config, redact a copy */
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			redact(elem)
+			m.SetMapIndex(iter.Key(), elem)
+		}
+		v.Set(m)
+	}
+}
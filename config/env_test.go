@@ -0,0 +1,157 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeSecret(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	return path
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	cfg := BuildInstance()
+	cfg.StorageCnf.CredentialMap = map[string]StorageCredentials{"bucket-1": {AccessKeyId: "from-file", SecretAccessKey: "from-file"}}
+
+	err := ApplyEnvOverrides(cfg, []string{
+		"HOME=/root",
+		"YPROXY_LOG_LEVEL=debug",
+		"YPROXY_PSQL_PORT=0",
+		"YPROXY_STORAGE__STORAGE_BUCKET=main",
+		"YPROXY_STORAGE__ENABLE_RATE_LIMITER=true",
+		"YPROXY_STORAGE__STORAGE_RATE_LIMIT=1024",
+		"YPROXY_STORAGE__CREDENTIAL_MAP__bucket-1__SECRET_ACCESS_KEY=from-env",
+		"YPROXY_STORAGE__CREDENTIAL_MAP__bucket.2__ACCESS_KEY_ID=new",
+		"YPROXY_STORAGE__TABLESPACE_MAP__ts1=bucket.2",
+		"YPROXY_VACUUM__PROTECTION_WINDOW=90m",
+		"YPROXY_crypto__crypters__fast__type=aes-gcm",
+	})
+	if err != nil {
+		t.Fatalf("failed to apply overrides: %v", err)
+	}
+
+	if cfg.LogLevel != "debug" || cfg.PsqlPort != 0 {
+		t.Fatalf("top-level settings are not overridden: %q %v", cfg.LogLevel, cfg.PsqlPort)
+	}
+	if cfg.StorageCnf.StorageBucket != "main" || !cfg.StorageCnf.EnableRateLimiter || cfg.StorageCnf.StorageRateLimit != 1024 {
+		t.Fatalf("storage settings are not overridden: %+v", cfg.StorageCnf)
+	}
+	if creds := cfg.StorageCnf.CredentialMap["bucket-1"]; creds.AccessKeyId != "from-file" || creds.SecretAccessKey != "from-env" {
+		t.Fatalf("expected credential map entry to be merged, got %+v", creds)
+	}
+	if creds := cfg.StorageCnf.CredentialMap["bucket.2"]; creds.AccessKeyId != "new" {
+		t.Fatalf("expected new credential map entry, got %+v", creds)
+	}
+	if cfg.StorageCnf.TablespaceMap["ts1"] != "bucket.2" {
+		t.Fatalf("expected tablespace map entry, got %v", cfg.StorageCnf.TablespaceMap)
+	}
+	if cfg.VacuumCnf.ProtectionWindow != 90*time.Minute {
+		t.Fatalf("expected protection window override, got %v", cfg.VacuumCnf.ProtectionWindow)
+	}
+	if cfg.CryptoCnf.Crypters["fast"].Type != CrypterTypeAESGCM {
+		t.Fatalf("expected crypter from environment, got %+v", cfg.CryptoCnf.Crypters)
+	}
+
+	for _, bad := range []string{
+		"YPROXY_STORAGE__STORAGE_TYPO=fs",
+		"YPROXY_STORAGE=fs",
+		"YPROXY_STORAGE__STORAGE_CONCURRENCY=many",
+		"YPROXY_LOG_LEVEL__X=debug",
+		"YPROXY_STORAGE__TABLESPACE_MAP=ts1",
+	} {
+		if err := ApplyEnvOverrides(BuildInstance(), []string{bad}); err == nil {
+			t.Fatalf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	cfg, err := ReadInstanceConfig(writeTestConfig(t, "yproxy.yaml",
+		"storage:\n  secret_access_key_file: "+writeSecret(t, "s3cret\n")+
+			"\n  credential_map:\n    b1:\n      access_key_id_file: "+writeSecret(t, "AKID")+"\n"))
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if cfg.StorageCnf.SecretAccessKey != "s3cret" {
+		t.Fatalf("expected secret from file, got %q", cfg.StorageCnf.SecretAccessKey)
+	}
+	if cfg.StorageCnf.CredentialMap["b1"].AccessKeyId != "AKID" {
+		t.Fatalf("expected credential map secret from file, got %+v", cfg.StorageCnf.CredentialMap["b1"])
+	}
+
+	_, err = ReadInstanceConfig(writeTestConfig(t, "yproxy.yaml",
+		"storage:\n  secret_access_key: inline\n  secret_access_key_file: "+writeSecret(t, "s3cret")+"\n"))
+	if err == nil {
+		t.Fatal("expected secret set both inline and by file to be rejected")
+	}
+
+	_, err = ReadInstanceConfig(writeTestConfig(t, "yproxy.yaml", "storage:\n  azure_sas_token_file: /nonexistent\n"))
+	if err == nil {
+		t.Fatal("expected missing secret file to be rejected")
+	}
+}
+
+func TestEnvOverridesSecretFile(t *testing.T) {
+	cfgPath := writeTestConfig(t, "yproxy.yaml", "storage:\n  secret_access_key_file: "+writeSecret(t, "from-file")+"\n")
+
+	t.Setenv("YPROXY_STORAGE__SECRET_ACCESS_KEY", "from-env")
+	cfg, err := ReadInstanceConfigWithEnv(cfgPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if cfg.StorageCnf.SecretAccessKey != "from-env" || cfg.StorageCnf.SecretAccessKeyFile != "" {
+		t.Fatalf("expected environment to take precedence over secret file, got %+v", cfg.StorageCnf)
+	}
+
+	/* config of other instance is not affected by environment */
+	cfg, err = ReadInstanceConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if cfg.StorageCnf.SecretAccessKey != "from-file" {
+		t.Fatalf("expected secret from file, got %q", cfg.StorageCnf.SecretAccessKey)
+	}
+
+	t.Setenv("YPROXY_STORAGE__SECRET_ACCESS_KEY", "")
+	t.Setenv("YPROXY_STORAGE__SECRET_ACCESS_KEY_FILE", writeSecret(t, "other-file"))
+	cfg, err = ReadInstanceConfigWithEnv(writeTestConfig(t, "yproxy.yaml", "storage:\n  secret_access_key: inline\n"))
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if cfg.StorageCnf.SecretAccessKey != "other-file" {
+		t.Fatalf("expected secret file from environment to replace inline secret, got %q", cfg.StorageCnf.SecretAccessKey)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := BuildInstance()
+	cfg.StorageCnf.AccessKeyId = "AKID"
+	cfg.StorageCnf.SecretAccessKey = "s3cret"
+	cfg.StorageCnf.StorageBucket = "bucket"
+	cfg.BackupStorageCnf.AzureConnectionString = "AccountKey=s3cret"
+	cfg.StorageCnf.CredentialMap = map[string]StorageCredentials{"b1": {SecretAccessKey: "s3cret"}}
+
+	data, err := json.Marshal(cfg.Redacted())
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	if strings.Contains(string(data), "s3cret") || strings.Contains(string(data), "AKID") {
+		t.Fatalf("secrets leaked into redacted config: %s", data)
+	}
+	if !strings.Contains(string(data), `"storage_bucket":"bucket"`) {
+		t.Fatalf("expected non-secret settings to be kept: %s", data)
+	}
+	if cfg.StorageCnf.SecretAccessKey != "s3cret" || cfg.StorageCnf.CredentialMap["b1"].SecretAccessKey != "s3cret" {
+		t.Fatal("original config must not be redacted")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync/atomic"
 
 	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v2"
@@ -20,6 +21,12 @@ type Instance struct {
 
 	VacuumCnf Vacuum `json:"vacuum" toml:"vacuum" yaml:"vacuum"`
 
+	QoSCnf QoS `json:"qos" toml:"qos" yaml:"qos"`
+
+	UploadJanitorCnf UploadJanitor `json:"upload_janitor" toml:"upload_janitor" yaml:"upload_janitor"`
+
+	ChecksumCnf Checksum `json:"checksum" toml:"checksum" yaml:"checksum"`
+
 	LogPath                string `json:"log_path" toml:"log_path" yaml:"log_path"`
 	LogLevel               string `json:"log_level" toml:"log_level" yaml:"log_level"`
 	SocketPath             string `json:"socket_path" toml:"socket_path" yaml:"socket_path"`
@@ -49,10 +56,26 @@ func (i *Instance) GetSystemdSocketPath() string {
 	return i.systemdSocketPath
 }
 
-var cfgInstance Instance
+/* replaced as a whole on reload, holders of previous value keep it */
+var cfgInstance atomic.Pointer[Instance]
+
+func init() {
+	cfgInstance.Store(&Instance{})
+}
 
 func InstanceConfig() *Instance {
-	return &cfgInstance
+	return cfgInstance.Load()
+}
+
+// SetInstanceConfig installs cfg as running instance config.
+func SetInstanceConfig(cfg *Instance) {
+	cfgInstance.Store(cfg)
+
+	configBytes, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+	if err != nil {
+		return
+	}
+	log.Println("Running config:", string(configBytes))
 }
 
 type InstanceOption func(*Instance)
@@ -147,35 +170,53 @@ func initInstanceConfig(file *os.File, cfgInstance *Instance) error {
 
 var bootstrapCfgPath = ""
 
+// BootstrapConfigPath returns path of config file loaded on start.
+func BootstrapConfigPath() string {
+	return bootstrapCfgPath
+}
+
+// ReloadInstanceConfig reads bootstrap config file again. Returned config
+// is not installed, caller validates it and calls SetInstanceConfig.
 func ReloadInstanceConfig() (*Instance, error) {
-	if err := LoadInstanceConfig(bootstrapCfgPath); err != nil {
+	if bootstrapCfgPath == "" {
+		return nil, fmt.Errorf("bootstrap config path is not set")
+	}
+	cfg, err := ReadInstanceConfigWithEnv(bootstrapCfgPath)
+	if err != nil {
 		return nil, err
 	}
-	return InstanceConfig(), nil
+	cfg.ReadSystemdSocketPath()
+	return &cfg, nil
 }
 
-func LoadInstanceConfig(cfgPath string) (err error) {
+func LoadInstanceConfig(cfgPath string) error {
 	if bootstrapCfgPath != "" && bootstrapCfgPath != cfgPath {
 		return fmt.Errorf("bootstrap config path already set")
 	}
 	bootstrapCfgPath = cfgPath
-	cfgInstance, err = ReadInstanceConfig(cfgPath)
+	cfg, err := ReadInstanceConfigWithEnv(cfgPath)
 	if err != nil {
-		return
+		return err
 	}
 
-	cfgInstance.ReadSystemdSocketPath()
+	cfg.ReadSystemdSocketPath()
+	SetInstanceConfig(&cfg)
+	return nil
+}
 
-	configBytes, err := json.MarshalIndent(cfgInstance, "", "  ")
-	if err != nil {
-		return
-	}
+// ReadInstanceConfig reads config file and secrets it refers to. Used for
+// config files of other instances too, so environment is not applied.
+func ReadInstanceConfig(cfgPath string) (Instance, error) {
+	return readInstanceConfig(cfgPath, nil)
+}
 
-	log.Println("Running config:", string(configBytes))
-	return
+// ReadInstanceConfigWithEnv is ReadInstanceConfig with YPROXY_ environment
+// overrides applied, it reads config of running instance.
+func ReadInstanceConfigWithEnv(cfgPath string) (Instance, error) {
+	return readInstanceConfig(cfgPath, os.Environ())
 }
 
-func ReadInstanceConfig(cfgPath string) (Instance, error) {
+func readInstanceConfig(cfgPath string, environ []string) (Instance, error) {
 	var cfg Instance
 	file, err := os.Open(cfgPath)
 	if err != nil {
@@ -191,6 +232,12 @@ func ReadInstanceConfig(cfgPath string) (Instance, error) {
 	if err := initInstanceConfig(file, &cfg); err != nil {
 		return cfg, err
 	}
+	if err := ApplyEnvOverrides(&cfg, environ); err != nil {
+		return cfg, err
+	}
+	if err := ResolveSecretFiles(&cfg); err != nil {
+		return cfg, err
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+const (
+	DefaultUploadJanitorMaxAge = 24 * time.Hour
+)
+
+// UploadJanitor aborts multipart uploads left unfinished, e.g. by crashed
+// INSERT, which keep their parts stored.
+type UploadJanitor struct {
+	// how often storage is checked, janitor is disabled when 0
+	Interval time.Duration `json:"interval" toml:"interval" yaml:"interval"`
+	// uploads started earlier are aborted
+	MaxAge time.Duration `json:"max_age" toml:"max_age" yaml:"max_age"`
+	// only log uploads which would be aborted
+	DryRun bool `json:"dry_run" toml:"dry_run" yaml:"dry_run"`
+}
+
+func (j *UploadJanitor) GetMaxAge() time.Duration {
+	if j.MaxAge <= 0 {
+		return DefaultUploadJanitorMaxAge
+	}
+	return j.MaxAge
+}
@@ -1,7 +1,18 @@
 package config
 
+import "time"
+
+const (
+	DefaultBucketCacheTTL = 24 * time.Hour
+)
+
 type Proxy struct {
 	ConsolePort string `json:"console_port" toml:"console_port" yaml:"console_port"`
 
-	BucketCachePath string `json:"bucket_cache_path" toml:"bucket_cache_path" yaml:"bucket_cache_path"`
+	/* listing cache database, listings are not cached when empty */
+	BucketCachePath string        `json:"bucket_cache_path" toml:"bucket_cache_path" yaml:"bucket_cache_path"`
+	BucketCacheTTL  time.Duration `json:"bucket_cache_ttl" toml:"bucket_cache_ttl" yaml:"bucket_cache_ttl"`
+
+	/* directory for COPY checkpoint journals, journaling is disabled when empty */
+	CopyJournalPath string `json:"copy_journal_path" toml:"copy_journal_path" yaml:"copy_journal_path"`
 }
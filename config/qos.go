@@ -0,0 +1,21 @@
+package config
+
+const (
+	QoSClassForeground = "foreground"
+	QoSClassBackground = "background"
+)
+
+// QoSClass limits requests served in class. Zero values mean unlimited.
+type QoSClass struct {
+	// bytes per second read from and written to storage by requests of class
+	RateLimit uint64 `json:"rate_limit" toml:"rate_limit" yaml:"rate_limit"`
+	// requests of class served at the same time, others wait
+	Concurrency int64 `json:"concurrency" toml:"concurrency" yaml:"concurrency"`
+}
+
+type QoS struct {
+	// foreground and background classes always exist, unlimited unless configured
+	Classes map[string]QoSClass `json:"classes" toml:"classes" yaml:"classes"`
+	// message type name, e.g. "COPYV2", to class serving it
+	MessageClasses map[string]string `json:"message_classes" toml:"message_classes" yaml:"message_classes"`
+}
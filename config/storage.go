@@ -1,24 +1,85 @@
 package config
 
-import "net/url"
+import (
+	"net/url"
+	"time"
+)
 
 type Crypto struct {
 	GPGKeyId   string `json:"gpg_key_id" toml:"gpg_key_id" yaml:"gpg_key_id"`
 	GPGKeyPath string `json:"gpg_key_path" toml:"gpg_key_path" yaml:"gpg_key_path"`
+
+	// encrypt new objects with per-object data key wrapped by GPG key (KEK)
+	UseKEK bool `json:"use_kek" toml:"use_kek" yaml:"use_kek"`
+
+	// format of new encrypted objects: "gpg" or seekable "aes-gcm"
+	EncryptionFormat    string `json:"encryption_format" toml:"encryption_format" yaml:"encryption_format"`
+	EncryptionChunkSize int    `json:"encryption_chunk_size" toml:"encryption_chunk_size" yaml:"encryption_chunk_size"`
+
+	// named crypters, selected by tablespace or Crypter storage setting
+	Crypters           map[string]Crypter `json:"crypters" toml:"crypters" yaml:"crypters"`
+	TablespaceCrypters map[string]string  `json:"tablespace_crypter_map" toml:"tablespace_crypter_map" yaml:"tablespace_crypter_map"`
+}
+
+type Crypter struct {
+	// one of "gpg", "aes-gcm" or "passthrough"
+	Type string `json:"type" toml:"type" yaml:"type"`
+
+	/* gpg */
+	GPGKeyPath       string `json:"gpg_key_path" toml:"gpg_key_path" yaml:"gpg_key_path"`
+	UseKEK           bool   `json:"use_kek" toml:"use_kek" yaml:"use_kek"`
+	EncryptionFormat string `json:"encryption_format" toml:"encryption_format" yaml:"encryption_format"`
+
+	/* aes-gcm, file with raw or hex-encoded 256-bit key */
+	KeyPath string `json:"key_path" toml:"key_path" yaml:"key_path"`
+
+	/* kms, data keys are wrapped by Vault transit key */
+	KMSAddress   string        `json:"kms_address" toml:"kms_address" yaml:"kms_address"`
+	KMSTokenPath string        `json:"kms_token_path" toml:"kms_token_path" yaml:"kms_token_path"`
+	KMSMount     string        `json:"kms_mount" toml:"kms_mount" yaml:"kms_mount"`
+	KMSKeyName   string        `json:"kms_key_name" toml:"kms_key_name" yaml:"kms_key_name"`
+	KMSNamespace string        `json:"kms_namespace" toml:"kms_namespace" yaml:"kms_namespace"`
+	KMSCacheTTL  time.Duration `json:"kms_cache_ttl" toml:"kms_cache_ttl" yaml:"kms_cache_ttl"`
+
+	EncryptionChunkSize int `json:"encryption_chunk_size" toml:"encryption_chunk_size" yaml:"encryption_chunk_size"`
 }
 
+const (
+	EncryptionFormatGPG    = "gpg"
+	EncryptionFormatAESGCM = "aes-gcm"
+
+	CrypterTypeGPG         = "gpg"
+	CrypterTypeAESGCM      = "aes-gcm"
+	CrypterTypePassthrough = "passthrough"
+	CrypterTypeKMS         = "kms"
+
+	DefaultKMSMount    = "transit"
+	DefaultKMSCacheTTL = 5 * time.Minute
+)
+
 type StorageCredentials struct {
-	AccessKeyId     string `json:"access_key_id" toml:"access_key_id" yaml:"access_key_id"`
-	SecretAccessKey string `json:"secret_access_key" toml:"secret_access_key" yaml:"secret_access_key"`
+	AccessKeyId     string `json:"access_key_id" toml:"access_key_id" yaml:"access_key_id" secret:"true"`
+	SecretAccessKey string `json:"secret_access_key" toml:"secret_access_key" yaml:"secret_access_key" secret:"true"`
+
+	// files holding secrets above, read on config load
+	AccessKeyIdFile     string `json:"access_key_id_file" toml:"access_key_id_file" yaml:"access_key_id_file"`
+	SecretAccessKeyFile string `json:"secret_access_key_file" toml:"secret_access_key_file" yaml:"secret_access_key_file"`
+
+	// service account key of GCS bucket
+	GCSCredentialsFile string `json:"gcs_credentials_file" toml:"gcs_credentials_file" yaml:"gcs_credentials_file"`
 }
 
 type Storage struct {
 	StorageEndpoint string `json:"storage_endpoint" toml:"storage_endpoint" yaml:"storage_endpoint"`
-	AccessKeyId     string `json:"access_key_id" toml:"access_key_id" yaml:"access_key_id"`
-	SecretAccessKey string `json:"secret_access_key" toml:"secret_access_key" yaml:"secret_access_key"`
+	AccessKeyId     string `json:"access_key_id" toml:"access_key_id" yaml:"access_key_id" secret:"true"`
+	SecretAccessKey string `json:"secret_access_key" toml:"secret_access_key" yaml:"secret_access_key" secret:"true"`
 	StoragePrefix   string `json:"storage_prefix" toml:"storage_prefix" yaml:"storage_prefix"`
 	StorageBucket   string `json:"storage_bucket" toml:"storage_bucket" yaml:"storage_bucket"`
 
+	// files holding secrets, read on config load
+	AccessKeyIdFile     string `json:"access_key_id_file" toml:"access_key_id_file" yaml:"access_key_id_file"`
+	SecretAccessKeyFile string `json:"secret_access_key_file" toml:"secret_access_key_file" yaml:"secret_access_key_file"`
+
 	CredentialMap map[string]StorageCredentials `json:"credential_map" toml:"credential_map" yaml:"credential_map"`
 
 	TablespaceMap map[string]string `json:"tablespace_map" toml:"tablespace_map" yaml:"tablespace_map"`
@@ -27,18 +88,52 @@ type Storage struct {
 	StorageConcurrency     int64 `json:"storage_concurrency" toml:"storage_concurrency" yaml:"storage_concurrency"`
 	CopyStorageConcurrency int64 `json:"copy_storage_concurrency" toml:"copy_storage_concurrency" yaml:"copy_storage_concurrency"`
 
+	// parallel transfer of single object, parts and ranges take slots of storage concurrency
+	UploadConcurrency   int   `json:"upload_concurrency" toml:"upload_concurrency" yaml:"upload_concurrency"`
+	DownloadConcurrency int   `json:"download_concurrency" toml:"download_concurrency" yaml:"download_concurrency"`
+	DownloadPartSize    int64 `json:"download_part_size" toml:"download_part_size" yaml:"download_part_size"`
+
 	// default will be false
 	EnableRateLimiter bool   `json:"enable_rate_limiter" toml:"enable_rate_limiter" yaml:"enable_rate_limiter"`
 	StorageRateLimit  uint64 `json:"storage_rate_limit" toml:"storage_rate_limit" yaml:"storage_rate_limit"`
+	// bytes per second uploaded, storage_rate_limit is used when unset
+	StorageWriteRateLimit uint64 `json:"storage_write_rate_limit" toml:"storage_write_rate_limit" yaml:"storage_write_rate_limit"`
 
 	StorageRegion string `json:"storage_region" toml:"storage_region" yaml:"storage_region"`
 
 	// copy behaviour control option
 	StorageOptimizeCopy bool `json:"storage_optimize_copy" toml:"storage_optimize_copy" yaml:"storage_optimize_copy"`
 
-	// File storage default s3. Available: s3, fs
+	// File storage default s3. Available: s3, fs, azblob, gcs, tiered
 	StorageType string `json:"storage_type" toml:"storage_type" yaml:"storage_type"`
 
+	// Azure Blob Storage credentials, storage_bucket is container name.
+	// Connection string takes precedence, then account key, then SAS token.
+	AzureAccountName      string `json:"azure_account_name" toml:"azure_account_name" yaml:"azure_account_name"`
+	AzureAccountKey       string `json:"azure_account_key" toml:"azure_account_key" yaml:"azure_account_key" secret:"true"`
+	AzureSASToken         string `json:"azure_sas_token" toml:"azure_sas_token" yaml:"azure_sas_token" secret:"true"`
+	AzureConnectionString string `json:"azure_connection_string" toml:"azure_connection_string" yaml:"azure_connection_string" secret:"true"`
+
+	AzureAccountKeyFile       string `json:"azure_account_key_file" toml:"azure_account_key_file" yaml:"azure_account_key_file"`
+	AzureSASTokenFile         string `json:"azure_sas_token_file" toml:"azure_sas_token_file" yaml:"azure_sas_token_file"`
+	AzureConnectionStringFile string `json:"azure_connection_string_file" toml:"azure_connection_string_file" yaml:"azure_connection_string_file"`
+
+	// Service account key for GCS, application default credentials are used when empty.
+	GCSCredentialsFile string `json:"gcs_credentials_file" toml:"gcs_credentials_file" yaml:"gcs_credentials_file"`
+
+	// Tiered storage acknowledges writes once they land in local directory
+	// and destages them to storage of tier_remote_storage_type in background.
+	TierLocalPath         string `json:"tier_local_path" toml:"tier_local_path" yaml:"tier_local_path"`
+	TierRemoteStorageType string `json:"tier_remote_storage_type" toml:"tier_remote_storage_type" yaml:"tier_remote_storage_type"`
+	// local tier size in bytes, objects already destaged are evicted above it. 0 means unlimited
+	TierCapacity       int64 `json:"tier_capacity" toml:"tier_capacity" yaml:"tier_capacity"`
+	TierDestageWorkers int   `json:"tier_destage_workers" toml:"tier_destage_workers" yaml:"tier_destage_workers"`
+
+	// Local cache of object blocks read from storage, disabled when path is empty.
+	BlockCachePath      string `json:"block_cache_path" toml:"block_cache_path" yaml:"block_cache_path"`
+	BlockCacheSize      int64  `json:"block_cache_size" toml:"block_cache_size" yaml:"block_cache_size"`
+	BlockCacheBlockSize int64  `json:"block_cache_block_size" toml:"block_cache_block_size" yaml:"block_cache_block_size"`
+
 	EndpointSourceHost   string `json:"storage_endpoint_source_host" toml:"storage_endpoint_source_host" yaml:"storage_endpoint_source_host"`
 	EndpointSourcePort   string `json:"storage_endpoint_source_port" toml:"storage_endpoint_source_port" yaml:"storage_endpoint_source_port"`
 	EndpointSourceScheme string `json:"storage_endpoint_source_scheme" toml:"storage_endpoint_source_scheme" yaml:"storage_endpoint_source_scheme"`
@@ -51,6 +146,15 @@ const (
 
 	DefaultEndpointSourceScheme = "https"
 
+	DefaultTierDestageWorkers = 4
+
+	/* 10 GB */
+	DefaultBlockCacheSize      = 10 * 1024 * 1024 * 1024
+	DefaultBlockCacheBlockSize = 1024 * 1024
+
+	/* 16 MB, the same as default multipart chunk size */
+	DefaultDownloadPartSize = 16 * 1024 * 1024
+
 	/* 1 GB per  second */
 	DefaultStorageRateLimit = 1024 * 1024 * 1024
 )
@@ -0,0 +1,446 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+/* sun_path of sockaddr_un, including terminating zero */
+const maxSocketPathLen = 108
+
+var storageTypes = []string{"s3", "fs", "azblob", "gcs", "tiered"}
+
+// Issue is a problem found in instance config. Warnings do not prevent
+// yproxy from starting.
+type Issue struct {
+	Field   string
+	Message string
+	Warning bool
+}
+
+func (i Issue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", level, i.Field, i.Message)
+}
+
+type Issues []Issue
+
+func (is *Issues) errorf(field, format string, args ...any) {
+	*is = append(*is, Issue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (is *Issues) warnf(field, format string, args ...any) {
+	*is = append(*is, Issue{Field: field, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// Err joins issues which are not warnings, nil if there are none.
+func (is Issues) Err() error {
+	var errs []error
+	for _, i := range is {
+		if !i.Warning {
+			errs = append(errs, errors.New(i.String()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckInstanceConfig reports unknown keys of config file and problems of
+// config read from it.
+func CheckInstanceConfig(cfgPath string, cfg *Instance) Issues {
+	var issues Issues
+	keys, err := UnknownConfigKeys(cfgPath)
+	if err != nil {
+		issues.errorf(cfgPath, "%v", err)
+	}
+	for _, k := range keys {
+		issues.errorf(k, "unknown key")
+	}
+	return append(issues, cfg.Validate()...)
+}
+
+// UnknownConfigKeys returns keys of config file not matching any setting.
+// Keys are matched the way decoder of file format does: case-insensitively
+// for toml and json, exactly for yaml.
+func UnknownConfigKeys(cfgPath string) ([]string, error) {
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	var tag string
+	foldCase := true
+	switch {
+	case strings.HasSuffix(cfgPath, ".toml"):
+		tag = "toml"
+		err = toml.Unmarshal(data, &raw)
+	case strings.HasSuffix(cfgPath, ".yaml"):
+		tag, foldCase = "yaml", false
+		err = yaml.Unmarshal(data, &raw)
+	case strings.HasSuffix(cfgPath, ".json"):
+		tag = "json"
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unknown config format type: %s. Use .toml, .yaml or .json suffix in filename", cfgPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unknownKeys(raw, reflect.TypeOf(Instance{}), tag, foldCase, ""), nil
+}
+
+// mapEntries converts decoded mapping of any format to string-keyed map.
+func mapEntries(v any) map[string]any {
+	switch m := v.(type) {
+	case map[string]any:
+		return m
+	case map[any]any:
+		res := make(map[string]any, len(m))
+		for k, e := range m {
+			res[fmt.Sprint(k)] = e
+		}
+		return res
+	}
+	return nil
+}
+
+func unknownKeys(v any, t reflect.Type, tag string, foldCase bool, prefix string) []string {
+	entries := mapEntries(v)
+	if entries == nil {
+		return nil
+	}
+	keys := slices.Sorted(maps.Keys(entries))
+
+	var res []string
+	switch t.Kind() {
+	case reflect.Struct:
+		for _, k := range keys {
+			f, ok := fieldByTag(t, tag, k, foldCase)
+			if !ok {
+				res = append(res, prefix+k)
+				continue
+			}
+			res = append(res, unknownKeys(entries[k], f.Type, tag, foldCase, prefix+k+".")...)
+		}
+	case reflect.Map:
+		for _, k := range keys {
+			res = append(res, unknownKeys(entries[k], t.Elem(), tag, foldCase, prefix+k+".")...)
+		}
+	}
+	return res
+}
+
+func fieldByTag(t reflect.Type, tag, key string, foldCase bool) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		if name == key || (foldCase && strings.EqualFold(name, key)) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Validate checks values of config and consistency between its sections.
+func (i *Instance) Validate() Issues {
+	var issues Issues
+
+	if i.SocketPath == "" {
+		issues.errorf("socket_path", "must be set")
+	}
+	checkSocket(&issues, "socket_path", i.SocketPath)
+	checkSocket(&issues, "interconnect_socket_path", i.InterconnectSocketPath)
+	if i.SocketPath != "" && i.SocketPath == i.InterconnectSocketPath {
+		issues.errorf("interconnect_socket_path", "must differ from socket_path")
+	}
+
+	ports := map[int]string{}
+	for _, p := range []struct {
+		field string
+		port  int
+	}{
+		{"stat_port", i.StatPort},
+		{"psql_port", i.PsqlPort},
+		{"debug_port", i.DebugPort},
+		{"metrics_port", i.MetricsPort},
+	} {
+		if p.port < 0 || p.port > 65535 {
+			issues.errorf(p.field, "port %d is out of range", p.port)
+			continue
+		}
+		if p.port == 0 {
+			continue
+		}
+		if other, ok := ports[p.port]; ok {
+			issues.errorf(p.field, "port %d is also used by %s", p.port, other)
+		}
+		ports[p.port] = p.field
+	}
+
+	i.StorageCnf.validate(&issues, "storage")
+	i.BackupStorageCnf.validate(&issues, "backup_storage")
+	i.CryptoCnf.validate(&issues, "crypto")
+	i.VacuumCnf.validate(&issues, "vacuum")
+	i.QoSCnf.validate(&issues, "qos")
+	if i.UploadJanitorCnf.Interval < 0 {
+		issues.errorf("upload_janitor.interval", "must not be negative")
+	}
+	if i.UploadJanitorCnf.MaxAge < 0 {
+		issues.errorf("upload_janitor.max_age", "must not be negative")
+	}
+	i.ChecksumCnf.validate(&issues, "checksum")
+
+	if i.ProxyCnf.BucketCacheTTL < 0 {
+		issues.errorf("proxy.bucket_cache_ttl", "must not be negative")
+	}
+	checkDir(&issues, "proxy.bucket_cache_path", filepath.Dir(i.ProxyCnf.BucketCachePath), i.ProxyCnf.BucketCachePath != "")
+	checkDir(&issues, "proxy.copy_journal_path", i.ProxyCnf.CopyJournalPath, i.ProxyCnf.CopyJournalPath != "")
+	return issues
+}
+
+func (s *Storage) validate(issues *Issues, section string) {
+	if !slices.Contains(storageTypes, s.StorageType) {
+		issues.errorf(section+".storage_type", "unknown storage type %q, expected one of %s", s.StorageType, strings.Join(storageTypes, ", "))
+	}
+	if s.StorageType == "tiered" {
+		if s.TierLocalPath == "" {
+			issues.errorf(section+".tier_local_path", "must be set for tiered storage")
+		}
+		if s.TierRemoteStorageType == "tiered" || (s.TierRemoteStorageType != "" && !slices.Contains(storageTypes, s.TierRemoteStorageType)) {
+			issues.errorf(section+".tier_remote_storage_type", "unsupported remote tier type %q", s.TierRemoteStorageType)
+		}
+		if s.TierCapacity < 0 {
+			issues.errorf(section+".tier_capacity", "must not be negative")
+		}
+	}
+
+	if s.StorageConcurrency <= 0 {
+		issues.errorf(section+".storage_concurrency", "must be positive, got %d", s.StorageConcurrency)
+	}
+	if s.CopyStorageConcurrency < 0 {
+		issues.errorf(section+".copy_storage_concurrency", "must not be negative, got %d", s.CopyStorageConcurrency)
+	}
+	if s.UploadConcurrency < 0 {
+		issues.errorf(section+".upload_concurrency", "must not be negative, got %d", s.UploadConcurrency)
+	}
+	if s.DownloadConcurrency < 0 {
+		issues.errorf(section+".download_concurrency", "must not be negative, got %d", s.DownloadConcurrency)
+	}
+	if s.DownloadPartSize < 0 {
+		issues.errorf(section+".download_part_size", "must not be negative, got %d", s.DownloadPartSize)
+	}
+	if s.EnableRateLimiter && s.StorageRateLimit == 0 {
+		issues.errorf(section+".storage_rate_limit", "must be positive when rate limiter is enabled")
+	}
+	if s.BlockCacheSize < 0 || s.BlockCacheBlockSize < 0 {
+		issues.errorf(section+".block_cache_size", "block cache sizes must not be negative")
+	}
+
+	for _, ts := range slices.Sorted(maps.Keys(s.TablespaceMap)) {
+		bucket := s.TablespaceMap[ts]
+		if bucket == "" {
+			issues.errorf(section+".tablespace_map."+ts, "bucket is empty")
+			continue
+		}
+		/* s3 requires credentials of every bucket, default one falls back to top-level credentials */
+		if _, ok := s.CredentialMap[bucket]; !ok && s.usesS3() && bucket != s.StorageBucket {
+			issues.errorf(section+".tablespace_map."+ts, "bucket %q has no entry in credential_map", bucket)
+		}
+	}
+	for _, bucket := range slices.Sorted(maps.Keys(s.CredentialMap)) {
+		creds := s.CredentialMap[bucket]
+		used := bucket == s.StorageBucket
+		for _, b := range s.TablespaceMap {
+			used = used || b == bucket
+		}
+		if !used {
+			issues.warnf(section+".credential_map."+bucket, "bucket is not used by any tablespace")
+		}
+		checkKeyFile(issues, section+".credential_map."+bucket+".gcs_credentials_file", creds.GCSCredentialsFile)
+		checkKeyFile(issues, section+".credential_map."+bucket+".access_key_id_file", creds.AccessKeyIdFile)
+		checkKeyFile(issues, section+".credential_map."+bucket+".secret_access_key_file", creds.SecretAccessKeyFile)
+	}
+	checkKeyFile(issues, section+".gcs_credentials_file", s.GCSCredentialsFile)
+	checkKeyFile(issues, section+".access_key_id_file", s.AccessKeyIdFile)
+	checkKeyFile(issues, section+".secret_access_key_file", s.SecretAccessKeyFile)
+	checkKeyFile(issues, section+".azure_account_key_file", s.AzureAccountKeyFile)
+	checkKeyFile(issues, section+".azure_sas_token_file", s.AzureSASTokenFile)
+	checkKeyFile(issues, section+".azure_connection_string_file", s.AzureConnectionStringFile)
+}
+
+func (s *Storage) usesS3() bool {
+	return s.StorageType == "s3" || (s.StorageType == "tiered" && (s.TierRemoteStorageType == "" || s.TierRemoteStorageType == "s3"))
+}
+
+func (c *Crypto) validate(issues *Issues, section string) {
+	checkKeyFile(issues, section+".gpg_key_path", c.GPGKeyPath)
+	checkEncryptionFormat(issues, section+".encryption_format", c.EncryptionFormat)
+	if c.EncryptionChunkSize < 0 {
+		issues.errorf(section+".encryption_chunk_size", "must not be negative")
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.Crypters)) {
+		cr := c.Crypters[name]
+		field := section + ".crypters." + name
+		switch cr.Type {
+		case CrypterTypeGPG:
+			if cr.GPGKeyPath == "" {
+				issues.errorf(field+".gpg_key_path", "must be set for gpg crypter")
+			}
+		case CrypterTypeAESGCM:
+			if cr.KeyPath == "" {
+				issues.errorf(field+".key_path", "must be set for aes-gcm crypter")
+			}
+		case CrypterTypeKMS:
+			if cr.KMSAddress == "" || cr.KMSKeyName == "" {
+				issues.errorf(field, "kms_address and kms_key_name must be set for kms crypter")
+			}
+		case "":
+			issues.errorf(field+".type", "must be set")
+		}
+		checkKeyFile(issues, field+".gpg_key_path", cr.GPGKeyPath)
+		checkKeyFile(issues, field+".key_path", cr.KeyPath)
+		checkKeyFile(issues, field+".kms_token_path", cr.KMSTokenPath)
+		checkEncryptionFormat(issues, field+".encryption_format", cr.EncryptionFormat)
+	}
+
+	for _, ts := range slices.Sorted(maps.Keys(c.TablespaceCrypters)) {
+		name := c.TablespaceCrypters[ts]
+		if _, ok := c.Crypters[name]; ok {
+			continue
+		}
+		/* legacy top-level gpg settings form the default crypter */
+		if name == "default" && c.GPGKeyPath != "" {
+			continue
+		}
+		issues.errorf(section+".tablespace_crypter_map."+ts, "crypter %q is not configured", name)
+	}
+}
+
+func (v *Vacuum) validate(issues *Issues, section string) {
+	if v.FileChunkPerSec <= 0 {
+		issues.errorf(section+".file_chunk_per_sec", "must be positive, got %d", v.FileChunkPerSec)
+	}
+	if v.TrashMoveWorkers <= 0 {
+		issues.errorf(section+".trash_move_workers", "must be positive, got %d", v.TrashMoveWorkers)
+	}
+	if v.TrashDeleteWorkers <= 0 {
+		issues.errorf(section+".trash_delete_workers", "must be positive, got %d", v.TrashDeleteWorkers)
+	}
+	if v.ProtectionWindow < 0 {
+		issues.errorf(section+".protection_window", "must not be negative")
+	}
+}
+
+func (q *QoS) validate(issues *Issues, section string) {
+	for _, name := range slices.Sorted(maps.Keys(q.Classes)) {
+		if q.Classes[name].Concurrency < 0 {
+			issues.errorf(section+".classes."+name+".concurrency", "must not be negative")
+		}
+	}
+	for _, tp := range slices.Sorted(maps.Keys(q.MessageClasses)) {
+		class := q.MessageClasses[tp]
+		if _, ok := q.Classes[class]; !ok && class != QoSClassForeground && class != QoSClassBackground {
+			issues.errorf(section+".message_classes."+tp, "class %q is not configured", class)
+		}
+	}
+}
+
+func (c *Checksum) validate(issues *Issues, section string) {
+	if c.Algorithm != "" && c.Algorithm != ChecksumCRC32C && c.Algorithm != ChecksumSHA256 {
+		issues.errorf(section+".algorithm", "unknown checksum algorithm %q, expected %s or %s", c.Algorithm, ChecksumCRC32C, ChecksumSHA256)
+	}
+	if c.OnMismatch != "" && c.OnMismatch != ChecksumMismatchError && c.OnMismatch != ChecksumMismatchWarn {
+		issues.errorf(section+".on_mismatch", "unknown action %q, expected %s or %s", c.OnMismatch, ChecksumMismatchError, ChecksumMismatchWarn)
+	}
+}
+
+func checkEncryptionFormat(issues *Issues, field, format string) {
+	if format != "" && format != EncryptionFormatGPG && format != EncryptionFormatAESGCM {
+		issues.errorf(field, "unknown encryption format %q", format)
+	}
+}
+
+// checkKeyFile requires secret file to be readable and not accessible by others.
+func checkKeyFile(issues *Issues, field, path string) {
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		issues.errorf(field, "%v", err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	st, err := f.Stat()
+	if err != nil {
+		issues.errorf(field, "%v", err)
+		return
+	}
+	if st.IsDir() {
+		issues.errorf(field, "%s is a directory", path)
+		return
+	}
+	if st.Mode().Perm()&0o077 != 0 {
+		issues.warnf(field, "%s is accessible by group or others (mode %#o)", path, st.Mode().Perm())
+	}
+}
+
+func checkDir(issues *Issues, field, dir string, enabled bool) {
+	if !enabled {
+		return
+	}
+	st, err := os.Stat(dir)
+	if err != nil {
+		issues.errorf(field, "%v", err)
+		return
+	}
+	if !st.IsDir() {
+		issues.errorf(field, "%s is not a directory", dir)
+	}
+}
+
+// checkSocket requires socket directory to exist and not to be writable by
+// everyone without sticky bit, so socket cannot be replaced by other user.
+func checkSocket(issues *Issues, field, path string) {
+	if path == "" {
+		return
+	}
+	if len(path) >= maxSocketPathLen {
+		issues.errorf(field, "socket path is longer than %d bytes", maxSocketPathLen-1)
+	}
+	if st, err := os.Lstat(path); err == nil && st.Mode().Type() != os.ModeSocket {
+		issues.errorf(field, "%s exists and is not a socket", path)
+	}
+
+	dir := filepath.Dir(path)
+	st, err := os.Stat(dir)
+	if err != nil {
+		issues.errorf(field, "socket directory: %v", err)
+		return
+	}
+	if !st.IsDir() {
+		issues.errorf(field, "socket directory %s is not a directory", dir)
+		return
+	}
+	if st.Mode().Perm()&0o002 != 0 && st.Mode()&os.ModeSticky == 0 {
+		issues.warnf(field, "socket directory %s is writable by everyone", dir)
+	}
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func issueFields(issues Issues, warnings bool) []string {
+	var fields []string
+	for _, i := range issues {
+		if i.Warning == warnings {
+			fields = append(fields, i.Field)
+		}
+	}
+	return fields
+}
+
+func TestUnknownConfigKeys(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		content string
+	}{
+		{"yproxy.yaml", "socket_path: /tmp/y.sock\nstorage:\n  storage_typo: fs\n  credential_map:\n    b1:\n      access_key: x\nvacum:\n  check_backup: false\n"},
+		{"yproxy.toml", "socket_path = \"/tmp/y.sock\"\nvacum = 1\n[storage]\nstorage_typo = \"fs\"\n[storage.credential_map.b1]\naccess_key = \"x\"\n"},
+		{"yproxy.json", `{"Socket_Path":"/tmp/y.sock","vacum":{},"storage":{"storage_typo":"fs","credential_map":{"b1":{"access_key":"x"}}}}`},
+	} {
+		keys, err := UnknownConfigKeys(writeTestConfig(t, c.name, c.content))
+		if err != nil {
+			t.Fatalf("%s: failed to check keys: %v", c.name, err)
+		}
+		expected := []string{"storage.credential_map.b1.access_key", "storage.storage_typo", "vacum"}
+		if !slices.Equal(keys, expected) {
+			t.Fatalf("%s: expected unknown keys %v, got %v", c.name, expected, keys)
+		}
+	}
+
+	/* yaml decoder matches keys exactly */
+	keys, err := UnknownConfigKeys(writeTestConfig(t, "yproxy.yaml", "Socket_Path: /tmp/y.sock\n"))
+	if err != nil {
+		t.Fatalf("failed to check keys: %v", err)
+	}
+	if !slices.Equal(keys, []string{"Socket_Path"}) {
+		t.Fatalf("expected case-sensitive yaml keys, got %v", keys)
+	}
+}
+
+func TestValidateDefaultConfig(t *testing.T) {
+	cfg := BuildInstance()
+	cfg.SocketPath = filepath.Join(t.TempDir(), "yproxy.sock")
+
+	if err := cfg.Validate().Err(); err != nil {
+		t.Fatalf("expected default config to be valid, got %v", err)
+	}
+
+	cfg.SocketPath = ""
+	if fields := issueFields(cfg.Validate(), false); !slices.Equal(fields, []string{"socket_path"}) {
+		t.Fatalf("expected missing socket path error, got %v", fields)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key")
+	if err := os.WriteFile(key, []byte("key"), 0644); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	cfg := BuildInstance()
+	cfg.SocketPath = filepath.Join(dir, "yproxy.sock")
+	cfg.InterconnectSocketPath = filepath.Join(dir, "missing", "ic.sock")
+	cfg.PsqlPort = cfg.StatPort
+	cfg.StorageCnf.StorageConcurrency = 0
+	cfg.StorageCnf.DownloadConcurrency = -1
+	cfg.StorageCnf.StorageBucket = "main"
+	cfg.StorageCnf.TablespaceMap = map[string]string{"ts1": "other", "ts2": "main", "ts3": "known"}
+	cfg.StorageCnf.CredentialMap = map[string]StorageCredentials{"known": {}, "unused": {}}
+	cfg.StorageCnf.EnableRateLimiter = true
+	cfg.StorageCnf.StorageRateLimit = 0
+	cfg.BackupStorageCnf.StorageType = "ftp"
+	cfg.CryptoCnf.GPGKeyPath = filepath.Join(dir, "missing.key")
+	cfg.CryptoCnf.Crypters = map[string]Crypter{
+		"fast": {Type: CrypterTypeAESGCM, KeyPath: key},
+		"bad":  {Type: CrypterTypeKMS},
+	}
+	cfg.CryptoCnf.TablespaceCrypters = map[string]string{"ts1": "fast", "ts2": "default", "ts3": "missing"}
+	cfg.VacuumCnf.TrashMoveWorkers = 0
+	cfg.ChecksumCnf = Checksum{Algorithm: "md5", OnMismatch: "ignore"}
+	cfg.QoSCnf = QoS{
+		Classes:        map[string]QoSClass{"bad": {Concurrency: -1}},
+		MessageClasses: map[string]string{"COPY": "missing", "CAT": "bad", "DELETE": QoSClassForeground},
+	}
+
+	issues := cfg.Validate()
+	errs := issueFields(issues, false)
+	for _, field := range []string{
+		"interconnect_socket_path",
+		"psql_port",
+		"storage.storage_concurrency",
+		"storage.download_concurrency",
+		"storage.storage_rate_limit",
+		"storage.tablespace_map.ts1",
+		"backup_storage.storage_type",
+		"crypto.gpg_key_path",
+		"crypto.crypters.bad",
+		"crypto.tablespace_crypter_map.ts3",
+		"vacuum.trash_move_workers",
+		"qos.classes.bad.concurrency",
+		"qos.message_classes.COPY",
+		"checksum.algorithm",
+		"checksum.on_mismatch",
+	} {
+		if !slices.Contains(errs, field) {
+			t.Fatalf("expected error for %s, got %v", field, issues)
+		}
+	}
+	for _, field := range []string{"storage.tablespace_map.ts2", "storage.tablespace_map.ts3", "crypto.tablespace_crypter_map.ts1", "crypto.tablespace_crypter_map.ts2", "qos.message_classes.CAT", "qos.message_classes.DELETE"} {
+		if slices.Contains(errs, field) {
+			t.Fatalf("unexpected error for %s: %v", field, issues)
+		}
+	}
+
+	warnings := issueFields(issues, true)
+	if !slices.Equal(warnings, []string{"storage.credential_map.unused", "crypto.crypters.fast.key_path"}) {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+	if err := issues.Err(); err == nil || strings.Contains(err.Error(), "warning") {
+		t.Fatalf("expected only errors to be joined, got %v", err)
+	}
+}
+
+func TestValidateSocketPath(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	cfg := BuildInstance()
+	cfg.SocketPath = file
+	cfg.InterconnectSocketPath = "/" + strings.Repeat("s", maxSocketPathLen)
+	fields := issueFields(cfg.Validate(), false)
+	if !slices.Equal(fields, []string{"socket_path", "interconnect_socket_path"}) {
+		t.Fatalf("expected socket path errors, got %v", fields)
+	}
+
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	cfg.SocketPath = filepath.Join(dir, "yproxy.sock")
+	cfg.InterconnectSocketPath = ""
+	if warnings := issueFields(cfg.Validate(), true); !slices.Equal(warnings, []string{"socket_path"}) {
+		t.Fatalf("expected world-writable socket directory warning, got %v", warnings)
+	}
+}
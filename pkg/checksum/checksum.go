@@ -0,0 +1,250 @@
+package checksum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+// Algorithm is checksum of object content, value is sent to clients in
+// PutComplete.
+type Algorithm byte
+
+const (
+	None = Algorithm(iota)
+	CRC32C
+	SHA256
+)
+
+/* marks sidecar of object whose stored content is encrypted */
+const encryptedMark = "encrypted"
+
+var ErrMismatch = errors.New("checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func (a Algorithm) String() string {
+	switch a {
+	case None:
+		return "none"
+	case CRC32C:
+		return config.ChecksumCRC32C
+	case SHA256:
+		return config.ChecksumSHA256
+	}
+	return fmt.Sprintf("checksum(%d)", byte(a))
+}
+
+// Parse returns algorithm by name, empty name means no checksum.
+func Parse(name string) (Algorithm, error) {
+	switch name {
+	case "":
+		return None, nil
+	case config.ChecksumCRC32C:
+		return CRC32C, nil
+	case config.ChecksumSHA256:
+		return SHA256, nil
+	}
+	return None, fmt.Errorf("unknown checksum algorithm %q", name)
+}
+
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case CRC32C:
+		return crc32.New(castagnoli)
+	case SHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+type Sum struct {
+	Algorithm Algorithm
+	Value     []byte
+}
+
+func (s Sum) String() string {
+	return s.Algorithm.String() + ":" + hex.EncodeToString(s.Value)
+}
+
+func (s Sum) Equal(o Sum) bool {
+	return s.Algorithm == o.Algorithm && bytes.Equal(s.Value, o.Value)
+}
+
+func ParseSum(text string) (Sum, error) {
+	name, value, ok := strings.Cut(strings.TrimSpace(text), ":")
+	if !ok {
+		return Sum{}, fmt.Errorf("malformed checksum %q", text)
+	}
+	alg, err := Parse(name)
+	if err != nil {
+		return Sum{}, err
+	}
+	if alg == None {
+		return Sum{}, fmt.Errorf("malformed checksum %q", text)
+	}
+	v, err := hex.DecodeString(value)
+	if err != nil {
+		return Sum{}, fmt.Errorf("malformed checksum %q: %w", text, err)
+	}
+	return Sum{Algorithm: alg, Value: v}, nil
+}
+
+// SidecarName returns name of object checksum of object name is stored in.
+func SidecarName(name string) string {
+	return storage.ChecksumSidecarName(name)
+}
+
+// Stored is checksum stored for object. Sum covers content as client sent
+// it, before compression and encryption.
+type Stored struct {
+	Sum Sum
+	// content is stored encrypted, so Sum covers only decrypted content
+	Encrypted bool
+}
+
+// Put stores checksum of object next to it, in the bucket setts resolve to.
+func Put(s storage.StorageWriter, name string, st Stored, setts []settings.StorageSettings) error {
+	text := st.Sum.String()
+	if st.Encrypted {
+		text += " " + encryptedMark
+	}
+	return s.PutFileToDest(SidecarName(name), strings.NewReader(text+"\n"), tablespaceSettings(setts))
+}
+
+// Get returns stored checksum of object.
+func Get(s storage.StorageReader, name string, setts []settings.StorageSettings) (Stored, error) {
+	r, err := s.CatFileFromStorage(SidecarName(name), 0, tablespaceSettings(setts))
+	if err != nil {
+		return Stored{}, err
+	}
+	defer func() { _ = r.Close() }()
+
+	/* sidecar is small, anything longer is not ours */
+	data, err := io.ReadAll(io.LimitReader(r, 1024))
+	if err != nil {
+		return Stored{}, err
+	}
+	text, mark, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	sum, err := ParseSum(text)
+	if err != nil {
+		return Stored{}, err
+	}
+	return Stored{Sum: sum, Encrypted: mark == encryptedMark}, nil
+}
+
+/* sidecar is written as small plain object, only its bucket matters */
+func tablespaceSettings(setts []settings.StorageSettings) []settings.StorageSettings {
+	for _, sett := range setts {
+		if sett.Name == message.TableSpaceSetting {
+			return []settings.StorageSettings{sett}
+		}
+	}
+	return nil
+}
+
+// Reader verifies content read through it against expected checksum. The
+// last byte of content is held back until checksum is verified, so strict
+// reader returns corrupted content truncated, followed by ErrMismatch.
+type Reader struct {
+	r      io.Reader
+	h      hash.Hash
+	want   Sum
+	strict bool
+
+	buf      []byte
+	out      []byte
+	held     bool
+	heldByte byte
+	err      error
+
+	mismatch bool
+	verified bool
+}
+
+func NewReader(r io.Reader, want Sum, strict bool) *Reader {
+	return &Reader{
+		r:      r,
+		h:      want.Algorithm.New(),
+		want:   want,
+		strict: strict,
+		buf:    make([]byte, 32*1024+1),
+	}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *Reader) fill() {
+	n, err := r.r.Read(r.buf[1:])
+	_, _ = r.h.Write(r.buf[1 : 1+n])
+
+	/* buf[0] keeps byte held back by previous fill */
+	content := r.buf[1 : 1+n]
+	if r.held {
+		r.buf[0] = r.heldByte
+		content = r.buf[:1+n]
+	}
+	r.held = len(content) > 0
+	if r.held {
+		r.heldByte = content[len(content)-1]
+		content = content[:len(content)-1]
+	}
+	r.out = content
+
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, io.EOF) {
+		r.err = err
+		return
+	}
+	r.err = io.EOF
+	if got := r.Sum(); !got.Equal(r.want) {
+		r.mismatch = true
+		if r.strict {
+			r.err = fmt.Errorf("%w: expected %s, got %s", ErrMismatch, r.want, got)
+			return
+		}
+	}
+	r.verified = !r.mismatch
+	if r.held {
+		r.out = r.out[:len(r.out)+1]
+		r.held = false
+	}
+}
+
+// Sum returns checksum of content read so far.
+func (r *Reader) Sum() Sum {
+	return Sum{Algorithm: r.want.Algorithm, Value: r.h.Sum(nil)}
+}
+
+// Verified tells whether content read up to EOF matches checksum.
+func (r *Reader) Verified() bool {
+	return r.verified
+}
+
+// Mismatch tells whether content read up to EOF does not match checksum.
+func (r *Reader) Mismatch() bool {
+	return r.mismatch
+}
@@ -0,0 +1,107 @@
+package checksum_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/checksum"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+func sumOf(t *testing.T, alg checksum.Algorithm, data []byte) checksum.Sum {
+	t.Helper()
+	h := alg.New()
+	_, err := h.Write(data)
+	require.NoError(t, err)
+	return checksum.Sum{Algorithm: alg, Value: h.Sum(nil)}
+}
+
+func TestParseSum(t *testing.T) {
+	sum := sumOf(t, checksum.SHA256, []byte("content"))
+	parsed, err := checksum.ParseSum(sum.String() + "\n")
+	require.NoError(t, err)
+	assert.True(t, sum.Equal(parsed))
+
+	for _, text := range []string{"", "crc32c", "none:00", "md5:00", "crc32c:zz"} {
+		_, err := checksum.ParseSum(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+
+	for _, alg := range []checksum.Algorithm{checksum.CRC32C, checksum.SHA256} {
+		want := sumOf(t, alg, data)
+
+		r := checksum.NewReader(iotest.OneByteReader(bytes.NewReader(data)), want, true)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+		assert.True(t, r.Verified())
+		assert.False(t, r.Mismatch())
+
+		corrupted := bytes.Clone(data)
+		corrupted[len(corrupted)-1] ^= 1
+
+		r = checksum.NewReader(bytes.NewReader(corrupted), want, true)
+		got, err = io.ReadAll(r)
+		assert.True(t, errors.Is(err, checksum.ErrMismatch))
+		assert.Equal(t, corrupted[:len(corrupted)-1], got)
+		assert.True(t, r.Mismatch())
+		assert.False(t, r.Verified())
+
+		r = checksum.NewReader(bytes.NewReader(corrupted), want, false)
+		got, err = io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, corrupted, got)
+		assert.True(t, r.Mismatch())
+	}
+}
+
+func TestReaderEmpty(t *testing.T) {
+	r := checksum.NewReader(bytes.NewReader(nil), sumOf(t, checksum.CRC32C, nil), true)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	assert.True(t, r.Verified())
+}
+
+func TestSidecar(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "storage") + string(os.PathSeparator)
+	require.NoError(t, os.MkdirAll(root, 0700))
+
+	s, err := storage.NewStorage(&config.Storage{
+		StorageType:   "fs",
+		StoragePrefix: root,
+	}, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/yproxy_checksums/seg/1", checksum.SidecarName("/seg/1"))
+	assert.Equal(t, "yproxy_checksums/seg/1", checksum.SidecarName("seg/1"))
+
+	_, err = checksum.Get(s, "/seg/1", nil)
+	assert.Error(t, err)
+
+	sum := sumOf(t, checksum.CRC32C, []byte("content"))
+	require.NoError(t, checksum.Put(s, "/seg/1", checksum.Stored{Sum: sum}, nil))
+
+	got, err := checksum.Get(s, "/seg/1", nil)
+	require.NoError(t, err)
+	assert.True(t, sum.Equal(got.Sum))
+	assert.False(t, got.Encrypted)
+
+	require.NoError(t, checksum.Put(s, "/seg/2", checksum.Stored{Sum: sum, Encrypted: true}, nil))
+	got, err = checksum.Get(s, "/seg/2", nil)
+	require.NoError(t, err)
+	assert.True(t, sum.Equal(got.Sum))
+	assert.True(t, got.Encrypted)
+}
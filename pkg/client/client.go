@@ -26,6 +26,9 @@ type YproxyClient interface {
 	SetExternalFilePath(path string)
 	ExternalFilePath() string
 
+	/* Finish request served in session, clear its state */
+	ResetRequest()
+
 	Close() error
 }
 
@@ -36,6 +39,9 @@ type YClient struct {
 	path    string
 
 	progress int64
+
+	/* called with state of every request finished in session */
+	OnRequestDone func(YproxyClient)
 }
 
 // ByteOffset implements YproxyClient.
@@ -72,6 +78,17 @@ func (y *YClient) SetOPType(optype message.MessageType) {
 	y.opstart = time.Now()
 }
 
+// ResetRequest implements YproxyClient.
+func (y *YClient) ResetRequest() {
+	if y.OnRequestDone != nil {
+		y.OnRequestDone(y)
+	}
+	y.op = 0
+	y.opstart = time.Time{}
+	y.path = ""
+	y.progress = 0
+}
+
 // Close implements YproxyClient.
 func (y *YClient) Close() error {
 	return y.Conn.Close()
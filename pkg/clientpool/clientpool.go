@@ -22,6 +22,8 @@ type Pool interface {
 
 	Put(client client.YproxyClient) error
 	Pop(id uint) (bool, error)
+	/* Record speed of operation client is serving */
+	Record(client client.YproxyClient)
 
 	Quantile(ct int, q []float64) []QuantInfo
 
@@ -78,26 +80,37 @@ func (c *PoolImpl) Quantile(ct int, q []float64) []QuantInfo {
 	return ret
 }
 
-func (c *PoolImpl) Pop(id uint) (bool, error) {
+func (c *PoolImpl) Record(cl client.YproxyClient) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	cl, ok := c.pool[id]
-	if ok {
+	c.record(cl)
+}
 
-		total := cl.ByteOffset()
-		if total > 0 {
-			ct := SizeToCat(total)
-			timeTotal := time.Since(cl.OPStart()).Nanoseconds()
+func (c *PoolImpl) record(cl client.YproxyClient) {
+	total := cl.ByteOffset()
+	if total <= 0 {
+		return
+	}
+	ct := SizeToCat(total)
+	timeTotal := time.Since(cl.OPStart()).Nanoseconds()
 
-			optyp := cl.OPType().String()
-			if c.opSpeed[ct][optyp] == nil {
-				c.opSpeed[ct][optyp], _ = tdigest.New()
-			}
+	optyp := cl.OPType().String()
+	if c.opSpeed[ct][optyp] == nil {
+		c.opSpeed[ct][optyp], _ = tdigest.New()
+	}
 
-			_ = c.opSpeed[ct][optyp].Add(float64(total) / float64(timeTotal))
-			metrics.StoreLatencyAndSizeInfo(cl.OPType().String(), float64(total), float64(timeTotal))
-		}
+	_ = c.opSpeed[ct][optyp].Add(float64(total) / float64(timeTotal))
+	metrics.StoreLatencyAndSizeInfo(optyp, float64(total), float64(timeTotal))
+}
+
+func (c *PoolImpl) Pop(id uint) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cl, ok := c.pool[id]
+	if ok {
+		c.record(cl)
 
 		delete(c.pool, id)
 		/* be conservative */
@@ -0,0 +1,155 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/DataDog/zstd"
+)
+
+// Codec compresses object content before it is encrypted and uploaded.
+type Codec byte
+
+const (
+	None = Codec(iota)
+	Zstd
+	LZ4
+)
+
+// HeaderSize is size of header compressed object content starts with.
+const HeaderSize = 8
+
+/* magic, codec, 3 reserved bytes */
+var headerMagic = []byte{0, 'Y', 'P', 'Z'}
+
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+func (c Codec) String() string {
+	switch c {
+	case None:
+		return "none"
+	case Zstd:
+		return "zstd"
+	case LZ4:
+		return "lz4"
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+// Parse returns codec by name, empty name means no compression.
+func Parse(name string) (Codec, error) {
+	switch name {
+	case "", "none":
+		return None, nil
+	case "zstd":
+		return Zstd, nil
+	case "lz4":
+		return LZ4, nil
+	}
+	return None, fmt.Errorf("%w %q", ErrUnknownCodec, name)
+}
+
+// ParseHeader returns codec of content starting with header.
+func ParseHeader(header []byte) (Codec, bool) {
+	if len(header) < HeaderSize || !bytes.Equal(header[:len(headerMagic)], headerMagic) {
+		return None, false
+	}
+	return Codec(header[len(headerMagic)]), true
+}
+
+func header(c Codec) []byte {
+	h := make([]byte, HeaderSize)
+	copy(h, headerMagic)
+	h[len(headerMagic)] = byte(c)
+	return h
+}
+
+// Writer compresses content written to it. Close flushes compressed
+// content, underlying writer is not closed.
+type Writer struct {
+	enc   io.WriteCloser
+	out   *countingWriter
+	in    int64
+	codec Codec
+}
+
+func NewWriter(w io.Writer, c Codec) (*Writer, error) {
+	out := &countingWriter{w: w}
+	if _, err := out.Write(header(c)); err != nil {
+		return nil, err
+	}
+
+	cw := &Writer{out: out, codec: c}
+	switch c {
+	case Zstd:
+		cw.enc = zstd.NewWriterLevel(out, zstd.DefaultCompression)
+	case LZ4:
+		cw.enc = newLZ4Writer(out)
+	default:
+		return nil, fmt.Errorf("%w %s", ErrUnknownCodec, c)
+	}
+	return cw, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.enc.Write(p)
+	w.in += int64(n)
+	return n, err
+}
+
+func (w *Writer) Close() error {
+	return w.enc.Close()
+}
+
+func (w *Writer) Codec() Codec {
+	return w.codec
+}
+
+// InputBytes returns size of content written.
+func (w *Writer) InputBytes() int64 {
+	return w.in
+}
+
+// OutputBytes returns size of compressed content including header.
+func (w *Writer) OutputBytes() int64 {
+	return w.out.n
+}
+
+// NewReader decompresses content starting with codec header, content
+// without one is returned as is.
+func NewReader(r io.Reader) (io.ReadCloser, Codec, error) {
+	br := bufio.NewReaderSize(r, HeaderSize)
+	h, err := br.Peek(HeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, None, err
+	}
+	c, ok := ParseHeader(h)
+	if !ok {
+		return io.NopCloser(br), None, nil
+	}
+	if _, err := br.Discard(HeaderSize); err != nil {
+		return nil, None, err
+	}
+
+	switch c {
+	case Zstd:
+		return zstd.NewReader(br), c, nil
+	case LZ4:
+		return io.NopCloser(newLZ4Reader(br)), c, nil
+	}
+	return nil, None, fmt.Errorf("%w %s", ErrUnknownCodec, c)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
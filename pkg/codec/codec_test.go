@@ -0,0 +1,84 @@
+package codec_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/pkg/codec"
+)
+
+func TestRoundTrip(t *testing.T) {
+	content := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 100000))
+
+	for _, c := range []codec.Codec{codec.Zstd, codec.LZ4} {
+		t.Run(c.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := codec.NewWriter(&buf, c)
+			require.NoError(t, err)
+			/* uneven writes cross lz4 block boundaries */
+			for rest := content; len(rest) > 0; {
+				n := min(len(rest), 300007)
+				_, err := w.Write(rest[:n])
+				require.NoError(t, err)
+				rest = rest[n:]
+			}
+			require.NoError(t, w.Close())
+
+			assert.Equal(t, int64(len(content)), w.InputBytes())
+			assert.Equal(t, int64(buf.Len()), w.OutputBytes())
+			assert.Less(t, w.OutputBytes(), w.InputBytes()/10)
+
+			got, ok := codec.ParseHeader(buf.Bytes())
+			require.True(t, ok)
+			assert.Equal(t, c, got)
+
+			r, got, err := codec.NewReader(&buf)
+			require.NoError(t, err)
+			defer func() { _ = r.Close() }()
+			assert.Equal(t, c, got)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, content, data)
+		})
+	}
+}
+
+func TestReaderPassthrough(t *testing.T) {
+	for _, content := range []string{"", "abc", "plain object content"} {
+		r, c, err := codec.NewReader(strings.NewReader(content))
+		require.NoError(t, err)
+		assert.Equal(t, codec.None, c)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+}
+
+func TestParse(t *testing.T) {
+	for name, want := range map[string]codec.Codec{"": codec.None, "none": codec.None, "zstd": codec.Zstd, "lz4": codec.LZ4} {
+		c, err := codec.Parse(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, c)
+	}
+	_, err := codec.Parse("brotli")
+	assert.ErrorIs(t, err, codec.ErrUnknownCodec)
+}
+
+func TestCorruptLZ4(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := codec.NewWriter(&buf, codec.LZ4)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(strings.Repeat("abc", 1000)))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, _, err := codec.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-5]))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	lz4 "github.com/bkaradzic/go-lz4"
+)
+
+/* lz4 content is sequence of blocks, each prefixed by its compressed size */
+const lz4BlockSize = 1 << 20
+
+var errCorruptLZ4 = errors.New("corrupt lz4 block")
+
+type lz4Writer struct {
+	w   io.Writer
+	buf []byte
+	enc []byte
+}
+
+func newLZ4Writer(w io.Writer) *lz4Writer {
+	return &lz4Writer{w: w, buf: make([]byte, 0, lz4BlockSize)}
+}
+
+func (w *lz4Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), lz4BlockSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == lz4BlockSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *lz4Writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	enc, err := lz4.Encode(w.enc, w.buf)
+	if err != nil {
+		return err
+	}
+	w.enc = enc[:cap(enc)]
+	w.buf = w.buf[:0]
+
+	size := binary.BigEndian.AppendUint32(nil, uint32(len(enc)))
+	if _, err := w.w.Write(size); err != nil {
+		return err
+	}
+	_, err = w.w.Write(enc)
+	return err
+}
+
+func (w *lz4Writer) Close() error {
+	return w.flush()
+}
+
+type lz4Reader struct {
+	r     io.Reader
+	block []byte
+	buf   []byte
+	dec   []byte
+}
+
+func newLZ4Reader(r io.Reader) *lz4Reader {
+	return &lz4Reader{r: r}
+}
+
+func (r *lz4Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *lz4Reader) next() error {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, size); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return errCorruptLZ4
+		}
+		return err
+	}
+	n := binary.BigEndian.Uint32(size)
+	if n > uint32(lz4.CompressBound(lz4BlockSize)) {
+		return fmt.Errorf("%w: size %d", errCorruptLZ4, n)
+	}
+
+	if cap(r.block) < int(n) {
+		r.block = make([]byte, n)
+	}
+	r.block = r.block[:n]
+	if _, err := io.ReadFull(r.r, r.block); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errCorruptLZ4
+		}
+		return err
+	}
+
+	/* block starts with its decompressed size */
+	if n < 4 || binary.LittleEndian.Uint32(r.block) > lz4BlockSize {
+		return errCorruptLZ4
+	}
+	dec, err := lz4.Decode(r.dec, r.block)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errCorruptLZ4, err)
+	}
+	r.dec = dec[:cap(dec)]
+	r.buf = dec
+	return nil
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/qos"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+// CheckConfig validates config read from cfgPath. Besides checks done on
+// start, crypters are built to verify key contents, and storages are probed
+// for reachability when probe is set.
+func CheckConfig(cfgPath string, cnf *config.Instance, probe bool) config.Issues {
+	issues := config.CheckInstanceConfig(cfgPath, cnf)
+
+	/* key files are read only when they passed checks */
+	if issues.Err() == nil && (cnf.CryptoCnf.GPGKeyPath != "" || len(cnf.CryptoCnf.Crypters) != 0) {
+		if _, err := crypt.NewRegistry(&cnf.CryptoCnf); err != nil {
+			issues = append(issues, config.Issue{Field: "crypto", Message: err.Error()})
+		}
+	}
+
+	if issues.Err() == nil {
+		if _, err := qos.NewManager(&cnf.QoSCnf); err != nil {
+			issues = append(issues, config.Issue{Field: "qos", Message: err.Error()})
+		}
+	}
+
+	if probe {
+		if err := storage.Probe(&cnf.StorageCnf, "yezzey"); err != nil {
+			issues = append(issues, config.Issue{Field: "storage", Message: fmt.Sprintf("probe failed: %v", err)})
+		}
+		/* backup storage is optional */
+		if !reflect.DeepEqual(cnf.BackupStorageCnf, *config.BuildBackupStorage()) {
+			if err := storage.Probe(&cnf.BackupStorageCnf, "backup"); err != nil {
+				issues = append(issues, config.Issue{Field: "backup_storage", Message: fmt.Sprintf("probe failed: %v", err)})
+			}
+		}
+	}
+	return issues
+}
+
+// CheckStartupConfig validates config loaded from cfgPath before it is
+// applied. Warnings are logged, errors are returned.
+func CheckStartupConfig(cfgPath string, cnf *config.Instance) error {
+	issues := config.CheckInstanceConfig(cfgPath, cnf)
+	for _, i := range issues {
+		if i.Warning {
+			ylogger.Zero.Warn().Str("setting", i.Field).Msg(i.Message)
+		}
+	}
+	return issues.Err()
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -18,14 +18,13 @@ import (
 	"github.com/yezzey-gp/yproxy/pkg/client"
 	"github.com/yezzey-gp/yproxy/pkg/clientpool"
 	"github.com/yezzey-gp/yproxy/pkg/core/pg"
-	"github.com/yezzey-gp/yproxy/pkg/crypt"
 	pio "github.com/yezzey-gp/yproxy/pkg/io"
 	"github.com/yezzey-gp/yproxy/pkg/message"
 	"github.com/yezzey-gp/yproxy/pkg/metrics"
 	"github.com/yezzey-gp/yproxy/pkg/proc"
 	"github.com/yezzey-gp/yproxy/pkg/proto"
+	"github.com/yezzey-gp/yproxy/pkg/qos"
 	"github.com/yezzey-gp/yproxy/pkg/sdnotifier"
-	"github.com/yezzey-gp/yproxy/pkg/storage"
 	"github.com/yezzey-gp/yproxy/pkg/ylogger"
 )
 
@@ -35,6 +34,10 @@ type Instance struct {
 	startTs time.Time
 
 	ProtoMgr proto.ProtoMgr
+
+	/* storages and crypters used by new connections, swapped on reload */
+	runtime  atomic.Pointer[runtime]
+	reloadMu sync.Mutex
 }
 
 func NewInstance() *Instance {
@@ -72,6 +75,23 @@ func (instance *Instance) Run(instanceCnf *config.Instance) error {
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
 
+	rt, err := newRuntime(instanceCnf, nil)
+	if err != nil {
+		ylogger.Zero.Error().Err(err).Msg("failed to configure storages and crypters")
+		return err
+	}
+	qos.SetManager(rt.qos)
+	instance.runtime.Store(rt)
+	go instance.runUploadJanitor(ctx)
+
+	notifier, err := sdnotifier.NewNotifier(instanceCnf.GetSystemdSocketPath(), instanceCnf.SystemdNotificationsDebug)
+	if err != nil {
+		ylogger.Zero.Error().Err(err).Msg("failed to initialize systemd notifier")
+		if instanceCnf.SystemdNotificationsDebug {
+			return err
+		}
+	}
+
 	var listener net.Listener
 	var iclistener net.Listener
 	var dws *DebugWebServer
@@ -86,18 +106,19 @@ func (instance *Instance) Run(instanceCnf *config.Instance) error {
 
 			switch s {
 			case syscall.SIGUSR1:
-				instanceCnf, err := config.ReloadInstanceConfig()
-
-				if err != nil {
-					log.Printf("failed to update log level: %s\n", err)
-					continue
+				_ = notifier.Reloading()
+				if err := instance.Reload(); err != nil {
+					ylogger.Zero.Error().Err(err).Msg("failed to reload config, keep running with previous one")
+					_ = notifier.Status(fmt.Sprintf("config reload failed: %v", err))
+				} else {
+					ylogger.Zero.Info().Msg("config reloaded")
+					_ = notifier.Status("config reloaded")
 				}
-
-				ylogger.ReloadLogger(instanceCnf.LogPath, instanceCnf.LogLevel)
+				_ = notifier.Ready()
 
 			case syscall.SIGHUP:
 				if dws != nil {
-					err := dws.ServeFor(time.Duration(instanceCnf.DebugMinutes) * time.Minute)
+					err := dws.ServeFor(time.Duration(config.InstanceConfig().DebugMinutes) * time.Minute)
 					if err != nil {
 						ylogger.Zero.Error().Err(err).Msg("Error in debug server")
 					}
@@ -161,16 +182,6 @@ func (instance *Instance) Run(instanceCnf *config.Instance) error {
 		}
 	}
 
-	s, err := storage.NewStorage(&instanceCnf.StorageCnf, "yezzey")
-	if err != nil {
-		return err
-	}
-
-	bs, err := storage.NewStorage(&instanceCnf.BackupStorageCnf, "backup")
-	if err != nil {
-		return err
-	}
-
 	if instanceCnf.PsqlPort != 0 {
 		config := &net.ListenConfig{Control: reusePort}
 		psqlListener, err := config.Listen(context.Background(), "tcp", fmt.Sprintf("localhost:%v", instanceCnf.PsqlPort))
@@ -180,7 +191,8 @@ func (instance *Instance) Run(instanceCnf *config.Instance) error {
 		}
 
 		instance.DispatchServer(psqlListener, func(c net.Conn) {
-			pg.PostgresIface(c, instance.pool, instance.startTs, s)
+			rt := instance.runtime.Load()
+			pg.PostgresIface(c, instance.pool, instance.startTs, rt.storage, rt.crypter)
 		})
 	}
 
@@ -204,16 +216,12 @@ func (instance *Instance) Run(instanceCnf *config.Instance) error {
 	}
 	ylogger.Zero.Info().Str("socket", instanceCnf.SocketPath).Msg("yproxy is listening unix socket")
 
-	var cr crypt.Crypter = nil
-	if instanceCnf.CryptoCnf.GPGKeyPath != "" {
-		cr, err = crypt.NewCrypto(&instanceCnf.CryptoCnf)
-	}
-
 	instance.DispatchServer(listener, func(clConn net.Conn) {
 		activeConnections.Add(1)
 		defer activeConnections.Done()
 		defer func() { _ = clConn.Close() }()
-		ycl := client.NewYClient(clConn)
+		rt := instance.runtime.Load()
+		ycl := &client.YClient{Conn: clConn, OnRequestDone: instance.pool.Record}
 		if err := instance.pool.Put(ycl); err != nil {
 			// This check is useless, but it's need to avoid violating the contract
 			// and making a mistake the below.
@@ -221,7 +229,7 @@ func (instance *Instance) Run(instanceCnf *config.Instance) error {
 			ylogger.Zero.Warn().Uint("id", ycl.ID()).Err(err).Msg("error putting client to pool")
 		}
 
-		if err := proc.ProcConn(instance.ProtoMgr, s, bs, cr, ycl, &instanceCnf.VacuumCnf); err != nil {
+		if err := proc.ProcConn(instance.ProtoMgr, rt.storage, rt.backupStorage, rt.crypter, ycl, rt.vacuumCnf()); err != nil {
 			ylogger.Zero.Warn().Uint("id", ycl.ID()).Err(err).Msg("error serving client")
 		}
 		if _, err := instance.pool.Pop(ycl.ID()); err != nil {
@@ -275,13 +283,6 @@ func (instance *Instance) Run(instanceCnf *config.Instance) error {
 		ylogger.Zero.Debug().Msg("interconnection closed")
 	})
 
-	notifier, err := sdnotifier.NewNotifier(instanceCnf.GetSystemdSocketPath(), instanceCnf.SystemdNotificationsDebug)
-	if err != nil {
-		ylogger.Zero.Error().Err(err).Msg("failed to initialize systemd notifier")
-		if instanceCnf.SystemdNotificationsDebug {
-			return err
-		}
-	}
 	_ = notifier.Ready()
 
 	go func() {
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+/* how often disabled janitor checks whether it was enabled by reload */
+const uploadJanitorIdlePoll = time.Minute
+
+// runUploadJanitor aborts stale multipart uploads of storage until ctx is
+// done. Settings and storage are taken from current runtime on every run,
+// so janitor follows config reloads.
+func (instance *Instance) runUploadJanitor(ctx context.Context) {
+	for {
+		rt := instance.runtime.Load()
+		cnf := rt.cnf.UploadJanitorCnf
+
+		wait := cnf.Interval
+		if wait <= 0 {
+			wait = uploadJanitorIdlePoll
+		} else {
+			cleanStaleUploads(rt.storage, &cnf, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// cleanStaleUploads aborts multipart uploads of every storage bucket
+// started before max age from now. Uploads in progress are kept.
+func cleanStaleUploads(s storage.StorageInteractor, cnf *config.UploadJanitor, now time.Time) {
+	metrics.UploadJanitorRuns.Inc()
+	before := now.Add(-cnf.GetMaxAge())
+
+	/* tablespaces may share bucket */
+	for _, bucket := range slices.Compact(slices.Sorted(slices.Values(s.ListBuckets()))) {
+		uploads, err := storage.ListStaleMultipartUploads(s, bucket, before)
+		if err != nil {
+			metrics.UploadJanitorErrors.Inc()
+			ylogger.Zero.Error().Err(err).Str("bucket", bucket).Msg("failed to list stale multipart uploads")
+			continue
+		}
+		metrics.UploadJanitorStale.Add(float64(len(uploads)))
+
+		for key, uploadId := range uploads {
+			if cnf.DryRun {
+				ylogger.Zero.Info().Str("bucket", bucket).Str("key", key).Str("uploadId", uploadId).Msg("stale multipart upload would be aborted")
+				continue
+			}
+			if err := s.AbortMultipartUpload(bucket, key, uploadId); err != nil {
+				metrics.UploadJanitorErrors.Inc()
+				ylogger.Zero.Error().Err(err).Str("bucket", bucket).Str("key", key).Str("uploadId", uploadId).Msg("failed to abort stale multipart upload")
+				continue
+			}
+			metrics.UploadJanitorAborted.Inc()
+			ylogger.Zero.Info().Str("bucket", bucket).Str("key", key).Str("uploadId", uploadId).Msg("stale multipart upload aborted")
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	mock "github.com/yezzey-gp/yproxy/pkg/mock"
+)
+
+type staleUploadsStorage struct {
+	*mock.MockStorageInteractor
+	stale  map[string]map[string]string
+	before []time.Time
+}
+
+func (s *staleUploadsStorage) ListStaleMultipartUploads(bucket string, before time.Time) (map[string]string, error) {
+	s.before = append(s.before, before)
+	uploads, ok := s.stale[bucket]
+	if !ok {
+		return nil, errors.New("access denied")
+	}
+	return uploads, nil
+}
+
+func TestCleanStaleUploads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	now := time.Now()
+
+	s := &staleUploadsStorage{
+		MockStorageInteractor: mock.NewMockStorageInteractor(ctrl),
+		stale: map[string]map[string]string{
+			"b1": {"seg/1": "u1", "seg/2": "u2"},
+		},
+	}
+	s.EXPECT().ListBuckets().Return([]string{"b1", "b2", "b1"}).Times(2)
+
+	/* dry run only counts uploads */
+	aborted := testutil.ToFloat64(metrics.UploadJanitorAborted)
+	errs := testutil.ToFloat64(metrics.UploadJanitorErrors)
+	cleanStaleUploads(s, &config.UploadJanitor{DryRun: true}, now)
+	assert.Equal(t, []time.Time{now.Add(-config.DefaultUploadJanitorMaxAge), now.Add(-config.DefaultUploadJanitorMaxAge)}, s.before)
+	assert.Equal(t, aborted, testutil.ToFloat64(metrics.UploadJanitorAborted))
+	assert.Equal(t, errs+1, testutil.ToFloat64(metrics.UploadJanitorErrors))
+
+	s.EXPECT().AbortMultipartUpload("b1", "seg/1", "u1").Return(nil)
+	s.EXPECT().AbortMultipartUpload("b1", "seg/2", "u2").Return(errors.New("no such upload"))
+	cleanStaleUploads(s, &config.UploadJanitor{MaxAge: time.Hour}, now)
+	assert.Equal(t, now.Add(-time.Hour), s.before[len(s.before)-1])
+	assert.Equal(t, aborted+1, testutil.ToFloat64(metrics.UploadJanitorAborted))
+	assert.Equal(t, errs+3, testutil.ToFloat64(metrics.UploadJanitorErrors))
+}
@@ -228,7 +228,7 @@ tr10:
  lval.str = string(lex.data[lex.ts:lex.te]); tok = SYSTEM; {( lex.p)++;  lex.cs = 9; goto _out }}
 	case 16:
 	{( lex.p) = ( lex.te) - 1
- lval.str = string(lex.data[lex.ts:lex.te]); tok = IDENT; {( lex.p)++;  lex.cs = 9; goto _out }}
+ lval.str = string(lex.data[lex.ts:lex.te]); tok = identToken(lval.str); {( lex.p)++;  lex.cs = 9; goto _out }}
 	}
 	
 	goto st9
@@ -286,7 +286,7 @@ tr36:
 //line lex.rl:113
  lex.te = ( lex.p)
 ( lex.p)--
-{ lval.str = string(lex.data[lex.ts:lex.te]); tok = IDENT; {( lex.p)++;  lex.cs = 9; goto _out }}
+{ lval.str = string(lex.data[lex.ts:lex.te]); tok = identToken(lval.str); {( lex.p)++;  lex.cs = 9; goto _out }}
 	goto st9
 	st9:
 //line NONE:1
@@ -1802,7 +1802,7 @@ tr72:
 	_out: {}
 	}
 
-//line lex.rl:127
+//line lex.rl:124
 
 
     return int(tok);
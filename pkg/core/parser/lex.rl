@@ -110,7 +110,7 @@ func (lex *Lexer) Lex(lval *yySymType) int {
             /SYSTEM/i => { lval.str = string(lex.data[lex.ts:lex.te]); tok = SYSTEM; fbreak;};
 
             qidentifier      => { lval.str = string(lex.data[lex.ts + 1:lex.te - 1]); tok = IDENT; fbreak;};
-            identifier      => { lval.str = string(lex.data[lex.ts:lex.te]); tok = IDENT; fbreak;};
+            identifier      => { lval.str = string(lex.data[lex.ts:lex.te]); tok = identToken(lval.str); fbreak;};
             sconst      => { lval.str = string(lex.data[lex.ts + 1:lex.te - 1]); tok = SCONST; fbreak;};
 
             '=' => { lval.str = string(lex.data[lex.ts:lex.te]); tok = TEQ; fbreak;};
@@ -118,9 +118,6 @@ func (lex *Lexer) Lex(lval *yySymType) int {
             ')' => { lval.str = string(lex.data[lex.ts:lex.te]); tok = TCLOSEBR; fbreak;};
             ',' => { lval.str = string(lex.data[lex.ts:lex.te]); tok = TCOMMA; fbreak;};
 
-            /true/i => { lval.str = string(lex.data[lex.ts:lex.te]); tok = TRUE_P; fbreak;};
-            /false/i => { lval.str = string(lex.data[lex.ts:lex.te]); tok = FALSE_P; fbreak;};
-
         *|;
 
         write exec;
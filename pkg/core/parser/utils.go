@@ -3,6 +3,7 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // Tokenizer is the struct used to generate SQL
@@ -40,6 +41,18 @@ func (t *Tokenizer) Reset(sql string) {
 	ResetLexer(t.l, []byte(sql))
 }
 
+// identToken returns the keyword token for unquoted
+// identifiers spelling boolean constants, IDENT otherwise.
+func identToken(ident string) int {
+	switch strings.ToLower(ident) {
+	case "true":
+		return TRUE_P
+	case "false":
+		return FALSE_P
+	}
+	return IDENT
+}
+
 func setParseTree(yylex interface{}, stmt Node) {
 	yylex.(*Tokenizer).ParseTree = stmt
 }
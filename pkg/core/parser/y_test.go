@@ -53,6 +53,28 @@ func TestParse(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			query: `COPY '/prefix' WITH (PORT 6002, RESUME TRUE)`,
+			exp: &parser.CopyCommand{
+				Path: "/prefix",
+				Options: []parser.Node{
+					&parser.Option{Name: "PORT", Arg: &parser.AExprIConst{Value: 6002}},
+					&parser.Option{Name: "RESUME", Arg: &parser.AExprBConst{Value: true}},
+				},
+			},
+			err: nil,
+		},
+		{
+			query: `copy '/prefix' with (verify false, resume True)`,
+			exp: &parser.CopyCommand{
+				Path: "/prefix",
+				Options: []parser.Node{
+					&parser.Option{Name: "verify", Arg: &parser.AExprBConst{Value: false}},
+					&parser.Option{Name: "resume", Arg: &parser.AExprBConst{Value: true}},
+				},
+			},
+			err: nil,
+		},
 	} {
 		tmp, err := parser.Parse(tt.query)
 
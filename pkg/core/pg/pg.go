@@ -12,12 +12,15 @@ import (
 	"github.com/yezzey-gp/yproxy/pkg/client"
 	"github.com/yezzey-gp/yproxy/pkg/clientpool"
 	"github.com/yezzey-gp/yproxy/pkg/core/parser"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	pio "github.com/yezzey-gp/yproxy/pkg/io"
+	"github.com/yezzey-gp/yproxy/pkg/message"
 	"github.com/yezzey-gp/yproxy/pkg/proc"
 	"github.com/yezzey-gp/yproxy/pkg/storage"
 	"github.com/yezzey-gp/yproxy/pkg/ylogger"
 )
 
-func PostgresIface(cl net.Conn, p clientpool.Pool, instanceStart time.Time, s storage.StorageInteractor) {
+func PostgresIface(cl net.Conn, p clientpool.Pool, instanceStart time.Time, s storage.StorageInteractor, cr crypt.Crypter) {
 	defer func() { _ = cl.Close() }()
 
 	conn := pgproto3.NewBackend(cl, cl)
@@ -112,17 +115,59 @@ init:
 			case *parser.CopyCommand:
 				port := 6000
 				oldCfgPath := "/etc/yproxy/yproxy.yaml"
+				resume := false
+				verify := false
+				confirm := false
+				decrypt := false
+				encrypt := false
+				var optErr error
 				for _, optNode := range q.Options {
 					opt := optNode.(*parser.Option)
 					switch strings.ToLower(opt.Name) {
 					case "config":
-						oldCfgPath = opt.Arg.(*parser.AExprSConst).Value
+						oldCfgPath, optErr = stringOption(opt)
 					case "port":
-						port = opt.Arg.(*parser.AExprIConst).Value
+						port, optErr = intOption(opt)
+					case "resume":
+						resume, optErr = boolOption(opt)
+					case "verify":
+						verify, optErr = boolOption(opt)
+					case "confirm":
+						confirm, optErr = boolOption(opt)
+					case "decrypt":
+						decrypt, optErr = boolOption(opt)
+					case "encrypt":
+						encrypt, optErr = boolOption(opt)
 					}
+					if optErr != nil {
+						break
+					}
+				}
+				if optErr == nil && confirm && !verify {
+					optErr = fmt.Errorf("option confirm requires verify")
+				}
+				if optErr != nil {
+					conn.Send(&pgproto3.ErrorResponse{
+						Message: optErr.Error(),
+					})
+					conn.Send(&pgproto3.ReadyForQuery{
+						TxStatus: 'I',
+					})
+					_ = conn.Flush()
+					continue
+				}
+				if verify {
+					err = ProcessCopyVerify(conn, q.Path, uint64(port), oldCfgPath, confirm, resume, decrypt, encrypt, s, cr)
+				} else {
+					err = ProcessCopy(conn, q.Path, uint64(port), oldCfgPath, resume, s)
+				}
+				if err != nil {
+					conn.Send(&pgproto3.ErrorResponse{
+						Message: err.Error(),
+					})
+				} else {
+					conn.Send(&pgproto3.CommandComplete{CommandTag: []byte("COPY")})
 				}
-				_ = ProcessCopy(conn, q.Path, uint64(port), oldCfgPath, s)
-				conn.Send(&pgproto3.CommandComplete{CommandTag: []byte("COPY")})
 
 				conn.Send(&pgproto3.ReadyForQuery{
 					TxStatus: 'I',
@@ -169,6 +214,27 @@ init:
 	}
 }
 
+func stringOption(opt *parser.Option) (string, error) {
+	if arg, ok := opt.Arg.(*parser.AExprSConst); ok {
+		return arg.Value, nil
+	}
+	return "", fmt.Errorf("option %s expects a string argument", opt.Name)
+}
+
+func intOption(opt *parser.Option) (int, error) {
+	if arg, ok := opt.Arg.(*parser.AExprIConst); ok {
+		return arg.Value, nil
+	}
+	return 0, fmt.Errorf("option %s expects an integer argument", opt.Name)
+}
+
+func boolOption(opt *parser.Option) (bool, error) {
+	if arg, ok := opt.Arg.(*parser.AExprBConst); ok {
+		return arg.Value, nil
+	}
+	return false, fmt.Errorf("option %s expects a boolean argument", opt.Name)
+}
+
 func quantToString(ct int) string {
 	switch ct {
 	case 0:
@@ -350,7 +416,7 @@ func ProcessShow(conn *pgproto3.Backend, s string, p clientpool.Pool, instanceSt
 	}
 }
 
-func ProcessCopy(conn *pgproto3.Backend, prefix string, port uint64, oldCfgPath string, s storage.StorageInteractor) error {
+func ProcessCopy(conn *pgproto3.Backend, prefix string, port uint64, oldCfgPath string, resume bool, s storage.StorageInteractor) error {
 	conn.Send(&pgproto3.RowDescription{
 		Fields: []pgproto3.FieldDescription{
 			{
@@ -371,7 +437,7 @@ func ProcessCopy(conn *pgproto3.Backend, prefix string, port uint64, oldCfgPath
 	// get config for old bucket
 	instanceCnf, err := config.ReadInstanceConfig(oldCfgPath)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	oldStorage, err := storage.NewStorage(&instanceCnf.StorageCnf, "")
@@ -379,7 +445,19 @@ func ProcessCopy(conn *pgproto3.Backend, prefix string, port uint64, oldCfgPath
 		return err
 	}
 
-	objects, skipped, err := proc.ListFilesToCopy(prefix, port, instanceCnf.StorageCnf, oldStorage, s)
+	var journal *proc.CopyJournal
+	if resume {
+		journalDir := config.InstanceConfig().ProxyCnf.CopyJournalPath
+		if journalDir == "" {
+			return fmt.Errorf("copy journal path is not specified")
+		}
+		journal, err = proc.ReadCopyJournal(proc.CopyJournalPath(journalDir, prefix, oldCfgPath))
+		if err != nil {
+			return err
+		}
+	}
+
+	objects, skipped, err := proc.ListFilesToCopy(prefix, port, instanceCnf.StorageCnf, oldStorage, s, nil, journal)
 	if err != nil {
 		return err
 	}
@@ -406,3 +484,87 @@ func ProcessCopy(conn *pgproto3.Backend, prefix string, port uint64, oldCfgPath
 
 	return err
 }
+
+// ProcessCopyVerify reports objects which differ from source in old storage.
+// Objects are copied first only if confirm is set.
+func ProcessCopyVerify(conn *pgproto3.Backend, prefix string, port uint64, oldCfgPath string, confirm, resume, decrypt, encrypt bool, s storage.StorageInteractor, cr crypt.Crypter) error {
+	conn.Send(&pgproto3.RowDescription{
+		Fields: []pgproto3.FieldDescription{
+			{
+				Name:        []byte("path"),
+				DataTypeOID: 25, /* textoid*/
+			},
+			{
+				Name:        []byte("reason"),
+				DataTypeOID: 25, /* textoid */
+			},
+			{
+				Name:        []byte("source size"),
+				DataTypeOID: 25, /* textoid */
+			},
+			{
+				Name:        []byte("destination size"),
+				DataTypeOID: 25, /* textoid */
+			},
+			{
+				Name:        []byte("source digest"),
+				DataTypeOID: 25, /* textoid */
+			},
+			{
+				Name:        []byte("destination digest"),
+				DataTypeOID: 25, /* textoid */
+			},
+			{
+				Name:        []byte("error"),
+				DataTypeOID: 25, /* textoid */
+			},
+		},
+	})
+
+	/* run the copy as a client request would and turn its replies into rows, dry-run unless confirmed */
+	srv, cl := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		defer func() { _ = srv.Close() }()
+		/* envelope crypter reads both single key and KEK/DEK objects */
+		done <- (&proc.ProtoMgrImpl{}).ProcessCopyExtended(
+			prefix, oldCfgPath, port, confirm, encrypt, decrypt, decrypt, false, resume, true, false, nil, s, cr, client.NewYClient(srv))
+	}()
+
+	var copyErr error
+	pr := pio.NewProtoReader(client.NewYClient(cl))
+	for {
+		tp, data, err := pr.ReadPacket()
+		if err != nil {
+			break
+		}
+		switch tp {
+		case message.MessageTypeCopyMismatch:
+			m := &message.CopyMismatchMessage{}
+			m.Decode(data)
+			conn.Send(&pgproto3.DataRow{
+				Values: [][]byte{
+					[]byte(m.Path),
+					[]byte(m.Reason.String()),
+					[]byte(fmt.Sprintf("%d", m.SourceSize)),
+					[]byte(fmt.Sprintf("%d", m.DestSize)),
+					[]byte(m.SourceDigest),
+					[]byte(m.DestDigest),
+					[]byte(m.Error),
+				},
+			})
+		case message.MessageTypeError:
+			em := &message.ErrorMessage{}
+			em.Decode(data)
+			if copyErr == nil {
+				copyErr = fmt.Errorf("%s: %s", em.Message, em.Error)
+			}
+		}
+	}
+	_ = cl.Close()
+
+	if err := <-done; err != nil && copyErr == nil {
+		copyErr = err
+	}
+	return copyErr
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/proc/yio/limiter"
+	"github.com/yezzey-gp/yproxy/pkg/qos"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+// runtime holds objects built from reloadable part of instance config.
+// Connection takes current runtime when accepted and serves all its
+// requests with it, so transfers in flight are not affected by reload.
+type runtime struct {
+	cnf *config.Instance
+
+	storage       storage.StorageInteractor
+	backupStorage storage.StorageInteractor
+	crypter       crypt.Crypter
+	qos           *qos.Manager
+}
+
+func (rt *runtime) vacuumCnf() *config.Vacuum {
+	return &rt.cnf.VacuumCnf
+}
+
+// newRuntime builds storages and crypters of cnf. Objects of prev runtime
+// are reused when their part of config is unchanged, so caches survive reload.
+func newRuntime(cnf *config.Instance, prev *runtime) (*runtime, error) {
+	rt := &runtime{cnf: cnf}
+
+	var err error
+	if prev == nil {
+		if rt.storage, err = storage.NewInstanceStorage(&cnf.StorageCnf, cnf, "yezzey"); err != nil {
+			return nil, err
+		}
+		if rt.backupStorage, err = storage.NewInstanceStorage(&cnf.BackupStorageCnf, cnf, "backup"); err != nil {
+			return nil, err
+		}
+	} else {
+		/* listing cache and checksums wrap storages of both kinds */
+		sameWrappers := reflect.DeepEqual(prev.cnf.ProxyCnf, cnf.ProxyCnf) &&
+			prev.cnf.ChecksumCnf.Algorithm == cnf.ChecksumCnf.Algorithm
+		if rt.storage, err = reloadStorage(cnf, &cnf.StorageCnf, "yezzey", &prev.cnf.StorageCnf, prev.storage, sameWrappers); err != nil {
+			return nil, err
+		}
+		if rt.backupStorage, err = reloadStorage(cnf, &cnf.BackupStorageCnf, "backup", &prev.cnf.BackupStorageCnf, prev.backupStorage, sameWrappers); err != nil {
+			return nil, err
+		}
+	}
+
+	if prev != nil && reflect.DeepEqual(prev.cnf.CryptoCnf, cnf.CryptoCnf) {
+		rt.crypter = prev.crypter
+	} else if cnf.CryptoCnf.GPGKeyPath != "" || len(cnf.CryptoCnf.Crypters) != 0 {
+		if rt.crypter, err = crypt.NewRegistry(&cnf.CryptoCnf); err != nil {
+			return nil, fmt.Errorf("failed to configure crypters: %w", err)
+		}
+	}
+
+	/* classes keep requests in flight counted while their config is unchanged */
+	if prev != nil && reflect.DeepEqual(prev.cnf.QoSCnf, cnf.QoSCnf) {
+		rt.qos = prev.qos
+	} else if rt.qos, err = qos.NewManager(&cnf.QoSCnf); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func reloadStorage(cnf *config.Instance, storageCnf *config.Storage, name string,
+	prevCnf *config.Storage, prev storage.StorageInteractor, sameWrappers bool) (storage.StorageInteractor, error) {
+	sameStorage := reflect.DeepEqual(*prevCnf, *storageCnf)
+	if sameStorage && sameWrappers {
+		return prev, nil
+	}
+	/* local tier directory is owned by running storage with its destage workers */
+	if prevCnf.StorageType == "tiered" || storageCnf.StorageType == "tiered" {
+		if !sameStorage {
+			return nil, fmt.Errorf("%s storage: tiered storage configuration cannot be changed without restart", name)
+		}
+		ylogger.Zero.Warn().Str("storage", name).Msg("tiered storage keeps running listing cache and checksum settings until restart")
+		return prev, nil
+	}
+
+	s, err := storage.NewInstanceStorage(storageCnf, cnf, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s storage: %w", name, err)
+	}
+	return s, nil
+}
+
+// warnRestartRequired logs changed settings which are applied on start only.
+func warnRestartRequired(prev, cnf *config.Instance) {
+	for _, f := range []struct {
+		name      string
+		prev, cur any
+	}{
+		{"socket_path", prev.SocketPath, cnf.SocketPath},
+		{"interconnect_socket_path", prev.InterconnectSocketPath, cnf.InterconnectSocketPath},
+		{"stat_port", prev.StatPort, cnf.StatPort},
+		{"psql_port", prev.PsqlPort, cnf.PsqlPort},
+		{"debug_port", prev.DebugPort, cnf.DebugPort},
+		{"metrics_port", prev.MetricsPort, cnf.MetricsPort},
+		{"sd_notifications_debug", prev.SystemdNotificationsDebug, cnf.SystemdNotificationsDebug},
+	} {
+		if f.prev != f.cur {
+			ylogger.Zero.Warn().Str("setting", f.name).Interface("running", f.prev).Interface("configured", f.cur).Msg("setting change requires restart")
+		}
+	}
+}
+
+// Reload re-reads config file and swaps runtime used by new connections.
+// Invalid config is rejected and previous runtime stays in use.
+func (instance *Instance) Reload() error {
+	cnf, err := config.ReloadInstanceConfig()
+	if err != nil {
+		return err
+	}
+	if err := CheckStartupConfig(config.BootstrapConfigPath(), cnf); err != nil {
+		return err
+	}
+	return instance.applyConfig(cnf)
+}
+
+func (instance *Instance) applyConfig(cnf *config.Instance) error {
+	instance.reloadMu.Lock()
+	defer instance.reloadMu.Unlock()
+
+	prev := instance.runtime.Load()
+	rt, err := newRuntime(cnf, prev)
+	if err != nil {
+		return err
+	}
+	if prev != nil {
+		warnRestartRequired(prev.cnf, cnf)
+	}
+
+	config.SetInstanceConfig(cnf)
+	/* limiter is allocated with new rate limit on next use */
+	limiter.ResetLimiter()
+	qos.SetManager(rt.qos)
+	instance.runtime.Store(rt)
+	ylogger.ReloadLogger(cnf.LogPath, cnf.LogLevel)
+	return nil
+}
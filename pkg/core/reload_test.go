@@ -0,0 +1,79 @@
+package core
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+)
+
+func writeReloadConfig(t *testing.T, cfgPath, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(cfgPath, []byte(content), 0600))
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := path.Join(dir, "yproxy.yaml")
+	base := func(prefix string) string {
+		return "storage:\n  storage_type: fs\n  storage_prefix: " + dir + prefix + "\n" +
+			"backup_storage:\n  storage_type: fs\n  storage_prefix: " + dir + "/b\n" +
+			"log_level: error\nsocket_path: " + dir + "/yproxy.sock\n"
+	}
+
+	writeReloadConfig(t, cfgPath, base("/a")+"vacuum:\n  check_backup: false\n")
+	require.NoError(t, config.LoadInstanceConfig(cfgPath))
+
+	instance := NewInstance()
+	rt, err := newRuntime(config.InstanceConfig(), nil)
+	require.NoError(t, err)
+	instance.runtime.Store(rt)
+	/* connection accepted before reload keeps its runtime */
+	inFlight := instance.runtime.Load()
+
+	/* only vacuum config changed, storages are kept */
+	writeReloadConfig(t, cfgPath, base("/a")+"vacuum:\n  check_backup: true\n")
+	require.NoError(t, instance.Reload())
+	cur := instance.runtime.Load()
+	assert.NotSame(t, inFlight, cur)
+	assert.Same(t, inFlight.storage, cur.storage)
+	assert.True(t, cur.vacuumCnf().CheckBackup)
+	assert.False(t, inFlight.vacuumCnf().CheckBackup)
+	assert.True(t, config.InstanceConfig().VacuumCnf.CheckBackup)
+
+	/* storage config changed, storage is rebuilt, backup one is kept */
+	writeReloadConfig(t, cfgPath, base("/c")+"vacuum:\n  check_backup: true\n")
+	require.NoError(t, instance.Reload())
+	next := instance.runtime.Load()
+	assert.NotSame(t, cur.storage, next.storage)
+	assert.Same(t, cur.backupStorage, next.backupStorage)
+	assert.Equal(t, dir+"/c", config.InstanceConfig().StorageCnf.StoragePrefix)
+
+	/* invalid config is rejected, running one stays */
+	writeReloadConfig(t, cfgPath, base("/a")+"crypto:\n  crypters:\n    broken:\n      type: rot13\n")
+	assert.Error(t, instance.Reload())
+	assert.Same(t, next, instance.runtime.Load())
+	assert.Same(t, next.cnf, config.InstanceConfig())
+
+	writeReloadConfig(t, cfgPath, base("/a")+"vacum:\n  check_backup: true\n")
+	assert.ErrorContains(t, instance.Reload(), "vacum")
+	assert.Same(t, next, instance.runtime.Load())
+
+	writeReloadConfig(t, cfgPath, "storage: [")
+	assert.Error(t, instance.Reload())
+	assert.Same(t, next, instance.runtime.Load())
+}
+
+func TestReloadTieredStorage(t *testing.T) {
+	dir := t.TempDir()
+	prev := &runtime{cnf: config.BuildInstance(config.WithStorageCnf(*config.BuildStorage(config.WithStorageType("tiered"))))}
+
+	cnf := config.BuildInstance(config.WithStorageCnf(*config.BuildStorage(config.WithStorageType("fs"))))
+	cnf.StorageCnf.StoragePrefix = dir
+	_, err := newRuntime(cnf, prev)
+	assert.ErrorContains(t, err, "restart")
+}
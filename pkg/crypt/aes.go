@@ -0,0 +1,103 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// AESKeyCrypter seals small payloads, such as data keys, with raw AES-256-GCM
+// key. Each payload is stored as random nonce followed by ciphertext.
+type AESKeyCrypter struct {
+	aead cipher.AEAD
+	key  []byte
+}
+
+var _ Crypter = &AESKeyCrypter{}
+
+// readAESKey reads 256-bit key stored either raw or hex-encoded.
+func readAESKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == dataKeySize {
+		return data, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != dataKeySize {
+		return nil, fmt.Errorf("key file %s must contain %d-byte key, raw or hex-encoded", path, dataKeySize)
+	}
+	return key, nil
+}
+
+func NewAESKeyCrypter(path string) (*AESKeyCrypter, error) {
+	key, err := readAESKey(path)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChunkAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AESKeyCrypter{
+		aead: aead,
+		key:  key,
+	}, nil
+}
+
+type sealWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  bytes.Buffer
+}
+
+func (w *sealWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close seals buffered payload. Underlying writer is not closed.
+func (w *sealWriter) Close() error {
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := w.w.Write(w.aead.Seal(nonce, nonce, w.buf.Bytes(), nil)); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (a *AESKeyCrypter) Encrypt(writer io.WriteCloser) (io.WriteCloser, error) {
+	return &sealWriter{w: writer, aead: a.aead}, nil
+}
+
+func (a *AESKeyCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error) {
+	sealed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(sealed) < a.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed payload is too short: %d bytes", len(sealed))
+	}
+	nonce := sealed[:a.aead.NonceSize()]
+	plain, err := a.aead.Open(nil, nonce, sealed[len(nonce):], nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return bytes.NewReader(plain), nil
+}
+
+func (a *AESKeyCrypter) CmpKey(path string) (bool, error) {
+	other, err := readAESKey(path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a.key, other), nil
+}
@@ -0,0 +1,339 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Chunked AES-GCM object layout:
+ *
+ *   | magic (4) | version (1) | reserved (3) | wrapped DEK len (4) | chunk size (4) | wrapped DEK | zero padding |
+ *   |<------------------------------------- ChunkedHeaderSize ------------------------------------------------->|
+ *   | chunk 0: AES-256-GCM(chunk size bytes) + tag | chunk 1 | ... | last chunk (possibly empty) + tag |
+ *
+ * Chunk nonce is its index, last chunk is sealed with final flag set, so
+ * reordered, truncated or extended objects fail authentication. Every
+ * encrypted chunk has the same size, which makes object offset of any
+ * plaintext offset computable without reading preceding data.
+ */
+const (
+	ChunkedHeaderSize = EnvelopeHeaderSize
+	ChunkedVersion    = byte(1)
+
+	DefaultChunkSize = 64 * 1024
+
+	chunkedFixedPartSize = 16
+	chunkTagSize         = 16
+	maxChunkSize         = 16 * 1024 * 1024
+)
+
+var chunkedMagic = []byte("YAGC")
+
+// SeekableDecrypter is implemented by crypters able to start decryption at
+// plaintext offset without decrypting object from its beginning.
+type SeekableDecrypter interface {
+	// DecryptAt returns plaintext of object starting at offset. Reader r
+	// reads object from its beginning, open reads object from given offset.
+	DecryptAt(r io.ReadCloser, open func(offset int64) (io.ReadCloser, error), offset int64) (io.ReadCloser, error)
+}
+
+type ChunkedCrypter struct {
+	/* used to wrap and unwrap data keys of new objects */
+	envelope *EnvelopeCrypter
+
+	chunkSize int
+}
+
+var _ Crypter = &ChunkedCrypter{}
+var _ SeekableDecrypter = &ChunkedCrypter{}
+
+func NewChunkedCrypter(kek Crypter, chunkSize int) *ChunkedCrypter {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		chunkSize = DefaultChunkSize
+	}
+	e, ok := kek.(*EnvelopeCrypter)
+	if !ok {
+		e = NewEnvelopeCrypter(kek)
+	}
+	return &ChunkedCrypter{
+		envelope:  e,
+		chunkSize: chunkSize,
+	}
+}
+
+// BuildChunkedHeader encodes wrapped data key and chunk size into fixed-size object header.
+func BuildChunkedHeader(wrapped []byte, chunkSize int) ([]byte, error) {
+	if len(wrapped) > ChunkedHeaderSize-chunkedFixedPartSize-headerNameSize {
+		return nil, fmt.Errorf("wrapped data key is too large: %d bytes", len(wrapped))
+	}
+	header := make([]byte, ChunkedHeaderSize)
+	copy(header, chunkedMagic)
+	header[4] = ChunkedVersion
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(wrapped)))
+	binary.BigEndian.PutUint32(header[12:16], uint32(chunkSize))
+	copy(header[chunkedFixedPartSize:], wrapped)
+	return header, nil
+}
+
+// ParseChunkedHeader returns wrapped data key and chunk size stored in object header.
+func ParseChunkedHeader(header []byte) ([]byte, int, error) {
+	if len(header) < ChunkedHeaderSize {
+		return nil, 0, fmt.Errorf("chunked header is too short: %d bytes", len(header))
+	}
+	if !IsChunkedHeader(header) {
+		return nil, 0, fmt.Errorf("object has no chunked header")
+	}
+	if header[4] != ChunkedVersion {
+		return nil, 0, fmt.Errorf("unsupported chunked format version %d", header[4])
+	}
+	ln := binary.BigEndian.Uint32(header[8:12])
+	/* wrapped key never extends into crypter name */
+	if int(ln) > ChunkedHeaderSize-chunkedFixedPartSize-headerNameSize {
+		return nil, 0, fmt.Errorf("chunked header is corrupted: wrapped key length %d", ln)
+	}
+	chunkSize := binary.BigEndian.Uint32(header[12:16])
+	if chunkSize == 0 || chunkSize > maxChunkSize {
+		return nil, 0, fmt.Errorf("chunked header is corrupted: chunk size %d", chunkSize)
+	}
+	return header[chunkedFixedPartSize : chunkedFixedPartSize+ln], int(chunkSize), nil
+}
+
+// IsChunkedHeader checks object prefix for chunked format magic.
+func IsChunkedHeader(prefix []byte) bool {
+	return len(prefix) >= len(chunkedMagic) && bytes.Equal(prefix[:len(chunkedMagic)], chunkedMagic)
+}
+
+func newChunkAEAD(dek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(idx uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	if final {
+		nonce[0] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[4:], idx)
+	return nonce
+}
+
+// Encrypt generates fresh data key, writes object header and returns
+// writer sealing payload chunk by chunk.
+func (c *ChunkedCrypter) Encrypt(writer io.WriteCloser) (io.WriteCloser, error) {
+	dek := make([]byte, dataKeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	wrapped, err := c.envelope.wrapKey(dek)
+	if err != nil {
+		return nil, err
+	}
+	header, err := BuildChunkedHeader(wrapped, c.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChunkAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(header); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &chunkedWriter{
+		w:    writer,
+		aead: aead,
+		buf:  make([]byte, 0, c.chunkSize),
+	}, nil
+}
+
+type chunkedWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  []byte
+	idx  uint64
+}
+
+func (w *chunkedWriter) seal(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.idx, final), w.buf, nil)
+	if _, err := w.w.Write(sealed); err != nil {
+		return errors.WithStack(err)
+	}
+	w.idx++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *chunkedWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		/* full chunk is sealed only when more data follows, last one must carry final flag */
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close seals last chunk. Underlying writer is not closed.
+func (w *chunkedWriter) Close() error {
+	return w.seal(true)
+}
+
+type chunkedReader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+
+	chunk []byte
+	plain []byte
+	idx   uint64
+	done  bool
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.r, r.chunk)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return 0, fmt.Errorf("chunked object is truncated: %w", io.ErrUnexpectedEOF)
+			}
+			return 0, errors.WithStack(err)
+		}
+		final := err == io.ErrUnexpectedEOF
+		if !final {
+			if _, err := r.r.Peek(1); err == io.EOF {
+				final = true
+			}
+		}
+		r.plain, err = r.aead.Open(r.chunk[:0], chunkNonce(r.idx, final), r.chunk[:n], nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to authenticate chunk %d: %w", r.idx, err)
+		}
+		r.idx++
+		r.done = final
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (c *ChunkedCrypter) readHeader(br *bufio.Reader) (cipher.AEAD, int, error) {
+	header := make([]byte, ChunkedHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	wrapped, chunkSize, err := ParseChunkedHeader(header)
+	if err != nil {
+		return nil, 0, err
+	}
+	dek, err := c.envelope.unwrapKey(wrapped)
+	if err != nil {
+		return nil, 0, err
+	}
+	aead, err := newChunkAEAD(dek)
+	if err != nil {
+		return nil, 0, err
+	}
+	return aead, chunkSize, nil
+}
+
+func newChunkedReader(r io.Reader, aead cipher.AEAD, chunkSize int, idx uint64) *chunkedReader {
+	return &chunkedReader{
+		r:     bufio.NewReader(r),
+		aead:  aead,
+		chunk: make([]byte, chunkSize+chunkTagSize),
+		idx:   idx,
+	}
+}
+
+// Decrypt returns payload plaintext. Objects in other formats are
+// decrypted by envelope crypter, so all formats stay readable.
+func (c *ChunkedCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error) {
+	br := bufio.NewReaderSize(reader, ChunkedHeaderSize)
+	prefix, err := br.Peek(len(chunkedMagic))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !IsChunkedHeader(prefix) {
+		return c.envelope.Decrypt(io.NopCloser(br))
+	}
+
+	aead, chunkSize, err := c.readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return newChunkedReader(br, aead, chunkSize, 0), nil
+}
+
+// DecryptAt reads chunked object from the chunk containing offset. Objects
+// in other formats are decrypted from the beginning up to offset.
+func (c *ChunkedCrypter) DecryptAt(r io.ReadCloser, open func(offset int64) (io.ReadCloser, error), offset int64) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, ChunkedHeaderSize)
+	prefix, err := br.Peek(len(chunkedMagic))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !IsChunkedHeader(prefix) {
+		content, err := c.envelope.Decrypt(io.NopCloser(br))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(io.Discard, content, offset); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(content), nil
+	}
+
+	aead, chunkSize, err := c.readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	idx := uint64(offset / int64(chunkSize))
+	if idx == 0 {
+		content := newChunkedReader(br, aead, chunkSize, 0)
+		if _, err := io.CopyN(io.Discard, content, offset); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(content), nil
+	}
+
+	/* header is all we need from object beginning */
+	_ = r.Close()
+	rr, err := open(ChunkedHeaderSize + int64(idx)*int64(chunkSize+chunkTagSize))
+	if err != nil {
+		return nil, err
+	}
+	content := newChunkedReader(rr, aead, chunkSize, idx)
+	if _, err := io.CopyN(io.Discard, content, offset%int64(chunkSize)); err != nil {
+		_ = rr.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{content, rr}, nil
+}
+
+func (c *ChunkedCrypter) CmpKey(path string) (bool, error) {
+	return c.envelope.CmpKey(path)
+}
@@ -0,0 +1,147 @@
+package crypt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+)
+
+const testChunkSize = 16
+
+func decryptAt(t *testing.T, cr crypt.SeekableDecrypter, data []byte, offset int64) ([]byte, []int64) {
+	t.Helper()
+	opened := []int64{}
+	r, err := cr.DecryptAt(io.NopCloser(bytes.NewReader(data)), func(off int64) (io.ReadCloser, error) {
+		opened = append(opened, off)
+		return io.NopCloser(bytes.NewReader(data[off:])), nil
+	}, offset)
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+	res, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return res, opened
+}
+
+func TestChunkedRoundTrip(t *testing.T) {
+	cr := crypt.NewChunkedCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv"), testChunkSize)
+
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 10 * testChunkSize, 10*testChunkSize + 7} {
+		payload := bytes.Repeat([]byte{'y'}, size)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+
+		encrypted := encryptAll(t, cr, payload)
+		assert.True(t, crypt.IsChunkedHeader(encrypted))
+		assert.Equal(t, payload, decryptAll(t, cr, encrypted), "size %d", size)
+
+		/* chunked objects are readable through envelope crypter */
+		assert.Equal(t, payload, decryptAll(t, crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")), encrypted))
+	}
+}
+
+func TestChunkedDecryptAt(t *testing.T) {
+	cr := crypt.NewChunkedCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv"), testChunkSize)
+
+	payload := make([]byte, 10*testChunkSize+7)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	encrypted := encryptAll(t, cr, payload)
+
+	for _, offset := range []int64{0, 5, testChunkSize, 3*testChunkSize + 4, int64(len(payload)) - 1, int64(len(payload))} {
+		res, opened := decryptAt(t, cr, encrypted, offset)
+		assert.Equal(t, payload[offset:], res, "offset %d", offset)
+
+		if offset < testChunkSize {
+			assert.Empty(t, opened)
+			continue
+		}
+		/* object is read from the chunk containing offset */
+		idx := offset / testChunkSize
+		assert.Equal(t, []int64{crypt.ChunkedHeaderSize + idx*(testChunkSize+16)}, opened)
+	}
+}
+
+func TestChunkedDecryptAtOtherFormats(t *testing.T) {
+	kek := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+	cr := crypt.NewChunkedCrypter(kek, testChunkSize)
+
+	payload := []byte("object written in older format")
+	for _, encrypted := range [][]byte{encryptAll(t, kek, payload), encryptAll(t, crypt.NewEnvelopeCrypter(kek), payload)} {
+		res, opened := decryptAt(t, cr, encrypted, 7)
+		assert.Equal(t, payload[7:], res)
+		assert.Empty(t, opened)
+	}
+}
+
+func TestChunkedTampering(t *testing.T) {
+	cr := crypt.NewChunkedCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv"), testChunkSize)
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	encrypted := encryptAll(t, cr, payload)
+	chunk := testChunkSize + 16
+
+	readAll := func(data []byte) error {
+		r, err := cr.Decrypt(io.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	flipped := bytes.Clone(encrypted)
+	flipped[crypt.ChunkedHeaderSize+chunk+3] ^= 1
+	assert.Error(t, readAll(flipped))
+
+	/* dropped last chunk makes previous one look final */
+	assert.Error(t, readAll(encrypted[:len(encrypted)-16]))
+
+	swapped := bytes.Clone(encrypted)
+	copy(swapped[crypt.ChunkedHeaderSize:], encrypted[crypt.ChunkedHeaderSize+chunk:crypt.ChunkedHeaderSize+2*chunk])
+	copy(swapped[crypt.ChunkedHeaderSize+chunk:], encrypted[crypt.ChunkedHeaderSize:crypt.ChunkedHeaderSize+chunk])
+	assert.Error(t, readAll(swapped))
+
+	assert.NoError(t, readAll(encrypted))
+}
+
+func TestChunkedRewrap(t *testing.T) {
+	oldCr := crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv"))
+	newCr := crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_2.priv"))
+
+	payload := bytes.Repeat([]byte("rotate me without touching payload"), 10)
+	encrypted := encryptAll(t, crypt.NewChunkedCrypter(oldCr, testChunkSize), payload)
+	header := encrypted[:crypt.ChunkedHeaderSize]
+
+	assert.True(t, oldCr.CanUnwrap(header))
+	assert.False(t, newCr.CanUnwrap(header))
+
+	newHeader, err := oldCr.Rewrap(header, newCr)
+	require.NoError(t, err)
+	assert.True(t, crypt.IsChunkedHeader(newHeader))
+
+	rotated := append(newHeader, encrypted[crypt.ChunkedHeaderSize:]...)
+	assert.Equal(t, payload, decryptAll(t, newCr, rotated))
+}
+
+func TestChunkedHeader(t *testing.T) {
+	header, err := crypt.BuildChunkedHeader([]byte("wrapped"), testChunkSize)
+	require.NoError(t, err)
+
+	wrapped, chunkSize, err := crypt.ParseChunkedHeader(header)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("wrapped"), wrapped)
+	assert.Equal(t, testChunkSize, chunkSize)
+
+	/* length reaching into crypter name is rejected */
+	binary.BigEndian.PutUint32(header[8:12], crypt.ChunkedHeaderSize-16)
+	_, _, err = crypt.ParseChunkedHeader(header)
+	assert.Error(t, err)
+}
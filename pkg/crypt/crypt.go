@@ -10,6 +10,7 @@ import (
 	"github.com/yezzey-gp/yproxy/pkg/ylogger"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
 type KeyVersion byte
@@ -26,6 +27,17 @@ type Crypter interface {
 	CmpKey(path string) (bool, error)
 }
 
+// IsGPGMessage reports whether object starting with prefix is OpenPGP
+// message encrypted to public key, as objects of single key encryption are.
+func IsGPGMessage(prefix []byte) bool {
+	p, err := packet.Read(bytes.NewReader(prefix))
+	if err != nil {
+		return false
+	}
+	_, ok := p.(*packet.EncryptedKey)
+	return ok
+}
+
 type GPGCrypter struct {
 	EntityList openpgp.EntityList
 
@@ -0,0 +1,261 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/pkg/errors"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+/*
+ * Envelope (KEK/DEK) object layout:
+ *
+ *   | magic (4) | version (1) | reserved (3) | wrapped DEK len (4) | wrapped DEK | zero padding |
+ *   |<------------------------------ EnvelopeHeaderSize ------------------------------------->|
+ *   | payload, OpenPGP symmetrically encrypted with DEK ...
+ *
+ * Header has a fixed size, so the data key can be re-wrapped under a new
+ * master key without touching the payload.
+ */
+const (
+	EnvelopeHeaderSize = 4096
+	EnvelopeVersion    = byte(1)
+
+	envelopeFixedPartSize = 12
+	dataKeySize           = 32
+)
+
+var envelopeMagic = []byte("YDEK")
+
+type EnvelopeCrypter struct {
+	/* key encryption key, used only to wrap and unwrap data keys */
+	kek Crypter
+}
+
+var _ Crypter = &EnvelopeCrypter{}
+var _ SeekableDecrypter = &EnvelopeCrypter{}
+
+func NewEnvelopeCrypter(kek Crypter) *EnvelopeCrypter {
+	return &EnvelopeCrypter{
+		kek: kek,
+	}
+}
+
+func dataConfig() *packet.Config {
+	return &packet.Config{
+		DefaultCipher: packet.CipherAES256,
+		/* DEK is random, there is no point in slow key derivation */
+		S2KCount: 1024,
+	}
+}
+
+func (e *EnvelopeCrypter) wrapKey(dek []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := e.kek.Encrypt(nopWriteCloser{&buf})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(dek); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *EnvelopeCrypter) unwrapKey(wrapped []byte) ([]byte, error) {
+	r, err := e.kek.Decrypt(io.NopCloser(bytes.NewReader(wrapped)))
+	if err != nil {
+		return nil, err
+	}
+	dek, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(dek) != dataKeySize {
+		return nil, fmt.Errorf("unwrapped data key has wrong size %d", len(dek))
+	}
+	return dek, nil
+}
+
+// BuildEnvelopeHeader encodes wrapped data key into fixed-size object header.
+func BuildEnvelopeHeader(wrapped []byte) ([]byte, error) {
+	/* header tail is reserved for crypter name */
+	if len(wrapped) > EnvelopeHeaderSize-envelopeFixedPartSize-headerNameSize {
+		return nil, fmt.Errorf("wrapped data key is too large: %d bytes", len(wrapped))
+	}
+	header := make([]byte, EnvelopeHeaderSize)
+	copy(header, envelopeMagic)
+	header[4] = EnvelopeVersion
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(wrapped)))
+	copy(header[envelopeFixedPartSize:], wrapped)
+	return header, nil
+}
+
+// ParseEnvelopeHeader returns wrapped data key stored in object header.
+func ParseEnvelopeHeader(header []byte) ([]byte, error) {
+	if len(header) < EnvelopeHeaderSize {
+		return nil, fmt.Errorf("envelope header is too short: %d bytes", len(header))
+	}
+	if !IsEnvelopeHeader(header) {
+		return nil, fmt.Errorf("object has no envelope header")
+	}
+	if header[4] != EnvelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", header[4])
+	}
+	ln := binary.BigEndian.Uint32(header[8:12])
+	/* wrapped key never extends into crypter name */
+	if int(ln) > EnvelopeHeaderSize-envelopeFixedPartSize-headerNameSize {
+		return nil, fmt.Errorf("envelope header is corrupted: wrapped key length %d", ln)
+	}
+	return header[envelopeFixedPartSize : envelopeFixedPartSize+ln], nil
+}
+
+// IsEnvelopeHeader checks object prefix for envelope magic.
+func IsEnvelopeHeader(prefix []byte) bool {
+	return len(prefix) >= len(envelopeMagic) && bytes.Equal(prefix[:len(envelopeMagic)], envelopeMagic)
+}
+
+// Encrypt generates fresh data key, writes its wrapped form as object header
+// and returns writer encrypting payload with data key.
+func (e *EnvelopeCrypter) Encrypt(writer io.WriteCloser) (io.WriteCloser, error) {
+	dek := make([]byte, dataKeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	wrapped, err := e.wrapKey(dek)
+	if err != nil {
+		return nil, err
+	}
+	header, err := BuildEnvelopeHeader(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(header); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	encryptedWriter, err := openpgp.SymmetricallyEncrypt(writer, dek, nil, dataConfig())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return encryptedWriter, nil
+}
+
+// Decrypt unwraps object data key and returns payload plaintext.
+// Objects without envelope header are decrypted with KEK directly,
+// so objects written before KEK was enabled stay readable.
+func (e *EnvelopeCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error) {
+	br := bufio.NewReaderSize(reader, EnvelopeHeaderSize)
+
+	prefix, err := br.Peek(len(envelopeMagic))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if IsChunkedHeader(prefix) {
+		return NewChunkedCrypter(e, 0).Decrypt(io.NopCloser(br))
+	}
+	if !IsEnvelopeHeader(prefix) {
+		ylogger.Zero.Debug().Msg("object has no envelope header, decrypt with single key")
+		return e.kek.Decrypt(io.NopCloser(br))
+	}
+
+	header := make([]byte, EnvelopeHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	wrapped, err := ParseEnvelopeHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := e.unwrapKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	prompted := false
+	md, err := openpgp.ReadMessage(br, nil, func(_ []openpgp.Key, symmetric bool) ([]byte, error) {
+		if !symmetric || prompted {
+			return nil, fmt.Errorf("data key does not match object payload")
+		}
+		prompted = true
+		return dek, nil
+	}, dataConfig())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return md.UnverifiedBody, nil
+}
+
+// DecryptAt implements SeekableDecrypter. Only chunked objects are
+// read from the middle, others are decrypted up to offset.
+func (e *EnvelopeCrypter) DecryptAt(r io.ReadCloser, open func(offset int64) (io.ReadCloser, error), offset int64) (io.ReadCloser, error) {
+	return NewChunkedCrypter(e, 0).DecryptAt(r, open, offset)
+}
+
+func parseWrappedKey(header []byte) ([]byte, error) {
+	if IsChunkedHeader(header) {
+		wrapped, _, err := ParseChunkedHeader(header)
+		return wrapped, err
+	}
+	return ParseEnvelopeHeader(header)
+}
+
+// CanUnwrap checks whether data key in envelope or chunked header is wrapped by this crypter KEK.
+func (e *EnvelopeCrypter) CanUnwrap(header []byte) bool {
+	wrapped, err := parseWrappedKey(header)
+	if err != nil {
+		return false
+	}
+	_, err = e.unwrapKey(wrapped)
+	return err == nil
+}
+
+// Rewrap unwraps data key from envelope or chunked header and returns new
+// header with the same data key wrapped by KEK of other crypter. Payload stays valid.
+func (e *EnvelopeCrypter) Rewrap(header []byte, other *EnvelopeCrypter) ([]byte, error) {
+	wrapped, err := parseWrappedKey(header)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := e.unwrapKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	rewrapped, err := other.wrapKey(dek)
+	if err != nil {
+		return nil, err
+	}
+	var newHeader []byte
+	if IsChunkedHeader(header) {
+		_, chunkSize, _ := ParseChunkedHeader(header)
+		newHeader, err = BuildChunkedHeader(rewrapped, chunkSize)
+	} else {
+		newHeader, err = BuildEnvelopeHeader(rewrapped)
+	}
+	if err != nil {
+		return nil, err
+	}
+	/* keep name of crypter which wrote object */
+	copy(newHeader[headerNameOffset:], header[headerNameOffset:EnvelopeHeaderSize])
+	return newHeader, nil
+}
+
+func (e *EnvelopeCrypter) CmpKey(path string) (bool, error) {
+	return e.kek.CmpKey(path)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,125 @@
+package crypt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+)
+
+type bufCloser struct {
+	*bytes.Buffer
+}
+
+func (bufCloser) Close() error { return nil }
+
+func newGPGCrypter(t *testing.T, path string) crypt.Crypter {
+	t.Helper()
+	cr, err := crypt.NewCrypto(&config.Crypto{GPGKeyPath: path})
+	require.NoError(t, err)
+	return cr
+}
+
+func encryptAll(t *testing.T, cr crypt.Crypter, data []byte) []byte {
+	t.Helper()
+	buf := bufCloser{&bytes.Buffer{}}
+	w, err := cr.Encrypt(buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decryptAll(t *testing.T, cr crypt.Crypter, data []byte) []byte {
+	t.Helper()
+	r, err := cr.Decrypt(io.NopCloser(bytes.NewReader(data)))
+	require.NoError(t, err)
+	res, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return res
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	kek := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+	cr := crypt.NewEnvelopeCrypter(kek)
+
+	payload := bytes.Repeat([]byte("yezzey append-optimized segment "), 4096)
+
+	encrypted := encryptAll(t, cr, payload)
+	assert.True(t, crypt.IsEnvelopeHeader(encrypted))
+	assert.Greater(t, len(encrypted), crypt.EnvelopeHeaderSize)
+
+	assert.Equal(t, payload, decryptAll(t, cr, encrypted))
+
+	/* every object gets its own data key */
+	other := encryptAll(t, cr, payload)
+	w1, err := crypt.ParseEnvelopeHeader(encrypted)
+	require.NoError(t, err)
+	w2, err := crypt.ParseEnvelopeHeader(other)
+	require.NoError(t, err)
+	assert.NotEqual(t, w1, w2)
+}
+
+func TestEnvelopeDecryptSingleKeyObject(t *testing.T) {
+	kek := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+
+	payload := []byte("object written before KEK was enabled")
+	encrypted := encryptAll(t, kek, payload)
+
+	assert.Equal(t, payload, decryptAll(t, crypt.NewEnvelopeCrypter(kek), encrypted))
+}
+
+func TestEnvelopeWrongKEK(t *testing.T) {
+	encrypted := encryptAll(t, crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")), []byte("secret"))
+
+	cr := crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_2.priv"))
+	_, err := cr.Decrypt(io.NopCloser(bytes.NewReader(encrypted)))
+	assert.Error(t, err)
+}
+
+func TestEnvelopeHeader(t *testing.T) {
+	header, err := crypt.BuildEnvelopeHeader([]byte("wrapped"))
+	require.NoError(t, err)
+	assert.Len(t, header, crypt.EnvelopeHeaderSize)
+
+	wrapped, err := crypt.ParseEnvelopeHeader(header)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("wrapped"), wrapped)
+
+	_, err = crypt.BuildEnvelopeHeader(make([]byte, crypt.EnvelopeHeaderSize))
+	assert.Error(t, err)
+
+	_, err = crypt.ParseEnvelopeHeader(make([]byte, crypt.EnvelopeHeaderSize))
+	assert.Error(t, err)
+
+	/* length reaching into crypter name is rejected */
+	binary.BigEndian.PutUint32(header[8:12], crypt.EnvelopeHeaderSize-16)
+	_, err = crypt.ParseEnvelopeHeader(header)
+	assert.Error(t, err)
+}
+
+func TestEnvelopeRewrap(t *testing.T) {
+	oldCr := crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv"))
+	newCr := crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_2.priv"))
+
+	payload := []byte("rotate me without touching payload")
+	encrypted := encryptAll(t, oldCr, payload)
+	header := encrypted[:crypt.EnvelopeHeaderSize]
+
+	assert.True(t, oldCr.CanUnwrap(header))
+	assert.False(t, newCr.CanUnwrap(header))
+
+	newHeader, err := oldCr.Rewrap(header, newCr)
+	require.NoError(t, err)
+	assert.True(t, newCr.CanUnwrap(newHeader))
+
+	rotated := append(newHeader, encrypted[crypt.EnvelopeHeaderSize:]...)
+	assert.Equal(t, payload, decryptAll(t, newCr, rotated))
+}
@@ -0,0 +1,210 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+const kmsRequestTimeout = 30 * time.Second
+
+// KMSKeyCrypter wraps and unwraps data keys with external key service
+// speaking Vault transit API, so master key never leaves the service.
+// Unwrapped keys are cached in memory for configured TTL.
+type KMSKeyCrypter struct {
+	cnf    *config.Crypter
+	client *http.Client
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]kmsCacheEntry
+}
+
+type kmsCacheEntry struct {
+	key     []byte
+	expires time.Time
+}
+
+var _ Crypter = &KMSKeyCrypter{}
+
+func NewKMSKeyCrypter(cnf *config.Crypter) (*KMSKeyCrypter, error) {
+	if cnf.KMSAddress == "" || cnf.KMSKeyName == "" {
+		return nil, fmt.Errorf("kms_address and kms_key_name must be configured for kms crypter")
+	}
+	ttl := cnf.KMSCacheTTL
+	if ttl <= 0 {
+		ttl = config.DefaultKMSCacheTTL
+	}
+	return &KMSKeyCrypter{
+		cnf:    cnf,
+		client: &http.Client{Timeout: kmsRequestTimeout},
+		ttl:    ttl,
+		cache:  map[string]kmsCacheEntry{},
+	}, nil
+}
+
+// token is read on every request, so token renewed by agent is picked up.
+func (k *KMSKeyCrypter) token() (string, error) {
+	if k.cnf.KMSTokenPath == "" {
+		return os.Getenv("VAULT_TOKEN"), nil
+	}
+	data, err := os.ReadFile(k.cnf.KMSTokenPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (k *KMSKeyCrypter) call(op string, req, resp any) error {
+	mount := k.cnf.KMSMount
+	if mount == "" {
+		mount = config.DefaultKMSMount
+	}
+	u, err := url.JoinPath(k.cnf.KMSAddress, "v1", mount, op, k.cnf.KMSKeyName)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	token, err := k.token()
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Vault-Token", token)
+	if k.cnf.KMSNamespace != "" {
+		httpReq.Header.Set("X-Vault-Namespace", k.cnf.KMSNamespace)
+	}
+
+	httpResp, err := k.client.Do(httpReq)
+	if err != nil {
+		metrics.KMSRequestErrors.Inc()
+		return errors.WithStack(err)
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusOK {
+		metrics.KMSRequestErrors.Inc()
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1024))
+		return fmt.Errorf("key service %s request failed: %s: %s", op, httpResp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+func (k *KMSKeyCrypter) wrap(plain []byte) ([]byte, error) {
+	start := time.Now()
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := k.call("encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plain)}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data.Ciphertext == "" {
+		return nil, fmt.Errorf("key service returned empty ciphertext")
+	}
+	metrics.StoreLatencyAndSizeInfo("KMS_WRAP", float64(len(plain)), float64(time.Since(start).Nanoseconds()))
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+func (k *KMSKeyCrypter) unwrap(wrapped []byte) ([]byte, error) {
+	k.mu.Lock()
+	e, ok := k.cache[string(wrapped)]
+	k.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		metrics.KMSKeyCacheHits.Inc()
+		return e.key, nil
+	}
+	metrics.KMSKeyCacheMisses.Inc()
+
+	start := time.Now()
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := k.call("decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	plain, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	metrics.StoreLatencyAndSizeInfo("KMS_UNWRAP", float64(len(plain)), float64(time.Since(start).Nanoseconds()))
+
+	now := time.Now()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for w, e := range k.cache {
+		if now.After(e.expires) {
+			delete(k.cache, w)
+		}
+	}
+	k.cache[string(wrapped)] = kmsCacheEntry{key: plain, expires: now.Add(k.ttl)}
+	ylogger.Zero.Debug().Int("cached keys", len(k.cache)).Msg("unwrapped data key by key service")
+	return plain, nil
+}
+
+type kmsWriter struct {
+	k   *KMSKeyCrypter
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (w *kmsWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close wraps buffered payload. Underlying writer is not closed.
+func (w *kmsWriter) Close() error {
+	wrapped, err := w.k.wrap(w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(wrapped); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (k *KMSKeyCrypter) Encrypt(writer io.WriteCloser) (io.WriteCloser, error) {
+	return &kmsWriter{k: k, w: writer}, nil
+}
+
+func (k *KMSKeyCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error) {
+	wrapped, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	plain, err := k.unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// CmpKey implements Crypter. Master key is not available locally.
+func (k *KMSKeyCrypter) CmpKey(string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,172 @@
+package crypt_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+)
+
+const kmsTestToken = "s.test-token"
+
+// transitServer mimics encrypt and decrypt endpoints of Vault transit engine.
+type transitServer struct {
+	*httptest.Server
+	aead     cipher.AEAD
+	decrypts atomic.Int32
+}
+
+func newTransitServer(t *testing.T) *transitServer {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{42}, 32))
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+
+	s := &transitServer{aead: aead}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *transitServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != kmsTestToken {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	req := map[string]string{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data map[string]string
+	switch r.URL.Path {
+	case "/v1/transit/encrypt/yezzey":
+		plain, err := base64.StdEncoding.DecodeString(req["plaintext"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		nonce := make([]byte, s.aead.NonceSize())
+		_, _ = rand.Read(nonce)
+		data = map[string]string{"ciphertext": "vault:v1:" + base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, plain, nil))}
+	case "/v1/transit/decrypt/yezzey":
+		s.decrypts.Add(1)
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(req["ciphertext"], "vault:v1:"))
+		if err != nil || len(sealed) < s.aead.NonceSize() {
+			http.Error(w, "bad ciphertext", http.StatusBadRequest)
+			return
+		}
+		plain, err := s.aead.Open(nil, sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():], nil)
+		if err != nil {
+			http.Error(w, "bad ciphertext", http.StatusBadRequest)
+			return
+		}
+		data = map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plain)}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func newKMSRegistry(t *testing.T, addr string, ttl time.Duration) *crypt.Registry {
+	t.Helper()
+	tokenPath := path.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte(kmsTestToken+"\n"), 0600))
+
+	reg, err := crypt.NewRegistry(&config.Crypto{
+		Crypters: map[string]config.Crypter{
+			"vault": {
+				Type:                config.CrypterTypeKMS,
+				KMSAddress:          addr,
+				KMSTokenPath:        tokenPath,
+				KMSKeyName:          "yezzey",
+				KMSCacheTTL:         ttl,
+				EncryptionChunkSize: testChunkSize,
+			},
+		},
+	})
+	require.NoError(t, err)
+	return reg
+}
+
+func TestKMSCrypter(t *testing.T) {
+	srv := newTransitServer(t)
+	reg := newKMSRegistry(t, srv.URL, time.Hour)
+
+	payload := bytes.Repeat([]byte("wrapped by key service "), 20)
+	encrypted := encryptAll(t, reg, payload)
+	assert.Equal(t, "vault", crypt.HeaderCrypter(encrypted))
+	/* master key stays in key service, header keeps only wrapped key */
+	assert.Contains(t, string(encrypted[:crypt.ChunkedHeaderSize]), "vault:v1:")
+
+	assert.Equal(t, payload, decryptAll(t, reg, encrypted))
+	res, _ := decryptAt(t, reg, encrypted, 100)
+	assert.Equal(t, payload[100:], res)
+
+	/* unwrapped key is served from cache */
+	assert.Equal(t, int32(1), srv.decrypts.Load())
+
+	/* other object has its own data key */
+	other := encryptAll(t, reg, payload)
+	assert.Equal(t, payload, decryptAll(t, reg, other))
+	assert.Equal(t, int32(2), srv.decrypts.Load())
+}
+
+func TestKMSCrypterCacheTTL(t *testing.T) {
+	srv := newTransitServer(t)
+	reg := newKMSRegistry(t, srv.URL, 50*time.Millisecond)
+
+	encrypted := encryptAll(t, reg, []byte("short-lived key"))
+	assert.Equal(t, []byte("short-lived key"), decryptAll(t, reg, encrypted))
+	assert.Equal(t, []byte("short-lived key"), decryptAll(t, reg, encrypted))
+	assert.Equal(t, int32(1), srv.decrypts.Load())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []byte("short-lived key"), decryptAll(t, reg, encrypted))
+	assert.Equal(t, int32(2), srv.decrypts.Load())
+}
+
+func TestKMSCrypterErrors(t *testing.T) {
+	srv := newTransitServer(t)
+	encrypted := encryptAll(t, newKMSRegistry(t, srv.URL, time.Hour), []byte("secret"))
+
+	/* wrong token */
+	reg, err := crypt.NewRegistry(&config.Crypto{
+		Crypters: map[string]config.Crypter{
+			"vault": {Type: config.CrypterTypeKMS, KMSAddress: srv.URL, KMSKeyName: "yezzey"},
+		},
+	})
+	require.NoError(t, err)
+	t.Setenv("VAULT_TOKEN", "s.wrong")
+	_, err = reg.Decrypt(io.NopCloser(bytes.NewReader(encrypted)))
+	assert.ErrorContains(t, err, "403")
+
+	/* key service is unavailable */
+	down := newKMSRegistry(t, "http://127.0.0.1:1", time.Hour)
+	_, err = down.Decrypt(io.NopCloser(bytes.NewReader(encrypted)))
+	assert.Error(t, err)
+
+	_, err = crypt.NewRegistry(&config.Crypto{
+		Crypters: map[string]config.Crypter{"vault": {Type: config.CrypterTypeKMS}},
+	})
+	assert.Error(t, err)
+}
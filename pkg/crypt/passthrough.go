@@ -0,0 +1,80 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Passthrough object layout:
+ *
+ *   | magic (4) | version (1) | zero padding |
+ *   |<------ PassthroughHeaderSize -------->|
+ *   | plaintext payload ...
+ *
+ * Header lets yproxy tell stored plaintext from encrypted objects.
+ */
+const (
+	PassthroughHeaderSize = EnvelopeHeaderSize
+	PassthroughVersion    = byte(1)
+)
+
+var passthroughMagic = []byte("YPLN")
+
+// PassthroughCrypter stores payload unencrypted.
+type PassthroughCrypter struct{}
+
+var _ Crypter = PassthroughCrypter{}
+var _ SeekableDecrypter = PassthroughCrypter{}
+
+// IsPassthroughHeader checks object prefix for passthrough magic.
+func IsPassthroughHeader(prefix []byte) bool {
+	return len(prefix) >= len(passthroughMagic) && bytes.Equal(prefix[:len(passthroughMagic)], passthroughMagic)
+}
+
+func (PassthroughCrypter) Encrypt(writer io.WriteCloser) (io.WriteCloser, error) {
+	header := make([]byte, PassthroughHeaderSize)
+	copy(header, passthroughMagic)
+	header[4] = PassthroughVersion
+	if _, err := writer.Write(header); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return nopWriteCloser{writer}, nil
+}
+
+func (PassthroughCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error) {
+	br := bufio.NewReaderSize(reader, PassthroughHeaderSize)
+	prefix, err := br.Peek(len(passthroughMagic))
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	if IsPassthroughHeader(prefix) {
+		if _, err := br.Discard(PassthroughHeaderSize); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return br, nil
+}
+
+func (p PassthroughCrypter) DecryptAt(r io.ReadCloser, open func(offset int64) (io.ReadCloser, error), offset int64) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, PassthroughHeaderSize)
+	prefix, err := br.Peek(len(passthroughMagic))
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	if !IsPassthroughHeader(prefix) {
+		if _, err := io.CopyN(io.Discard, br, offset); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(br), nil
+	}
+	_ = r.Close()
+	return open(PassthroughHeaderSize + offset)
+}
+
+func (PassthroughCrypter) CmpKey(string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,434 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/tablespace"
+)
+
+/*
+ * Objects written through registry have name of their crypter stored in
+ * the last bytes of fixed-size header (envelope, chunked or passthrough),
+ * so decryption does not depend on client flags or current tablespace
+ * mapping. Objects without name are decrypted with default crypter.
+ */
+const (
+	DefaultCrypterName = "default"
+
+	headerNameSize   = 64
+	headerNameOffset = EnvelopeHeaderSize - headerNameSize
+)
+
+// CrypterFactory builds crypter of some type from its configuration.
+type CrypterFactory func(cnf *config.Crypter) (Crypter, error)
+
+var (
+	factoriesMu sync.Mutex
+	factories   = map[string]CrypterFactory{
+		config.CrypterTypeGPG: func(cnf *config.Crypter) (Crypter, error) {
+			return newGPGObjectCrypter(cnf.GPGKeyPath, cnf.UseKEK, cnf.EncryptionFormat, cnf.EncryptionChunkSize)
+		},
+		config.CrypterTypeAESGCM: func(cnf *config.Crypter) (Crypter, error) {
+			kek, err := NewAESKeyCrypter(cnf.KeyPath)
+			if err != nil {
+				return nil, err
+			}
+			return NewChunkedCrypter(kek, cnf.EncryptionChunkSize), nil
+		},
+		config.CrypterTypeKMS: func(cnf *config.Crypter) (Crypter, error) {
+			kek, err := NewKMSKeyCrypter(cnf)
+			if err != nil {
+				return nil, err
+			}
+			return NewChunkedCrypter(kek, cnf.EncryptionChunkSize), nil
+		},
+		config.CrypterTypePassthrough: func(*config.Crypter) (Crypter, error) {
+			return PassthroughCrypter{}, nil
+		},
+	}
+)
+
+// RegisterCrypterType makes crypter type available in configuration.
+func RegisterCrypterType(tp string, f CrypterFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[tp] = f
+}
+
+func newGPGObjectCrypter(keyPath string, useKEK bool, format string, chunkSize int) (Crypter, error) {
+	cr, err := NewCrypto(&config.Crypto{GPGKeyPath: keyPath})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case format == config.EncryptionFormatAESGCM:
+		return NewChunkedCrypter(cr, chunkSize), nil
+	case useKEK:
+		return NewEnvelopeCrypter(cr), nil
+	default:
+		return cr, nil
+	}
+}
+
+// HeaderCrypter returns name of crypter stored in object header, if any.
+func HeaderCrypter(header []byte) string {
+	if len(header) < EnvelopeHeaderSize || !(IsEnvelopeHeader(header) || IsChunkedHeader(header) || IsPassthroughHeader(header)) {
+		return ""
+	}
+	return string(bytes.TrimRight(header[headerNameOffset:EnvelopeHeaderSize], "\x00"))
+}
+
+// KeyVersionOf returns key version reported to client for objects written by crypter.
+func KeyVersionOf(cr Crypter) KeyVersion {
+	switch c := cr.(type) {
+	case *namedCrypter:
+		return KeyVersionOf(c.Crypter)
+	case *selectedCrypter:
+		return KeyVersionOf(c.r.crypters[c.name])
+	case *EnvelopeCrypter, *ChunkedCrypter:
+		return KEKDEKEncryption
+	default:
+		return SingleKeyEncryption
+	}
+}
+
+// Registry holds named crypters and selects one for every object.
+type Registry struct {
+	crypters    map[string]Crypter
+	tablespaces map[string]string
+}
+
+var _ Crypter = &Registry{}
+
+// NewRegistry builds crypters of configuration. Legacy top-level GPG
+// settings become crypter named DefaultCrypterName.
+func NewRegistry(cnf *config.Crypto) (*Registry, error) {
+	r := &Registry{
+		crypters:    map[string]Crypter{},
+		tablespaces: map[string]string{},
+	}
+	if cnf.GPGKeyPath != "" {
+		cr, err := newGPGObjectCrypter(cnf.GPGKeyPath, cnf.UseKEK, cnf.EncryptionFormat, cnf.EncryptionChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		r.crypters[DefaultCrypterName] = &namedCrypter{Crypter: cr, name: DefaultCrypterName}
+	}
+
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	for name, c := range cnf.Crypters {
+		if name == "" || len(name) > headerNameSize {
+			return nil, fmt.Errorf("crypter name %q must be 1 to %d bytes long", name, headerNameSize)
+		}
+		if _, ok := r.crypters[name]; ok {
+			return nil, fmt.Errorf("crypter %q is configured twice", name)
+		}
+		f, ok := factories[c.Type]
+		if !ok {
+			return nil, fmt.Errorf("crypter %q has unknown type %q", name, c.Type)
+		}
+		cr, err := f(&c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure crypter %q: %w", name, err)
+		}
+		r.crypters[name] = &namedCrypter{Crypter: cr, name: name}
+	}
+
+	for ts, name := range cnf.TablespaceCrypters {
+		if _, ok := r.crypters[name]; !ok {
+			return nil, fmt.Errorf("tablespace %s refers to unknown crypter %q", ts, name)
+		}
+		r.tablespaces[ts] = name
+	}
+	if len(r.crypters) == 0 {
+		return nil, fmt.Errorf("no crypters configured")
+	}
+	return r, nil
+}
+
+// Get returns crypter by name.
+func (r *Registry) Get(name string) (Crypter, bool) {
+	cr, ok := r.crypters[name]
+	return cr, ok
+}
+
+// DefaultName returns name of crypter used when object settings
+// request none.
+func (r *Registry) DefaultName() string {
+	if _, ok := r.crypters[DefaultCrypterName]; ok || len(r.crypters) != 1 {
+		return DefaultCrypterName
+	}
+	for name := range r.crypters {
+		return name
+	}
+	return DefaultCrypterName
+}
+
+// ForSettings returns crypter for object with given storage settings:
+// explicitly requested one, one of object tablespace or default one.
+func (r *Registry) ForSettings(setts []settings.StorageSettings) (Crypter, error) {
+	name, ts := "", tablespace.DefaultTableSpace
+	for _, s := range setts {
+		switch s.Name {
+		case message.CrypterSetting:
+			name = s.Value
+		case message.TableSpaceSetting:
+			ts = s.Value
+		}
+	}
+	if name == "" {
+		name = r.tablespaces[ts]
+	}
+	if name == "" {
+		name = r.DefaultName()
+	}
+	if _, ok := r.crypters[name]; !ok {
+		return nil, fmt.Errorf("crypter %q is not configured", name)
+	}
+	return &selectedCrypter{r: r, name: name}, nil
+}
+
+// ObjectCrypter returns crypter decrypting object starting with header:
+// one named in header or, for objects without name, default one.
+func (r *Registry) ObjectCrypter(header []byte) (Crypter, error) {
+	return r.selected().objectCrypter(header)
+}
+
+func (r *Registry) selected() *selectedCrypter {
+	return &selectedCrypter{r: r, name: r.DefaultName()}
+}
+
+func (r *Registry) Encrypt(writer io.WriteCloser) (io.WriteCloser, error) {
+	return r.selected().Encrypt(writer)
+}
+
+func (r *Registry) Decrypt(reader io.ReadCloser) (io.Reader, error) {
+	return r.selected().Decrypt(reader)
+}
+
+func (r *Registry) DecryptAt(reader io.ReadCloser, open func(offset int64) (io.ReadCloser, error), offset int64) (io.ReadCloser, error) {
+	return r.selected().DecryptAt(reader, open, offset)
+}
+
+func (r *Registry) CmpKey(path string) (bool, error) {
+	return r.selected().CmpKey(path)
+}
+
+// selectedCrypter encrypts with chosen crypter and decrypts with crypter
+// named in object header.
+type selectedCrypter struct {
+	r    *Registry
+	name string
+}
+
+var _ SeekableDecrypter = &selectedCrypter{}
+
+func (s *selectedCrypter) crypter() (Crypter, error) {
+	cr, ok := s.r.crypters[s.name]
+	if !ok {
+		return nil, fmt.Errorf("crypter %q is not configured", s.name)
+	}
+	return cr, nil
+}
+
+func (s *selectedCrypter) Encrypt(writer io.WriteCloser) (io.WriteCloser, error) {
+	cr, err := s.crypter()
+	if err != nil {
+		return nil, err
+	}
+	return cr.Encrypt(writer)
+}
+
+// objectCrypter picks crypter of object by its header.
+func (s *selectedCrypter) objectCrypter(header []byte) (Crypter, error) {
+	name := HeaderCrypter(header)
+	if name == "" {
+		/* objects written before registry was introduced */
+		name = s.name
+		if _, ok := s.r.crypters[DefaultCrypterName]; ok {
+			name = DefaultCrypterName
+		}
+		cr, ok := s.r.crypters[name]
+		if !ok {
+			return nil, fmt.Errorf("crypter %q is not configured", name)
+		}
+		return legacyCrypter(cr), nil
+	}
+	cr, ok := s.r.crypters[name]
+	if !ok {
+		return nil, fmt.Errorf("object is encrypted by unknown crypter %q", name)
+	}
+	return cr, nil
+}
+
+func (s *selectedCrypter) peekCrypter(br *bufio.Reader) (Crypter, error) {
+	header, err := br.Peek(EnvelopeHeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	return s.objectCrypter(header)
+}
+
+// legacyCrypter returns crypter decrypting unnamed objects of cr. Those
+// were written with or without KEK depending on client request rather than
+// crypter configuration, so envelope and chunked headers are detected.
+func legacyCrypter(cr Crypter) Crypter {
+	if n, ok := cr.(*namedCrypter); ok {
+		cr = n.Crypter
+	}
+	switch cr.(type) {
+	case *EnvelopeCrypter, *ChunkedCrypter:
+		return cr
+	}
+	return NewEnvelopeCrypter(cr)
+}
+
+// KeyEnvelope returns envelope crypter wrapping data keys with key of cr.
+func KeyEnvelope(cr Crypter) *EnvelopeCrypter {
+	if n, ok := cr.(*namedCrypter); ok {
+		cr = n.Crypter
+	}
+	switch c := cr.(type) {
+	case *EnvelopeCrypter:
+		return c
+	case *ChunkedCrypter:
+		return c.envelope
+	}
+	return NewEnvelopeCrypter(cr)
+}
+
+func (s *selectedCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error) {
+	br := bufio.NewReaderSize(reader, EnvelopeHeaderSize)
+	cr, err := s.peekCrypter(br)
+	if err != nil {
+		return nil, err
+	}
+	return cr.Decrypt(io.NopCloser(br))
+}
+
+func (s *selectedCrypter) DecryptAt(reader io.ReadCloser, open func(offset int64) (io.ReadCloser, error), offset int64) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(reader, EnvelopeHeaderSize)
+	cr, err := s.peekCrypter(br)
+	if err != nil {
+		return nil, err
+	}
+	rc := struct {
+		io.Reader
+		io.Closer
+	}{br, reader}
+	if sd, ok := cr.(SeekableDecrypter); ok {
+		return sd.DecryptAt(rc, open, offset)
+	}
+	content, err := cr.Decrypt(rc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, content, offset); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(content), nil
+}
+
+func (s *selectedCrypter) CmpKey(path string) (bool, error) {
+	cr, err := s.crypter()
+	if err != nil {
+		return false, err
+	}
+	return cr.CmpKey(path)
+}
+
+// namedCrypter stores its name in headers of objects it writes.
+type namedCrypter struct {
+	Crypter
+	name string
+}
+
+func (n *namedCrypter) Encrypt(writer io.WriteCloser) (io.WriteCloser, error) {
+	sw := &stampWriter{w: writer, name: n.name}
+	w, err := n.Crypter.Encrypt(sw)
+	if err != nil {
+		return nil, err
+	}
+	return &stampCloser{WriteCloser: w, sw: sw}, nil
+}
+
+func (n *namedCrypter) DecryptAt(r io.ReadCloser, open func(offset int64) (io.ReadCloser, error), offset int64) (io.ReadCloser, error) {
+	if sd, ok := n.Crypter.(SeekableDecrypter); ok {
+		return sd.DecryptAt(r, open, offset)
+	}
+	content, err := n.Crypter.Decrypt(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, content, offset); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(content), nil
+}
+
+// stampWriter holds back object header to put crypter name into it.
+type stampWriter struct {
+	w       io.Writer
+	name    string
+	header  []byte
+	flushed bool
+}
+
+func (s *stampWriter) flush() error {
+	if s.flushed {
+		return nil
+	}
+	s.flushed = true
+	/* plain GPG objects have no header to keep name in */
+	if len(s.header) == EnvelopeHeaderSize && (IsEnvelopeHeader(s.header) || IsChunkedHeader(s.header) || IsPassthroughHeader(s.header)) {
+		copy(s.header[headerNameOffset:], s.name)
+	}
+	if _, err := s.w.Write(s.header); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *stampWriter) Write(p []byte) (int, error) {
+	if s.flushed {
+		return s.w.Write(p)
+	}
+	n := min(len(p), EnvelopeHeaderSize-len(s.header))
+	s.header = append(s.header, p[:n]...)
+	if len(s.header) < EnvelopeHeaderSize {
+		return n, nil
+	}
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	if n == len(p) {
+		return n, nil
+	}
+	m, err := s.w.Write(p[n:])
+	return n + m, err
+}
+
+func (s *stampWriter) Close() error {
+	return s.flush()
+}
+
+type stampCloser struct {
+	io.WriteCloser
+	sw *stampWriter
+}
+
+func (s *stampCloser) Close() error {
+	if err := s.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return s.sw.flush()
+}
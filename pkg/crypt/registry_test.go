@@ -0,0 +1,179 @@
+package crypt_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+)
+
+func writeAESKey(t *testing.T) string {
+	t.Helper()
+	p := path.Join(t.TempDir(), "aes.key")
+	require.NoError(t, os.WriteFile(p, []byte(hex.EncodeToString(bytes.Repeat([]byte{7}, 32))+"\n"), 0600))
+	return p
+}
+
+func newTestRegistry(t *testing.T) *crypt.Registry {
+	t.Helper()
+	reg, err := crypt.NewRegistry(&config.Crypto{
+		GPGKeyPath: "../../test/regress/gpg/gpg_1.priv",
+		UseKEK:     true,
+		Crypters: map[string]config.Crypter{
+			"fast":  {Type: config.CrypterTypeAESGCM, KeyPath: writeAESKey(t), EncryptionChunkSize: testChunkSize},
+			"plain": {Type: config.CrypterTypePassthrough},
+		},
+		TablespaceCrypters: map[string]string{"ts1": "fast"},
+	})
+	require.NoError(t, err)
+	return reg
+}
+
+func tsSettings(ts string) []settings.StorageSettings {
+	return []settings.StorageSettings{{Name: message.TableSpaceSetting, Value: ts}}
+}
+
+func TestRegistrySelection(t *testing.T) {
+	reg := newTestRegistry(t)
+	payload := bytes.Repeat([]byte("tablespace data "), 10)
+
+	cases := []struct {
+		setts   []settings.StorageSettings
+		crypter string
+		version crypt.KeyVersion
+	}{
+		{nil, crypt.DefaultCrypterName, crypt.KEKDEKEncryption},
+		{tsSettings("pg_default"), crypt.DefaultCrypterName, crypt.KEKDEKEncryption},
+		{tsSettings("ts1"), "fast", crypt.KEKDEKEncryption},
+		{append(tsSettings("ts1"), settings.StorageSettings{Name: message.CrypterSetting, Value: "plain"}), "plain", crypt.SingleKeyEncryption},
+	}
+	for _, c := range cases {
+		cr, err := reg.ForSettings(c.setts)
+		require.NoError(t, err)
+		assert.Equal(t, c.version, crypt.KeyVersionOf(cr))
+
+		encrypted := encryptAll(t, cr, payload)
+		assert.Equal(t, c.crypter, crypt.HeaderCrypter(encrypted))
+
+		/* crypter is picked from object header, not from settings */
+		assert.Equal(t, payload, decryptAll(t, reg, encrypted), c.crypter)
+
+		res, _ := decryptAt(t, reg, encrypted, 20)
+		assert.Equal(t, payload[20:], res, c.crypter)
+	}
+
+	_, err := reg.ForSettings([]settings.StorageSettings{{Name: message.CrypterSetting, Value: "missing"}})
+	assert.Error(t, err)
+}
+
+func TestRegistryPassthrough(t *testing.T) {
+	reg := newTestRegistry(t)
+	cr, ok := reg.Get("plain")
+	require.True(t, ok)
+
+	encrypted := encryptAll(t, cr, []byte("stored as is"))
+	assert.Equal(t, []byte("stored as is"), encrypted[crypt.PassthroughHeaderSize:])
+}
+
+func TestRegistryLegacyObjects(t *testing.T) {
+	reg := newTestRegistry(t)
+	kek := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+
+	payload := []byte("object written before registry was configured")
+	for _, encrypted := range [][]byte{encryptAll(t, kek, payload), encryptAll(t, crypt.NewEnvelopeCrypter(kek), payload)} {
+		assert.Empty(t, crypt.HeaderCrypter(encrypted))
+		assert.Equal(t, payload, decryptAll(t, reg, encrypted))
+
+		cr, err := reg.ForSettings(tsSettings("ts1"))
+		require.NoError(t, err)
+		assert.Equal(t, payload, decryptAll(t, cr, encrypted))
+	}
+}
+
+func TestRegistryLegacyObjectsWithoutKEK(t *testing.T) {
+	/* KEK/DEK objects used to be written on client request, regardless of use_kek */
+	reg, err := crypt.NewRegistry(&config.Crypto{GPGKeyPath: "../../test/regress/gpg/gpg_1.priv"})
+	require.NoError(t, err)
+	kek := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+
+	payload := bytes.Repeat([]byte("object written before registry was configured"), 100)
+	for _, encrypted := range [][]byte{
+		encryptAll(t, kek, payload),
+		encryptAll(t, crypt.NewEnvelopeCrypter(kek), payload),
+		encryptAll(t, crypt.NewChunkedCrypter(kek, testChunkSize), payload),
+	} {
+		assert.Empty(t, crypt.HeaderCrypter(encrypted))
+		assert.Equal(t, payload, decryptAll(t, reg, encrypted))
+
+		cr, err := reg.ObjectCrypter(encrypted[:crypt.EnvelopeHeaderSize])
+		require.NoError(t, err)
+		assert.Equal(t, payload, decryptAll(t, cr, encrypted))
+	}
+}
+
+func TestRegistryObjectCrypter(t *testing.T) {
+	reg := newTestRegistry(t)
+	fast, err := reg.ForSettings(tsSettings("ts1"))
+	require.NoError(t, err)
+
+	encrypted := encryptAll(t, fast, []byte("payload"))
+	cr, err := reg.ObjectCrypter(encrypted[:crypt.EnvelopeHeaderSize])
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), decryptAll(t, cr, encrypted))
+
+	unknown := bytes.Clone(encrypted)
+	copy(unknown[crypt.EnvelopeHeaderSize-64:], "missing")
+	_, err = reg.ObjectCrypter(unknown[:crypt.EnvelopeHeaderSize])
+	assert.Error(t, err)
+}
+
+func TestRegistryRewrapKeepsName(t *testing.T) {
+	reg := newTestRegistry(t)
+	oldCr := crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv"))
+	newCr := crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_2.priv"))
+
+	encrypted := encryptAll(t, reg, []byte("payload"))
+	newHeader, err := oldCr.Rewrap(encrypted[:crypt.EnvelopeHeaderSize], newCr)
+	require.NoError(t, err)
+	assert.Equal(t, crypt.DefaultCrypterName, crypt.HeaderCrypter(newHeader))
+}
+
+func TestRegistryConfiguration(t *testing.T) {
+	_, err := crypt.NewRegistry(&config.Crypto{})
+	assert.Error(t, err)
+
+	_, err = crypt.NewRegistry(&config.Crypto{Crypters: map[string]config.Crypter{"x": {Type: "rot13"}}})
+	assert.Error(t, err)
+
+	_, err = crypt.NewRegistry(&config.Crypto{
+		Crypters:           map[string]config.Crypter{"plain": {Type: config.CrypterTypePassthrough}},
+		TablespaceCrypters: map[string]string{"ts1": "missing"},
+	})
+	assert.Error(t, err)
+
+	/* single configured crypter is default one */
+	crypt.RegisterCrypterType("custom", func(*config.Crypter) (crypt.Crypter, error) {
+		return crypt.PassthroughCrypter{}, nil
+	})
+	reg, err := crypt.NewRegistry(&config.Crypto{Crypters: map[string]config.Crypter{"mine": {Type: "custom"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "mine", crypt.HeaderCrypter(encryptAll(t, reg, []byte("data"))))
+
+	/* empty object still gets header */
+	assert.Equal(t, "mine", crypt.HeaderCrypter(encryptAll(t, reg, nil)))
+	r, err := reg.Decrypt(io.NopCloser(bytes.NewReader(encryptAll(t, reg, nil))))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
@@ -5,4 +5,7 @@ const (
 	TableSpaceSetting   = "TableSpace"
 	MultipartChunkSize  = "MultipartChunkSize"
 	MultipartUpload     = "MultipartUpload"
+	CrypterSetting      = "Crypter"
+	QoSClassSetting     = "QoSClass"
+	CompressionSetting  = "Compression"
 )
@@ -3,6 +3,7 @@ package message
 import (
 	"encoding/binary"
 
+	"github.com/yezzey-gp/yproxy/pkg/settings"
 	"github.com/yezzey-gp/yproxy/pkg/ylogger"
 )
 
@@ -11,6 +12,8 @@ const (
 	CopyDecrypt    = 0b10
 	CopyUseKEK     = 0b100
 	CopyServerSide = 0b1000
+	CopyResume     = 0b10000
+	CopyVerify     = 0b100000
 )
 
 type CopyMessageV2 struct {
@@ -22,11 +25,15 @@ type CopyMessageV2 struct {
 	Confirm        bool
 	KEKDecrypt     bool
 	ServerSideCopy bool
+	Resume         bool
+	Verify         bool
+	/* settings of objects written to destination, e.g. tablespace */
+	Settings []settings.StorageSettings
 }
 
 var _ ProtoMessage = &CopyMessageV2{}
 
-func NewCopyMessageV2(name, oldCfgPath string, encrypt, decrypt, confirm, kEKDecrypt, ssCopy bool, port uint64) *CopyMessageV2 {
+func NewCopyMessageV2(name, oldCfgPath string, encrypt, decrypt, confirm, kEKDecrypt, ssCopy, resume, verify bool, port uint64) *CopyMessageV2 {
 	return &CopyMessageV2{
 		Name:           name,
 		Encrypt:        encrypt,
@@ -36,6 +43,8 @@ func NewCopyMessageV2(name, oldCfgPath string, encrypt, decrypt, confirm, kEKDec
 		Confirm:        confirm,
 		KEKDecrypt:     kEKDecrypt,
 		ServerSideCopy: ssCopy,
+		Resume:         resume,
+		Verify:         verify,
 	}
 }
 
@@ -53,6 +62,12 @@ func (message *CopyMessageV2) Encode() []byte {
 	if message.ServerSideCopy {
 		flags |= CopyServerSide
 	}
+	if message.Resume {
+		flags |= CopyResume
+	}
+	if message.Verify {
+		flags |= CopyVerify
+	}
 
 	encodedMessage := []byte{
 		byte(MessageTypeCopyV2),
@@ -82,6 +97,17 @@ func (message *CopyMessageV2) Encode() []byte {
 		encodedMessage = append(encodedMessage, 0)
 	}
 
+	/* older servers stop reading after confirm flag */
+	if len(message.Settings) > 0 {
+		encodedMessage = binary.BigEndian.AppendUint64(encodedMessage, uint64(len(message.Settings)))
+		for _, s := range message.Settings {
+			encodedMessage = append(encodedMessage, []byte(s.Name)...)
+			encodedMessage = append(encodedMessage, 0)
+			encodedMessage = append(encodedMessage, []byte(s.Value)...)
+			encodedMessage = append(encodedMessage, 0)
+		}
+	}
+
 	binary.BigEndian.PutUint64(byteLen, uint64(len(encodedMessage)+8))
 	ylogger.Zero.Debug().Str("type", MessageType(encodedMessage[0]).String()).Msg("send")
 	ylogger.Zero.Debug().Str("object-path", MessageType(encodedMessage[0]).String()).Msg("decrypt object")
@@ -101,6 +127,12 @@ func (encodedMessage *CopyMessageV2) Decode(data []byte) {
 	if data[1]&CopyServerSide != 0 {
 		encodedMessage.ServerSideCopy = true
 	}
+	if data[1]&CopyResume != 0 {
+		encodedMessage.Resume = true
+	}
+	if data[1]&CopyVerify != 0 {
+		encodedMessage.Verify = true
+	}
 
 	nameLen := binary.BigEndian.Uint64(data[4:12])
 	encodedMessage.Name = string(data[12 : 12+nameLen])
@@ -112,4 +144,22 @@ func (encodedMessage *CopyMessageV2) Decode(data []byte) {
 	if data[ind] == 1 {
 		encodedMessage.Confirm = true
 	}
+
+	totalOff := ind + 1
+	if uint64(len(data)) < totalOff+8 {
+		return
+	}
+	settLen := binary.BigEndian.Uint64(data[totalOff : totalOff+8])
+	totalOff += 8
+
+	encodedMessage.Settings = make([]settings.StorageSettings, settLen)
+	for i := 0; i < int(settLen); i++ {
+		var currOff uint64
+
+		encodedMessage.Settings[i].Name, currOff = GetCstring(data[totalOff:])
+		totalOff += currOff
+
+		encodedMessage.Settings[i].Value, currOff = GetCstring(data[totalOff:])
+		totalOff += currOff
+	}
 }
@@ -0,0 +1,90 @@
+package message
+
+import (
+	"encoding/binary"
+)
+
+type CopyMismatchReason byte
+
+const (
+	CopyMismatchMissing = CopyMismatchReason(iota + 1)
+	CopyMismatchSize
+	CopyMismatchChecksum
+	CopyMismatchError
+)
+
+func (r CopyMismatchReason) String() string {
+	switch r {
+	case CopyMismatchMissing:
+		return "missing"
+	case CopyMismatchSize:
+		return "size"
+	case CopyMismatchChecksum:
+		return "checksum"
+	case CopyMismatchError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// CopyMismatchMessage reports object which failed post-copy verification.
+// Digests are either ETags or hex SHA-256 of compared content.
+type CopyMismatchMessage struct {
+	Reason CopyMismatchReason
+	Path   string
+
+	SourceSize int64
+	DestSize   int64
+
+	SourceDigest string
+	DestDigest   string
+
+	Error string
+}
+
+var _ ProtoMessage = &CopyMismatchMessage{}
+
+func (m *CopyMismatchMessage) Encode() []byte {
+	encodedMessage := []byte{
+		byte(MessageTypeCopyMismatch),
+		byte(m.Reason),
+		0,
+		0,
+	}
+
+	byteLen := make([]byte, 8)
+
+	binary.BigEndian.PutUint64(byteLen, uint64(m.SourceSize))
+	encodedMessage = append(encodedMessage, byteLen...)
+	binary.BigEndian.PutUint64(byteLen, uint64(m.DestSize))
+	encodedMessage = append(encodedMessage, byteLen...)
+
+	for _, str := range []string{m.Path, m.SourceDigest, m.DestDigest, m.Error} {
+		byteStr := []byte(str)
+		binary.BigEndian.PutUint64(byteLen, uint64(len(byteStr)))
+		encodedMessage = append(encodedMessage, byteLen...)
+		encodedMessage = append(encodedMessage, byteStr...)
+	}
+
+	binary.BigEndian.PutUint64(byteLen, uint64(len(encodedMessage)+8))
+	return append(byteLen, encodedMessage...)
+}
+
+func (m *CopyMismatchMessage) Decode(data []byte) {
+	m.Reason = CopyMismatchReason(data[1])
+	m.SourceSize = int64(binary.BigEndian.Uint64(data[4:12]))
+	m.DestSize = int64(binary.BigEndian.Uint64(data[12:20]))
+
+	off := uint64(20)
+	readString := func() string {
+		ln := binary.BigEndian.Uint64(data[off : off+8])
+		off += 8
+		str := string(data[off : off+ln])
+		off += ln
+		return str
+	}
+	m.Path = readString()
+	m.SourceDigest = readString()
+	m.DestDigest = readString()
+	m.Error = readString()
+}
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"encoding/binary"
+)
+
+type CopyMethod byte
+
+const (
+	CopyMethodServerSide = CopyMethod(iota + 1)
+	CopyMethodStreamed
+)
+
+func (m CopyMethod) String() string {
+	switch m {
+	case CopyMethodServerSide:
+		return "server-side"
+	case CopyMethodStreamed:
+		return "streamed"
+	}
+	return "unknown"
+}
+
+// CopyStatusMessage reports how single object was copied in server-side copy mode.
+type CopyStatusMessage struct {
+	Method CopyMethod
+	Path   string
+	Size   int64
+}
+
+var _ ProtoMessage = &CopyStatusMessage{}
+
+func NewCopyStatusMessage(method CopyMethod, path string, size int64) *CopyStatusMessage {
+	return &CopyStatusMessage{
+		Method: method,
+		Path:   path,
+		Size:   size,
+	}
+}
+
+func (m *CopyStatusMessage) Encode() []byte {
+	encodedMessage := []byte{
+		byte(MessageTypeCopyStatus),
+		byte(m.Method),
+		0,
+		0,
+	}
+
+	byteLen := make([]byte, 8)
+
+	binary.BigEndian.PutUint64(byteLen, uint64(m.Size))
+	encodedMessage = append(encodedMessage, byteLen...)
+
+	bytePath := []byte(m.Path)
+	binary.BigEndian.PutUint64(byteLen, uint64(len(bytePath)))
+	encodedMessage = append(encodedMessage, byteLen...)
+	encodedMessage = append(encodedMessage, bytePath...)
+
+	binary.BigEndian.PutUint64(byteLen, uint64(len(encodedMessage)+8))
+	return append(byteLen, encodedMessage...)
+}
+
+func (m *CopyStatusMessage) Decode(data []byte) {
+	m.Method = CopyMethod(data[1])
+	m.Size = int64(binary.BigEndian.Uint64(data[4:12]))
+
+	pathLen := binary.BigEndian.Uint64(data[12:20])
+	m.Path = string(data[20 : 20+pathLen])
+}
@@ -37,6 +37,18 @@ const (
 	MessageCollectObsolete = MessageType(64)
 	MessageDeleteObsolete  = MessageType(65)
 
+	MessageTypeRotateKeys       = MessageType(66)
+	MessageTypeRotateKeysStatus = MessageType(67)
+
+	MessageTypeCopyStatus   = MessageType(68)
+	MessageTypeCopyMismatch = MessageType(69)
+
+	MessageTypeSession   = MessageType(70)
+	MessageTypeTerminate = MessageType(71)
+
+	MessageTypeStartup    = MessageType(72)
+	MessageTypeServerInfo = MessageType(73)
+
 	DecryptMessage   = RequestEncryption(1)
 	NoDecryptMessage = RequestEncryption(0)
 
@@ -77,6 +89,8 @@ func (m MessageType) String() string {
 		return "LISTV2"
 	case MessageTypeObjectMeta:
 		return "OBJECT META"
+	case MessageTypePatch:
+		return "PATCH"
 	case MessageTypeCopy:
 		return "COPY"
 	case MessageTypeCopyV2:
@@ -95,6 +109,22 @@ func (m MessageType) String() string {
 		return "COPY COMPLETE"
 	case MessageTypeDelete2:
 		return "DELETE2"
+	case MessageTypeRotateKeys:
+		return "ROTATE KEYS"
+	case MessageTypeRotateKeysStatus:
+		return "ROTATE KEYS STATUS"
+	case MessageTypeCopyStatus:
+		return "COPY STATUS"
+	case MessageTypeCopyMismatch:
+		return "COPY MISMATCH"
+	case MessageTypeSession:
+		return "SESSION"
+	case MessageTypeTerminate:
+		return "TERMINATE"
+	case MessageTypeStartup:
+		return "STARTUP"
+	case MessageTypeServerInfo:
+		return "SERVER INFO"
 	}
 	return "UNKNOWN"
 }
@@ -180,6 +180,15 @@ func TestPutCompleteMsg(t *testing.T) {
 
 		assert.Equal(msg.KeyVersion, msg2.KeyVersion)
 	}
+
+	msg := message.NewPutCompleteMessage(2)
+	msg.ChecksumAlgorithm = 1
+	msg.Checksum = []byte{0xde, 0xad, 0xbe, 0xef}
+	body := msg.Encode()
+
+	msg2 := message.PutCompleteMessage{}
+	msg2.Decode(body[8:])
+	assert.Equal(*msg, msg2)
 }
 
 func TestCatMsgV2(t *testing.T) {
@@ -499,3 +508,132 @@ func TestErrorMsg(t *testing.T) {
 	assert.Equal(errString, msg2.Error)
 	assert.Equal(messageString, msg2.Message)
 }
+
+func TestRotateKeysMsg(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := message.NewRotateKeysMessage("/segments/prefix", "/etc/yproxy/new.priv", true, false)
+	body := msg.Encode()
+
+	assert.Equal(body[8], byte(message.MessageTypeRotateKeys))
+
+	msg2 := message.RotateKeysMessage{}
+	msg2.Decode(body[8:])
+
+	assert.Equal("/segments/prefix", msg2.Prefix)
+	assert.Equal("/etc/yproxy/new.priv", msg2.NewKeyPath)
+	assert.True(msg2.Confirm)
+	assert.False(msg2.Reencrypt)
+}
+
+func TestRotateKeysStatusMsg(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := message.NewRotateKeysStatusMessage(message.RotateStatusFailed, "/segments/1", fmt.Errorf("no such key"))
+	body := msg.Encode()
+
+	assert.Equal(body[8], byte(message.MessageTypeRotateKeysStatus))
+
+	msg2 := message.RotateKeysStatusMessage{}
+	msg2.Decode(body[8:])
+
+	assert.Equal(message.RotateStatusFailed, msg2.Status)
+	assert.Equal("/segments/1", msg2.Path)
+	assert.Equal("no such key", msg2.Error)
+}
+
+func TestCopyStatusMsg(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := message.NewCopyStatusMessage(message.CopyMethodServerSide, "/segments/1", 6*1024*1024*1024)
+	body := msg.Encode()
+
+	assert.Equal(body[8], byte(message.MessageTypeCopyStatus))
+
+	msg2 := message.CopyStatusMessage{}
+	msg2.Decode(body[8:])
+
+	assert.Equal(message.CopyMethodServerSide, msg2.Method)
+	assert.Equal("/segments/1", msg2.Path)
+	assert.Equal(int64(6*1024*1024*1024), msg2.Size)
+}
+
+func TestCopyV2Msg(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := message.NewCopyMessageV2("myname/mynextname", "myoldcfg/path", true, false, true, true, false, true, true, 5432)
+	body := msg.Encode()
+
+	assert.Equal(body[8], byte(message.MessageTypeCopyV2))
+
+	msg2 := message.CopyMessageV2{}
+	msg2.Decode(body[8:])
+
+	assert.Equal("myname/mynextname", msg2.Name)
+	assert.Equal("myoldcfg/path", msg2.OldCfgPath)
+	assert.True(msg2.Encrypt)
+	assert.False(msg2.Decrypt)
+	assert.True(msg2.Confirm)
+	assert.True(msg2.KEKDecrypt)
+	assert.False(msg2.ServerSideCopy)
+	assert.True(msg2.Resume)
+	assert.True(msg2.Verify)
+	assert.Equal(uint64(5432), msg2.Port)
+}
+
+func TestCopyV2MsgSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := message.NewCopyMessageV2("myname/mynextname", "myoldcfg/path", true, false, true, false, false, false, false, 5432)
+	msg.Settings = []settings.StorageSettings{{Name: message.TableSpaceSetting, Value: "ts1"}}
+	body := msg.Encode()
+
+	msg2 := message.CopyMessageV2{}
+	msg2.Decode(body[8:])
+
+	assert.Equal("myname/mynextname", msg2.Name)
+	assert.True(msg2.Confirm)
+	assert.Equal(msg.Settings, msg2.Settings)
+}
+
+func TestCopyMismatchMsg(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := &message.CopyMismatchMessage{
+		Reason:       message.CopyMismatchChecksum,
+		Path:         "/segments/1",
+		SourceSize:   100,
+		DestSize:     100,
+		SourceDigest: "aaa",
+		DestDigest:   "bbb",
+	}
+	body := msg.Encode()
+
+	assert.Equal(body[8], byte(message.MessageTypeCopyMismatch))
+
+	msg2 := message.CopyMismatchMessage{}
+	msg2.Decode(body[8:])
+
+	assert.Equal(*msg, msg2)
+}
+
+func TestStartupMsg(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := message.NewStartupMessage(message.ProtocolVersion, message.CapabilityKEK|message.CapabilitySession)
+	body := msg.Encode()
+	assert.Equal(uint64(len(body)), binary.BigEndian.Uint64(body[:8]))
+	assert.Equal(message.MessageTypeStartup, message.MessageType(body[8]))
+
+	msg2 := message.StartupMessage{}
+	msg2.Decode(body[8:])
+	assert.Equal(*msg, msg2)
+
+	info := message.NewServerInfoMessage(1, message.CapabilityPatch|message.CapabilityServerSideCopy, "1.2-abc")
+	body = info.Encode()
+
+	info2 := message.ServerInfoMessage{}
+	info2.Decode(body[8:])
+	assert.Equal(*info, info2)
+	assert.Equal("server-side-copy,patch", info2.Capabilities.String())
+}
@@ -6,6 +6,9 @@ import (
 
 type PutCompleteMessage struct {
 	KeyVersion uint16
+	/* checksum of object content, none when algorithm is 0 */
+	ChecksumAlgorithm byte
+	Checksum          []byte
 }
 
 var _ ProtoMessage = &PutCompleteMessage{}
@@ -27,6 +30,10 @@ func (c *PutCompleteMessage) Encode() []byte {
 	kv := make([]byte, 2)
 	binary.BigEndian.PutUint16(kv, uint16(c.KeyVersion))
 	bt = append(bt, kv...)
+	if c.ChecksumAlgorithm != 0 {
+		bt = append(bt, c.ChecksumAlgorithm, byte(len(c.Checksum)))
+		bt = append(bt, c.Checksum...)
+	}
 
 	ln := len(bt) + 8
 
@@ -37,4 +44,14 @@ func (c *PutCompleteMessage) Encode() []byte {
 
 func (c *PutCompleteMessage) Decode(body []byte) {
 	c.KeyVersion = binary.BigEndian.Uint16(body[4 : 4+2])
+	/* older servers send key version only */
+	if len(body) < 8 {
+		return
+	}
+	sz := int(body[7])
+	if len(body) < 8+sz {
+		return
+	}
+	c.ChecksumAlgorithm = body[6]
+	c.Checksum = append([]byte(nil), body[8:8+sz]...)
 }
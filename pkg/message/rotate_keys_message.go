@@ -0,0 +1,70 @@
+package message
+
+import (
+	"encoding/binary"
+)
+
+const (
+	RotateConfirm   = 0b1
+	RotateReencrypt = 0b10
+)
+
+type RotateKeysMessage struct {
+	Prefix     string
+	NewKeyPath string
+	Confirm    bool
+	Reencrypt  bool // re-encrypt payload instead of re-wrapping data key only
+}
+
+var _ ProtoMessage = &RotateKeysMessage{}
+
+func NewRotateKeysMessage(prefix, newKeyPath string, confirm, reencrypt bool) *RotateKeysMessage {
+	return &RotateKeysMessage{
+		Prefix:     prefix,
+		NewKeyPath: newKeyPath,
+		Confirm:    confirm,
+		Reencrypt:  reencrypt,
+	}
+}
+
+func (m *RotateKeysMessage) Encode() []byte {
+	flags := byte(0)
+	if m.Confirm {
+		flags |= RotateConfirm
+	}
+	if m.Reencrypt {
+		flags |= RotateReencrypt
+	}
+
+	encodedMessage := []byte{
+		byte(MessageTypeRotateKeys),
+		flags,
+		0,
+		0,
+	}
+
+	byteLen := make([]byte, 8)
+
+	bytePrefix := []byte(m.Prefix)
+	binary.BigEndian.PutUint64(byteLen, uint64(len(bytePrefix)))
+	encodedMessage = append(encodedMessage, byteLen...)
+	encodedMessage = append(encodedMessage, bytePrefix...)
+
+	byteKeyPath := []byte(m.NewKeyPath)
+	binary.BigEndian.PutUint64(byteLen, uint64(len(byteKeyPath)))
+	encodedMessage = append(encodedMessage, byteLen...)
+	encodedMessage = append(encodedMessage, byteKeyPath...)
+
+	binary.BigEndian.PutUint64(byteLen, uint64(len(encodedMessage)+8))
+	return append(byteLen, encodedMessage...)
+}
+
+func (m *RotateKeysMessage) Decode(data []byte) {
+	m.Confirm = data[1]&RotateConfirm != 0
+	m.Reencrypt = data[1]&RotateReencrypt != 0
+
+	prefixLen := binary.BigEndian.Uint64(data[4:12])
+	m.Prefix = string(data[12 : 12+prefixLen])
+	keyPathLen := binary.BigEndian.Uint64(data[12+prefixLen : 12+prefixLen+8])
+	m.NewKeyPath = string(data[12+prefixLen+8 : 12+prefixLen+8+keyPathLen])
+}
@@ -0,0 +1,81 @@
+package message
+
+import (
+	"encoding/binary"
+)
+
+type RotateStatus byte
+
+const (
+	RotateStatusRewrapped = RotateStatus(iota + 1)
+	RotateStatusReencrypted
+	RotateStatusSkipped
+	RotateStatusFailed
+)
+
+func (s RotateStatus) String() string {
+	switch s {
+	case RotateStatusRewrapped:
+		return "rewrapped"
+	case RotateStatusReencrypted:
+		return "reencrypted"
+	case RotateStatusSkipped:
+		return "skipped"
+	case RotateStatusFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// RotateKeysStatusMessage reports result of key rotation for single object.
+type RotateKeysStatusMessage struct {
+	Status RotateStatus
+	Path   string
+	Error  string
+}
+
+var _ ProtoMessage = &RotateKeysStatusMessage{}
+
+func NewRotateKeysStatusMessage(status RotateStatus, path string, err error) *RotateKeysStatusMessage {
+	m := &RotateKeysStatusMessage{
+		Status: status,
+		Path:   path,
+	}
+	if err != nil {
+		m.Error = err.Error()
+	}
+	return m
+}
+
+func (m *RotateKeysStatusMessage) Encode() []byte {
+	encodedMessage := []byte{
+		byte(MessageTypeRotateKeysStatus),
+		byte(m.Status),
+		0,
+		0,
+	}
+
+	byteLen := make([]byte, 8)
+
+	bytePath := []byte(m.Path)
+	binary.BigEndian.PutUint64(byteLen, uint64(len(bytePath)))
+	encodedMessage = append(encodedMessage, byteLen...)
+	encodedMessage = append(encodedMessage, bytePath...)
+
+	byteError := []byte(m.Error)
+	binary.BigEndian.PutUint64(byteLen, uint64(len(byteError)))
+	encodedMessage = append(encodedMessage, byteLen...)
+	encodedMessage = append(encodedMessage, byteError...)
+
+	binary.BigEndian.PutUint64(byteLen, uint64(len(encodedMessage)+8))
+	return append(byteLen, encodedMessage...)
+}
+
+func (m *RotateKeysStatusMessage) Decode(data []byte) {
+	m.Status = RotateStatus(data[1])
+
+	pathLen := binary.BigEndian.Uint64(data[4:12])
+	m.Path = string(data[12 : 12+pathLen])
+	errorLen := binary.BigEndian.Uint64(data[12+pathLen : 12+pathLen+8])
+	m.Error = string(data[12+pathLen+8 : 12+pathLen+8+errorLen])
+}
@@ -0,0 +1,53 @@
+package message
+
+import "encoding/binary"
+
+// SessionMessage opens session: requests following it are served on the
+// same connection until TerminateMessage.
+type SessionMessage struct {
+}
+
+var _ ProtoMessage = &SessionMessage{}
+
+func NewSessionMessage() *SessionMessage {
+	return &SessionMessage{}
+}
+
+func (c *SessionMessage) Encode() []byte {
+	return encodeEmpty(MessageTypeSession)
+}
+
+func (c *SessionMessage) Decode(body []byte) {
+}
+
+// TerminateMessage ends session.
+type TerminateMessage struct {
+}
+
+var _ ProtoMessage = &TerminateMessage{}
+
+func NewTerminateMessage() *TerminateMessage {
+	return &TerminateMessage{}
+}
+
+func (c *TerminateMessage) Encode() []byte {
+	return encodeEmpty(MessageTypeTerminate)
+}
+
+func (c *TerminateMessage) Decode(body []byte) {
+}
+
+func encodeEmpty(tp MessageType) []byte {
+	bt := []byte{
+		byte(tp),
+		0,
+		0,
+		0,
+	}
+
+	ln := len(bt) + 8
+
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(ln))
+	return append(bs, bt...)
+}
@@ -0,0 +1,138 @@
+package message
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	/* protocol version spoken by this build */
+	ProtocolVersion = uint32(1)
+	/* oldest protocol version served */
+	MinProtocolVersion = uint32(1)
+)
+
+// Capability is bitmap of protocol features server supports.
+type Capability uint64
+
+const (
+	CapabilityKEK = Capability(1 << iota)
+	CapabilityServerSideCopy
+	CapabilityPatch
+	CapabilitySession
+	CapabilityCompression
+)
+
+var capabilityNames = []struct {
+	c    Capability
+	name string
+}{
+	{CapabilityKEK, "kek"},
+	{CapabilityServerSideCopy, "server-side-copy"},
+	{CapabilityPatch, "patch"},
+	{CapabilitySession, "session"},
+	{CapabilityCompression, "compression"},
+}
+
+// Names returns names of capabilities set in bitmap.
+func (c Capability) Names() []string {
+	var names []string
+	for _, cn := range capabilityNames {
+		if c&cn.c != 0 {
+			names = append(names, cn.name)
+		}
+	}
+	return names
+}
+
+func (c Capability) String() string {
+	return strings.Join(c.Names(), ",")
+}
+
+// StartupMessage is optional first message of connection: client sends its
+// protocol version and capabilities it requires, server answers with
+// ServerInfoMessage or Error if it can not serve them.
+type StartupMessage struct {
+	ProtocolVersion uint32
+	Required        Capability
+}
+
+var _ ProtoMessage = &StartupMessage{}
+
+func NewStartupMessage(version uint32, required Capability) *StartupMessage {
+	return &StartupMessage{
+		ProtocolVersion: version,
+		Required:        required,
+	}
+}
+
+func (c *StartupMessage) Encode() []byte {
+	bt := []byte{
+		byte(MessageTypeStartup),
+		0,
+		0,
+		0,
+	}
+
+	bt = binary.BigEndian.AppendUint32(bt, c.ProtocolVersion)
+	bt = binary.BigEndian.AppendUint64(bt, uint64(c.Required))
+	ln := len(bt) + 8
+
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(ln))
+	return append(bs, bt...)
+}
+
+func (c *StartupMessage) Decode(body []byte) {
+	if len(body) < 16 {
+		return
+	}
+	c.ProtocolVersion = binary.BigEndian.Uint32(body[4:8])
+	c.Required = Capability(binary.BigEndian.Uint64(body[8:16]))
+}
+
+// ServerInfoMessage answers StartupMessage with negotiated protocol version,
+// server capabilities and yproxy version.
+type ServerInfoMessage struct {
+	ProtocolVersion uint32
+	Capabilities    Capability
+	Version         string
+}
+
+var _ ProtoMessage = &ServerInfoMessage{}
+
+func NewServerInfoMessage(version uint32, capabilities Capability, yproxyVersion string) *ServerInfoMessage {
+	return &ServerInfoMessage{
+		ProtocolVersion: version,
+		Capabilities:    capabilities,
+		Version:         yproxyVersion,
+	}
+}
+
+func (c *ServerInfoMessage) Encode() []byte {
+	bt := []byte{
+		byte(MessageTypeServerInfo),
+		0,
+		0,
+		0,
+	}
+
+	bt = binary.BigEndian.AppendUint32(bt, c.ProtocolVersion)
+	bt = binary.BigEndian.AppendUint64(bt, uint64(c.Capabilities))
+	bt = append(bt, []byte(c.Version)...)
+	bt = append(bt, 0)
+	ln := len(bt) + 8
+
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(ln))
+	return append(bs, bt...)
+}
+
+func (c *ServerInfoMessage) Decode(body []byte) {
+	if len(body) < 16 {
+		return
+	}
+	c.ProtocolVersion = binary.BigEndian.Uint32(body[4:8])
+	c.Capabilities = Capability(binary.BigEndian.Uint64(body[8:16]))
+	c.Version, _ = GetCstring(body[16:])
+}
@@ -12,6 +12,7 @@ import (
 
 var (
 	latencyBuckets = []float64{0.001, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 50, 100, 500, 1000}
+	ratioBuckets   = []float64{1, 1.25, 1.5, 2, 3, 4, 6, 8, 16}
 	sizeBuckets    = []float64{1, 128, 1024, 128 * 1024, 1024 * 1024, 2 * 1024 * 1024, 8 * 1024 * 1024, 16 * 1024 * 1024, 128 * 1024 * 1024, 1024 * 1024 * 1024}
 
 	HandlerNames = map[string]bool{
@@ -21,11 +22,19 @@ var (
 		"LIMIT_WRITE":      true,
 		"S3_PUT":           true,
 		"S3_GET":           true,
+		"AZBLOB_PUT":       true,
+		"AZBLOB_GET":       true,
+		"GCS_PUT":          true,
+		"GCS_GET":          true,
+		"TIER_DESTAGE":     true,
+		"KMS_WRAP":         true,
+		"KMS_UNWRAP":       true,
 		"CAT":              true,
 		"CATV2":            true,
 		"PUT":              true,
 		"PUTV2":            true,
 		"PUTV3":            true,
+		"PATCH":            true,
 		"DELETE":           true,
 		"LIST":             true,
 		"LISTV2":           true,
@@ -37,6 +46,7 @@ var (
 		"UNTRASHIFY":       true,
 		"COLLECT OBSOLETE": true,
 		"DELETE OBSOLETE":  true,
+		"ROTATE KEYS":      true,
 	}
 )
 
@@ -71,6 +81,115 @@ var (
 		Name: "write_req_errors_total",
 		Help: "The total number of errors during reads",
 	})
+	TierPendingObjects = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tier_pending_objects",
+		Help: "The number of objects in local tier waiting to be destaged",
+	})
+	TierPendingBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tier_pending_bytes",
+		Help: "The size of objects in local tier waiting to be destaged",
+	})
+	TierLocalBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tier_local_bytes",
+		Help: "The size of objects in local tier",
+	})
+	TierDestageErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tier_destage_errors_total",
+		Help: "The total number of failed destage attempts",
+	})
+	TierEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tier_evictions_total",
+		Help: "The total number of objects evicted from local tier",
+	})
+	BlockCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "block_cache_hits_total",
+		Help: "The total number of object blocks read from block cache",
+	})
+	BlockCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "block_cache_misses_total",
+		Help: "The total number of object blocks read from storage on block cache miss",
+	})
+	BlockCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "block_cache_bytes",
+		Help: "The size of block cache",
+	})
+	KMSKeyCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kms_key_cache_hits_total",
+		Help: "The total number of data keys unwrapped from local cache",
+	})
+	KMSKeyCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kms_key_cache_misses_total",
+		Help: "The total number of data keys unwrapped by key service",
+	})
+	KMSRequestErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kms_request_errors_total",
+		Help: "The total number of failed key service requests",
+	})
+	RateLimitWriteWaitSeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rate_limit_write_wait_seconds_total",
+		Help: "The total time uploads waited for write rate limiter",
+	})
+	RateLimitWriteBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rate_limit_write_bytes_total",
+		Help: "The total size of data uploaded to storage through write rate limiter",
+	})
+	UploadJanitorRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "upload_janitor_runs_total",
+		Help: "The total number of multipart upload janitor runs",
+	})
+	UploadJanitorStale = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "upload_janitor_stale_uploads_total",
+		Help: "The total number of stale multipart uploads found by janitor",
+	})
+	UploadJanitorAborted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "upload_janitor_aborted_uploads_total",
+		Help: "The total number of stale multipart uploads aborted by janitor",
+	})
+	UploadJanitorErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "upload_janitor_errors_total",
+		Help: "The total number of failed janitor listings and aborts",
+	})
+	QoSRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "qos_requests_total",
+		Help: "The total number of requests served in qos class",
+	}, []string{"class"})
+	QoSInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "qos_requests_in_flight",
+		Help: "The number of requests being served in qos class",
+	}, []string{"class"})
+	QoSWaitSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "qos_wait_seconds_total",
+		Help: "The total time requests waited for qos class concurrency slot",
+	}, []string{"class"})
+	QoSThrottleSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "qos_throttle_seconds_total",
+		Help: "The total time requests were throttled by qos class rate limit",
+	}, []string{"class"})
+	QoSBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "qos_bytes_total",
+		Help: "The total size of object data transferred by requests of qos class",
+	}, []string{"class", "direction"})
+	CompressionInputBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "compression_input_bytes_total",
+		Help: "The total size of object content before compression",
+	}, []string{"codec"})
+	CompressionOutputBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "compression_output_bytes_total",
+		Help: "The total size of object content after compression",
+	}, []string{"codec"})
+	CompressionRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "compression_ratio",
+		Help:    "Compression ratio of uploaded objects",
+		Buckets: ratioBuckets,
+	}, []string{"codec"})
+	ChecksumVerified = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "checksum_verified_total",
+		Help: "The total number of objects read matching their stored checksum",
+	})
+	ChecksumMismatches = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "checksum_mismatches_total",
+		Help: "The total number of objects read not matching their stored checksum",
+	})
 	HistogramLatencyVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "request_latency",
 		Help:    "Request latency in seconds",
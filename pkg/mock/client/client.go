@@ -154,6 +154,18 @@ func (mr *MockYproxyClientMockRecorder) ReplyError(err, msg any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplyError", reflect.TypeOf((*MockYproxyClient)(nil).ReplyError), err, msg)
 }
 
+// ResetRequest mocks base method.
+func (m *MockYproxyClient) ResetRequest() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ResetRequest")
+}
+
+// ResetRequest indicates an expected call of ResetRequest.
+func (mr *MockYproxyClientMockRecorder) ResetRequest() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetRequest", reflect.TypeOf((*MockYproxyClient)(nil).ResetRequest))
+}
+
 // SetByteOffset mocks base method.
 func (m *MockYproxyClient) SetByteOffset(arg0 int64) {
 	m.ctrl.T.Helper()
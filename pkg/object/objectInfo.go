@@ -6,4 +6,6 @@ type ObjectInfo struct {
 	Path    string
 	Size    int64
 	LastMod time.Time
+	/* empty when storage does not provide it */
+	ETag string
 }
@@ -0,0 +1,65 @@
+package proc
+
+import (
+	"io"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/checksum"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+// checksumReader returns reader verifying object content against checksum
+// stored on PUT, nil when checksums are disabled or object has none. Checksum
+// covers content as client sent it, so content of encrypted object is
+// verified only if it is decrypted.
+func checksumReader(s storage.StorageReader, name string, setts []settings.StorageSettings, decrypt bool, r io.Reader) *checksum.Reader {
+	cnf := config.InstanceConfig().ChecksumCnf
+	if cnf.Algorithm == "" {
+		return nil
+	}
+	want, err := checksum.Get(s, name, setts)
+	if err != nil {
+		ylogger.Zero.Debug().Err(err).Str("object-path", name).Msg("object checksum is not available")
+		return nil
+	}
+	if want.Encrypted != decrypt {
+		ylogger.Zero.Debug().Str("object-path", name).Bool("encrypted", want.Encrypted).Bool("decrypt", decrypt).Msg("object content is not covered by checksum")
+		return nil
+	}
+	return checksum.NewReader(r, want.Sum, cnf.GetOnMismatch() == config.ChecksumMismatchError)
+}
+
+func reportChecksum(name string, vr *checksum.Reader) {
+	switch {
+	case vr.Verified():
+		metrics.ChecksumVerified.Inc()
+		ylogger.Zero.Debug().Str("object-path", name).Str("checksum", vr.Sum().String()).Msg("object checksum verified")
+	case vr.Mismatch():
+		metrics.ChecksumMismatches.Inc()
+		if config.InstanceConfig().ChecksumCnf.GetOnMismatch() == config.ChecksumMismatchWarn {
+			ylogger.Zero.Warn().Str("object-path", name).Str("checksum", vr.Sum().String()).Msg("object content does not match its checksum")
+		} else {
+			ylogger.Zero.Error().Str("object-path", name).Str("checksum", vr.Sum().String()).Msg("object content does not match its checksum")
+		}
+	}
+}
+
+// keepChecksum runs rewrite of object which does not change its decrypted
+// content, e.g. key rotation, and stores checksum of object back after it.
+// Storage drops stored checksum of object on any write.
+func keepChecksum(s storage.StorageInteractor, name string, rewrite func() error) error {
+	stored, sumErr := checksum.Get(s, name, nil)
+	if err := rewrite(); err != nil {
+		return err
+	}
+	if sumErr != nil {
+		return nil
+	}
+	if err := checksum.Put(s, name, stored, nil); err != nil {
+		ylogger.Zero.Warn().Err(err).Str("object-path", name).Msg("failed to store object checksum back")
+	}
+	return nil
+}
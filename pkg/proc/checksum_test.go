@@ -0,0 +1,101 @@
+package proc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/checksum"
+	pio "github.com/yezzey-gp/yproxy/pkg/io"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/proc"
+)
+
+func TestCatVerifiesChecksum(t *testing.T) {
+	s := newTestStorage(t)
+
+	prev := config.InstanceConfig().ChecksumCnf
+	t.Cleanup(func() { config.InstanceConfig().ChecksumCnf = prev })
+
+	payload := bytes.Repeat([]byte("checksummed "), 1000)
+	h := checksum.CRC32C.New()
+	_, _ = h.Write(payload)
+	sum := checksum.Sum{Algorithm: checksum.CRC32C, Value: h.Sum(nil)}
+
+	corrupted := bytes.Clone(payload)
+	corrupted[100] ^= 1
+
+	require.NoError(t, s.PutFileToDest("intact", bytes.NewReader(payload), nil))
+	require.NoError(t, checksum.Put(s, "intact", checksum.Stored{Sum: sum}, nil))
+	require.NoError(t, s.PutFileToDest("corrupted", bytes.NewReader(corrupted), nil))
+	require.NoError(t, checksum.Put(s, "corrupted", checksum.Stored{Sum: sum}, nil))
+	require.NoError(t, s.PutFileToDest("unsummed", bytes.NewReader(payload), nil))
+
+	cat := func(name string, offset uint64) ([]byte, error) {
+		ycl := newProcConnTestClient(nil)
+		err := (&proc.ProtoMgrImpl{}).ProcessCatExtended(s, nil, name, false, false, offset, nil, nil, ycl)
+		return ycl.rw.Written(), err
+	}
+
+	config.InstanceConfig().ChecksumCnf = config.Checksum{Algorithm: config.ChecksumCRC32C}
+
+	out, err := cat("intact", 0)
+	require.NoError(t, err)
+	assert.Equal(t, payload, out)
+
+	out, err = cat("unsummed", 0)
+	require.NoError(t, err)
+	assert.Equal(t, payload, out)
+
+	/* partial reads are not verified */
+	out, err = cat("corrupted", 10)
+	require.NoError(t, err)
+	assert.Equal(t, corrupted[10:], out)
+
+	out, err = cat("corrupted", 0)
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+	assert.Equal(t, corrupted[:len(corrupted)-1], out)
+
+	config.InstanceConfig().ChecksumCnf.OnMismatch = config.ChecksumMismatchWarn
+
+	out, err = cat("corrupted", 0)
+	require.NoError(t, err)
+	assert.Equal(t, corrupted, out)
+}
+
+func TestCatEncryptedChecksum(t *testing.T) {
+	s := newTestStorage(t)
+	cr := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+
+	prev := config.InstanceConfig().ChecksumCnf
+	t.Cleanup(func() { config.InstanceConfig().ChecksumCnf = prev })
+	config.InstanceConfig().ChecksumCnf = config.Checksum{Algorithm: config.ChecksumCRC32C}
+
+	payload := bytes.Repeat([]byte("encrypted "), 1000)
+	put := newProcConnTestClient(append(
+		(&message.CopyDataMessage{Sz: uint64(len(payload)), Data: payload}).Encode(),
+		message.NewCopyDoneMessage().Encode()...))
+	require.NoError(t, (&proc.ProtoMgrImpl{}).ProcessPutExtended(s, pio.NewProtoReader(put), "enc", true, nil, cr, put, false))
+
+	stored, err := checksum.Get(s, "enc", nil)
+	require.NoError(t, err)
+	assert.True(t, stored.Encrypted)
+
+	cat := func(decrypt bool) ([]byte, error) {
+		ycl := newProcConnTestClient(nil)
+		err := (&proc.ProtoMgrImpl{}).ProcessCatExtended(s, nil, "enc", decrypt, false, 0, nil, cr, ycl)
+		return ycl.rw.Written(), err
+	}
+
+	/* raw content is ciphertext, which checksum does not cover */
+	out, err := cat(false)
+	require.NoError(t, err)
+	assert.Equal(t, readRaw(t, s, "enc"), out)
+	assert.NotEqual(t, payload, out)
+
+	out, err = cat(true)
+	require.NoError(t, err)
+	assert.Equal(t, payload, out)
+}
@@ -0,0 +1,63 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/yezzey-gp/yproxy/pkg/codec"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+)
+
+// decryptAt decrypts object read by r starting at offset. Offset of
+// compressed object is in decompressed content, such object is decrypted
+// from beginning and seeked is false.
+func decryptAt(sd crypt.SeekableDecrypter, r io.ReadCloser, open func(offset int64) (io.ReadCloser, error), offset int64) (io.ReadCloser, bool, error) {
+	/* beginning of object read by probe is replayed for seek */
+	rec := &recordingReader{r: r, buf: &bytes.Buffer{}}
+	probe, err := sd.DecryptAt(struct {
+		io.Reader
+		io.Closer
+	}{rec, r}, open, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	br := bufio.NewReaderSize(probe, codec.HeaderSize)
+	header, err := br.Peek(codec.HeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		_ = probe.Close()
+		return nil, false, err
+	}
+	if _, ok := codec.ParseHeader(header); ok {
+		rec.buf = nil
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, probe}, false, nil
+	}
+
+	replay := rec.buf.Bytes()
+	rec.buf = nil
+	rc, err := sd.DecryptAt(struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(replay), r), r}, open, offset)
+	if err != nil {
+		return nil, false, err
+	}
+	return rc, true, nil
+}
+
+type recordingReader struct {
+	r   io.Reader
+	buf *bytes.Buffer
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.buf != nil {
+		r.buf.Write(p[:n])
+	}
+	return n, err
+}
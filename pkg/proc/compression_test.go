@@ -0,0 +1,46 @@
+package proc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/pkg/codec"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/proc"
+)
+
+func compress(t *testing.T, c codec.Codec, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := codec.NewWriter(&buf, c)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestCatCompressedChunkedObject(t *testing.T) {
+	s := newTestStorage(t)
+
+	cr := crypt.NewChunkedCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv"), 64)
+
+	payload := make([]byte, 5000)
+	for i := range payload {
+		payload[i] = byte(i % 7)
+	}
+	putEncrypted(t, s, cr, "plain", payload)
+	putEncrypted(t, s, cr, "zstd", compress(t, codec.Zstd, payload))
+	putEncrypted(t, s, cr, "lz4", compress(t, codec.LZ4, payload))
+
+	for _, name := range []string{"plain", "zstd", "lz4"} {
+		for _, offset := range []uint64{0, 100, 3000} {
+			ycl := newProcConnTestClient(nil)
+			err := (&proc.ProtoMgrImpl{}).ProcessCatExtended(s, nil, name, true, false, offset, nil, cr, ycl)
+			require.NoError(t, err)
+			assert.Equal(t, payload[offset:], ycl.rw.Written(), "%s at offset %d", name, offset)
+		}
+	}
+}
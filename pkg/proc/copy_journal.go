@@ -0,0 +1,218 @@
+package proc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+type CopyState string
+
+const (
+	CopyStateInFlight = CopyState("in-flight")
+	CopyStateDone     = CopyState("done")
+	CopyStateFailed   = CopyState("failed")
+)
+
+// CopyJournalEntry is checkpoint of single object copy. Source and
+// destination ETag and size are recorded, so on resume object is skipped
+// only if neither side has changed since it was copied.
+type CopyJournalEntry struct {
+	Path  string    `json:"path"`
+	State CopyState `json:"state"`
+
+	SourceSize int64  `json:"source_size"`
+	SourceETag string `json:"source_etag,omitempty"`
+	Size       int64  `json:"size"`
+	ETag       string `json:"etag,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// CopyJournal is append-only log of COPY progress, one JSON entry per line.
+// Last entry of object wins on replay.
+type CopyJournal struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries map[string]*CopyJournalEntry
+}
+
+// CopyJournalPath returns journal file of copy job, identified by
+// copied prefix and source config.
+func CopyJournalPath(dir, prefix, oldCfgPath string) string {
+	h := sha256.Sum256([]byte(oldCfgPath + "\x00" + prefix))
+	return path.Join(dir, fmt.Sprintf("copy-%s.journal", hex.EncodeToString(h[:8])))
+}
+
+func replayCopyJournal(f *os.File) (map[string]*CopyJournalEntry, error) {
+	entries := map[string]*CopyJournalEntry{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		e := &CopyJournalEntry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			/* last line may be torn by crash, everything before it is valid */
+			ylogger.Zero.Warn().Err(err).Str("journal", f.Name()).Msg("skipping corrupted copy journal entry")
+			continue
+		}
+		entries[e.Path] = e
+	}
+	return entries, sc.Err()
+}
+
+// OpenCopyJournal opens journal for writing. With resume set previous
+// entries are replayed, otherwise journal is started from scratch.
+func OpenCopyJournal(journalPath string, resume bool) (*CopyJournal, error) {
+	if err := os.MkdirAll(path.Dir(journalPath), 0700); err != nil {
+		return nil, err
+	}
+	flags := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(journalPath, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := replayCopyJournal(f)
+	if err == nil {
+		err = terminateTornLine(f)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &CopyJournal{
+		f:       f,
+		entries: entries,
+	}, nil
+}
+
+// terminateTornLine ends unfinished last line, so new entries are not glued to it.
+func terminateTornLine(f *os.File) error {
+	st, err := f.Stat()
+	if err != nil || st.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, st.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = f.Write([]byte{'\n'})
+	return err
+}
+
+// ReadCopyJournal loads journal without opening it for writing.
+// Missing journal is treated as empty.
+func ReadCopyJournal(journalPath string) (*CopyJournal, error) {
+	f, err := os.Open(journalPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &CopyJournal{entries: map[string]*CopyJournalEntry{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	entries, err := replayCopyJournal(f)
+	if err != nil {
+		return nil, err
+	}
+	return &CopyJournal{entries: entries}, nil
+}
+
+func (j *CopyJournal) record(e *CopyJournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries[e.Path] = e
+	if j.f == nil {
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+func (j *CopyJournal) Start(src *object.ObjectInfo) error {
+	return j.record(&CopyJournalEntry{
+		Path:       src.Path,
+		State:      CopyStateInFlight,
+		SourceSize: src.Size,
+		SourceETag: src.ETag,
+	})
+}
+
+func (j *CopyJournal) Done(src, dst *object.ObjectInfo) error {
+	return j.record(&CopyJournalEntry{
+		Path:       src.Path,
+		State:      CopyStateDone,
+		SourceSize: src.Size,
+		SourceETag: src.ETag,
+		Size:       dst.Size,
+		ETag:       dst.ETag,
+	})
+}
+
+func (j *CopyJournal) Fail(src *object.ObjectInfo, err error) error {
+	return j.record(&CopyJournalEntry{
+		Path:       src.Path,
+		State:      CopyStateFailed,
+		SourceSize: src.Size,
+		SourceETag: src.ETag,
+		Error:      err.Error(),
+	})
+}
+
+func (j *CopyJournal) Entry(p string) (CopyJournalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[p]
+	if !ok {
+		return CopyJournalEntry{}, false
+	}
+	return *e, true
+}
+
+func etagMatches(journaled, actual string) bool {
+	/* missing ETag (some storages, stale listing cache) can not prove object is unchanged */
+	return journaled != "" && journaled == actual
+}
+
+// Verified checks that object was copied completely and that neither source
+// nor destination object has changed since then.
+func (j *CopyJournal) Verified(src, dst *object.ObjectInfo) bool {
+	if dst == nil {
+		return false
+	}
+	e, ok := j.Entry(src.Path)
+	if !ok || e.State != CopyStateDone {
+		return false
+	}
+	return e.SourceSize == src.Size && etagMatches(e.SourceETag, src.ETag) &&
+		e.Size == dst.Size && etagMatches(e.ETag, dst.ETag)
+}
+
+func (j *CopyJournal) Close() error {
+	if j.f == nil {
+		return nil
+	}
+	return j.f.Close()
+}
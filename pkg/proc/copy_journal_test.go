@@ -0,0 +1,88 @@
+package proc_test
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/proc"
+)
+
+func TestCopyJournalResume(t *testing.T) {
+	journalPath := proc.CopyJournalPath(t.TempDir(), "/segments", "/etc/yproxy/old.yaml")
+
+	done := &object.ObjectInfo{Path: "/segments/1", Size: 10, ETag: "src-1"}
+	inFlight := &object.ObjectInfo{Path: "/segments/2", Size: 20, ETag: "src-2"}
+	failed := &object.ObjectInfo{Path: "/segments/3", Size: 30}
+	dst := &object.ObjectInfo{Path: "/segments/1", Size: 10, ETag: "dst-1"}
+
+	j, err := proc.OpenCopyJournal(journalPath, false)
+	require.NoError(t, err)
+	require.NoError(t, j.Start(done))
+	require.NoError(t, j.Done(done, dst))
+	require.NoError(t, j.Start(inFlight))
+	require.NoError(t, j.Start(failed))
+	require.NoError(t, j.Fail(failed, fmt.Errorf("access denied")))
+	require.NoError(t, j.Close())
+
+	/* simulate crash in the middle of write */
+	f, err := os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"path":"/segments/2","sta`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j, err = proc.OpenCopyJournal(journalPath, true)
+	require.NoError(t, err)
+	require.NoError(t, j.Done(inFlight, inFlight))
+	require.NoError(t, j.Close())
+
+	j, err = proc.OpenCopyJournal(journalPath, true)
+	require.NoError(t, err)
+	defer func() { _ = j.Close() }()
+
+	require.True(t, j.Verified(inFlight, inFlight))
+	require.True(t, j.Verified(done, dst))
+	require.False(t, j.Verified(done, nil))
+	require.False(t, j.Verified(done, &object.ObjectInfo{Path: "/segments/1", Size: 10, ETag: "other"}))
+	require.False(t, j.Verified(&object.ObjectInfo{Path: "/segments/1", Size: 11, ETag: "src-1"}, dst))
+	/* unknown ETag means object has to be copied again */
+	require.False(t, j.Verified(done, &object.ObjectInfo{Path: "/segments/1", Size: 10}))
+	require.False(t, j.Verified(&object.ObjectInfo{Path: "/segments/1", Size: 10}, dst))
+	require.False(t, j.Verified(failed, &object.ObjectInfo{Path: "/segments/3", Size: 30}))
+
+	e, ok := j.Entry(failed.Path)
+	require.True(t, ok)
+	require.Equal(t, proc.CopyStateFailed, e.State)
+	require.Equal(t, "access denied", e.Error)
+}
+
+func TestCopyJournalRestart(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := proc.CopyJournalPath(dir, "/segments", "/etc/yproxy/old.yaml")
+	require.NotEqual(t, journalPath, proc.CopyJournalPath(dir, "/other", "/etc/yproxy/old.yaml"))
+
+	obj := &object.ObjectInfo{Path: "/segments/1", Size: 10, ETag: "etag-1"}
+
+	j, err := proc.OpenCopyJournal(journalPath, false)
+	require.NoError(t, err)
+	require.NoError(t, j.Done(obj, obj))
+	require.NoError(t, j.Close())
+
+	r, err := proc.ReadCopyJournal(journalPath)
+	require.NoError(t, err)
+	require.True(t, r.Verified(obj, obj))
+
+	/* copy without resume starts from scratch */
+	j, err = proc.OpenCopyJournal(journalPath, false)
+	require.NoError(t, err)
+	require.False(t, j.Verified(obj, obj))
+	require.NoError(t, j.Close())
+
+	r, err = proc.ReadCopyJournal(path.Join(dir, "missing.journal"))
+	require.NoError(t, err)
+	require.False(t, r.Verified(obj, obj))
+}
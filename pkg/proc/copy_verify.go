@@ -0,0 +1,196 @@
+package proc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/yezzey-gp/yproxy/pkg/client"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/proc/yio"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+	"golang.org/x/sync/semaphore"
+)
+
+// CopyVerifier checks that copied objects match their source.
+// Objects which are not re-encrypted are compared by size and ETag first,
+// falling back to SHA-256 when ETags are not comparable (e.g. multipart
+// upload). Content is hashed after decryption if crypter of its side is set.
+type CopyVerifier struct {
+	Src, Dst storage.StorageInteractor
+
+	/* source storage prefix, stripped from listed paths */
+	SrcPrefix string
+
+	/* nil means objects are compared as stored */
+	SrcCrypter crypt.Crypter
+	DstCrypter crypt.Crypter
+
+	/* settings copied objects were written with */
+	DstSettings []settings.StorageSettings
+
+	/*
+	 * Objects are not re-encrypted by copy, so the same stored bytes
+	 * (e.g. after server-side copy) mean the same content.
+	 */
+	Identical bool
+
+	Concurrency int64
+
+	/* used for read retries, may be nil */
+	Ycl client.YproxyClient
+}
+
+func (v *CopyVerifier) open(s storage.StorageInteractor, p string) (io.ReadCloser, error) {
+	var setts []settings.StorageSettings
+	if s == v.Dst {
+		setts = v.DstSettings
+	}
+	if v.Ycl != nil {
+		return yio.NewYRetryReader(yio.NewRestartReader(s, p, setts), v.Ycl), nil
+	}
+	return s.CatFileFromStorage(p, 0, setts)
+}
+
+func (v *CopyVerifier) digest(s storage.StorageInteractor, p string, cr crypt.Crypter) (string, error) {
+	r, err := v.open(s, p)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+
+	var content io.Reader = r
+	if cr != nil {
+		content, err = cr.Decrypt(r)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt object: %w", err)
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyObject returns nil if destination object matches source one.
+func (v *CopyVerifier) VerifyObject(src, dst *object.ObjectInfo) *message.CopyMismatchMessage {
+	mismatch := &message.CopyMismatchMessage{
+		Path:         src.Path,
+		SourceSize:   src.Size,
+		SourceDigest: src.ETag,
+	}
+	if dst == nil {
+		mismatch.Reason = message.CopyMismatchMissing
+		return mismatch
+	}
+	mismatch.DestSize = dst.Size
+	mismatch.DestDigest = dst.ETag
+
+	if v.Identical && src.ETag != "" && src.ETag == dst.ETag && src.Size == dst.Size {
+		return nil
+	}
+	if v.SrcCrypter == nil && v.DstCrypter == nil && src.Size != dst.Size {
+		mismatch.Reason = message.CopyMismatchSize
+		return mismatch
+	}
+
+	p := strings.TrimPrefix(src.Path, v.SrcPrefix)
+
+	srcDigest, err := v.digest(v.Src, p, v.SrcCrypter)
+	if err != nil {
+		mismatch.Reason = message.CopyMismatchError
+		mismatch.Error = fmt.Sprintf("source: %s", err)
+		return mismatch
+	}
+	dstDigest, err := v.digest(v.Dst, p, v.DstCrypter)
+	if err != nil {
+		mismatch.Reason = message.CopyMismatchError
+		mismatch.Error = fmt.Sprintf("destination: %s", err)
+		return mismatch
+	}
+	if srcDigest == dstDigest {
+		return nil
+	}
+	mismatch.Reason = message.CopyMismatchChecksum
+	mismatch.SourceDigest = srcDigest
+	mismatch.DestDigest = dstDigest
+	return mismatch
+}
+
+// Verify checks every copied object and every skipped object present at
+// destination. Skipped objects missing at destination were not meant to be
+// copied and are not reported.
+func (v *CopyVerifier) Verify(prefix string, copied, skipped []*object.ObjectInfo) ([]*message.CopyMismatchMessage, error) {
+	dstObjs, err := v.Dst.ListPath(prefix, false, v.DstSettings)
+	if err != nil {
+		return nil, err
+	}
+	dstByPath := make(map[string]*object.ObjectInfo, len(dstObjs))
+	for _, o := range dstObjs {
+		dstByPath[o.Path] = o
+	}
+
+	seen := map[string]struct{}{}
+	toVerify := make([]*object.ObjectInfo, 0, len(copied)+len(skipped))
+	for _, o := range copied {
+		seen[o.Path] = struct{}{}
+		toVerify = append(toVerify, o)
+	}
+	for _, o := range skipped {
+		if _, ok := seen[o.Path]; ok {
+			continue
+		}
+		if _, ok := dstByPath[o.Path]; !ok {
+			continue
+		}
+		seen[o.Path] = struct{}{}
+		toVerify = append(toVerify, o)
+	}
+
+	ylogger.Zero.Info().Str("prefix", prefix).Int("objects", len(toVerify)).Msg("verifying copy")
+
+	sem := semaphore.NewWeighted(max(v.Concurrency, 1))
+	wg := sync.WaitGroup{}
+	var mu sync.Mutex
+	mismatches := []*message.CopyMismatchMessage{}
+
+	for _, o := range toVerify {
+		_ = sem.Acquire(context.TODO(), 1)
+		wg.Add(1)
+
+		go func(src *object.ObjectInfo) {
+			defer sem.Release(1)
+			defer wg.Done()
+
+			m := v.VerifyObject(src, dstByPath[src.Path])
+			if m == nil {
+				return
+			}
+			ylogger.Zero.Error().
+				Str("path", m.Path).
+				Str("reason", m.Reason.String()).
+				Int64("source size", m.SourceSize).
+				Int64("destination size", m.DestSize).
+				Str("error", m.Error).
+				Msg("copy verification mismatch")
+
+			mu.Lock()
+			defer mu.Unlock()
+			mismatches = append(mismatches, m)
+		}(o)
+	}
+	wg.Wait()
+
+	ylogger.Zero.Info().Int("verified", len(toVerify)).Int("mismatches", len(mismatches)).Msg("copy verification finished")
+	return mismatches, nil
+}
@@ -0,0 +1,90 @@
+package proc_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/proc"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+func TestCopyVerifierAsIs(t *testing.T) {
+	src := newTestStorage(t)
+	dst := newTestStorage(t)
+
+	for _, s := range []storage.StorageInteractor{src, dst} {
+		putRaw(t, s, "/seg/same", []byte("same content"))
+		putRaw(t, s, "/seg/skipped", []byte("skipped content"))
+	}
+	putRaw(t, src, "/seg/corrupted", []byte("source content"))
+	putRaw(t, dst, "/seg/corrupted", []byte("broken content"))
+	putRaw(t, src, "/seg/truncated", []byte("source content"))
+	putRaw(t, dst, "/seg/truncated", []byte("source"))
+	putRaw(t, src, "/seg/missing", []byte("missing content"))
+	putRaw(t, src, "/seg/not-copied", []byte("garbage"))
+
+	objs, err := src.ListPath("/seg", false, nil)
+	require.NoError(t, err)
+	var copied, skipped []*object.ObjectInfo
+	for _, o := range objs {
+		switch o.Path {
+		case "/seg/skipped", "/seg/not-copied":
+			skipped = append(skipped, o)
+		default:
+			copied = append(copied, o)
+		}
+	}
+
+	v := &proc.CopyVerifier{Src: src, Dst: dst, Identical: true, Concurrency: 2}
+	mismatches, err := v.Verify("/seg", copied, skipped)
+	require.NoError(t, err)
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+	require.Len(t, mismatches, 3)
+	require.Equal(t, "/seg/corrupted", mismatches[0].Path)
+	require.Equal(t, message.CopyMismatchChecksum, mismatches[0].Reason)
+	require.NotEqual(t, mismatches[0].SourceDigest, mismatches[0].DestDigest)
+	require.Equal(t, "/seg/missing", mismatches[1].Path)
+	require.Equal(t, message.CopyMismatchMissing, mismatches[1].Reason)
+	require.Equal(t, "/seg/truncated", mismatches[2].Path)
+	require.Equal(t, message.CopyMismatchSize, mismatches[2].Reason)
+}
+
+func TestCopyVerifierReencrypted(t *testing.T) {
+	src := newTestStorage(t)
+	dst := newTestStorage(t)
+
+	oldKey := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+	newKey := crypt.NewEnvelopeCrypter(newGPGCrypter(t, "../../test/regress/gpg/gpg_2.priv"))
+
+	putEncrypted(t, src, oldKey, "/seg/ok", []byte("plain content"))
+	putEncrypted(t, dst, newKey, "/seg/ok", []byte("plain content"))
+	putEncrypted(t, src, oldKey, "/seg/differs", []byte("plain content"))
+	putEncrypted(t, dst, newKey, "/seg/differs", []byte("other content"))
+	/* copied as is instead of re-encryption */
+	putEncrypted(t, src, oldKey, "/seg/raw", []byte("plain content"))
+	putEncrypted(t, dst, oldKey, "/seg/raw", []byte("plain content"))
+
+	copied, err := src.ListPath("/seg", false, nil)
+	require.NoError(t, err)
+
+	v := &proc.CopyVerifier{
+		Src:        src,
+		Dst:        dst,
+		SrcCrypter: oldKey,
+		DstCrypter: newKey,
+	}
+	mismatches, err := v.Verify("/seg", copied, nil)
+	require.NoError(t, err)
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+	require.Len(t, mismatches, 2)
+	require.Equal(t, "/seg/differs", mismatches[0].Path)
+	require.Equal(t, message.CopyMismatchChecksum, mismatches[0].Reason)
+	require.Equal(t, "/seg/raw", mismatches[1].Path)
+	require.Equal(t, message.CopyMismatchError, mismatches[1].Reason)
+}
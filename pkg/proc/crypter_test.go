@@ -0,0 +1,69 @@
+package proc_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/proc"
+)
+
+func TestCatRegistryObjects(t *testing.T) {
+	s := newTestStorage(t)
+
+	keyPath := filepath.Join(t.TempDir(), "aes.key")
+	require.NoError(t, os.WriteFile(keyPath, []byte(hex.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0600))
+
+	/* default crypter is plain GPG, as without use_kek */
+	reg, err := crypt.NewRegistry(&config.Crypto{
+		GPGKeyPath: "../../test/regress/gpg/gpg_1.priv",
+		Crypters: map[string]config.Crypter{
+			"fast":  {Type: config.CrypterTypeAESGCM, KeyPath: keyPath},
+			"plain": {Type: config.CrypterTypePassthrough},
+		},
+	})
+	require.NoError(t, err)
+	fast, ok := reg.Get("fast")
+	require.True(t, ok)
+	plain, ok := reg.Get("plain")
+	require.True(t, ok)
+
+	payload := bytes.Repeat([]byte("written before registry existed "), 1000)
+	kek := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+	putEncrypted(t, s, kek, "single", payload)
+	putEncrypted(t, s, crypt.NewEnvelopeCrypter(kek), "envelope", payload)
+	putEncrypted(t, s, fast, "named", payload)
+	putEncrypted(t, s, plain, "passthrough", payload)
+
+	cat := func(name string, decrypt, kek bool) []byte {
+		ycl := newProcConnTestClient(nil)
+		err := (&proc.ProtoMgrImpl{}).ProcessCatExtended(s, nil, name, decrypt, kek, 0, nil, reg, ycl)
+		require.NoError(t, err, name)
+		return ycl.rw.Written()
+	}
+
+	assert.Equal(t, payload, cat("single", true, false))
+	assert.Equal(t, payload, cat("envelope", true, true))
+	assert.Equal(t, payload, cat("envelope", true, false))
+	assert.Equal(t, payload, cat("named", true, false))
+
+	/* object naming its crypter is decrypted whatever client asks */
+	assert.Equal(t, payload, cat("named", false, false))
+	assert.Equal(t, payload, cat("passthrough", false, false))
+	assert.Equal(t, payload, cat("passthrough", true, false))
+
+	/* client flag decides only for unnamed objects */
+	r, err := s.CatFileFromStorage("envelope", 0, nil)
+	require.NoError(t, err)
+	stored, err := io.ReadAll(r)
+	require.NoError(t, err)
+	_ = r.Close()
+	assert.Equal(t, stored, cat("envelope", false, false))
+}
@@ -0,0 +1,76 @@
+package proc_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+// newTestStorage returns fs storage in temporary directory.
+func newTestStorage(t *testing.T) storage.StorageInteractor {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "storage") + string(os.PathSeparator)
+	require.NoError(t, os.MkdirAll(root, 0700))
+	s, err := storage.NewStorage(&config.Storage{
+		StorageType:   "fs",
+		StoragePrefix: root,
+	}, "")
+	require.NoError(t, err)
+	return s
+}
+
+func newGPGCrypter(t *testing.T, path string) crypt.Crypter {
+	t.Helper()
+	cr, err := crypt.NewCrypto(&config.Crypto{GPGKeyPath: path})
+	require.NoError(t, err)
+	return cr
+}
+
+func putRaw(t *testing.T, s storage.StorageInteractor, name string, data []byte) {
+	t.Helper()
+	require.NoError(t, s.PutFileToDest(name, bytes.NewReader(data), nil))
+}
+
+func readRaw(t *testing.T, s storage.StorageInteractor, name string) []byte {
+	t.Helper()
+	r, err := s.CatFileFromStorage(name, 0, nil)
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+func putEncrypted(t *testing.T, s storage.StorageInteractor, cr crypt.Crypter, name string, data []byte) {
+	t.Helper()
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := cr.Encrypt(pw)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		_, _ = w.Write(data)
+		_ = pw.CloseWithError(w.Close())
+	}()
+	require.NoError(t, s.PutFileToDest(name, pr, nil))
+}
+
+func readDecrypted(t *testing.T, s storage.StorageInteractor, cr crypt.Crypter, name string) []byte {
+	t.Helper()
+	r, err := s.CatFileFromStorage(name, 0, nil)
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+	plain, err := cr.Decrypt(r)
+	require.NoError(t, err)
+	data, err := io.ReadAll(plain)
+	require.NoError(t, err)
+	return data
+}
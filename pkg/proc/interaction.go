@@ -1,6 +1,7 @@
 package proc
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -11,16 +12,21 @@ import (
 
 	"github.com/yezzey-gp/yproxy/config"
 	"github.com/yezzey-gp/yproxy/pkg/backups"
+	"github.com/yezzey-gp/yproxy/pkg/checksum"
 	"github.com/yezzey-gp/yproxy/pkg/client"
+	"github.com/yezzey-gp/yproxy/pkg/codec"
 	"github.com/yezzey-gp/yproxy/pkg/crypt"
 	"github.com/yezzey-gp/yproxy/pkg/database"
 	pio "github.com/yezzey-gp/yproxy/pkg/io"
 	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
 	"github.com/yezzey-gp/yproxy/pkg/object"
 	"github.com/yezzey-gp/yproxy/pkg/proc/yio"
 	"github.com/yezzey-gp/yproxy/pkg/proto"
+	"github.com/yezzey-gp/yproxy/pkg/qos"
 	"github.com/yezzey-gp/yproxy/pkg/settings"
 	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/tablespace"
 	"github.com/yezzey-gp/yproxy/pkg/ylogger"
 	"golang.org/x/sync/semaphore"
 )
@@ -50,6 +56,35 @@ func (*ProtoMgrImpl) ProcessCatExtended(
 	defer func() { _ = yr.Close() }()
 	var err error
 
+	if reg, ok := cr.(*crypt.Registry); ok {
+		/*
+		 * object header names its crypter, including passthrough one, so named
+		 * objects are decrypted whatever client asks. Client flag decides only
+		 * for unnamed objects, which are decrypted by default crypter.
+		 */
+		br := bufio.NewReaderSize(yr, crypt.EnvelopeHeaderSize)
+		header, err := br.Peek(crypt.EnvelopeHeaderSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			ylogger.Zero.Error().Err(err).Msg("failed to read object header")
+			return err
+		}
+		yr = struct {
+			io.Reader
+			io.Closer
+		}{br, yr}
+		contentReader = yr
+		if named := crypt.HeaderCrypter(header); named != "" || decrypt {
+			if cr, err = reg.ObjectCrypter(header); err != nil {
+				ylogger.Zero.Error().Err(err).Str("object-path", name).Msg("failed to select crypter")
+				return err
+			}
+			ylogger.Zero.Debug().Str("object-path", name).Str("crypter", named).Bool("requested", decrypt).Msg("object crypter selected")
+			decrypt = true
+			/* crypter of unnamed object detects KEK/DEK header itself */
+			kek = false
+		}
+	}
+
 	if decrypt {
 		if cr == nil {
 			err := fmt.Errorf("failed to decrypt object, decrypter not configured")
@@ -57,18 +92,50 @@ func (*ProtoMgrImpl) ProcessCatExtended(
 			ylogger.Zero.Error().Err(err).Msg("cat failed")
 			return err
 		}
-		ylogger.Zero.Debug().Str("object-path", name).Msg("decrypt object")
-		contentReader, err = cr.Decrypt(yr)
-		if err != nil {
-			ylogger.Zero.Error().Err(err).Msg("failed to decrypt object")
-			return err
+		if kek {
+			cr = crypt.NewEnvelopeCrypter(cr)
+		}
+		ylogger.Zero.Debug().Str("object-path", name).Bool("kek", kek).Msg("decrypt object")
+		if sd, ok := cr.(crypt.SeekableDecrypter); ok && startOffset != 0 {
+			/* chunked objects are read from the chunk containing offset */
+			rc, seeked, err := decryptAt(sd, yr, func(offset int64) (io.ReadCloser, error) {
+				return yio.NewYRetryReaderAt(yio.NewRestartReader(s, name, settings), ycl, offset), nil
+			}, int64(startOffset))
+			if err != nil {
+				ylogger.Zero.Error().Err(err).Msg("failed to decrypt object")
+				return err
+			}
+			defer func() { _ = rc.Close() }()
+			contentReader = rc
+			if seeked {
+				startOffset = 0
+			}
+		} else {
+			contentReader, err = cr.Decrypt(yr)
+			if err != nil {
+				ylogger.Zero.Error().Err(err).Msg("failed to decrypt object")
+				return err
+			}
 		}
 	}
 
-	if kek {
-		err := fmt.Errorf("KEK is currently unsupported")
-		ylogger.Zero.Error().Err(err).Msg("cat failed")
-		// return err
+	dr, compression, err := codec.NewReader(contentReader)
+	if err != nil {
+		ylogger.Zero.Error().Err(err).Msg("failed to decompress object")
+		return err
+	}
+	defer func() { _ = dr.Close() }()
+	if compression != codec.None {
+		ylogger.Zero.Debug().Str("object-path", name).Str("codec", compression.String()).Msg("decompress object")
+	}
+	contentReader = dr
+
+	/* checksum covers whole content */
+	var vr *checksum.Reader
+	if startOffset == 0 {
+		if vr = checksumReader(s, name, settings, decrypt, contentReader); vr != nil {
+			contentReader = vr
+		}
 	}
 
 	if startOffset != 0 {
@@ -78,6 +145,9 @@ func (*ProtoMgrImpl) ProcessCatExtended(
 	}
 
 	n, err := io.Copy(ycl.GetRW(), contentReader)
+	if vr != nil {
+		reportChecksum(name, vr)
+	}
 	if err != nil {
 		if errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrClosedPipe) {
 			ylogger.Zero.Warn().Err(err).Uint("client id", ycl.ID()).Int64("copied bytes", n).Msg("client disconnected during cat")
@@ -103,10 +173,34 @@ func (*ProtoMgrImpl) ProcessPutExtended(
 
 	ycl.SetExternalFilePath(name)
 
-	var w io.WriteCloser
-	r, w := io.Pipe()
+	keyVersion := crypt.SingleKeyEncryption
+	if encrypt {
+		var err error
+		cr, keyVersion, err = objectCrypter(cr, settings)
+		if err != nil {
+			ylogger.Zero.Error().Err(err).Str("path", name).Msg("failed to select crypter")
+			return err
+		}
+	}
+
+	sumAlg, err := checksum.Parse(config.InstanceConfig().ChecksumCnf.Algorithm)
+	if err != nil {
+		ylogger.Zero.Error().Err(err).Str("path", name).Msg("failed to select checksum")
+		return err
+	}
+	/* checksum of content as client sent it */
+	sumHash := sumAlg.New()
+
+	compression, err := objectCompression(settings)
+	if err != nil {
+		_ = ycl.ReplyError(err, "failed to select compression")
+		ylogger.Zero.Error().Err(err).Str("path", name).Msg("failed to select compression")
+		return err
+	}
+
+	r, pw := io.Pipe()
 
-	w = yio.NewYproxyWriter(w, ycl)
+	var w io.WriteCloser = yio.NewYproxyWriter(pw, ycl)
 
 	defer func() { _ = r.Close() }()
 	defer func() { _ = w.Close() }()
@@ -117,10 +211,13 @@ func (*ProtoMgrImpl) ProcessPutExtended(
 	go func() {
 		defer wg.Done()
 
+		/* upload fails instead of waiting for content never written */
 		ww := w
 		if encrypt {
 			if cr == nil {
-				ylogger.Zero.Error().Err(fmt.Errorf("failed to encrypt, crypter not configured")).Str("path", name).Msg("connection aborted")
+				err := fmt.Errorf("failed to encrypt, crypter not configured")
+				ylogger.Zero.Error().Err(err).Str("path", name).Msg("connection aborted")
+				_ = pw.CloseWithError(err)
 				return
 			}
 
@@ -128,14 +225,37 @@ func (*ProtoMgrImpl) ProcessPutExtended(
 			ww, err = cr.Encrypt(w)
 			if err != nil {
 				ylogger.Zero.Error().Err(err).Msg("failed to encrypt")
+				_ = pw.CloseWithError(err)
 				return
 			}
 		} else {
 			ylogger.Zero.Debug().Str("path", name).Msg("omit encryption for upload chunks")
 		}
 
+		/* content is compressed before encryption */
+		enc := ww
+		var cw *codec.Writer
+		if compression != codec.None {
+			var err error
+			cw, err = codec.NewWriter(enc, compression)
+			if err != nil {
+				ylogger.Zero.Error().Err(err).Str("path", name).Msg("failed to compress")
+				_ = pw.CloseWithError(err)
+				return
+			}
+			ww = cw
+		}
+
 		defer func() {
-			if err := ww.Close(); err != nil {
+			if cw != nil {
+				if err := cw.Close(); err != nil {
+					ylogger.Zero.Error().Err(err).Msg("failed to close connection")
+					return
+				}
+				observeCompression(name, cw)
+			}
+
+			if err := enc.Close(); err != nil {
 				ylogger.Zero.Error().Err(err).Msg("failed to close connection")
 				return
 			}
@@ -170,6 +290,9 @@ func (*ProtoMgrImpl) ProcessPutExtended(
 					ylogger.Zero.Error().Uint("client id", ycl.ID()).Int("write bytes", n).Uint64("msg size", msg.Sz).Msg("failed to put object due to unfull write")
 					return
 				}
+				if sumHash != nil {
+					_, _ = sumHash.Write(msg.Data)
+				}
 			case message.MessageTypeCopyDone:
 				msg := message.CopyDoneMessage{}
 				msg.Decode(body)
@@ -192,8 +315,22 @@ func (*ProtoMgrImpl) ProcessPutExtended(
 
 	wg.Wait()
 
+	var sum checksum.Sum
+	if sumHash != nil {
+		sum = checksum.Sum{Algorithm: sumAlg, Value: sumHash.Sum(nil)}
+		/* object is already stored, CAT of object without checksum just is not verified */
+		if err := checksum.Put(s, name, checksum.Stored{Sum: sum, Encrypted: encrypt}, settings); err != nil {
+			ylogger.Zero.Error().Err(err).Str("name", name).Msg("failed to store object checksum")
+		} else {
+			ylogger.Zero.Debug().Str("name", name).Str("checksum", sum.String()).Msg("object checksum stored")
+		}
+	}
+
 	if replyKV {
-		if _, err := ycl.GetRW().Write(message.NewPutCompleteMessage(uint16(crypt.SingleKeyEncryption)).Encode()); err != nil {
+		msg := message.NewPutCompleteMessage(uint16(keyVersion))
+		msg.ChecksumAlgorithm = byte(sum.Algorithm)
+		msg.Checksum = sum.Value
+		if _, err := ycl.GetRW().Write(msg.Encode()); err != nil {
 			ylogger.Zero.Error().Err(err).Bool("encrypt", encrypt).Str("name", name).Msg("failed to upload")
 			return err
 		}
@@ -230,8 +367,7 @@ func (*ProtoMgrImpl) ProcessListExtended(prefix string,
 		_, err = ycl.GetRW().Write(message.NewObjectMetaMessage(objectMetas[i:min(i+chunkSize, len(objectMetas))]).Encode())
 		if err != nil {
 			_ = ycl.ReplyError(err, "failed to upload")
-
-			return nil
+			return err
 		}
 	}
 
@@ -254,24 +390,13 @@ func (*ProtoMgrImpl) ProcessCopyExtended(
 	decrypt,
 	kEKDecrypt,
 	serverSide,
+	resume,
+	verify,
 	replyKV bool,
+	settings []settings.StorageSettings,
 	s storage.StorageInteractor,
 	cr crypt.Crypter,
 	ycl client.YproxyClient) error {
-	if kEKDecrypt {
-		err := fmt.Errorf("KEK decryption in Copy not supported")
-		_ = ycl.ReplyError(err, "failed to complete request")
-		ylogger.Zero.Error().Err(err).Msg("failed to complete request")
-		return err
-	}
-
-	if serverSide {
-		err := fmt.Errorf("server-side Copy not supported")
-		_ = ycl.ReplyError(err, "failed to complete request")
-		ylogger.Zero.Error().Err(err).Msg("failed to complete request")
-		return err
-	}
-
 	ycl.SetExternalFilePath(name)
 
 	// Get config for old bucket
@@ -280,29 +405,103 @@ func (*ProtoMgrImpl) ProcessCopyExtended(
 		_ = ycl.ReplyError(fmt.Errorf("could not read old config: %s", err), "failed to complete request")
 
 		ylogger.Zero.Error().Err(err).Msg("failed to complete request")
-		return nil
+		return err
 	}
 	oldStorage, err := storage.NewStorage(&sourceInstanceCnf.StorageCnf, "")
 	if err != nil {
 		return err
 	}
-	ylogger.Zero.Debug().Interface("cnf", sourceInstanceCnf).Msg("loaded new config")
+	ylogger.Zero.Debug().Interface("cnf", sourceInstanceCnf.Redacted()).Msg("loaded new config")
 
-	objectMetas, _, err := ListFilesToCopy(name, port, sourceInstanceCnf.StorageCnf, oldStorage, s)
+	if serverSide && !sameStorageEndpoint(sourceInstanceCnf.StorageCnf, config.InstanceConfig().StorageCnf, destinationBucket(s, settings)) {
+		err := fmt.Errorf("server-side copy requires source and destination on the same storage endpoint with the same credentials")
+		_ = ycl.ReplyError(err, "failed to complete request")
+		ylogger.Zero.Error().Err(err).Msg("failed to complete request")
+		return err
+	}
+
+	journalDir := config.InstanceConfig().ProxyCnf.CopyJournalPath
+	if resume && journalDir == "" {
+		err := fmt.Errorf("resume requested, but copy journal path is not specified")
+		_ = ycl.ReplyError(err, "failed to complete request")
+		ylogger.Zero.Error().Err(err).Msg("failed to complete request")
+		return err
+	}
+
+	var journal *CopyJournal
+	if journalDir != "" && (confirm || resume) {
+		journalPath := CopyJournalPath(journalDir, name, oldCfgPath)
+		if confirm {
+			journal, err = OpenCopyJournal(journalPath, resume)
+		} else {
+			/* dry-run must not touch journal of real copy */
+			journal, err = ReadCopyJournal(journalPath)
+		}
+		if err != nil {
+			_ = ycl.ReplyError(err, "failed to open copy journal")
+			ylogger.Zero.Error().Err(err).Str("journal", journalPath).Msg("failed to open copy journal")
+			return err
+		}
+		defer func() { _ = journal.Close() }()
+		ylogger.Zero.Info().Str("journal", journalPath).Bool("resume", resume).Msg("using copy journal")
+	}
+
+	var verifyJournal *CopyJournal
+	if resume {
+		verifyJournal = journal
+	}
+	objectMetas, skipped, err := ListFilesToCopy(name, port, sourceInstanceCnf.StorageCnf, oldStorage, s, settings, verifyJournal)
 	if err != nil {
 		_ = ycl.ReplyError(err, "failed to list files to copy")
 		ylogger.Zero.Error().Err(err).Msg("failed to list files to copy")
 		return err
 	}
 
-	if confirm {
-		var my sync.Mutex
+	encCr, keyVersion, err := objectCrypter(cr, settings)
+	if err != nil {
+		_ = ycl.ReplyError(err, "failed to select crypter")
+		return err
+	}
+	srcKeyVersion := crypt.SingleKeyEncryption
+	if kEKDecrypt {
+		srcKeyVersion = crypt.KEKDEKEncryption
+	}
+	if !encrypt && !decrypt {
+		/* objects are copied as is and keep source encryption */
+		keyVersion = srcKeyVersion
+	} else if !encrypt {
+		keyVersion = crypt.SingleKeyEncryption
+	}
 
+	var ssCopy bool
+	var decCr crypt.Crypter
+	if confirm || verify {
 		eq, err := cr.CmpKey(sourceInstanceCnf.CryptoCnf.GPGKeyPath)
 		if err != nil {
 			return err
 		}
-		ssCopy := (!encrypt && !decrypt) || (encrypt && decrypt && eq)
+		ssCopy = (!encrypt && !decrypt) || (encrypt && decrypt && eq && srcKeyVersion == keyVersion)
+
+		if decrypt {
+			decCr, err = crypt.NewCrypto(&sourceInstanceCnf.CryptoCnf)
+			if err != nil {
+				_ = ycl.ReplyError(err, "failed to configure decrypter")
+				ylogger.Zero.Error().Err(err).Msg("failed to configure decrypter")
+				return err
+			}
+			if kEKDecrypt {
+				decCr = crypt.NewEnvelopeCrypter(decCr)
+			}
+		}
+		if !encrypt {
+			encCr = nil
+		}
+	}
+	copied := objectMetas
+
+	if confirm {
+		var my sync.Mutex
+		var writeErr error
 
 		var failed []*object.ObjectInfo
 		retryCount := 0
@@ -325,99 +524,46 @@ func (*ProtoMgrImpl) ProcessCopyExtended(
 
 					ylogger.Zero.Debug().Int("index", i).Str("object path", objectMetas[i].Path).Int64("object size", objectMetas[i].Size).Msg("copying...")
 
-					// If keys are equal, try performing server-side copy
-					if ssCopy {
-						if err := s.CopyObject(
-							path,
-							path,
-							sourceInstanceCnf.StorageCnf.StoragePrefix,
-							sourceInstanceCnf.StorageCnf.StorageBucket,
-							/* XXX: we do copy always from source bucket to default bucket */
-							s.DefaultBucket()); err == nil {
-							return
+					if journal != nil {
+						if err := journal.Start(objectMetas[i]); err != nil {
+							ylogger.Zero.Warn().Err(err).Str("path", path).Msg("failed to write copy journal")
 						}
-						ylogger.Zero.Error().Err(err).Msg("failed server-side copy")
 					}
 
-					/* get reader */
-					readerFromOldBucket := yio.NewYRetryReader(
-						yio.NewRestartReader(oldStorage, path, nil), ycl)
-					var fromReader io.Reader
-					fromReader = readerFromOldBucket
-					defer func() { _ = readerFromOldBucket.Close() }()
-
-					if decrypt {
-						oldCr, err := crypt.NewCrypto(&sourceInstanceCnf.CryptoCnf)
-						if err != nil {
-							ylogger.Zero.Error().Err(err).Msg("failed to configure decrypter")
-							my.Lock()
-							failed = append(failed, objectMetas[i])
-							my.Unlock()
-							return
-						}
-						fromReader, err = oldCr.Decrypt(readerFromOldBucket)
-						if err != nil {
-							ylogger.Zero.Error().Err(err).Msg("failed to decrypt object")
-							my.Lock()
-							failed = append(failed, objectMetas[i])
-							my.Unlock()
-							return
-						}
+					method, err := copyObject(path, oldStorage, s, settings, sourceInstanceCnf.StorageCnf, decCr, encCr, ssCopy, serverSide, ycl)
+					var dst *object.ObjectInfo
+					if err == nil && journal != nil {
+						dst, err = statObject(s, path, settings)
 					}
 
-					/* re-encrypt */
-					readerEncrypt, writerEncrypt := io.Pipe()
+					my.Lock()
+					defer my.Unlock()
 
-					go func() {
-						defer func() {
-							if err := writerEncrypt.Close(); err != nil {
-								ylogger.Zero.Warn().Err(err).Msg("failed to close writer")
-							}
-						}()
-
-						var writerToNewBucket io.WriteCloser = writerEncrypt
-
-						if encrypt {
-							var err error
-							writerToNewBucket, err = cr.Encrypt(writerEncrypt)
-							if err != nil {
-								ylogger.Zero.Error().Err(err).Msg("failed to encrypt object")
-								my.Lock()
-								failed = append(failed, objectMetas[i])
-								my.Unlock()
-								return
+					if err != nil {
+						ylogger.Zero.Error().Str("path", path).Err(err).Msg("failed to copy object")
+						failed = append(failed, objectMetas[i])
+						if journal != nil {
+							if err := journal.Fail(objectMetas[i], err); err != nil {
+								ylogger.Zero.Warn().Err(err).Str("path", path).Msg("failed to write copy journal")
 							}
 						}
+						return
+					}
 
-						if _, err := io.Copy(writerToNewBucket, fromReader); err != nil {
-							ylogger.Zero.Error().Str("path", path).Err(err).Msg("failed to copy data")
-							my.Lock()
-							failed = append(failed, objectMetas[i])
-							my.Unlock()
-							return
-						}
-
-						if err := writerToNewBucket.Close(); err != nil {
-							ylogger.Zero.Error().Str("path", path).Err(err).Msg("failed to close writer")
-							my.Lock()
-							failed = append(failed, objectMetas[i])
-							my.Unlock()
-							return
+					if journal != nil {
+						if err := journal.Done(objectMetas[i], dst); err != nil {
+							ylogger.Zero.Warn().Err(err).Str("path", path).Msg("failed to write copy journal")
 						}
-					}()
-
-					// Write file
-					err = s.PutFileToDest(path, readerEncrypt, nil)
-					if err != nil {
-						ylogger.Zero.Error().Err(err).Msg("failed to upload file")
-						my.Lock()
-						failed = append(failed, objectMetas[i])
-						my.Unlock()
-						return
+					}
+					if serverSide && writeErr == nil {
+						_, writeErr = ycl.GetRW().Write(message.NewCopyStatusMessage(method, path, objectMetas[i].Size).Encode())
 					}
 				}(i)
 			}
 			wg.Wait()
+			if writeErr != nil {
+				return writeErr
+			}
 			objectMetas = failed
 			failed = make([]*object.ObjectInfo, 0)
 		}
@@ -437,8 +583,43 @@ func (*ProtoMgrImpl) ProcessCopyExtended(
 		ylogger.Zero.Info().Msg("It was a dry-run, nothing was copied")
 	}
 
+	if verify {
+		verifier := &CopyVerifier{
+			Src:         oldStorage,
+			Dst:         s,
+			SrcPrefix:   sourceInstanceCnf.StorageCnf.StoragePrefix,
+			SrcCrypter:  decCr,
+			DstSettings: settings,
+			Identical:   ssCopy,
+			Concurrency: sourceInstanceCnf.StorageCnf.CopyStorageConcurrency,
+			Ycl:         ycl,
+		}
+		if _, ok := cr.(*crypt.Registry); ok && encrypt {
+			/* registry picks crypter of every object itself */
+			verifier.DstCrypter = cr
+		} else if encrypt {
+			/* envelope crypter reads both single key and KEK/DEK objects */
+			verifier.DstCrypter = crypt.NewEnvelopeCrypter(cr)
+		}
+		mismatches, err := verifier.Verify(name, copied, skipped)
+		if err != nil {
+			_ = ycl.ReplyError(err, "failed to verify copy")
+			return err
+		}
+		for _, m := range mismatches {
+			if _, err := ycl.GetRW().Write(m.Encode()); err != nil {
+				return err
+			}
+		}
+		if len(mismatches) > 0 {
+			err := fmt.Errorf("%d objects differ from source", len(mismatches))
+			_ = ycl.ReplyError(err, "copy verification failed")
+			return err
+		}
+	}
+
 	if replyKV {
-		if _, err = ycl.GetRW().Write(message.NewCopyCompleteMessage(byte(crypt.SingleKeyEncryption)).Encode()); err != nil {
+		if _, err = ycl.GetRW().Write(message.NewCopyCompleteMessage(byte(keyVersion)).Encode()); err != nil {
 			_ = ycl.ReplyError(err, "failed to upload")
 			return err
 		}
@@ -452,6 +633,99 @@ func (*ProtoMgrImpl) ProcessCopyExtended(
 	return nil
 }
 
+// copyObject copies single object from source storage. If object is not
+// re-encrypted (ssCopy), storage is asked to copy it first; in server-side
+// mode failure of such copy is final, otherwise object is streamed.
+func copyObject(
+	path string,
+	src, dst storage.StorageInteractor,
+	dstSettings []settings.StorageSettings,
+	srcCnf config.Storage,
+	decCr, encCr crypt.Crypter,
+	ssCopy, serverSide bool,
+	ycl client.YproxyClient) (message.CopyMethod, error) {
+	if ssCopy {
+		err := dst.CopyObject(
+			path,
+			path,
+			srcCnf.StoragePrefix,
+			srcCnf.StorageBucket,
+			/* XXX: we do copy always from source bucket */
+			destinationBucket(dst, dstSettings))
+		if err == nil {
+			return message.CopyMethodServerSide, nil
+		}
+		if serverSide {
+			/* data is the same, streaming it through proxy will not help */
+			return message.CopyMethodServerSide, err
+		}
+		ylogger.Zero.Error().Str("path", path).Err(err).Msg("failed server-side copy")
+	}
+
+	/* get reader */
+	readerFromOldBucket := yio.NewYRetryReader(
+		yio.NewRestartReader(src, path, nil), ycl)
+	var fromReader io.Reader = readerFromOldBucket
+	defer func() { _ = readerFromOldBucket.Close() }()
+
+	if decCr != nil {
+		var err error
+		fromReader, err = decCr.Decrypt(readerFromOldBucket)
+		if err != nil {
+			return message.CopyMethodStreamed, fmt.Errorf("failed to decrypt object: %w", err)
+		}
+	}
+
+	/* re-encrypt */
+	readerEncrypt, writerEncrypt := io.Pipe()
+	copyErr := make(chan error, 1)
+
+	go func() {
+		err := func() error {
+			/* limit bytes actually uploaded, i.e. encrypted ones */
+			upload := yio.NewUploadWriter(writerEncrypt)
+			writerToNewBucket := upload
+			if encCr != nil {
+				var err error
+				writerToNewBucket, err = encCr.Encrypt(upload)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt object: %w", err)
+				}
+			}
+			if _, err := io.Copy(writerToNewBucket, fromReader); err != nil {
+				return fmt.Errorf("failed to copy data: %w", err)
+			}
+			return writerToNewBucket.Close()
+		}()
+		/* upload must not succeed with partial data */
+		_ = writerEncrypt.CloseWithError(err)
+		copyErr <- err
+	}()
+
+	// Write file
+	if err := dst.PutFileToDest(path, readerEncrypt, dstSettings); err != nil {
+		_ = readerEncrypt.CloseWithError(err)
+		<-copyErr
+		return message.CopyMethodStreamed, fmt.Errorf("failed to upload file: %w", err)
+	}
+	return message.CopyMethodStreamed, <-copyErr
+}
+
+// statObject returns listing info of single object, e.g. to record its ETag
+// in copy journal.
+func statObject(s storage.StorageLister, p string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	objs, err := s.ListPath(p, false, setts)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range objs {
+		if strings.TrimLeft(o.Path, "/") == strings.TrimLeft(p, "/") {
+			return o, nil
+		}
+	}
+	return nil, fmt.Errorf("object %s not found after copy", p)
+}
+
 func (*ProtoMgrImpl) ProcessDeleteExtended(
 	msg message.DeleteMessage,
 	s storage.StorageInteractor,
@@ -724,6 +998,105 @@ func (*ProtoMgrImpl) ProcessDeleteObsolete(msg message.DeleteObsoleteMessage,
 	return nil
 }
 
+// sameStorageEndpoint checks that objects of source storage can be copied
+// to destination bucket by storage itself, without streaming data through
+// yproxy. Destination storage copies with credentials of its bucket, those
+// must be the ones source bucket is read with.
+func sameStorageEndpoint(src, dst config.Storage, dstBucket string) bool {
+	storageType := func(st config.Storage) string {
+		if st.StorageType == "" {
+			return config.DefaultStorageType
+		}
+		return st.StorageType
+	}
+	credentials := func(st config.Storage, bucket string) config.StorageCredentials {
+		if cred, ok := st.CredentialMap[bucket]; ok {
+			return config.StorageCredentials{AccessKeyId: cred.AccessKeyId, SecretAccessKey: cred.SecretAccessKey}
+		}
+		return config.StorageCredentials{AccessKeyId: st.AccessKeyId, SecretAccessKey: st.SecretAccessKey}
+	}
+	return storageType(src) == storageType(dst) &&
+		src.StorageEndpoint == dst.StorageEndpoint &&
+		src.StorageRegion == dst.StorageRegion &&
+		credentials(src, src.StorageBucket) == credentials(dst, dstBucket) &&
+		src.AzureAccountName == dst.AzureAccountName &&
+		src.GCSCredentialsFile == dst.GCSCredentialsFile
+}
+
+// objectCrypter returns crypter for newly written objects along with
+// key version reported back to client.
+func objectCrypter(cr crypt.Crypter, setts []settings.StorageSettings) (crypt.Crypter, crypt.KeyVersion, error) {
+	if reg, ok := cr.(*crypt.Registry); ok {
+		sel, err := reg.ForSettings(setts)
+		if err != nil {
+			return nil, crypt.SingleKeyEncryption, err
+		}
+		return sel, crypt.KeyVersionOf(sel), nil
+	}
+	cnf := config.InstanceConfig().CryptoCnf
+	if cr != nil && cnf.EncryptionFormat == config.EncryptionFormatAESGCM {
+		/* chunked objects always have data key wrapped by KEK */
+		return crypt.NewChunkedCrypter(cr, cnf.EncryptionChunkSize), crypt.KEKDEKEncryption, nil
+	}
+	if cr != nil && cnf.UseKEK {
+		return crypt.NewEnvelopeCrypter(cr), crypt.KEKDEKEncryption, nil
+	}
+	return cr, crypt.SingleKeyEncryption, nil
+}
+
+// destinationBucket returns bucket objects written with settings go to.
+func destinationBucket(s storage.StorageInteractor, setts []settings.StorageSettings) string {
+	ts := storage.ResolveStorageSetting(setts, message.TableSpaceSetting, tablespace.DefaultTableSpace)
+	if bucket, ok := config.InstanceConfig().StorageCnf.TablespaceMap[ts]; ok {
+		return bucket
+	}
+	return s.DefaultBucket()
+}
+
+// objectCompression returns codec newly written object is compressed with.
+func objectCompression(setts []settings.StorageSettings) (codec.Codec, error) {
+	for _, s := range setts {
+		if s.Name == message.CompressionSetting {
+			return codec.Parse(s.Value)
+		}
+	}
+	return codec.None, nil
+}
+
+func observeCompression(name string, cw *codec.Writer) {
+	in, out := cw.InputBytes(), cw.OutputBytes()
+	metrics.CompressionInputBytes.WithLabelValues(cw.Codec().String()).Add(float64(in))
+	metrics.CompressionOutputBytes.WithLabelValues(cw.Codec().String()).Add(float64(out))
+	if out > 0 {
+		metrics.CompressionRatio.WithLabelValues(cw.Codec().String()).Observe(float64(in) / float64(out))
+	}
+	ylogger.Zero.Debug().Str("path", name).Str("codec", cw.Codec().String()).Int64("size", in).Int64("compressed size", out).Msg("object compressed")
+}
+
+// requestSettings returns storage settings of request, used to select its
+// qos class before request is served.
+func requestSettings(tp message.MessageType, body []byte) []settings.StorageSettings {
+	switch tp {
+	case message.MessageTypeCatV2:
+		msg := message.CatMessageV2{}
+		msg.Decode(body)
+		return msg.Settings
+	case message.MessageTypePutV2:
+		msg := message.PutMessageV2{}
+		msg.Decode(body)
+		return msg.Settings
+	case message.MessageTypePutV3:
+		msg := message.PutMessageV3{}
+		msg.Decode(body)
+		return msg.Settings
+	case message.MessageTypeListV2:
+		msg := message.ListMessageV2{}
+		msg.Decode(body)
+		return msg.Settings
+	}
+	return nil
+}
+
 func ProcConn(
 	m proto.ProtoMgr,
 	s storage.StorageInteractor,
@@ -743,10 +1116,55 @@ func ProcConn(
 		return err
 	}
 
+	if tp == message.MessageTypeStartup {
+		if err := procStartup(cr, ycl, body); err != nil {
+			return err
+		}
+		ycl.ResetRequest()
+
+		tp, body, err = pr.ReadPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				/* client asked for server info only */
+				return nil
+			}
+			_ = ycl.ReplyError(err, "failed to read request packet")
+			return err
+		}
+	}
+
+	if tp == message.MessageTypeSession {
+		return procSession(m, s, bs, cr, ycl, cnf, pr)
+	}
+	return procRequest(m, s, bs, cr, ycl, cnf, pr, tp, body)
+}
+
+func procRequest(
+	m proto.ProtoMgr,
+	s storage.StorageInteractor,
+	bs storage.StorageInteractor,
+	cr crypt.Crypter,
+	ycl client.YproxyClient,
+	cnf *config.Vacuum,
+	pr *pio.ProtoReader,
+	tp message.MessageType,
+	body []byte) error {
+
 	ylogger.Zero.Debug().Str("msg-type", tp.String()).Msg("received client request")
 
 	ycl.SetOPType(tp)
 
+	class := qos.Current().Select(tp, requestSettings(tp, body))
+	release, err := class.Acquire(context.Background())
+	if err != nil {
+		_ = ycl.ReplyError(err, "failed to acquire qos class")
+		return err
+	}
+	defer release()
+	ylogger.Zero.Debug().Str("msg-type", tp.String()).Str("class", class.Name()).Msg("serving request in qos class")
+	s = class.WrapStorage(s)
+	bs = class.WrapStorage(bs)
+
 	switch tp {
 	case message.MessageTypeCat:
 
@@ -793,6 +1211,14 @@ func ProcConn(
 			return err
 		}
 
+	case message.MessageTypePatch:
+		msg := message.PatchMessage{}
+		msg.Decode(body)
+
+		if err := m.ProcessPatchExtended(s, pr, msg.Name, msg.Offset, msg.Encrypt, ycl); err != nil {
+			return err
+		}
+
 	case message.MessageTypeList:
 		msg := message.ListMessage{}
 		msg.Decode(body)
@@ -828,6 +1254,9 @@ func ProcConn(
 			false,
 			false,
 			false,
+			false,
+			false,
+			nil,
 			s, cr, ycl)
 		if err != nil {
 			return err
@@ -846,7 +1275,10 @@ func ProcConn(
 			msg.Decrypt,
 			msg.KEKDecrypt,
 			msg.ServerSideCopy,
+			msg.Resume,
+			msg.Verify,
 			true,
+			msg.Settings,
 			s, cr, ycl)
 		if err != nil {
 			return err
@@ -892,6 +1324,12 @@ func ProcConn(
 		if err := m.ProcessDeleteObsolete(msg, s, bs, ycl); err != nil {
 			return err
 		}
+	case message.MessageTypeRotateKeys:
+		msg := message.RotateKeysMessage{}
+		msg.Decode(body)
+		if err := m.ProcessRotateKeys(msg, s, cr, ycl); err != nil {
+			return err
+		}
 	default:
 		unsupErr := ycl.ReplyError(fmt.Errorf("wrong request type: %s", tp.String()), "message is unsupported in ProcConn")
 		ylogger.Zero.Error().Err(unsupErr).Msg("failed to send error reply")
@@ -930,7 +1368,10 @@ func ProcMotion(s storage.StorageInteractor, cr crypt.Crypter, ycl client.Yproxy
 	return nil
 }
 
-func ListFilesToCopy(prefix string, port uint64, cfg config.Storage, src storage.StorageLister, dst storage.StorageLister) ([]*object.ObjectInfo, []*object.ObjectInfo, error) {
+// ListFilesToCopy splits source objects into ones to be copied and skipped.
+// Without journal any object present at destination is skipped, with journal
+// only objects verified by it are.
+func ListFilesToCopy(prefix string, port uint64, cfg config.Storage, src storage.StorageLister, dst storage.StorageLister, dstSettings []settings.StorageSettings, journal *CopyJournal) ([]*object.ObjectInfo, []*object.ObjectInfo, error) {
 	objectMetas, err := src.ListPath(prefix, true, nil)
 	if err != nil {
 		return nil, nil, err
@@ -942,13 +1383,13 @@ func ListFilesToCopy(prefix string, port uint64, cfg config.Storage, src storage
 		return nil, nil, err
 	}
 
-	copied, err := dst.ListPath(prefix, false, nil)
+	copied, err := dst.ListPath(prefix, false, dstSettings)
 	if err != nil {
 		return nil, nil, err
 	}
-	copiedSizes := make(map[string]int64)
+	copiedObjects := make(map[string]*object.ObjectInfo)
 	for _, c := range copied {
-		copiedSizes[c.Path] = c.Size
+		copiedObjects[c.Path] = c
 	}
 
 	toCopy := []*object.ObjectInfo{}
@@ -967,16 +1408,22 @@ func ListFilesToCopy(prefix string, port uint64, cfg config.Storage, src storage
 				continue
 			}
 		}
-		if sz, ok := copiedSizes[objectMetas[i].Path]; ok {
+		if c, ok := copiedObjects[objectMetas[i].Path]; ok {
+			if journal == nil || journal.Verified(objectMetas[i], c) {
+				ylogger.Zero.Info().
+					Int("index", i).
+					Str("object path", objectMetas[i].Path).
+					Int64("object size", objectMetas[i].Size).
+					Int64("copied size", c.Size).
+					Msg("already copied, skipping...")
+
+				skipped = append(skipped, objectMetas[i])
+				continue
+			}
 			ylogger.Zero.Info().
-				Int("index", i).
 				Str("object path", objectMetas[i].Path).
-				Int64("object size", objectMetas[i].Size).
-				Int64("copied size", sz).
-				Msg("already copied, skipping...")
-
-			skipped = append(skipped, objectMetas[i])
-			continue
+				Int64("copied size", c.Size).
+				Msg("copy is not verified by journal, copying again")
 		}
 
 		ylogger.Zero.Debug().Str("object path", objectMetas[i].Path).Int64("object size", objectMetas[i].Size).Msg("will be copied")
@@ -3,14 +3,20 @@ package proc_test
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/yezzey-gp/yproxy/config"
+	pio "github.com/yezzey-gp/yproxy/pkg/io"
 	"github.com/yezzey-gp/yproxy/pkg/message"
 	"github.com/yezzey-gp/yproxy/pkg/proc"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
 )
 
 type procConnTestClient struct {
@@ -68,6 +74,13 @@ func (c *procConnTestClient) ExternalFilePath() string {
 	return c.externalPath
 }
 
+func (c *procConnTestClient) ResetRequest() {
+	c.op = 0
+	c.opStart = time.Time{}
+	c.byteOffset = 0
+	c.externalPath = ""
+}
+
 func (c *procConnTestClient) Close() error {
 	c.closed = true
 	return c.rw.Close()
@@ -136,6 +149,24 @@ func TestProcConnCopyDoneRepliesErrorWithoutPanic(t *testing.T) {
 	require.Contains(t, errorMessage.Error, "wrong request type")
 }
 
+func TestProcConnSessionResetsRequestState(t *testing.T) {
+	const unknownType = message.MessageType(200)
+
+	input := append(testPacket(message.MessageTypeSession), testPacket(unknownType)...)
+	input = append(input, testPacket(message.MessageTypeTerminate)...)
+	ycl := newProcConnTestClient(input)
+
+	require.NoError(t, proc.ProcConn(&proc.ProtoMgrImpl{}, nil, nil, nil, ycl, &config.Vacuum{}))
+	require.True(t, ycl.closed)
+	require.Equal(t, message.MessageType(0), ycl.OPType())
+
+	written := ycl.rw.Written()
+	ready := message.NewReadyForQueryMessage().Encode()
+	require.Equal(t, ready, written[:len(ready)])
+	body := decodeWrittenPacket(t, written[len(ready):])
+	require.Equal(t, message.MessageTypeError, message.MessageType(body[0]))
+}
+
 func testPacket(tp message.MessageType) []byte {
 	body := []byte{byte(tp), 0, 0, 0}
 	packet := make([]byte, 8, 8+len(body))
@@ -152,3 +183,78 @@ func decodeWrittenPacket(t *testing.T, packet []byte) []byte {
 
 	return packet[8:]
 }
+
+func TestServerSideCopyRequiresSameCredentials(t *testing.T) {
+	cnf := config.InstanceConfig()
+	prevStorage, prevProxy := cnf.StorageCnf, cnf.ProxyCnf
+	t.Cleanup(func() { cnf.StorageCnf, cnf.ProxyCnf = prevStorage, prevProxy })
+
+	cnf.ProxyCnf.CopyJournalPath = ""
+	cnf.StorageCnf = config.Storage{
+		StorageType:     "fs",
+		StorageEndpoint: "http://s3",
+		StorageBucket:   "dst",
+		AccessKeyId:     "dst-key",
+		SecretAccessKey: "dst-secret",
+		CredentialMap: map[string]config.StorageCredentials{
+			"shared": {AccessKeyId: "src-key", SecretAccessKey: "src-secret"},
+		},
+		TablespaceMap: map[string]string{"ts1": "shared"},
+	}
+
+	oldCfgPath := filepath.Join(t.TempDir(), "old.yaml")
+	require.NoError(t, os.WriteFile(oldCfgPath, []byte(fmt.Sprintf(
+		"storage:\n  storage_type: fs\n  storage_prefix: %s/\n  storage_endpoint: http://s3\n  storage_bucket: src\n  access_key_id: src-key\n  secret_access_key: src-secret\n",
+		t.TempDir())), 0600))
+
+	dst := newTestStorage(t)
+	copyTo := func(setts []settings.StorageSettings) error {
+		/* resume without journal fails right after endpoint check */
+		return (&proc.ProtoMgrImpl{}).ProcessCopyExtended("prefix", oldCfgPath, 0, true, false, false, false, true, true, false, true, setts, dst, nil, newProcConnTestClient(nil))
+	}
+
+	err := copyTo(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "same credentials")
+
+	err = copyTo([]settings.StorageSettings{{Name: message.TableSpaceSetting, Value: "ts1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "copy journal path is not specified")
+}
+
+func TestPutFailsWhenEncryptionFails(t *testing.T) {
+	s := newTestStorage(t)
+	payload := []byte("content")
+	put := newProcConnTestClient(append(
+		(&message.CopyDataMessage{Sz: uint64(len(payload)), Data: payload}).Encode(),
+		message.NewCopyDoneMessage().Encode()...))
+
+	/* upload must not wait for content never written */
+	done := make(chan error, 1)
+	go func() {
+		done <- (&proc.ProtoMgrImpl{}).ProcessPutExtended(s, pio.NewProtoReader(put), "obj", true, nil, nil, put, false)
+	}()
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("put hangs")
+	}
+}
+
+func TestProcConnSessionEndsOnFailedRequest(t *testing.T) {
+	input := append(testPacket(message.MessageTypeSession),
+		message.NewCopyMessage("prefix", filepath.Join(t.TempDir(), "missing.yaml"), false, false, false, 0).Encode()...)
+	/* requests after failed one are not served */
+	input = append(input, message.NewListMessage("prefix").Encode()...)
+	ycl := newProcConnTestClient(input)
+
+	require.Error(t, proc.ProcConn(&proc.ProtoMgrImpl{}, nil, nil, nil, ycl, &config.Vacuum{}))
+	require.True(t, ycl.closed)
+
+	written := ycl.rw.Written()
+	ready := message.NewReadyForQueryMessage().Encode()
+	require.Equal(t, ready, written[:len(ready)])
+	body := decodeWrittenPacket(t, written[len(ready):])
+	require.Equal(t, message.MessageTypeError, message.MessageType(body[0]))
+}
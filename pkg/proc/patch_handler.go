@@ -0,0 +1,174 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/yezzey-gp/yproxy/pkg/client"
+	"github.com/yezzey-gp/yproxy/pkg/codec"
+	pio "github.com/yezzey-gp/yproxy/pkg/io"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/proc/yio"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+// suffix of temporary object used when storage cannot patch object in place
+const patchTmpSuffix = ".patch"
+
+// receivePatch spools patch data sent by client as CopyData messages up to
+// CopyDone, since storages need seekable patch.
+func receivePatch(pr *pio.ProtoReader) (*os.File, error) {
+	f, err := os.CreateTemp("", "yproxy-patch-*")
+	if err != nil {
+		return nil, err
+	}
+	/* file is not needed after close */
+	_ = os.Remove(f.Name())
+
+	for {
+		tp, body, err := pr.ReadPacket()
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		switch tp {
+		case message.MessageTypeCopyData:
+			msg := message.CopyDataMessage{}
+			msg.Decode(body)
+			if _, err := f.Write(msg.Data); err != nil {
+				_ = f.Close()
+				return nil, err
+			}
+		case message.MessageTypeCopyDone:
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				_ = f.Close()
+				return nil, err
+			}
+			return f, nil
+		default:
+			_ = f.Close()
+			return nil, fmt.Errorf("unexpected message %s in patch data", tp)
+		}
+	}
+}
+
+// patchedContent returns orig with patchLen bytes at offset replaced by patch.
+func patchedContent(orig io.Reader, patch io.Reader, offset, patchLen int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(func() error {
+			if n, err := io.CopyN(pw, orig, offset); err != nil {
+				if errors.Is(err, io.EOF) {
+					return fmt.Errorf("patch offset %d is beyond end of object of %d bytes", offset, n)
+				}
+				return err
+			}
+			if _, err := io.Copy(pw, patch); err != nil {
+				return err
+			}
+			/* patch may extend object */
+			if _, err := io.CopyN(io.Discard, orig, patchLen); err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			_, err := io.Copy(pw, orig)
+			return err
+		}())
+	}()
+	return pr
+}
+
+// rewriteObject applies patch by reading object, uploading patched content
+// to temporary object and moving it over original one.
+func rewriteObject(s storage.StorageInteractor, name string, patch io.ReadSeeker, offset int64, ycl client.YproxyClient) error {
+	patchLen, err := patch.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := patch.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	orig := yio.NewYRetryReader(yio.NewRestartReader(s, name, nil), ycl)
+	defer func() { _ = orig.Close() }()
+	content := patchedContent(orig, patch, offset, patchLen)
+	defer func() { _ = content.Close() }()
+
+	tmpName := name + patchTmpSuffix
+	if err := s.PutFileToDest(tmpName, content, nil); err != nil {
+		return err
+	}
+	return s.MoveObject(s.DefaultBucket(), tmpName, name)
+}
+
+// objectCodec returns codec object content is compressed with.
+func objectCodec(s storage.StorageInteractor, name string) (codec.Codec, error) {
+	r, err := s.CatFileFromStorage(name, 0, nil)
+	if err != nil {
+		return codec.None, err
+	}
+	defer func() { _ = r.Close() }()
+
+	header := make([]byte, codec.HeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return codec.None, err
+	}
+	c, _ := codec.ParseHeader(header[:n])
+	return c, nil
+}
+
+func (*ProtoMgrImpl) ProcessPatchExtended(
+	s storage.StorageInteractor,
+	pr *pio.ProtoReader,
+	name string,
+	offset uint64,
+	encrypt bool,
+	ycl client.YproxyClient) error {
+
+	ycl.SetExternalFilePath(name)
+
+	patch, err := receivePatch(pr)
+	if err != nil {
+		_ = ycl.ReplyError(err, "failed to receive patch data")
+		ylogger.Zero.Error().Err(err).Str("name", name).Msg("failed to receive patch data")
+		return err
+	}
+	defer func() { _ = patch.Close() }()
+
+	/* encrypted stream can not be changed at arbitrary offset */
+	if encrypt {
+		err := fmt.Errorf("patching of encrypted objects is not supported")
+		_ = ycl.ReplyError(err, "failed to patch object")
+		return err
+	}
+
+	/* offsets of compressed object are not in stored bytes */
+	if c, err := objectCodec(s, name); err != nil {
+		_ = ycl.ReplyError(err, "failed to patch object")
+		ylogger.Zero.Error().Err(err).Str("name", name).Msg("failed to read object header")
+		return err
+	} else if c != codec.None {
+		err := fmt.Errorf("patching of objects compressed with %s is not supported", c)
+		_ = ycl.ReplyError(err, "failed to patch object")
+		return err
+	}
+
+	err = s.PatchFile(name, patch, int64(offset))
+	if errors.Is(err, storage.ErrPatchNotSupported) {
+		ylogger.Zero.Debug().Err(err).Str("name", name).Msg("storage can not patch object in place, rewriting object")
+		err = rewriteObject(s, name, patch, int64(offset), ycl)
+	}
+	if err != nil {
+		_ = ycl.ReplyError(err, "failed to patch object")
+		ylogger.Zero.Error().Err(err).Str("name", name).Msg("failed to patch object")
+		return err
+	}
+	if _, err := ycl.GetRW().Write(message.NewReadyForQueryMessage().Encode()); err != nil {
+		ylogger.Zero.Error().Err(err).Str("name", name).Msg("failed to patch object")
+		return err
+	}
+	return nil
+}
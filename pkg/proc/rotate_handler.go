@@ -0,0 +1,284 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/client"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/proc/yio"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+	"golang.org/x/sync/semaphore"
+)
+
+// suffix of temporary object used when key rotation cannot patch object in place
+const rotateTmpSuffix = ".rotate"
+
+type KeyRotator struct {
+	StorageInterractor storage.StorageInteractor
+
+	OldCrypter *crypt.EnvelopeCrypter
+	NewCrypter *crypt.EnvelopeCrypter
+	/* name of crypter owning old key, crypt.DefaultCrypterName if empty */
+	CrypterName string
+
+	Confirm   bool
+	Reencrypt bool
+
+	Ycl client.YproxyClient
+}
+
+// replaceObject uploads content to temporary object and moves it over original one,
+// so crash in the middle never leaves object half-written.
+func (kr *KeyRotator) replaceObject(path string, content io.Reader) error {
+	tmpPath := path + rotateTmpSuffix
+	if err := kr.StorageInterractor.PutFileToDest(tmpPath, content, nil); err != nil {
+		return err
+	}
+	return kr.StorageInterractor.MoveObject(kr.StorageInterractor.DefaultBucket(), tmpPath, path)
+}
+
+func (kr *KeyRotator) rewrap(path string, header []byte, br *bufio.Reader) error {
+	newHeader, err := kr.OldCrypter.Rewrap(header, kr.NewCrypter)
+	if err != nil {
+		return err
+	}
+	if !kr.Confirm {
+		return nil
+	}
+
+	return keepChecksum(kr.StorageInterractor, path, func() error {
+		/* header has fixed size, so try to rewrite it in place first */
+		err := kr.StorageInterractor.PatchFile(path, bytes.NewReader(newHeader), 0)
+		if err == nil {
+			return nil
+		}
+		ylogger.Zero.Debug().Err(err).Str("path", path).Msg("failed to patch object header, rewriting object")
+
+		if _, err := br.Discard(crypt.EnvelopeHeaderSize); err != nil {
+			return err
+		}
+		return kr.replaceObject(path, io.MultiReader(bytes.NewReader(newHeader), br))
+	})
+}
+
+func (kr *KeyRotator) reencrypt(path string, br *bufio.Reader, chunked bool) error {
+	plain, err := kr.OldCrypter.Decrypt(io.NopCloser(br))
+	if err != nil {
+		return err
+	}
+	if !kr.Confirm {
+		return nil
+	}
+
+	/* keep seekable format of chunked objects */
+	var encCr crypt.Crypter = kr.NewCrypter
+	if chunked {
+		encCr = crypt.NewChunkedCrypter(kr.NewCrypter, config.InstanceConfig().CryptoCnf.EncryptionChunkSize)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := encCr.Encrypt(pw)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(w, plain); err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		_ = pw.CloseWithError(w.Close())
+	}()
+	defer func() { _ = pr.Close() }()
+
+	return keepChecksum(kr.StorageInterractor, path, func() error {
+		return kr.replaceObject(path, pr)
+	})
+}
+
+// RotateObject moves single object under new master key. Objects whose data key
+// is already wrapped by new key are skipped, which makes rotation resumable.
+func (kr *KeyRotator) RotateObject(path string) (message.RotateStatus, error) {
+	r := yio.NewYRetryReader(yio.NewRestartReader(kr.StorageInterractor, path, nil), kr.Ycl)
+	defer func() { _ = r.Close() }()
+	br := bufio.NewReaderSize(r, crypt.EnvelopeHeaderSize)
+
+	header, err := br.Peek(crypt.EnvelopeHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return message.RotateStatusFailed, err
+	}
+
+	crypterName := kr.CrypterName
+	if crypterName == "" {
+		crypterName = crypt.DefaultCrypterName
+	}
+	if crName := crypt.HeaderCrypter(header); crName != "" && crName != crypterName {
+		/* only objects of default crypter are under rotated key */
+		return message.RotateStatusSkipped, nil
+	}
+
+	chunked := crypt.IsChunkedHeader(header)
+	if !crypt.IsEnvelopeHeader(header) && !chunked && !crypt.IsGPGMessage(header) {
+		/* unencrypted objects, passthrough ones included, have no key to rotate */
+		return message.RotateStatusSkipped, nil
+	}
+	if crypt.IsEnvelopeHeader(header) || chunked {
+		if kr.NewCrypter.CanUnwrap(header) {
+			return message.RotateStatusSkipped, nil
+		}
+		if !kr.Reencrypt {
+			if err := kr.rewrap(path, bytes.Clone(header), br); err != nil {
+				return message.RotateStatusFailed, err
+			}
+			return message.RotateStatusRewrapped, nil
+		}
+	}
+
+	if err := kr.reencrypt(path, br, chunked); err != nil {
+		return message.RotateStatusFailed, err
+	}
+	return message.RotateStatusReencrypted, nil
+}
+
+func (*ProtoMgrImpl) ProcessRotateKeys(
+	msg message.RotateKeysMessage,
+	s storage.StorageInteractor,
+	cr crypt.Crypter,
+	ycl client.YproxyClient) error {
+	ycl.SetExternalFilePath(msg.Prefix)
+
+	if cr == nil {
+		err := fmt.Errorf("failed to rotate keys, crypter not configured")
+		_ = ycl.ReplyError(err, "failed to complete request")
+		return err
+	}
+	crypterName := crypt.DefaultCrypterName
+	if reg, ok := cr.(*crypt.Registry); ok {
+		/* rotated key is the one of default crypter */
+		crypterName = reg.DefaultName()
+		if cr, ok = reg.Get(crypterName); !ok {
+			err := fmt.Errorf("failed to rotate keys, crypter %q not configured", crypterName)
+			_ = ycl.ReplyError(err, "failed to complete request")
+			return err
+		}
+	}
+	newCr, err := crypt.NewCrypto(&config.Crypto{GPGKeyPath: msg.NewKeyPath})
+	if err != nil {
+		_ = ycl.ReplyError(err, "failed to load new key")
+		return err
+	}
+
+	kr := &KeyRotator{
+		StorageInterractor: s,
+		OldCrypter:         crypt.KeyEnvelope(cr),
+		NewCrypter:         crypt.NewEnvelopeCrypter(newCr),
+		CrypterName:        crypterName,
+		Confirm:            msg.Confirm,
+		Reencrypt:          msg.Reencrypt,
+		Ycl:                ycl,
+	}
+
+	objectMetas, err := s.ListPath(msg.Prefix, false, nil)
+	if err != nil {
+		_ = ycl.ReplyError(fmt.Errorf("could not list objects: %s", err), "failed to complete request")
+		return err
+	}
+
+	ylogger.Zero.Info().
+		Str("prefix", msg.Prefix).
+		Int("objects", len(objectMetas)).
+		Bool("confirm", msg.Confirm).
+		Bool("reencrypt", msg.Reencrypt).
+		Msg("rotating object keys")
+
+	concurrency := max(config.InstanceConfig().StorageCnf.CopyStorageConcurrency, 1)
+	sem := semaphore.NewWeighted(concurrency)
+	wg := sync.WaitGroup{}
+
+	var mu sync.Mutex
+	var writeErr error
+	counts := map[message.RotateStatus]int{}
+
+	/* leftovers of interrupted rotation are removed before new ones may appear */
+	objects := objectMetas[:0]
+	for _, obj := range objectMetas {
+		if !strings.HasSuffix(obj.Path, rotateTmpSuffix) {
+			objects = append(objects, obj)
+			continue
+		}
+		if !msg.Confirm {
+			continue
+		}
+		if err := s.DeleteObject(s.DefaultBucket(), obj.Path); err != nil {
+			_ = ycl.ReplyError(err, "failed to delete leftover of interrupted rotation")
+			return err
+		}
+		ylogger.Zero.Info().Str("path", obj.Path).Msg("deleted leftover of interrupted rotation")
+	}
+
+	for _, obj := range objects {
+
+		_ = sem.Acquire(context.TODO(), 1)
+		wg.Add(1)
+
+		go func(path string) {
+			defer sem.Release(1)
+			defer wg.Done()
+
+			status, err := kr.RotateObject(path)
+			if err != nil {
+				ylogger.Zero.Error().Err(err).Str("path", path).Msg("failed to rotate object key")
+			} else {
+				ylogger.Zero.Debug().Str("path", path).Str("status", status.String()).Msg("rotated object key")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			counts[status]++
+			if writeErr == nil {
+				_, writeErr = ycl.GetRW().Write(message.NewRotateKeysStatusMessage(status, path, err).Encode())
+			}
+		}(obj.Path)
+	}
+	wg.Wait()
+
+	if writeErr != nil {
+		return writeErr
+	}
+
+	ylogger.Zero.Info().
+		Int("rewrapped", counts[message.RotateStatusRewrapped]).
+		Int("reencrypted", counts[message.RotateStatusReencrypted]).
+		Int("skipped", counts[message.RotateStatusSkipped]).
+		Int("failed", counts[message.RotateStatusFailed]).
+		Msg("key rotation finished")
+
+	if counts[message.RotateStatusFailed] > 0 {
+		err := fmt.Errorf("failed to rotate keys of %d objects", counts[message.RotateStatusFailed])
+		_ = ycl.ReplyError(err, "failed objects")
+		return err
+	}
+
+	if !msg.Confirm {
+		ylogger.Zero.Warn().Msg("It was a dry-run, nothing was rotated")
+	} else {
+		/* running config still has old key, which cannot read rotated objects */
+		ylogger.Zero.Warn().Str("new key", msg.NewKeyPath).Msg("rotated objects are readable only after gpg_key_path is switched to new key")
+	}
+
+	if _, err := ycl.GetRW().Write(message.NewReadyForQueryMessage().Encode()); err != nil {
+		_ = ycl.ReplyError(err, "failed to upload")
+		return err
+	}
+	return nil
+}
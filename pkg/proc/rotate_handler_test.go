@@ -0,0 +1,99 @@
+package proc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/checksum"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/proc"
+)
+
+func TestKeyRotatorRotateObject(t *testing.T) {
+	s := newTestStorage(t)
+
+	oldKEK := newGPGCrypter(t, "../../test/regress/gpg/gpg_1.priv")
+	newKEK := newGPGCrypter(t, "../../test/regress/gpg/gpg_2.priv")
+
+	kr := &proc.KeyRotator{
+		StorageInterractor: s,
+		OldCrypter:         crypt.NewEnvelopeCrypter(oldKEK),
+		NewCrypter:         crypt.NewEnvelopeCrypter(newKEK),
+		Confirm:            true,
+		Ycl:                newProcConnTestClient(nil),
+	}
+
+	envelopeData := bytes.Repeat([]byte("envelope "), 10000)
+	singleKeyData := []byte("single key object")
+	putEncrypted(t, s, kr.OldCrypter, "/seg/envelope", envelopeData)
+	putEncrypted(t, s, oldKEK, "/seg/single", singleKeyData)
+
+	/* rotation keeps decrypted content, so its checksum stays valid */
+	sum := checksum.Stored{Sum: checksum.Sum{Algorithm: checksum.CRC32C, Value: []byte{1, 2, 3, 4}}, Encrypted: true}
+	require.NoError(t, checksum.Put(s, "/seg/single", sum, nil))
+
+	status, err := kr.RotateObject("/seg/envelope")
+	require.NoError(t, err)
+	require.Equal(t, message.RotateStatusRewrapped, status)
+
+	status, err = kr.RotateObject("/seg/single")
+	require.NoError(t, err)
+	require.Equal(t, message.RotateStatusReencrypted, status)
+
+	require.Equal(t, envelopeData, readDecrypted(t, s, kr.NewCrypter, "/seg/envelope"))
+	require.Equal(t, singleKeyData, readDecrypted(t, s, kr.NewCrypter, "/seg/single"))
+
+	stored, err := checksum.Get(s, "/seg/single", nil)
+	require.NoError(t, err)
+	require.Equal(t, sum, stored)
+
+	/* second run after crash or restart does nothing */
+	for _, name := range []string{"/seg/envelope", "/seg/single"} {
+		status, err = kr.RotateObject(name)
+		require.NoError(t, err)
+		require.Equal(t, message.RotateStatusSkipped, status)
+	}
+
+	/* unencrypted objects have no key to rotate */
+	putRaw(t, s, "/plain/raw", []byte("unencrypted object"))
+	putRaw(t, s, "/plain/empty", nil)
+	putEncrypted(t, s, crypt.PassthroughCrypter{}, "/plain/passthrough", []byte("passthrough object"))
+	for _, name := range []string{"/plain/raw", "/plain/empty", "/plain/passthrough"} {
+		status, err = kr.RotateObject(name)
+		require.NoError(t, err, name)
+		require.Equal(t, message.RotateStatusSkipped, status, name)
+	}
+
+	objects, err := s.ListPath("/seg", false, nil)
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+}
+
+func TestProcessRotateKeysRegistry(t *testing.T) {
+	s := newTestStorage(t)
+
+	reg, err := crypt.NewRegistry(&config.Crypto{GPGKeyPath: "../../test/regress/gpg/gpg_1.priv", UseKEK: true})
+	require.NoError(t, err)
+	newKEK := newGPGCrypter(t, "../../test/regress/gpg/gpg_2.priv")
+
+	data := bytes.Repeat([]byte("registry "), 1000)
+	putEncrypted(t, s, reg, "/seg/obj", data)
+	/* leftover of rotation interrupted before move */
+	require.NoError(t, s.PutFileToDest("/seg/obj.rotate", bytes.NewReader([]byte("partial")), nil))
+
+	err = (&proc.ProtoMgrImpl{}).ProcessRotateKeys(message.RotateKeysMessage{
+		Prefix:     "/seg",
+		NewKeyPath: "../../test/regress/gpg/gpg_2.priv",
+		Confirm:    true,
+	}, s, reg, newProcConnTestClient(nil))
+	require.NoError(t, err)
+
+	require.Equal(t, data, readDecrypted(t, s, crypt.NewEnvelopeCrypter(newKEK), "/seg/obj"))
+
+	objects, err := s.ListPath("/seg", false, nil)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+}
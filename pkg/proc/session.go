@@ -0,0 +1,128 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/client"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	pio "github.com/yezzey-gp/yproxy/pkg/io"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/proto"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+/* keep framed chunks well below max packet size of reader */
+const sessionCopyDataSize = 1 << 18
+
+// procSession serves requests on one connection until TERMINATE or client
+// disconnect. Every served request ends with ReadyForQuery. Failed request is
+// answered with Error instead and ends session, so requests pipelined after
+// it are not served.
+func procSession(
+	m proto.ProtoMgr,
+	s storage.StorageInteractor,
+	bs storage.StorageInteractor,
+	cr crypt.Crypter,
+	ycl client.YproxyClient,
+	cnf *config.Vacuum,
+	pr *pio.ProtoReader) error {
+
+	ylogger.Zero.Debug().Uint("client id", ycl.ID()).Msg("session started")
+
+	if _, err := ycl.GetRW().Write(message.NewReadyForQueryMessage().Encode()); err != nil {
+		return err
+	}
+
+	for {
+		tp, body, err := pr.ReadPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				ylogger.Zero.Debug().Uint("client id", ycl.ID()).Msg("session closed by client")
+				return nil
+			}
+			_ = ycl.ReplyError(err, "failed to read request packet")
+			return err
+		}
+
+		switch tp {
+		case message.MessageTypeTerminate:
+			ylogger.Zero.Debug().Uint("client id", ycl.ID()).Msg("session terminated")
+			return nil
+		case message.MessageTypeStartup, message.MessageTypeSession, message.MessageTypeGool:
+			err := fmt.Errorf("request %s is not allowed in session", tp.String())
+			_ = ycl.ReplyError(err, "failed to serve session request")
+			return err
+		}
+
+		if err := serveSessionRequest(m, s, bs, cr, ycl, cnf, pr, tp, body); err != nil {
+			return err
+		}
+		ycl.ResetRequest()
+	}
+}
+
+func serveSessionRequest(
+	m proto.ProtoMgr,
+	s storage.StorageInteractor,
+	bs storage.StorageInteractor,
+	cr crypt.Crypter,
+	ycl client.YproxyClient,
+	cnf *config.Vacuum,
+	pr *pio.ProtoReader,
+	tp message.MessageType,
+	body []byte) error {
+
+	switch tp {
+	case message.MessageTypeCat, message.MessageTypeCatV2:
+		/* raw content has no end but connection close, frame it */
+		fcl := &framedClient{
+			YproxyClient: ycl,
+			rw:           &copyDataWriter{ReadWriteCloser: ycl.GetRW()},
+		}
+		if err := procRequest(m, s, bs, cr, fcl, cnf, pr, tp, body); err != nil {
+			return err
+		}
+	case message.MessageCollectObsolete, message.MessageDeleteObsolete:
+		if err := procRequest(m, s, bs, cr, ycl, cnf, pr, tp, body); err != nil {
+			return err
+		}
+	default:
+		/* handler replies ReadyForQuery itself */
+		return procRequest(m, s, bs, cr, ycl, cnf, pr, tp, body)
+	}
+
+	_, err := ycl.GetRW().Write(message.NewReadyForQueryMessage().Encode())
+	return err
+}
+
+// framedClient sends content written by request handler in CopyData
+// messages, error replies go unframed.
+type framedClient struct {
+	client.YproxyClient
+	rw io.ReadWriteCloser
+}
+
+func (c *framedClient) GetRW() io.ReadWriteCloser {
+	return c.rw
+}
+
+type copyDataWriter struct {
+	io.ReadWriteCloser
+}
+
+func (w *copyDataWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := min(len(p)-written, sessionCopyDataSize)
+		msg := &message.CopyDataMessage{Sz: uint64(n), Data: p[written : written+n]}
+		if _, err := w.ReadWriteCloser.Write(msg.Encode()); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
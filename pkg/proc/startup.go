@@ -0,0 +1,49 @@
+package proc
+
+import (
+	"fmt"
+
+	"github.com/yezzey-gp/yproxy/pkg"
+	"github.com/yezzey-gp/yproxy/pkg/client"
+	"github.com/yezzey-gp/yproxy/pkg/crypt"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+// ServerCapabilities returns protocol features served by yproxy with given
+// crypter.
+func ServerCapabilities(cr crypt.Crypter) message.Capability {
+	caps := message.CapabilityServerSideCopy | message.CapabilityPatch | message.CapabilitySession | message.CapabilityCompression
+	if cr != nil {
+		caps |= message.CapabilityKEK
+	}
+	return caps
+}
+
+// procStartup negotiates protocol version and answers with server info,
+// client requiring capabilities server lacks is rejected.
+func procStartup(cr crypt.Crypter, ycl client.YproxyClient, body []byte) error {
+	ycl.SetOPType(message.MessageTypeStartup)
+
+	msg := message.StartupMessage{}
+	msg.Decode(body)
+
+	if msg.ProtocolVersion < message.MinProtocolVersion {
+		err := fmt.Errorf("protocol version %d is not supported, minimum is %d", msg.ProtocolVersion, message.MinProtocolVersion)
+		_ = ycl.ReplyError(err, "failed to negotiate protocol")
+		return err
+	}
+
+	caps := ServerCapabilities(cr)
+	if missing := msg.Required &^ caps; missing != 0 {
+		err := fmt.Errorf("required capabilities are not supported: %#x (%s)", uint64(missing), missing)
+		_ = ycl.ReplyError(err, "failed to negotiate protocol")
+		return err
+	}
+
+	version := min(msg.ProtocolVersion, message.ProtocolVersion)
+	ylogger.Zero.Debug().Uint32("client version", msg.ProtocolVersion).Uint32("version", version).Str("capabilities", caps.String()).Msg("protocol negotiated")
+
+	_, err := ycl.GetRW().Write(message.NewServerInfoMessage(version, caps, pkg.YproxyVersionRevision).Encode())
+	return err
+}
@@ -93,14 +93,22 @@ func NewWriter(writer io.WriteCloser, limiter *rate.Limiter) *Writer {
 }
 
 func (w *Writer) Wait(n int) error {
+	if w.limiter == nil {
+		return nil
+	}
 	start := time.Now()
 	err := w.limiter.WaitN(w.ctx, n)
-	waitTime := time.Since(start).Nanoseconds()
-	metrics.StoreLatencyAndSizeInfo("LIMIT_WRITE", float64(n), float64(waitTime))
+	waitTime := time.Since(start)
+	metrics.StoreLatencyAndSizeInfo("LIMIT_WRITE", float64(n), float64(waitTime.Nanoseconds()))
+	metrics.RateLimitWriteWaitSeconds.Add(waitTime.Seconds())
+	metrics.RateLimitWriteBytes.Add(float64(n))
 	return err
 }
 
 func (w *Writer) getBurstableLimit(n int) int {
+	if w.limiter == nil {
+		return n
+	}
 	return min(w.limiter.Burst(), n)
 }
 
@@ -109,21 +117,33 @@ func (r *Writer) Write(buf []byte) (int, error) {
 		return 0, fmt.Errorf("empty buffer passed")
 	}
 
-	end := r.getBurstableLimit(len(buf))
-	// in a case of write we should wait before handling query
-	limiterErr := r.Wait(end)
-	if limiterErr != nil {
-		ylogger.Zero.Error().Err(limiterErr).Msg("Error happened while limiting")
+	written := 0
+	/* limiter does not allow to wait for more than burst, write by parts */
+	for written < len(buf) {
+		end := written + r.getBurstableLimit(len(buf)-written)
+		// in a case of write we should wait before handling query
+		limiterErr := r.Wait(end - written)
+		if limiterErr != nil {
+			ylogger.Zero.Error().Err(limiterErr).Msg("Error happened while limiting")
+		}
+		n, err := r.writer.Write(buf[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
 	}
-	n, err := r.writer.Write(buf[:end])
 
-	return n, err
+	return written, nil
 }
 
 var (
 	/* Single limiter for all external storage interaction */
 	mu         sync.Mutex
 	netLimiter *rate.Limiter = nil
+
+	/* Uploads are limited separately, so they do not take budget of reads */
+	writeMu         sync.Mutex
+	netWriteLimiter *rate.Limiter = nil
 )
 
 func GetLimiter() *rate.Limiter {
@@ -143,3 +163,38 @@ func GetLimiter() *rate.Limiter {
 
 	return netLimiter
 }
+
+// GetWriteLimiter returns limiter of bytes uploaded to external storage.
+// Its rate is storage_write_rate_limit, or storage_rate_limit when unset.
+func GetWriteLimiter() *rate.Limiter {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	if netWriteLimiter != nil {
+		return netWriteLimiter
+	}
+
+	cnf := config.InstanceConfig().StorageCnf
+	netLimit := cnf.StorageWriteRateLimit
+	if netLimit == 0 {
+		netLimit = cnf.StorageRateLimit
+	}
+	ylogger.Zero.Debug().Uint64("bytes per sec", netLimit).Msg("allocate write limiter")
+
+	netWriteLimiter = rate.NewLimiter(rate.Limit(netLimit),
+		int(netLimit))
+
+	return netWriteLimiter
+}
+
+// ResetLimiter drops shared limiters, so they are allocated with current rate
+// limits on next use. Readers and writers created before keep old limiters.
+func ResetLimiter() {
+	mu.Lock()
+	netLimiter = nil
+	mu.Unlock()
+
+	writeMu.Lock()
+	netWriteLimiter = nil
+	writeMu.Unlock()
+}
@@ -26,6 +26,9 @@ type YRestartReader struct {
 	s          storage.StorageInteractor
 	name       string
 	settings   []settings.StorageSettings
+
+	/* set when object is read by parallel ranges */
+	ranged storage.RangeRetrier
 }
 
 // Close implements RestartReader.
@@ -72,6 +75,7 @@ func (y *YRestartReader) Restart(offsetStart int64) error {
 	if err != nil {
 		return err
 	}
+	y.ranged, _ = r.(storage.RangeRetrier)
 
 	/* with limiter ? */
 
@@ -84,6 +88,16 @@ func (y *YRestartReader) Restart(offsetStart int64) error {
 	return nil
 }
 
+// RetryRange implements storage.RangeRetrier.
+func (y *YRestartReader) RetryRange(offset int64) error {
+	if y.ranged == nil {
+		return fmt.Errorf("object is not read by ranges")
+	}
+	return y.ranged.RetryRange(offset)
+}
+
+var _ storage.RangeRetrier = &YRestartReader{}
+
 type YproxyRetryReader struct {
 	io.ReadCloser
 	underlying RestartReader
@@ -145,6 +159,14 @@ func (y *YproxyRetryReader) Read(p []byte) (int, error) {
 				y.offsetReached += int64(n)
 			}
 
+			/* object read by ranges refetches failed range only */
+			if rr, ok := y.underlying.(storage.RangeRetrier); ok {
+				if err := rr.RetryRange(y.offsetReached); err == nil {
+					ylogger.Zero.Warn().Int64("offset reached", y.offsetReached).Msg("retrying failed range")
+					continue
+				}
+			}
+
 			// what if close failed?
 			_ = y.underlying.Close()
 
@@ -181,4 +203,15 @@ func NewYRetryReader(r RestartReader, selfCl client.YproxyClient) io.ReadCloser
 	}
 }
 
+// NewYRetryReaderAt returns retry reader which starts reading object at offset.
+func NewYRetryReaderAt(r RestartReader, selfCl client.YproxyClient, offset int64) io.ReadCloser {
+	return &YproxyRetryReader{
+		underlying:    r,
+		retryLimit:    defaultRetryLimit,
+		selfCl:        selfCl,
+		offsetReached: offset,
+		needReacquire: true, /* do initial storage request */
+	}
+}
+
 var _ io.ReadCloser = &YproxyRetryReader{}
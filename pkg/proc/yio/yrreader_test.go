@@ -135,3 +135,37 @@ func TestYproxyRetryReaderSimpleReadRetry(t *testing.T) {
 
 	assert.Nil(t, yr.Close())
 }
+
+// rangeRestartReader is restart reader of object read by ranges.
+type rangeRestartReader struct {
+	*mockio.MockRestartReader
+	retried []int64
+}
+
+func (r *rangeRestartReader) RetryRange(offset int64) error {
+	r.retried = append(r.retried, offset)
+	return nil
+}
+
+func TestYproxyRetryReaderRetryRange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	rr := &rangeRestartReader{MockRestartReader: mockio.NewMockRestartReader(ctrl)}
+	ycl := mockcl.NewMockYproxyClient(ctrl)
+	ycl.EXPECT().SetByteOffset(int64(3)).Times(1)
+
+	yr := yio.NewYRetryReader(rr, ycl)
+
+	/* failed range is refetched, reader is not restarted */
+	rr.EXPECT().Restart(int64(0)).Return(nil).Times(1)
+	rr.EXPECT().Read(gomock.Any()).Return(0, fmt.Errorf("range failed"))
+	rr.EXPECT().Read(gomock.Any()).Return(3, nil)
+	rr.EXPECT().Close().Times(1)
+
+	n, err := yr.Read(make([]byte, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []int64{0}, rr.retried)
+
+	assert.Nil(t, yr.Close())
+}
@@ -57,7 +57,7 @@ func NewYproxyWriter(under io.WriteCloser, selfCl client.YproxyClient) io.WriteC
 	/* with limiter ? */
 
 	if config.InstanceConfig().StorageCnf.EnableRateLimiter {
-		w.lim = limiter.GetLimiter()
+		w.lim = limiter.GetWriteLimiter()
 		w.underlying = limiter.NewWriter(under, w.lim)
 	} else {
 		w.underlying = under
@@ -66,4 +66,13 @@ func NewYproxyWriter(under io.WriteCloser, selfCl client.YproxyClient) io.WriteC
 	return w
 }
 
+// NewUploadWriter limits data written to under, which is uploaded to
+// storage, by write rate limiter when it is enabled.
+func NewUploadWriter(under io.WriteCloser) io.WriteCloser {
+	if !config.InstanceConfig().StorageCnf.EnableRateLimiter {
+		return under
+	}
+	return limiter.NewWriter(under, limiter.GetWriteLimiter())
+}
+
 var _ io.WriteCloser = &YproxyWriter{}
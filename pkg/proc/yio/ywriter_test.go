@@ -1 +1,59 @@
 package yio_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/proc/yio"
+	"github.com/yezzey-gp/yproxy/pkg/proc/yio/limiter"
+)
+
+type bufCloser struct {
+	bytes.Buffer
+}
+
+func (*bufCloser) Close() error {
+	return nil
+}
+
+func TestUploadWriterLimited(t *testing.T) {
+	cfg := config.BuildInstance()
+	cfg.StorageCnf.EnableRateLimiter = true
+	cfg.StorageCnf.StorageRateLimit = 1 << 30
+	cfg.StorageCnf.StorageWriteRateLimit = 1024
+	config.SetInstanceConfig(cfg)
+	limiter.ResetLimiter()
+	t.Cleanup(func() {
+		config.SetInstanceConfig(config.BuildInstance())
+		limiter.ResetLimiter()
+	})
+
+	sent := testutil.ToFloat64(metrics.RateLimitWriteBytes)
+
+	var buf bufCloser
+	w := yio.NewUploadWriter(&buf)
+	start := time.Now()
+	/* write above burst is not cut short */
+	n, err := w.Write(make([]byte, 2048))
+	require.NoError(t, err)
+	assert.Equal(t, 2048, n)
+	assert.Equal(t, 2048, buf.Len())
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	assert.Equal(t, sent+2048, testutil.ToFloat64(metrics.RateLimitWriteBytes))
+
+	/* reads keep their own budget */
+	assert.NotSame(t, limiter.GetLimiter(), limiter.GetWriteLimiter())
+	assert.Equal(t, 1<<30, limiter.GetLimiter().Burst())
+}
+
+func TestUploadWriterDisabled(t *testing.T) {
+	var buf bufCloser
+	w := yio.NewUploadWriter(&buf)
+	assert.Same(t, &buf, w)
+}
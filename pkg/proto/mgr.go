@@ -32,6 +32,14 @@ type ProtoMgr interface {
 		ycl client.YproxyClient,
 		replyKV bool) error
 
+	ProcessPatchExtended(
+		s storage.StorageInteractor,
+		pr *pio.ProtoReader,
+		name string,
+		offset uint64,
+		encrypt bool,
+		ycl client.YproxyClient) error
+
 	ProcessListExtended(prefix string,
 		settings []settings.StorageSettings,
 		s storage.StorageInteractor,
@@ -48,7 +56,10 @@ type ProtoMgr interface {
 		decrypt,
 		kEKDecrypt,
 		serverSide,
+		resume,
+		verify,
 		replyKV bool,
+		settings []settings.StorageSettings,
 		s storage.StorageInteractor,
 		cr crypt.Crypter,
 		ycl client.YproxyClient) error
@@ -83,4 +94,10 @@ type ProtoMgr interface {
 		s storage.StorageInteractor,
 		bs storage.StorageInteractor,
 		ycl client.YproxyClient) error
+
+	ProcessRotateKeys(
+		msg message.RotateKeysMessage,
+		s storage.StorageInteractor,
+		cr crypt.Crypter,
+		ycl client.YproxyClient) error
 }
@@ -0,0 +1,124 @@
+package qos
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+// Class is a budget shared by all requests served in it.
+type Class struct {
+	name string
+	lim  *rate.Limiter
+	sem  *semaphore.Weighted
+}
+
+func newClass(name string, cnf config.QoSClass) *Class {
+	c := &Class{name: name}
+	if cnf.RateLimit != 0 {
+		c.lim = rate.NewLimiter(rate.Limit(cnf.RateLimit), int(cnf.RateLimit))
+	}
+	if cnf.Concurrency != 0 {
+		c.sem = semaphore.NewWeighted(cnf.Concurrency)
+	}
+	return c
+}
+
+func (c *Class) Name() string {
+	return c.name
+}
+
+// Acquire waits until request may be served in class. Returned function
+// must be called when request is done.
+func (c *Class) Acquire(ctx context.Context) (func(), error) {
+	metrics.QoSRequests.WithLabelValues(c.name).Inc()
+	if c.sem != nil {
+		start := time.Now()
+		if err := c.sem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		metrics.QoSWaitSeconds.WithLabelValues(c.name).Add(time.Since(start).Seconds())
+	}
+	metrics.QoSInFlight.WithLabelValues(c.name).Inc()
+
+	return func() {
+		metrics.QoSInFlight.WithLabelValues(c.name).Dec()
+		if c.sem != nil {
+			c.sem.Release(1)
+		}
+	}, nil
+}
+
+// wait accounts n bytes transferred by request of class, throttling it
+// when class rate limit is exceeded.
+func (c *Class) wait(n int, direction string) error {
+	metrics.QoSBytes.WithLabelValues(c.name, direction).Add(float64(n))
+	if c.lim == nil || n == 0 {
+		return nil
+	}
+	start := time.Now()
+	/* limiter rejects waits above burst, account big reads by parts */
+	for n > 0 {
+		part := min(n, c.lim.Burst())
+		if err := c.lim.WaitN(context.Background(), part); err != nil {
+			return err
+		}
+		n -= part
+	}
+	metrics.QoSThrottleSeconds.WithLabelValues(c.name).Add(time.Since(start).Seconds())
+	return nil
+}
+
+type reader struct {
+	io.Reader
+	class     *Class
+	direction string
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if werr := r.class.wait(n, r.direction); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+type readCloser struct {
+	reader
+	io.Closer
+}
+
+type readSeeker struct {
+	reader
+	io.Seeker
+}
+
+type rangeReadCloser struct {
+	*readCloser
+	storage.RangeRetrier
+}
+
+// Reader limits bytes of object read from storage by class rate. Readers
+// of parallel ranges stay so.
+func (c *Class) Reader(rc io.ReadCloser) io.ReadCloser {
+	r := &readCloser{reader{rc, c, "read"}, rc}
+	if rr, ok := rc.(storage.RangeRetrier); ok {
+		return &rangeReadCloser{r, rr}
+	}
+	return r
+}
+
+// WriteSource limits bytes of object written to storage by class rate.
+func (c *Class) WriteSource(r io.Reader) io.Reader {
+	return &reader{r, c, "write"}
+}
+
+func (c *Class) writeSeekSource(r io.ReadSeeker) io.ReadSeeker {
+	return &readSeeker{reader{r, c, "write"}, r}
+}
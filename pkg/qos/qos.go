@@ -0,0 +1,123 @@
+package qos
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync/atomic"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+/* maintenance requests, they must not starve reads and writes of database */
+var backgroundMessages = []message.MessageType{
+	message.MessageTypeCopy,
+	message.MessageTypeCopyV2,
+	message.MessageTypeDelete,
+	message.MessageTypeDelete2,
+	message.MessageTypeUntrashify,
+	message.MessageCollectObsolete,
+	message.MessageDeleteObsolete,
+	message.MessageTypeRotateKeys,
+}
+
+var requestMessages = append([]message.MessageType{
+	message.MessageTypeCat,
+	message.MessageTypeCatV2,
+	message.MessageTypePut,
+	message.MessageTypePutV2,
+	message.MessageTypePutV3,
+	message.MessageTypePatch,
+	message.MessageTypeList,
+	message.MessageTypeListV2,
+	message.MessageTypeGool,
+}, backgroundMessages...)
+
+// Manager selects class serving request.
+type Manager struct {
+	classes        map[string]*Class
+	messageClasses map[message.MessageType]*Class
+}
+
+// NewManager builds classes of cnf. Foreground and background classes
+// always exist and are unlimited unless configured.
+func NewManager(cnf *config.QoS) (*Manager, error) {
+	m := &Manager{
+		classes: map[string]*Class{
+			config.QoSClassForeground: newClass(config.QoSClassForeground, config.QoSClass{}),
+			config.QoSClassBackground: newClass(config.QoSClassBackground, config.QoSClass{}),
+		},
+		messageClasses: map[message.MessageType]*Class{},
+	}
+	for name, c := range cnf.Classes {
+		if c.Concurrency < 0 {
+			return nil, fmt.Errorf("qos class %q: concurrency must not be negative", name)
+		}
+		m.classes[name] = newClass(name, c)
+	}
+	for _, tp := range backgroundMessages {
+		m.messageClasses[tp] = m.classes[config.QoSClassBackground]
+	}
+
+	byName := map[string]message.MessageType{}
+	for _, tp := range requestMessages {
+		byName[tp.String()] = tp
+	}
+	for _, name := range slices.Sorted(maps.Keys(cnf.MessageClasses)) {
+		tp, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("qos: unknown message type %q", name)
+		}
+		c, ok := m.classes[cnf.MessageClasses[name]]
+		if !ok {
+			return nil, fmt.Errorf("qos: message type %q: class %q is not configured", name, cnf.MessageClasses[name])
+		}
+		m.messageClasses[tp] = c
+	}
+	return m, nil
+}
+
+// Class returns class by name.
+func (m *Manager) Class(name string) (*Class, bool) {
+	c, ok := m.classes[name]
+	return c, ok
+}
+
+// Select returns class of request. Class named in request settings wins
+// over one configured for message type.
+func (m *Manager) Select(tp message.MessageType, setts []settings.StorageSettings) *Class {
+	for _, s := range setts {
+		if s.Name != message.QoSClassSetting {
+			continue
+		}
+		if c, ok := m.classes[s.Value]; ok {
+			return c
+		}
+		ylogger.Zero.Warn().Str("class", s.Value).Str("msg-type", tp.String()).Msg("unknown qos class requested")
+	}
+	if c, ok := m.messageClasses[tp]; ok {
+		return c
+	}
+	return m.classes[config.QoSClassForeground]
+}
+
+var current atomic.Pointer[Manager]
+
+func init() {
+	m, _ := NewManager(&config.QoS{})
+	current.Store(m)
+}
+
+// Current returns manager used for new requests.
+func Current() *Manager {
+	return current.Load()
+}
+
+// SetManager installs manager used for new requests. Requests in flight
+// keep classes they were served in.
+func SetManager(m *Manager) {
+	current.Store(m)
+}
@@ -0,0 +1,109 @@
+package qos_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	mock "github.com/yezzey-gp/yproxy/pkg/mock"
+	"github.com/yezzey-gp/yproxy/pkg/qos"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"go.uber.org/mock/gomock"
+)
+
+func TestSelect(t *testing.T) {
+	m, err := qos.NewManager(&config.QoS{
+		Classes:        map[string]config.QoSClass{"batch": {Concurrency: 1}},
+		MessageClasses: map[string]string{"LISTV2": "batch", "DELETE2": config.QoSClassForeground},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, config.QoSClassForeground, m.Select(message.MessageTypeCatV2, nil).Name())
+	assert.Equal(t, config.QoSClassBackground, m.Select(message.MessageTypeCopyV2, nil).Name())
+	assert.Equal(t, config.QoSClassForeground, m.Select(message.MessageTypeDelete2, nil).Name())
+	assert.Equal(t, "batch", m.Select(message.MessageTypeListV2, nil).Name())
+
+	/* request hint wins over message type */
+	hint := []settings.StorageSettings{{Name: message.QoSClassSetting, Value: "batch"}}
+	assert.Equal(t, "batch", m.Select(message.MessageTypePutV3, hint).Name())
+	unknown := []settings.StorageSettings{{Name: message.QoSClassSetting, Value: "urgent"}}
+	assert.Equal(t, config.QoSClassBackground, m.Select(message.MessageTypeCopyV2, unknown).Name())
+
+	_, err = qos.NewManager(&config.QoS{MessageClasses: map[string]string{"CATV3": config.QoSClassBackground}})
+	assert.ErrorContains(t, err, "CATV3")
+	_, err = qos.NewManager(&config.QoS{MessageClasses: map[string]string{"CAT": "batch"}})
+	assert.ErrorContains(t, err, "batch")
+}
+
+func TestAcquireConcurrency(t *testing.T) {
+	m, err := qos.NewManager(&config.QoS{Classes: map[string]config.QoSClass{
+		config.QoSClassBackground: {Concurrency: 1},
+	}})
+	require.NoError(t, err)
+	bg := m.Select(message.MessageTypeCopyV2, nil)
+	fg := m.Select(message.MessageTypeCatV2, nil)
+
+	release, err := bg.Acquire(context.Background())
+	require.NoError(t, err)
+
+	/* background class is full, foreground is not affected */
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = bg.Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	releaseFg, err := fg.Acquire(context.Background())
+	require.NoError(t, err)
+	releaseFg()
+
+	release()
+	release, err = bg.Acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
+
+func TestWrapStorage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	m, err := qos.NewManager(&config.QoS{Classes: map[string]config.QoSClass{
+		"slow": {RateLimit: 1024},
+	}})
+	require.NoError(t, err)
+	class, ok := m.Class("slow")
+	require.True(t, ok)
+
+	s := mock.NewMockStorageInteractor(ctrl)
+	s.EXPECT().CatFileFromStorage("obj", int64(0), nil).Return(io.NopCloser(bytes.NewReader(make([]byte, 2048))), nil)
+	s.EXPECT().PutFileToDest("obj", gomock.Any(), nil).DoAndReturn(func(_ string, r io.Reader, _ []settings.StorageSettings) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+
+	read := testutil.ToFloat64(metrics.QoSBytes.WithLabelValues("slow", "read"))
+	written := testutil.ToFloat64(metrics.QoSBytes.WithLabelValues("slow", "write"))
+
+	ws := class.WrapStorage(s)
+	start := time.Now()
+	rc, err := ws.CatFileFromStorage("obj", 0, nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Len(t, data, 2048)
+	/* burst covers first second, rest is throttled */
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+
+	require.NoError(t, ws.PutFileToDest("obj", bytes.NewReader(make([]byte, 100)), nil))
+
+	assert.Equal(t, read+2048, testutil.ToFloat64(metrics.QoSBytes.WithLabelValues("slow", "read")))
+	assert.Equal(t, written+100, testutil.ToFloat64(metrics.QoSBytes.WithLabelValues("slow", "write")))
+
+	assert.Nil(t, class.WrapStorage(nil))
+}
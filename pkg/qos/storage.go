@@ -0,0 +1,53 @@
+package qos
+
+import (
+	"io"
+
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+// classStorage accounts object data transferred by requests of class.
+type classStorage struct {
+	storage.StorageInteractor
+	class *Class
+}
+
+func (s *classStorage) CatFileFromStorage(name string, offset int64, setts []settings.StorageSettings) (io.ReadCloser, error) {
+	rc, err := s.StorageInteractor.CatFileFromStorage(name, offset, setts)
+	if err != nil {
+		return nil, err
+	}
+	return s.class.Reader(rc), nil
+}
+
+func (s *classStorage) PutFileToDest(name string, r io.Reader, setts []settings.StorageSettings) error {
+	return s.StorageInteractor.PutFileToDest(name, s.class.WriteSource(r), setts)
+}
+
+func (s *classStorage) PatchFile(name string, r io.ReadSeeker, startOffset int64) error {
+	return s.StorageInteractor.PatchFile(name, s.class.writeSeekSource(r), startOffset)
+}
+
+type classStaterStorage struct {
+	*classStorage
+	stater storage.StorageStater
+}
+
+func (s *classStaterStorage) StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	return s.stater.StatObject(name, setts)
+}
+
+// WrapStorage returns storage serving requests of class. Storages able
+// to stat objects stay so.
+func (c *Class) WrapStorage(s storage.StorageInteractor) storage.StorageInteractor {
+	if s == nil {
+		return nil
+	}
+	cs := &classStorage{StorageInteractor: s, class: c}
+	if st, ok := s.(storage.StorageStater); ok {
+		return &classStaterStorage{classStorage: cs, stater: st}
+	}
+	return cs
+}
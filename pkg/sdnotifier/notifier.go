@@ -39,7 +39,12 @@ func NewNotifier(ns string, debug bool) (*Notifier, error) {
 }
 
 func (n *Notifier) Reloading() error {
-	return n.send([]byte(fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d\n", uint64(C.get_nsecs()))))
+	return n.send([]byte(fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d\n", uint64(C.get_nsecs())/1000)))
+}
+
+// Status reports free-form service status shown by systemctl status.
+func (n *Notifier) Status(status string) error {
+	return n.send([]byte(fmt.Sprintf("STATUS=%s\n", status)))
 }
 
 func (n *Notifier) Ready() error {
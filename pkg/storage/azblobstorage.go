@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/tablespace"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+/* interval of polling for asynchronous copy-blob completion */
+const azCopyPollInterval = 500 * time.Millisecond
+
+// AzBlobStorageInteractor stores objects as block blobs. Buckets of
+// the rest of yproxy are containers of one storage account.
+type AzBlobStorageInteractor struct {
+	client *azblob.Client
+
+	cnf *config.Storage
+
+	TSToBucketMap map[string]string
+}
+
+var _ StorageInteractor = &AzBlobStorageInteractor{}
+
+func newAzBlobClient(cnf *config.Storage) (*azblob.Client, error) {
+	if cnf.AzureConnectionString != "" {
+		return azblob.NewClientFromConnectionString(cnf.AzureConnectionString, nil)
+	}
+
+	serviceURL := cnf.StorageEndpoint
+	if serviceURL == "" {
+		if cnf.AzureAccountName == "" {
+			return nil, fmt.Errorf("azure_account_name or storage_endpoint must be configured for azblob storage")
+		}
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", cnf.AzureAccountName)
+	}
+
+	if cnf.AzureAccountKey != "" {
+		cred, err := azblob.NewSharedKeyCredential(cnf.AzureAccountName, cnf.AzureAccountKey)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+	}
+	if cnf.AzureSASToken != "" {
+		return azblob.NewClientWithNoCredential(serviceURL+"?"+strings.TrimPrefix(cnf.AzureSASToken, "?"), nil)
+	}
+	return nil, fmt.Errorf("no credentials configured for azblob storage; set azure_connection_string, azure_account_key or azure_sas_token")
+}
+
+func NewAzBlobStorageInteractor(cnf *config.Storage) (*AzBlobStorageInteractor, error) {
+	client, err := newAzBlobClient(cnf)
+	if err != nil {
+		return nil, err
+	}
+	return &AzBlobStorageInteractor{
+		client:        client,
+		cnf:           cnf,
+		TSToBucketMap: buildBucketMapFromCnf(cnf),
+	}, nil
+}
+
+// ListBuckets implements StorageInteractor.
+func (s *AzBlobStorageInteractor) ListBuckets() []string {
+	keys := []string{}
+
+	for _, v := range s.TSToBucketMap {
+		keys = append(keys, v)
+	}
+	return keys
+}
+
+// DefaultBucket implements StorageInteractor.
+func (s *AzBlobStorageInteractor) DefaultBucket() string {
+	return s.cnf.StorageBucket
+}
+
+func (s *AzBlobStorageInteractor) objectPath(name string) string {
+	return strings.TrimLeft(path.Join(s.cnf.StoragePrefix, name), "/")
+}
+
+func (s *AzBlobStorageInteractor) containerForSettings(setts []settings.StorageSettings) (string, error) {
+	tableSpace := ResolveStorageSetting(setts, message.TableSpaceSetting, tablespace.DefaultTableSpace)
+
+	bucket, ok := s.TSToBucketMap[tableSpace]
+	if !ok {
+		err := fmt.Errorf("failed to match tablespace %s to azure container", tableSpace)
+		ylogger.Zero.Err(err).Str("tablespace", tableSpace).Msg("failed to match tablespace to azure container")
+		return "", err
+	}
+	return bucket, nil
+}
+
+func (s *AzBlobStorageInteractor) blockBlob(bucket, objectPath string) *blockblob.Client {
+	return s.client.ServiceClient().NewContainerClient(bucket).NewBlockBlobClient(objectPath)
+}
+
+func (s *AzBlobStorageInteractor) CatFileFromStorage(name string, offset int64, setts []settings.StorageSettings) (io.ReadCloser, error) {
+	timeStart := time.Now()
+	objectPath := s.objectPath(name)
+
+	bucket, err := s.containerForSettings(setts)
+	if err != nil {
+		return nil, err
+	}
+
+	ylogger.Zero.Debug().Str("key", objectPath).Int64("offset", offset).Str("container", bucket).Msg("requesting external storage")
+
+	resp, err := s.blockBlob(bucket, objectPath).DownloadStream(context.TODO(), &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	getTime := time.Since(timeStart).Nanoseconds()
+	objLen := 1.0
+	if resp.ContentLength != nil {
+		objLen = float64(*resp.ContentLength)
+	}
+	metrics.StoreLatencyAndSizeInfo("AZBLOB_GET", objLen, float64(getTime))
+	return resp.Body, nil
+}
+
+// StatObject implements StorageStater.
+func (s *AzBlobStorageInteractor) StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	bucket, err := s.containerForSettings(setts)
+	if err != nil {
+		return nil, err
+	}
+	props, err := s.blockBlob(bucket, s.objectPath(name)).GetProperties(context.TODO(), nil)
+	if err != nil {
+		return nil, err
+	}
+	info := &object.ObjectInfo{Path: "/" + strings.TrimLeft(name, "/")}
+	if props.ContentLength != nil {
+		info.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		info.LastMod = *props.LastModified
+	}
+	if props.ETag != nil {
+		info.ETag = string(*props.ETag)
+	}
+	return info, nil
+}
+
+func accessTier(storageClass string) *blob.AccessTier {
+	tier := blob.AccessTier(storageClass)
+	if !slices.Contains(blob.PossibleAccessTierValues(), tier) {
+		/* S3 storage classes (e.g. STANDARD) mean default tier of account */
+		return nil
+	}
+	return &tier
+}
+
+func (s *AzBlobStorageInteractor) PutFileToDest(name string, r io.Reader, settings []settings.StorageSettings) error {
+	timeStart := time.Now()
+	objectPath := s.objectPath(name)
+
+	storageClass := ResolveStorageSetting(settings, message.StorageClassSetting, "STANDARD")
+	multipartChunkSizeStr := ResolveStorageSetting(settings, message.MultipartChunkSize, "16777216")
+	multipartChunkSize, err := strconv.ParseInt(multipartChunkSizeStr, 10, 64)
+	if err != nil {
+		return err
+	}
+	multipartUpload, err := strconv.ParseBool(ResolveStorageSetting(settings, message.MultipartUpload, "1"))
+	if err != nil {
+		return err
+	}
+
+	bucket, err := s.containerForSettings(settings)
+	if err != nil {
+		return err
+	}
+	bb := s.blockBlob(bucket, objectPath)
+
+	putLen := int(multipartChunkSize)
+	if multipartUpload {
+		/* blocks are staged one by one and committed when stream ends */
+		_, err = bb.UploadStream(context.TODO(), r, &blockblob.UploadStreamOptions{
+			BlockSize:   multipartChunkSize,
+			Concurrency: 1,
+			AccessTier:  accessTier(storageClass),
+		})
+	} else {
+		var body []byte
+		body, err = io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		putLen = len(body)
+		_, err = bb.Upload(context.TODO(), streaming.NopCloser(bytes.NewReader(body)), &blockblob.UploadOptions{
+			Tier: accessTier(storageClass),
+		})
+	}
+
+	putTime := time.Since(timeStart).Nanoseconds()
+	metrics.StoreLatencyAndSizeInfo("AZBLOB_PUT", float64(putLen), float64(putTime))
+	return err
+}
+
+// PatchFile overwrites object content starting from startOffset. Block blobs
+// cannot be modified in place, so object is re-uploaded with patched range.
+// Upload is committed only if object was not changed meanwhile.
+func (s *AzBlobStorageInteractor) PatchFile(name string, r io.ReadSeeker, startOffset int64) error {
+	objectPath := s.objectPath(name)
+	bb := s.blockBlob(s.cnf.StorageBucket, objectPath)
+
+	patchLen, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := bb.DownloadStream(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.ContentLength == nil || *resp.ContentLength < startOffset {
+		return fmt.Errorf("patch offset %d is beyond end of object %s", startOffset, objectPath)
+	}
+
+	tail := io.MultiReader(
+		/* skip patched range of original content */
+		readerFunc(func(p []byte) (int, error) {
+			if _, err := io.CopyN(io.Discard, resp.Body, patchLen); err != nil && err != io.EOF {
+				return 0, err
+			}
+			return 0, io.EOF
+		}),
+		resp.Body,
+	)
+
+	ylogger.Zero.Debug().Str("key", objectPath).Int64("offset", startOffset).Int64("length", patchLen).Msg("patching azure blob")
+
+	_, err = bb.UploadStream(context.TODO(), io.MultiReader(io.LimitReader(resp.Body, startOffset), r, tail), &blockblob.UploadStreamOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfMatch: resp.ETag,
+			},
+		},
+	})
+	return err
+}
+
+func (s *AzBlobStorageInteractor) ListPath(prefix string, _ bool, settings []settings.StorageSettings) ([]*object.ObjectInfo, error) {
+	bucket, err := s.containerForSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.ListBucketPath(bucket, prefix, false)
+}
+
+func (s *AzBlobStorageInteractor) ListBucketPath(bucket, prefix string, _ bool) ([]*object.ObjectInfo, error) {
+	prefix = strings.TrimLeft(path.Join(s.cnf.StoragePrefix, prefix), "/")
+	metas := make([]*object.ObjectInfo, 0)
+
+	ylogger.Zero.Debug().Str("container", bucket).Str("prefix", prefix).Msg("listing container")
+
+	pager := s.client.NewListBlobsFlatPager(bucket, &container.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+	for pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			ylogger.Zero.Warn().Err(err).Msg("failed to list prefix")
+			return nil, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			cPath, ok := strings.CutPrefix(*item.Name, s.cnf.StoragePrefix)
+			if !ok {
+				ylogger.Zero.Debug().Str("path", *item.Name).Msg("skipping file")
+				continue
+			}
+			if !strings.HasPrefix(cPath, "/") {
+				cPath = "/" + cPath
+			}
+
+			meta := &object.ObjectInfo{
+				Path: cPath,
+			}
+			if props := item.Properties; props != nil {
+				if props.ContentLength != nil {
+					meta.Size = *props.ContentLength
+				}
+				if props.LastModified != nil {
+					meta.LastMod = *props.LastModified
+				}
+				if props.ETag != nil {
+					meta.ETag = string(*props.ETag)
+				}
+			}
+			metas = append(metas, meta)
+		}
+	}
+
+	return metas, nil
+}
+
+// ListFailedMultipartUploads implements StorageInteractor. Uncommitted
+// blocks are not visible as objects and are garbage collected by Azure.
+func (s *AzBlobStorageInteractor) ListFailedMultipartUploads(string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+// AbortMultipartUpload implements StorageInteractor, see ListFailedMultipartUploads.
+func (s *AzBlobStorageInteractor) AbortMultipartUpload(string, string, string) error {
+	return nil
+}
+
+func (s *AzBlobStorageInteractor) DeleteObject(bucket, key string) error {
+	if !strings.HasPrefix(key, s.cnf.StoragePrefix) {
+		key = path.Join(s.cnf.StoragePrefix, key)
+	}
+	key = strings.TrimLeft(key, "/")
+
+	_, err := s.client.DeleteBlob(context.TODO(), bucket, key, &blob.DeleteOptions{
+		DeleteSnapshots: to(blob.DeleteSnapshotsOptionTypeInclude),
+	})
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		ylogger.Zero.Err(err).Msg("failed to delete old object")
+		return err
+	}
+	ylogger.Zero.Debug().Str("container", bucket).Str("path", key).Msg("deleted object")
+	return nil
+}
+
+func to[T any](v T) *T {
+	return &v
+}
+
+// copyBlob performs copy-blob inside the storage account and waits for
+// its completion, as copy may be processed asynchronously.
+func (s *AzBlobStorageInteractor) copyBlob(from, to, fromStorageBucket, toStorageBucket string) error {
+	src := s.client.ServiceClient().NewContainerClient(fromStorageBucket).NewBlobClient(from)
+	dst := s.blockBlob(toStorageBucket, to)
+
+	ylogger.Zero.Debug().Str("to", to).Str("from", src.URL()).Msg("requesting copy-blob")
+
+	resp, err := dst.StartCopyFromURL(context.TODO(), src.URL(), nil)
+	if err != nil {
+		return err
+	}
+
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		time.Sleep(azCopyPollInterval)
+
+		props, err := dst.GetProperties(context.TODO(), nil)
+		if err != nil {
+			return err
+		}
+		if props.CopyID != nil && resp.CopyID != nil && *props.CopyID != *resp.CopyID {
+			return fmt.Errorf("copy of %s was superseded by another copy", to)
+		}
+		status = props.CopyStatus
+		if status != nil && *status != blob.CopyStatusTypePending && *status != blob.CopyStatusTypeSuccess {
+			desc := ""
+			if props.CopyStatusDescription != nil {
+				desc = *props.CopyStatusDescription
+			}
+			return fmt.Errorf("copy of %s finished with status %s: %s", to, *status, desc)
+		}
+	}
+
+	ylogger.Zero.Debug().Str("path-from", from).Str("path-to", to).Msg("copied object")
+	return nil
+}
+
+func (s *AzBlobStorageInteractor) MoveObject(bucket string, from string, to string) error {
+	if from == to {
+		return nil
+	}
+	if err := s.copyBlob(s.objectPath(from), s.objectPath(to), bucket, bucket); err != nil {
+		return err
+	}
+	return s.DeleteObject(bucket, from)
+}
+
+func (s *AzBlobStorageInteractor) CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket string) error {
+	return s.copyBlob(
+		strings.TrimLeft(path.Join(fromStoragePrefix, from), "/"),
+		s.objectPath(to),
+		fromStorageBucket,
+		toStorageBucket)
+}
@@ -0,0 +1,345 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+/* well-known Azurite development account */
+const (
+	azuriteAccount = "devstoreaccount1"
+	azuriteKey     = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+type fakeBlob struct {
+	data    []byte
+	etag    string
+	lastMod time.Time
+}
+
+// fakeBlobServer serves subset of Blob service REST API used by azblob
+// storage: block blob upload, ranged download, properties, flat listing,
+// delete and copy-blob. Requests are not authenticated.
+type fakeBlobServer struct {
+	account string
+
+	mu     sync.Mutex
+	seq    int
+	blobs  map[string]*fakeBlob
+	blocks map[string]map[string][]byte
+}
+
+func (f *fakeBlobServer) commit(key string, data []byte) *fakeBlob {
+	f.seq++
+	b := &fakeBlob{
+		data:    data,
+		etag:    fmt.Sprintf(`"0x%X"`, f.seq),
+		lastMod: time.Now().UTC().Truncate(time.Second),
+	}
+	f.blobs[key] = b
+	delete(f.blocks, key)
+	return b
+}
+
+func writeBlobHeaders(w http.ResponseWriter, b *fakeBlob) {
+	w.Header().Set("ETag", b.etag)
+	w.Header().Set("Last-Modified", b.lastMod.Format(http.TimeFormat))
+}
+
+func writeBlobError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%s</Code></Error>`, code)
+}
+
+func (f *fakeBlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	/* /<account>/<container>[/<blob>] */
+	p := strings.TrimPrefix(r.URL.Path, "/"+f.account+"/")
+	cont, name, _ := strings.Cut(p, "/")
+	key := cont + "/" + name
+	q := r.URL.Query()
+
+	if name == "" {
+		switch {
+		case r.Method == http.MethodPut && q.Get("restype") == "container":
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet && q.Get("comp") == "list":
+			f.list(w, cont, q.Get("prefix"))
+		default:
+			writeBlobError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+		}
+		return
+	}
+
+	b, exists := f.blobs[key]
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || match != b.etag) {
+		writeBlobError(w, http.StatusPreconditionFailed, "ConditionNotMet")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBlobError(w, http.StatusBadRequest, "InvalidInput")
+			return
+		}
+		switch {
+		case q.Get("comp") == "block":
+			if f.blocks[key] == nil {
+				f.blocks[key] = map[string][]byte{}
+			}
+			f.blocks[key][q.Get("blockid")] = body
+		case q.Get("comp") == "blocklist":
+			list := struct {
+				Latest []string `xml:"Latest"`
+			}{}
+			if err := xml.Unmarshal(body, &list); err != nil {
+				writeBlobError(w, http.StatusBadRequest, "InvalidXmlDocument")
+				return
+			}
+			data := []byte{}
+			for _, id := range list.Latest {
+				block, ok := f.blocks[key][id]
+				if !ok {
+					writeBlobError(w, http.StatusBadRequest, "InvalidBlockList")
+					return
+				}
+				data = append(data, block...)
+			}
+			writeBlobHeaders(w, f.commit(key, data))
+		case r.Header.Get("x-ms-copy-source") != "":
+			src, err := url.Parse(r.Header.Get("x-ms-copy-source"))
+			if err != nil {
+				writeBlobError(w, http.StatusBadRequest, "InvalidHeaderValue")
+				return
+			}
+			from, ok := f.blobs[strings.TrimPrefix(src.Path, "/"+f.account+"/")]
+			if !ok {
+				writeBlobError(w, http.StatusNotFound, "CannotVerifyCopySource")
+				return
+			}
+			writeBlobHeaders(w, f.commit(key, append([]byte{}, from.data...)))
+			w.Header().Set("x-ms-copy-id", strconv.Itoa(f.seq))
+			w.Header().Set("x-ms-copy-status", "success")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		default:
+			writeBlobHeaders(w, f.commit(key, body))
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			writeBlobError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		writeBlobHeaders(w, b)
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		data := b.data
+		status := http.StatusOK
+		rng := r.Header.Get("x-ms-range")
+		if rng == "" {
+			rng = r.Header.Get("Range")
+		}
+		if rng != "" && r.Method == http.MethodGet {
+			var start, end int64
+			end = int64(len(b.data)) - 1
+			if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+				if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err != nil {
+					writeBlobError(w, http.StatusBadRequest, "InvalidRange")
+					return
+				}
+			}
+			if start >= int64(len(b.data)) {
+				writeBlobError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			end = min(end, int64(len(b.data))-1)
+			data = b.data[start : end+1]
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(b.data)))
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodDelete:
+		if !exists {
+			writeBlobError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(f.blobs, key)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeBlobError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+func (f *fakeBlobServer) list(w http.ResponseWriter, cont, prefix string) {
+	names := []string{}
+	for key := range f.blobs {
+		if name, ok := strings.CutPrefix(key, cont+"/"); ok && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := &bytes.Buffer{}
+	out.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+	for _, name := range names {
+		b := f.blobs[cont+"/"+name]
+		out.WriteString("<Blob><Name>")
+		_ = xml.EscapeText(out, []byte(name))
+		_, _ = fmt.Fprintf(out, "</Name><Properties><Last-Modified>%s</Last-Modified><Etag>%s</Etag><Content-Length>%d</Content-Length><BlobType>BlockBlob</BlobType></Properties></Blob>",
+			b.lastMod.Format(http.TimeFormat), b.etag, len(b.data))
+	}
+	out.WriteString("</Blobs><NextMarker/></EnumerationResults>")
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(out.Bytes())
+}
+
+// azuriteStorage returns azblob storage backed by Azurite emulator, e.g.
+// AZURITE_ENDPOINT=http://127.0.0.1:10000/devstoreaccount1, or by in-process
+// fake of Blob service when AZURITE_ENDPOINT is not set.
+func azuriteStorage(t *testing.T, bucket string) storage.StorageInteractor {
+	endpoint := os.Getenv("AZURITE_ENDPOINT")
+	if endpoint == "" {
+		ts := httptest.NewServer(&fakeBlobServer{
+			account: azuriteAccount,
+			blobs:   map[string]*fakeBlob{},
+			blocks:  map[string]map[string][]byte{},
+		})
+		t.Cleanup(ts.Close)
+		endpoint = ts.URL + "/" + azuriteAccount
+	}
+
+	cnf := &config.Storage{
+		StorageType:      "azblob",
+		StorageEndpoint:  endpoint,
+		StorageBucket:    bucket,
+		StoragePrefix:    "prefix/",
+		AzureAccountName: azuriteAccount,
+		AzureAccountKey:  azuriteKey,
+	}
+
+	cred, err := azblob.NewSharedKeyCredential(azuriteAccount, azuriteKey)
+	require.NoError(t, err)
+	client, err := azblob.NewClientWithSharedKeyCredential(endpoint, cred, nil)
+	require.NoError(t, err)
+	_, err = client.CreateContainer(context.Background(), bucket, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		require.NoError(t, err)
+	}
+
+	s, err := storage.NewStorage(cnf, "")
+	require.NoError(t, err)
+	return s
+}
+
+func TestAzBlobStorage(t *testing.T) {
+	bucket := fmt.Sprintf("yproxy-test-%d", os.Getpid())
+	s := azuriteStorage(t, bucket)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	setts := []settings.StorageSettings{
+		{Name: message.MultipartChunkSize, Value: "4096"},
+	}
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader(content), setts))
+
+	t.Run("ranged read", func(t *testing.T) {
+		r, err := s.CatFileFromStorage("seg/obj", 9995, nil)
+		require.NoError(t, err)
+		defer func() { _ = r.Close() }()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, content[9995:], data)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		objs, err := s.ListPath("seg/", false, nil)
+		require.NoError(t, err)
+		require.Len(t, objs, 1)
+		require.Equal(t, "/seg/obj", objs[0].Path)
+		require.Equal(t, int64(len(content)), objs[0].Size)
+		require.NotEmpty(t, objs[0].ETag)
+	})
+
+	t.Run("single request upload", func(t *testing.T) {
+		require.NoError(t, s.PutFileToDest("seg/single", bytes.NewReader([]byte("single")), []settings.StorageSettings{
+			{Name: message.MultipartUpload, Value: "0"},
+		}))
+
+		r, err := s.CatFileFromStorage("seg/single", 0, nil)
+		require.NoError(t, err)
+		defer func() { _ = r.Close() }()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, []byte("single"), data)
+
+		require.NoError(t, s.DeleteObject(bucket, "seg/single"))
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		require.NoError(t, s.PatchFile("seg/obj", bytes.NewReader([]byte("abc")), 10))
+
+		r, err := s.CatFileFromStorage("seg/obj", 0, nil)
+		require.NoError(t, err)
+		defer func() { _ = r.Close() }()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		expected := append([]byte{}, content...)
+		copy(expected[10:], "abc")
+		require.Equal(t, expected, data)
+	})
+
+	t.Run("copy and move", func(t *testing.T) {
+		require.NoError(t, s.CopyObject("seg/obj", "seg/copy", "prefix/", bucket, bucket))
+		require.NoError(t, s.MoveObject(bucket, "seg/copy", "seg/moved"))
+
+		objs, err := s.ListPath("seg/", false, nil)
+		require.NoError(t, err)
+		paths := []string{}
+		for _, o := range objs {
+			paths = append(paths, o.Path)
+		}
+		require.ElementsMatch(t, []string{"/seg/obj", "/seg/moved"}, paths)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteObject(bucket, "seg/obj"))
+		require.NoError(t, s.DeleteObject(bucket, "seg/moved"))
+		/* deleting missing object is not an error */
+		require.NoError(t, s.DeleteObject(bucket, "seg/moved"))
+
+		objs, err := s.ListPath("seg/", false, nil)
+		require.NoError(t, err)
+		require.Empty(t, objs)
+	})
+}
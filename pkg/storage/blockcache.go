@@ -0,0 +1,505 @@
+package storage
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+func hashHex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+type cacheBlock struct {
+	key  string
+	name string
+	size int64
+}
+
+// BlockCache keeps fixed-size blocks of objects on local disk and evicts
+// least recently used ones above size limit. Block is keyed by object name,
+// ETag and block index, so blocks of changed object are never served.
+// Layout is <dir>/<sha256(name)>/<sha256(etag)[:16]>-<index>.
+type BlockCache struct {
+	dir       string
+	limit     int64
+	blockSize int64
+
+	mu     sync.Mutex
+	lru    *list.List /* front is most recently used */
+	index  map[string]*list.Element
+	byName map[string]map[string]struct{}
+	size   int64
+
+	lock *os.File
+}
+
+func NewBlockCache(dir string, limit, blockSize int64) (*BlockCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("block cache %s is owned by another process: %w", dir, err)
+	}
+	c := &BlockCache{
+		dir:       dir,
+		limit:     limit,
+		blockSize: blockSize,
+		lru:       list.New(),
+		index:     map[string]*list.Element{},
+		byName:    map[string]map[string]struct{}{},
+		lock:      lock,
+	}
+	if err := c.load(); err != nil {
+		_ = lock.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// load restores index of blocks cached before restart, ordered by modification time.
+func (c *BlockCache) load() error {
+	type found struct {
+		key, name string
+		size      int64
+		mtime     time.Time
+	}
+	blocks := []found{}
+	err := filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(c.dir, p)
+		if err != nil {
+			return err
+		}
+		nameHash, _, ok := strings.Cut(rel, "/")
+		if !ok {
+			/* lock file */
+			return nil
+		}
+		if strings.HasSuffix(rel, ".tmp") {
+			return os.Remove(p)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, found{rel, nameHash, info.Size(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(blocks, func(a, b found) int { return a.mtime.Compare(b.mtime) })
+
+	c.mu.Lock()
+	for _, b := range blocks {
+		c.addLocked(&cacheBlock{key: b.key, name: b.name, size: b.size})
+	}
+	victims := c.evictLocked()
+	c.mu.Unlock()
+	c.remove(victims)
+
+	ylogger.Zero.Info().Str("path", c.dir).Int("blocks", len(blocks)).Int64("size", c.size).Msg("loaded block cache")
+	return nil
+}
+
+var (
+	/* block caches opened by storages, directory lock is held once per process */
+	blockCachesMu sync.Mutex
+	blockCaches   = map[string]*BlockCache{}
+)
+
+// openSharedBlockCache returns block cache of directory, shared within
+// process, so storage rebuilt on reload keeps cached blocks.
+func openSharedBlockCache(dir string, limit, blockSize int64) (*BlockCache, error) {
+	blockCachesMu.Lock()
+	defer blockCachesMu.Unlock()
+
+	if c, ok := blockCaches[dir]; ok {
+		if c.blockSize != blockSize {
+			ylogger.Zero.Warn().Str("path", dir).Int64("block size", c.blockSize).Msg("block cache is open, block size change requires restart")
+		}
+		c.setLimit(limit)
+		return c, nil
+	}
+	c, err := NewBlockCache(dir, limit, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	blockCaches[dir] = c
+	return c, nil
+}
+
+// setLimit changes size limit and evicts blocks above it.
+func (c *BlockCache) setLimit(limit int64) {
+	c.mu.Lock()
+	c.limit = limit
+	victims := c.evictLocked()
+	c.mu.Unlock()
+	c.remove(victims)
+}
+
+func (c *BlockCache) Close() error {
+	return c.lock.Close()
+}
+
+func (c *BlockCache) BlockSize() int64 {
+	return c.blockSize
+}
+
+func (c *BlockCache) blockKey(nameHash, etag string, idx int64) string {
+	return path.Join(nameHash, fmt.Sprintf("%s-%d", hashHex(etag)[:16], idx))
+}
+
+func (c *BlockCache) addLocked(b *cacheBlock) {
+	if el, ok := c.index[b.key]; ok {
+		c.removeLocked(el)
+	}
+	c.index[b.key] = c.lru.PushFront(b)
+	if c.byName[b.name] == nil {
+		c.byName[b.name] = map[string]struct{}{}
+	}
+	c.byName[b.name][b.key] = struct{}{}
+	c.size += b.size
+}
+
+func (c *BlockCache) removeLocked(el *list.Element) {
+	b := c.lru.Remove(el).(*cacheBlock)
+	delete(c.index, b.key)
+	delete(c.byName[b.name], b.key)
+	if len(c.byName[b.name]) == 0 {
+		delete(c.byName, b.name)
+	}
+	c.size -= b.size
+}
+
+// evictLocked drops least recently used blocks from index above limit.
+// Returned blocks are to be removed from disk without lock held.
+func (c *BlockCache) evictLocked() []string {
+	victims := []string{}
+	for c.size > c.limit && c.lru.Len() > 0 {
+		el := c.lru.Back()
+		victims = append(victims, el.Value.(*cacheBlock).key)
+		c.removeLocked(el)
+	}
+	metrics.BlockCacheBytes.Set(float64(c.size))
+	return victims
+}
+
+func (c *BlockCache) remove(keys []string) {
+	for _, key := range keys {
+		if err := os.Remove(path.Join(c.dir, key)); err != nil && !os.IsNotExist(err) {
+			ylogger.Zero.Warn().Err(err).Str("block", key).Msg("failed to remove cached block")
+		}
+	}
+}
+
+// Get returns cached block, if any.
+func (c *BlockCache) Get(name, etag string, idx int64) ([]byte, bool) {
+	key := c.blockKey(hashHex(name), etag, idx)
+
+	c.mu.Lock()
+	el, ok := c.index[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		metrics.BlockCacheMisses.Inc()
+		return nil, false
+	}
+	data, err := os.ReadFile(path.Join(c.dir, key))
+	if err != nil {
+		ylogger.Zero.Warn().Err(err).Str("block", key).Msg("failed to read cached block")
+		c.mu.Lock()
+		if el, ok := c.index[key]; ok {
+			c.removeLocked(el)
+		}
+		c.mu.Unlock()
+		metrics.BlockCacheMisses.Inc()
+		return nil, false
+	}
+	metrics.BlockCacheHits.Inc()
+	return data, true
+}
+
+// Put stores block, evicting older blocks if cache is full.
+func (c *BlockCache) Put(name, etag string, idx int64, data []byte) {
+	nameHash := hashHex(name)
+	key := c.blockKey(nameHash, etag, idx)
+	p := path.Join(c.dir, key)
+
+	if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+		ylogger.Zero.Warn().Err(err).Msg("failed to create block cache directory")
+		return
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", p, time.Now().UnixNano())
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		ylogger.Zero.Warn().Err(err).Str("block", key).Msg("failed to write cached block")
+		_ = os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		ylogger.Zero.Warn().Err(err).Str("block", key).Msg("failed to write cached block")
+		_ = os.Remove(tmp)
+		return
+	}
+
+	c.mu.Lock()
+	c.addLocked(&cacheBlock{key: key, name: nameHash, size: int64(len(data))})
+	victims := c.evictLocked()
+	c.mu.Unlock()
+	c.remove(victims)
+}
+
+// Has reports whether any block of object is cached.
+func (c *BlockCache) Has(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.byName[hashHex(name)]) > 0
+}
+
+// Invalidate drops all cached blocks of object.
+func (c *BlockCache) Invalidate(name string) {
+	nameHash := hashHex(name)
+
+	c.mu.Lock()
+	keys := []string{}
+	for key := range c.byName[nameHash] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		c.removeLocked(c.index[key])
+	}
+	metrics.BlockCacheBytes.Set(float64(c.size))
+	c.mu.Unlock()
+
+	if len(keys) > 0 {
+		ylogger.Zero.Debug().Str("name", name).Int("blocks", len(keys)).Msg("invalidated cached blocks")
+	}
+	c.remove(keys)
+}
+
+// CachedStorageInteractor serves object reads through BlockCache.
+// Storage not implementing StorageStater is read directly, as cached
+// blocks can not be matched to object version.
+type CachedStorageInteractor struct {
+	StorageInteractor
+
+	cache  *BlockCache
+	prefix string
+}
+
+var _ StorageInteractor = &CachedStorageInteractor{}
+
+type cachedStaterStorage struct {
+	*CachedStorageInteractor
+	stater StorageStater
+}
+
+// StatObject implements StorageStater.
+func (s *cachedStaterStorage) StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	return s.stater.StatObject(name, setts)
+}
+
+// NewCachedStorageInteractor returns storage reading objects through
+// cache. Storages able to stat objects stay so.
+func NewCachedStorageInteractor(s StorageInteractor, cache *BlockCache, storagePrefix string) StorageInteractor {
+	cs := &CachedStorageInteractor{
+		StorageInteractor: s,
+		cache:             cache,
+		prefix:            storagePrefix,
+	}
+	if st, ok := s.(StorageStater); ok {
+		return &cachedStaterStorage{CachedStorageInteractor: cs, stater: st}
+	}
+	return cs
+}
+
+func (s *CachedStorageInteractor) CatFileFromStorage(name string, offset int64, setts []settings.StorageSettings) (io.ReadCloser, error) {
+	stater, ok := s.StorageInteractor.(StorageStater)
+	if !ok {
+		return s.StorageInteractor.CatFileFromStorage(name, offset, setts)
+	}
+	name = strings.TrimLeft(name, "/")
+
+	/*
+	 * Without cached blocks there is nothing to validate, so object is
+	 * requested right away and its metadata is taken from response.
+	 */
+	start := offset - offset%s.cache.BlockSize()
+	var upstream io.ReadCloser
+	if !s.cache.Has(name) {
+		r, err := s.StorageInteractor.CatFileFromStorage(name, start, setts)
+		if err != nil {
+			if r != nil {
+				_ = r.Close()
+			}
+			return nil, err
+		}
+		if ir, ok := r.(ObjectInfoReader); ok {
+			if info := ir.ObjectInfo(); info != nil && info.ETag != "" {
+				return s.reader(name, info, setts, offset, r, start), nil
+			}
+		}
+		upstream = r
+	}
+
+	info, err := stater.StatObject(name, setts)
+	if err != nil || info.ETag == "" {
+		ylogger.Zero.Debug().Err(err).Str("name", name).Msg("bypassing block cache")
+		if upstream != nil {
+			_ = upstream.Close()
+		}
+		return s.StorageInteractor.CatFileFromStorage(name, offset, setts)
+	}
+	return s.reader(name, info, setts, offset, upstream, start), nil
+}
+
+// reader returns reader of object from offset. Upstream, if any, is
+// storage stream positioned at upstreamOff.
+func (s *CachedStorageInteractor) reader(name string, info *object.ObjectInfo, setts []settings.StorageSettings, offset int64, upstream io.ReadCloser, upstreamOff int64) *cachedReader {
+	return &cachedReader{
+		s:           s.StorageInteractor,
+		cache:       s.cache,
+		name:        name,
+		etag:        info.ETag,
+		setts:       setts,
+		size:        info.Size,
+		off:         offset,
+		upstream:    upstream,
+		upstreamOff: upstreamOff,
+	}
+}
+
+func (s *CachedStorageInteractor) PutFileToDest(name string, r io.Reader, setts []settings.StorageSettings) error {
+	defer s.cache.Invalidate(strings.TrimLeft(name, "/"))
+	return s.StorageInteractor.PutFileToDest(name, r, setts)
+}
+
+func (s *CachedStorageInteractor) PatchFile(name string, r io.ReadSeeker, startOffset int64) error {
+	defer s.cache.Invalidate(strings.TrimLeft(name, "/"))
+	return s.StorageInteractor.PatchFile(name, r, startOffset)
+}
+
+func (s *CachedStorageInteractor) MoveObject(bucket string, from string, to string) error {
+	defer s.cache.Invalidate(objectName(s.prefix, from))
+	defer s.cache.Invalidate(objectName(s.prefix, to))
+	return s.StorageInteractor.MoveObject(bucket, from, to)
+}
+
+func (s *CachedStorageInteractor) DeleteObject(bucket, key string) error {
+	defer s.cache.Invalidate(objectName(s.prefix, key))
+	return s.StorageInteractor.DeleteObject(bucket, key)
+}
+
+func (s *CachedStorageInteractor) CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket string) error {
+	defer s.cache.Invalidate(objectName(s.prefix, to))
+	return s.StorageInteractor.CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket)
+}
+
+// ListStaleMultipartUploads implements StaleUploadLister.
+func (s *CachedStorageInteractor) ListStaleMultipartUploads(bucket string, before time.Time) (map[string]string, error) {
+	return ListStaleMultipartUploads(s.StorageInteractor, bucket, before)
+}
+
+// cachedReader reads object block by block. Missing blocks are read from
+// storage sequentially, reusing single storage stream, and put in cache.
+type cachedReader struct {
+	s     StorageReader
+	cache *BlockCache
+
+	name  string
+	etag  string
+	setts []settings.StorageSettings
+	size  int64
+
+	/* offset of next byte to return */
+	off int64
+
+	buf    []byte
+	bufOff int64
+
+	upstream    io.ReadCloser
+	upstreamOff int64
+}
+
+func (r *cachedReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.off < r.bufOff || r.off >= r.bufOff+int64(len(r.buf)) {
+		if err := r.load(r.off / r.cache.BlockSize()); err != nil {
+			return 0, err
+		}
+		if r.off >= r.bufOff+int64(len(r.buf)) {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	n := copy(p, r.buf[r.off-r.bufOff:])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *cachedReader) load(idx int64) error {
+	start := idx * r.cache.BlockSize()
+	want := min(r.cache.BlockSize(), r.size-start)
+
+	if data, ok := r.cache.Get(r.name, r.etag, idx); ok && int64(len(data)) == want {
+		r.buf, r.bufOff = data, start
+		return nil
+	}
+
+	if r.upstream == nil || r.upstreamOff != start {
+		r.closeUpstream()
+		up, err := r.s.CatFileFromStorage(r.name, start, r.setts)
+		if err != nil {
+			return err
+		}
+		r.upstream, r.upstreamOff = up, start
+	}
+
+	data := make([]byte, want)
+	n, err := io.ReadFull(r.upstream, data)
+	r.upstreamOff += int64(n)
+	if err != nil {
+		r.closeUpstream()
+		return err
+	}
+	r.cache.Put(r.name, r.etag, idx, data)
+	r.buf, r.bufOff = data, start
+	return nil
+}
+
+func (r *cachedReader) closeUpstream() {
+	if r.upstream != nil {
+		_ = r.upstream.Close()
+		r.upstream = nil
+	}
+}
+
+func (r *cachedReader) Close() error {
+	r.closeUpstream()
+	return nil
+}
@@ -0,0 +1,131 @@
+package storage_test
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+func cacheBlocks(t *testing.T, dir string) int64 {
+	size := int64(0)
+	require.NoError(t, filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == "lock" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	}))
+	return size
+}
+
+func newCachedTestStorage(t *testing.T, limit int64) (storage.StorageInteractor, *testStorage, string, string) {
+	counting, cnf := newFSTestStorage(t, "")
+	cacheDir := t.TempDir()
+
+	cache, err := storage.NewBlockCache(cacheDir, limit, 4)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cache.Close() })
+
+	return storage.NewCachedStorageInteractor(counting, cache, cnf.StoragePrefix), counting, cnf.StoragePrefix, cacheDir
+}
+
+func TestBlockCacheReadThrough(t *testing.T) {
+	s, counting, dataDir, cacheDir := newCachedTestStorage(t, 1024)
+
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("0123456789")), nil))
+
+	require.Equal(t, "0123456789", catAll(t, s, "seg/obj"))
+	/* all blocks are read with single storage request */
+	require.Equal(t, int64(1), counting.cats.Load())
+	require.Equal(t, int64(10), cacheBlocks(t, cacheDir))
+
+	r, err := s.CatFileFromStorage("seg/obj", 5, nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "56789", string(data))
+	require.Equal(t, int64(1), counting.cats.Load())
+
+	/* changed object has different ETag, stale blocks are not served */
+	require.NoError(t, os.WriteFile(path.Join(dataDir, "seg/obj"), []byte("abcdefghij"), 0644))
+	require.Equal(t, "abcdefghij", catAll(t, s, "seg/obj"))
+	require.Equal(t, int64(2), counting.cats.Load())
+}
+
+func TestBlockCacheInvalidation(t *testing.T) {
+	s, _, _, cacheDir := newCachedTestStorage(t, 1024)
+
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("0123456789")), nil))
+	require.Equal(t, "0123456789", catAll(t, s, "seg/obj"))
+	require.Equal(t, int64(10), cacheBlocks(t, cacheDir))
+
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("new")), nil))
+	require.Equal(t, int64(0), cacheBlocks(t, cacheDir))
+	require.Equal(t, "new", catAll(t, s, "seg/obj"))
+
+	require.NoError(t, s.MoveObject("", "seg/obj", "seg/moved"))
+	require.Equal(t, int64(0), cacheBlocks(t, cacheDir))
+
+	require.Equal(t, "new", catAll(t, s, "seg/moved"))
+	require.NoError(t, s.DeleteObject("", "seg/moved"))
+	require.Equal(t, int64(0), cacheBlocks(t, cacheDir))
+}
+
+func TestBlockCacheEviction(t *testing.T) {
+	s, counting, _, cacheDir := newCachedTestStorage(t, 8)
+
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("0123456789")), nil))
+	require.Equal(t, "0123456789", catAll(t, s, "seg/obj"))
+	require.LessOrEqual(t, cacheBlocks(t, cacheDir), int64(8))
+
+	/* last blocks are still cached, first one is read from storage again */
+	r, err := s.CatFileFromStorage("seg/obj", 4, nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "456789", string(data))
+	require.Equal(t, int64(1), counting.cats.Load())
+
+	require.Equal(t, "0123456789", catAll(t, s, "seg/obj"))
+	require.Equal(t, int64(2), counting.cats.Load())
+}
+
+func TestBlockCacheColdRead(t *testing.T) {
+	s, counting, _, cacheDir := newCachedTestStorage(t, 1024)
+
+	_, ok := s.(storage.StorageStater)
+	require.True(t, ok)
+
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("0123456789")), nil))
+
+	/* metadata of object without cached blocks comes with its content */
+	r, err := s.CatFileFromStorage("seg/obj", 6, nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "6789", string(data))
+	require.Equal(t, int64(1), counting.cats.Load())
+	require.Equal(t, int64(0), counting.stats.Load())
+	/* blocks containing requested bytes */
+	require.Equal(t, int64(6), cacheBlocks(t, cacheDir))
+
+	/* cached blocks are validated by stat */
+	require.Equal(t, "0123456789", catAll(t, s, "seg/obj"))
+	require.Equal(t, int64(2), counting.cats.Load())
+	require.Equal(t, int64(1), counting.stats.Load())
+}
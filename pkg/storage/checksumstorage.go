@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/tablespace"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+/* checksums are stored in separate tree, out of listings of object prefixes */
+const checksumSidecarDir = "yproxy_checksums"
+
+// ChecksumSidecarName returns name of object the checksum of object name is
+// stored in. Sidecar is stored in the bucket of object.
+func ChecksumSidecarName(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return "/" + checksumSidecarDir + name
+	}
+	return checksumSidecarDir + "/" + name
+}
+
+func isChecksumSidecar(name string) bool {
+	return strings.HasPrefix(strings.TrimLeft(name, "/"), checksumSidecarDir+"/")
+}
+
+// ChecksumStorageInteractor keeps checksum sidecars in step with objects
+// changed through it. Stored checksum of object is dropped before object is
+// written, so object never goes with checksum of other content; it is moved
+// and copied along with object and deleted with it. With checksums disabled
+// objects are changed as is and sidecars are only hidden from listings.
+type ChecksumStorageInteractor struct {
+	StorageInteractor
+
+	cnf      *config.Storage
+	maintain bool
+
+	TSToBucketMap map[string]string
+}
+
+var _ StorageInteractor = &ChecksumStorageInteractor{}
+
+type checksumStaterStorage struct {
+	*ChecksumStorageInteractor
+	stater StorageStater
+}
+
+// StatObject implements StorageStater.
+func (s *checksumStaterStorage) StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	return s.stater.StatObject(name, setts)
+}
+
+// NewChecksumStorageInteractor returns storage maintaining checksum
+// sidecars, if checksums are enabled. Storages able to stat objects stay so.
+func NewChecksumStorageInteractor(s StorageInteractor, cnf *config.Storage, checksumCnf *config.Checksum) StorageInteractor {
+	cs := &ChecksumStorageInteractor{
+		StorageInteractor: s,
+		cnf:               cnf,
+		maintain:          checksumCnf.Algorithm != "",
+		TSToBucketMap:     buildBucketMapFromCnf(cnf),
+	}
+	if st, ok := s.(StorageStater); ok {
+		return &checksumStaterStorage{ChecksumStorageInteractor: cs, stater: st}
+	}
+	return cs
+}
+
+func (s *ChecksumStorageInteractor) sidecar(key string) string {
+	return ChecksumSidecarName("/" + objectName(s.cnf.StoragePrefix, key))
+}
+
+// dropSidecar deletes stored checksum of object, missing one is not an error.
+func (s *ChecksumStorageInteractor) dropSidecar(bucket, key string) error {
+	err := s.StorageInteractor.DeleteObject(bucket, s.sidecar(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		ylogger.Zero.Error().Err(err).Str("bucket", bucket).Str("key", key).Msg("failed to drop object checksum")
+		return err
+	}
+	return nil
+}
+
+func (s *ChecksumStorageInteractor) PutFileToDest(name string, r io.Reader, setts []settings.StorageSettings) error {
+	if s.maintain && !isChecksumSidecar(name) {
+		bucket := s.TSToBucketMap[ResolveStorageSetting(setts, message.TableSpaceSetting, tablespace.DefaultTableSpace)]
+		if err := s.dropSidecar(bucket, name); err != nil {
+			return err
+		}
+	}
+	return s.StorageInteractor.PutFileToDest(name, r, setts)
+}
+
+func (s *ChecksumStorageInteractor) PatchFile(name string, r io.ReadSeeker, startOffset int64) error {
+	if !s.maintain {
+		return s.StorageInteractor.PatchFile(name, r, startOffset)
+	}
+	if err := s.dropSidecar(s.DefaultBucket(), name); err != nil {
+		return err
+	}
+	return s.StorageInteractor.PatchFile(name, r, startOffset)
+}
+
+func (s *ChecksumStorageInteractor) DeleteObject(bucket, key string) error {
+	if s.maintain && !isChecksumSidecar(objectName(s.cnf.StoragePrefix, key)) {
+		if err := s.dropSidecar(bucket, key); err != nil {
+			return err
+		}
+	}
+	return s.StorageInteractor.DeleteObject(bucket, key)
+}
+
+func (s *ChecksumStorageInteractor) MoveObject(bucket string, from string, to string) error {
+	if !s.maintain || from == to || isChecksumSidecar(objectName(s.cnf.StoragePrefix, from)) {
+		return s.StorageInteractor.MoveObject(bucket, from, to)
+	}
+	if err := s.dropSidecar(bucket, to); err != nil {
+		return err
+	}
+	if err := s.StorageInteractor.MoveObject(bucket, from, to); err != nil {
+		return err
+	}
+	/* objects put with checksums disabled have no sidecar */
+	if err := s.StorageInteractor.MoveObject(bucket, s.sidecar(from), s.sidecar(to)); err != nil {
+		ylogger.Zero.Debug().Err(err).Str("from", from).Str("to", to).Msg("object checksum is not moved")
+	}
+	return nil
+}
+
+func (s *ChecksumStorageInteractor) CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket string) error {
+	if !s.maintain {
+		return s.StorageInteractor.CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket)
+	}
+	if err := s.dropSidecar(toStorageBucket, to); err != nil {
+		return err
+	}
+	if err := s.StorageInteractor.CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket); err != nil {
+		return err
+	}
+	err := s.StorageInteractor.CopyObject(
+		ChecksumSidecarName("/"+objectName(fromStoragePrefix, from)), s.sidecar(to),
+		fromStoragePrefix, fromStorageBucket, toStorageBucket)
+	if err != nil {
+		ylogger.Zero.Debug().Err(err).Str("from", from).Str("to", to).Msg("object checksum is not copied")
+	}
+	return nil
+}
+
+func withoutSidecars(objs []*object.ObjectInfo, err error) ([]*object.ObjectInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*object.ObjectInfo, 0, len(objs))
+	for _, o := range objs {
+		if !isChecksumSidecar(o.Path) {
+			res = append(res, o)
+		}
+	}
+	return res, nil
+}
+
+func (s *ChecksumStorageInteractor) ListPath(prefix string, useCache bool, setts []settings.StorageSettings) ([]*object.ObjectInfo, error) {
+	return withoutSidecars(s.StorageInteractor.ListPath(prefix, useCache, setts))
+}
+
+func (s *ChecksumStorageInteractor) ListBucketPath(bucket, prefix string, useCache bool) ([]*object.ObjectInfo, error) {
+	return withoutSidecars(s.StorageInteractor.ListBucketPath(bucket, prefix, useCache))
+}
+
+// ListStaleMultipartUploads implements StaleUploadLister.
+func (s *ChecksumStorageInteractor) ListStaleMultipartUploads(bucket string, before time.Time) (map[string]string, error) {
+	return ListStaleMultipartUploads(s.StorageInteractor, bucket, before)
+}
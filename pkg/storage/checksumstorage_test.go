@@ -0,0 +1,92 @@
+package storage_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+func newChecksumTestStorage(t *testing.T, algorithm string) (storage.StorageInteractor, string) {
+	root := t.TempDir() + "/"
+	s, err := storage.NewInstanceStorage(&config.Storage{StorageType: "fs", StoragePrefix: root},
+		&config.Instance{ChecksumCnf: config.Checksum{Algorithm: algorithm}}, "")
+	require.NoError(t, err)
+	return s, root
+}
+
+func TestChecksumSidecarFollowsObject(t *testing.T) {
+	s, root := newChecksumTestStorage(t, config.ChecksumCRC32C)
+
+	put := func(name, content string) {
+		require.NoError(t, s.PutFileToDest(name, bytes.NewReader([]byte(content)), nil))
+	}
+	sidecar := func(name string) string {
+		r, err := s.CatFileFromStorage(storage.ChecksumSidecarName(name), 0, nil)
+		if err != nil {
+			return ""
+		}
+		defer func() { _ = r.Close() }()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	put("/seg/a", "content")
+	put(storage.ChecksumSidecarName("/seg/a"), "sum of a")
+
+	objs, err := s.ListPath("/", false, nil)
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+	require.Equal(t, "/seg/a", objs[0].Path)
+
+	require.NoError(t, s.MoveObject(s.DefaultBucket(), "/seg/a", "/seg/b"))
+	require.Empty(t, sidecar("/seg/a"))
+	require.Equal(t, "sum of a", sidecar("/seg/b"))
+
+	require.NoError(t, s.CopyObject("/seg/b", "/seg/c", root, "", ""))
+	require.Equal(t, "sum of a", sidecar("/seg/c"))
+
+	/* object put without checksum must not keep checksum of old content */
+	put("/seg/c", "new content")
+	require.Empty(t, sidecar("/seg/c"))
+
+	/* move over object drops its checksum */
+	put(storage.ChecksumSidecarName("/seg/c"), "sum of c")
+	put("/seg/d", "unsummed")
+	require.NoError(t, s.MoveObject(s.DefaultBucket(), "/seg/d", "/seg/c"))
+	require.Empty(t, sidecar("/seg/c"))
+
+	require.NoError(t, s.PatchFile("/seg/b", bytes.NewReader([]byte("C")), 0))
+	require.Empty(t, sidecar("/seg/b"))
+
+	put(storage.ChecksumSidecarName("/seg/b"), "sum of b")
+	require.NoError(t, s.DeleteObject(s.DefaultBucket(), "/seg/b"))
+	require.Empty(t, sidecar("/seg/b"))
+	_, err = os.Stat(path.Join(root, "seg", "b"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestChecksumSidecarUntouchedWhenDisabled(t *testing.T) {
+	s, root := newChecksumTestStorage(t, "")
+
+	require.NoError(t, s.PutFileToDest("/seg/a", bytes.NewReader([]byte("content")), nil))
+	require.NoError(t, s.PutFileToDest(storage.ChecksumSidecarName("/seg/a"), bytes.NewReader([]byte("sum of a")), nil))
+
+	/* objects are changed as is, without requests for sidecars */
+	require.NoError(t, s.MoveObject(s.DefaultBucket(), "/seg/a", "/seg/b"))
+	require.NoError(t, s.DeleteObject(s.DefaultBucket(), "/seg/b"))
+	_, err := os.Stat(path.Join(root, storage.ChecksumSidecarName("/seg/a")))
+	require.NoError(t, err)
+
+	/* sidecars left by enabled checksums stay out of listings */
+	objs, err := s.ListPath("/", false, nil)
+	require.NoError(t, err)
+	require.Empty(t, objs)
+}
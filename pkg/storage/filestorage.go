@@ -48,9 +48,34 @@ func (s *FileStorageInteractor) CatFileFromStorage(name string, offset int64, _
 	if err != nil {
 		return nil, err
 	}
+	r := &objectInfoReader{ReadCloser: file}
+	if info, err := file.Stat(); err == nil {
+		r.info = fileObjectInfo(name, info)
+	}
 	_, err = io.CopyN(io.Discard, file, offset)
-	return file, err
+	return r, err
+}
+
+// fileObjectInfo returns metadata of object. ETag is derived from
+// modification time and size.
+func fileObjectInfo(name string, info os.FileInfo) *object.ObjectInfo {
+	return &object.ObjectInfo{
+		Path:    "/" + strings.TrimLeft(name, "/"),
+		Size:    info.Size(),
+		LastMod: info.ModTime(),
+		ETag:    fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+	}
 }
+
+// StatObject implements StorageStater.
+func (s *FileStorageInteractor) StatObject(name string, _ []settings.StorageSettings) (*object.ObjectInfo, error) {
+	info, err := os.Stat(path.Join(s.cnf.StoragePrefix, name))
+	if err != nil {
+		return nil, err
+	}
+	return fileObjectInfo(name, info), nil
+}
+
 func (s *FileStorageInteractor) ListPath(prefix string, _ bool, _ []settings.StorageSettings) ([]*object.ObjectInfo, error) {
 	var data []*object.ObjectInfo
 	err := filepath.WalkDir(s.cnf.StoragePrefix, func(path string, d fs.DirEntry, err error) error {
@@ -95,13 +120,36 @@ func (s *FileStorageInteractor) PutFileToDest(name string, r io.Reader, _ []sett
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(file, r)
-	return err
+	if _, err = io.Copy(file, r); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
 }
 
+// PatchFile overwrites file content starting at startOffset, which must not
+// be beyond end of file.
 func (s *FileStorageInteractor) PatchFile(name string, r io.ReadSeeker, startOffset int64) error {
-	//UNUSED TODO
-	return fmt.Errorf("TODO")
+	f, err := os.OpenFile(path.Join(s.cnf.StoragePrefix, name), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if startOffset > fi.Size() {
+		return fmt.Errorf("patch offset %d is beyond end of file %s of %d bytes", startOffset, name, fi.Size())
+	}
+	if _, err := f.Seek(startOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Close()
 }
 
 func (s *FileStorageInteractor) MoveObject(_ /*bucket*/, from string, to string) error {
@@ -128,12 +176,16 @@ func (s *FileStorageInteractor) CopyObject(from, to, fromStoragePrefix, _, _ str
 	if err != nil {
 		return err
 	}
-	toFile, err := os.OpenFile(toPath, os.O_CREATE|os.O_WRONLY, 0644)
+	defer func() { _ = fromFile.Close() }()
+	toFile, err := os.OpenFile(toPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(fromFile, toFile)
-	return err
+	if _, err = io.Copy(toFile, fromFile); err != nil {
+		_ = toFile.Close()
+		return err
+	}
+	return toFile.Close()
 }
 
 func (s *FileStorageInteractor) DeleteObject(_ /*bucket*/, key string) error {
@@ -0,0 +1,36 @@
+package storage_test
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+func TestFileStorageCopyObject(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	s, err := storage.NewStorage(&config.Storage{StorageType: "fs", StoragePrefix: dstDir}, "")
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(path.Join(srcDir, "seg"), 0700))
+	require.NoError(t, os.WriteFile(path.Join(srcDir, "seg", "obj"), []byte("new content"), 0644))
+	/* stale longer destination must be overwritten completely */
+	require.NoError(t, os.MkdirAll(path.Join(dstDir, "seg"), 0700))
+	require.NoError(t, os.WriteFile(path.Join(dstDir, "seg", "obj"), []byte("stale destination content"), 0644))
+
+	require.NoError(t, s.CopyObject("seg/obj", "seg/obj", srcDir, "", ""))
+
+	data, err := os.ReadFile(path.Join(dstDir, "seg", "obj"))
+	require.NoError(t, err)
+	require.Equal(t, "new content", string(data))
+
+	data, err = os.ReadFile(path.Join(srcDir, "seg", "obj"))
+	require.NoError(t, err)
+	require.Equal(t, "new content", string(data))
+}
@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/tablespace"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+const gcsDefaultEndpoint = "https://storage.googleapis.com"
+
+// GCSStorageInteractor talks to Google Cloud Storage with the Go client
+// library. Buckets are routed by tablespace with TablespaceMap, each bucket
+// may use its own service account from CredentialMap.
+type GCSStorageInteractor struct {
+	endpoint string
+
+	cnf *config.Storage
+
+	TSToBucketMap map[string]string
+	credentialMap map[string]config.StorageCredentials
+
+	mu      sync.Mutex
+	clients map[string]*gcs.Client
+}
+
+var _ StorageInteractor = &GCSStorageInteractor{}
+
+func NewGCSStorageInteractor(cnf *config.Storage) *GCSStorageInteractor {
+	endpoint := cnf.StorageEndpoint
+	if endpoint == "" {
+		endpoint = gcsDefaultEndpoint
+	}
+	/* config is shared, default bucket entry goes to copy */
+	credMap := maps.Clone(cnf.CredentialMap)
+	if credMap == nil {
+		credMap = map[string]config.StorageCredentials{}
+	}
+	if _, ok := credMap[cnf.StorageBucket]; !ok {
+		credMap[cnf.StorageBucket] = config.StorageCredentials{
+			GCSCredentialsFile: cnf.GCSCredentialsFile,
+		}
+	}
+	return &GCSStorageInteractor{
+		endpoint:      strings.TrimRight(endpoint, "/"),
+		cnf:           cnf,
+		TSToBucketMap: buildBucketMapFromCnf(cnf),
+		credentialMap: credMap,
+		clients:       map[string]*gcs.Client{},
+	}
+}
+
+// client returns client authorized for bucket.
+func (s *GCSStorageInteractor) client(bucket string) (*gcs.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cl, ok := s.clients[bucket]; ok {
+		return cl, nil
+	}
+
+	credsFile := s.cnf.GCSCredentialsFile
+	if creds, ok := s.credentialMap[bucket]; ok && creds.GCSCredentialsFile != "" {
+		credsFile = creds.GCSCredentialsFile
+	}
+
+	ctx := context.Background()
+	opts := []option.ClientOption{}
+	if s.endpoint != gcsDefaultEndpoint {
+		opts = append(opts, option.WithEndpoint(s.endpoint+"/storage/v1/"))
+	}
+	if credsFile != "" {
+		opts = append(opts, option.WithAuthCredentialsFile(option.ServiceAccount, credsFile))
+	}
+
+	cl, err := gcs.NewClient(ctx, opts...)
+	if err != nil && credsFile == "" && s.cnf.StorageEndpoint != "" {
+		/* emulators do not require authorization */
+		ylogger.Zero.Debug().Err(err).Str("bucket", bucket).Msg("no gcs credentials found, using anonymous access")
+		cl, err = gcs.NewClient(ctx, append(opts, option.WithoutAuthentication())...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.clients[bucket] = cl
+	return cl, nil
+}
+
+func (s *GCSStorageInteractor) object(bucket, objectPath string) (*gcs.ObjectHandle, error) {
+	cl, err := s.client(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Bucket(bucket).Object(objectPath), nil
+}
+
+func (s *GCSStorageInteractor) objectPath(name string) string {
+	return strings.TrimLeft(path.Join(s.cnf.StoragePrefix, name), "/")
+}
+
+func (s *GCSStorageInteractor) bucketForSettings(setts []settings.StorageSettings) (string, error) {
+	tableSpace := ResolveStorageSetting(setts, message.TableSpaceSetting, tablespace.DefaultTableSpace)
+
+	bucket, ok := s.TSToBucketMap[tableSpace]
+	if !ok {
+		err := fmt.Errorf("failed to match tablespace %s to gcs bucket", tableSpace)
+		ylogger.Zero.Err(err).Str("tablespace", tableSpace).Msg("failed to match tablespace to gcs bucket")
+		return "", err
+	}
+	return bucket, nil
+}
+
+// ListBuckets implements StorageInteractor.
+func (s *GCSStorageInteractor) ListBuckets() []string {
+	keys := []string{}
+
+	for _, v := range s.TSToBucketMap {
+		keys = append(keys, v)
+	}
+	return keys
+}
+
+// DefaultBucket implements StorageInteractor.
+func (s *GCSStorageInteractor) DefaultBucket() string {
+	return s.cnf.StorageBucket
+}
+
+func (s *GCSStorageInteractor) CatFileFromStorage(name string, offset int64, setts []settings.StorageSettings) (io.ReadCloser, error) {
+	timeStart := time.Now()
+	objectPath := s.objectPath(name)
+
+	bucket, err := s.bucketForSettings(setts)
+	if err != nil {
+		return nil, err
+	}
+
+	ylogger.Zero.Debug().Str("key", objectPath).Int64("offset", offset).Str("bucket", bucket).Msg("requesting external storage")
+
+	obj, err := s.object(bucket, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	/* compressed objects are stored as is, never let client decompress them */
+	r, err := obj.ReadCompressed(true).NewRangeReader(context.Background(), offset, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	getTime := time.Since(timeStart).Nanoseconds()
+	objLen := 1.0
+	if r.Remain() > 0 {
+		objLen = float64(r.Remain())
+	}
+	metrics.StoreLatencyAndSizeInfo("GCS_GET", objLen, float64(getTime))
+	return r, nil
+}
+
+// StatObject implements StorageStater.
+func (s *GCSStorageInteractor) StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	bucket, err := s.bucketForSettings(setts)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.object(bucket, s.objectPath(name))
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := obj.Attrs(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return gcsObjectInfo("/"+strings.TrimLeft(name, "/"), attrs), nil
+}
+
+func gcsObjectInfo(p string, attrs *gcs.ObjectAttrs) *object.ObjectInfo {
+	/* composite objects have no md5 */
+	etag := base64.StdEncoding.EncodeToString(attrs.MD5)
+	if len(attrs.MD5) == 0 {
+		etag = base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, attrs.CRC32C))
+	}
+	return &object.ObjectInfo{
+		Path:    p,
+		Size:    attrs.Size,
+		LastMod: attrs.Updated,
+		ETag:    etag,
+	}
+}
+
+// upload writes r to w and commits the object. The upload is cancelled if
+// reading r fails, so partial content is never committed.
+func upload(ctx context.Context, cancel context.CancelFunc, w *gcs.Writer, r io.Reader) (int64, error) {
+	n, err := io.Copy(w, r)
+	if err != nil {
+		cancel()
+		_ = w.Close()
+		return n, err
+	}
+	return n, w.Close()
+}
+
+func (s *GCSStorageInteractor) PutFileToDest(name string, r io.Reader, settings []settings.StorageSettings) error {
+	timeStart := time.Now()
+	objectPath := s.objectPath(name)
+
+	storageClass := ResolveStorageSetting(settings, message.StorageClassSetting, "STANDARD")
+	multipartChunkSizeStr := ResolveStorageSetting(settings, message.MultipartChunkSize, "16777216")
+	multipartChunkSize, err := strconv.ParseInt(multipartChunkSizeStr, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := s.bucketForSettings(settings)
+	if err != nil {
+		return err
+	}
+	obj, err := s.object(bucket, objectPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	/* the whole object is rewritten, so retrying the upload is safe */
+	w := obj.Retryer(gcs.WithPolicy(gcs.RetryAlways)).NewWriter(ctx)
+	w.ChunkSize = int(max(multipartChunkSize, 1))
+	w.StorageClass = storageClass
+
+	putLen, err := upload(ctx, cancel, w, r)
+
+	putTime := time.Since(timeStart).Nanoseconds()
+	metrics.StoreLatencyAndSizeInfo("GCS_PUT", float64(putLen), float64(putTime))
+	return err
+}
+
+// PatchFile rewrites object with content starting at startOffset replaced.
+// New object is committed only if object generation was not changed meanwhile.
+func (s *GCSStorageInteractor) PatchFile(name string, r io.ReadSeeker, startOffset int64) error {
+	objectPath := s.objectPath(name)
+	bucket := s.cnf.StorageBucket
+
+	patchLen, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	obj, err := s.object(bucket, objectPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	orig, err := obj.ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = orig.Close() }()
+	if orig.Attrs.Size < startOffset {
+		return fmt.Errorf("patch offset %d is beyond end of object %s", startOffset, objectPath)
+	}
+
+	head := io.LimitReader(orig, startOffset)
+	tail := io.MultiReader(readerFunc(func([]byte) (int, error) {
+		/* skip patched range of original content */
+		if _, err := io.CopyN(io.Discard, orig, patchLen); err != nil && err != io.EOF {
+			return 0, err
+		}
+		return 0, io.EOF
+	}), orig)
+
+	ylogger.Zero.Debug().Str("key", objectPath).Int64("offset", startOffset).Int64("length", patchLen).Msg("patching gcs object")
+
+	w := obj.If(gcs.Conditions{GenerationMatch: orig.Attrs.Generation}).NewWriter(ctx)
+	_, err = upload(ctx, cancel, w, io.MultiReader(head, r, tail))
+	return err
+}
+
+func (s *GCSStorageInteractor) ListPath(prefix string, _ bool, settings []settings.StorageSettings) ([]*object.ObjectInfo, error) {
+	bucket, err := s.bucketForSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.ListBucketPath(bucket, prefix, false)
+}
+
+func (s *GCSStorageInteractor) ListBucketPath(bucket, prefix string, _ bool) ([]*object.ObjectInfo, error) {
+	prefix = strings.TrimLeft(path.Join(s.cnf.StoragePrefix, prefix), "/")
+	metas := make([]*object.ObjectInfo, 0)
+
+	ylogger.Zero.Debug().Str("bucket", bucket).Str("prefix", prefix).Msg("listing bucket")
+
+	cl, err := s.client(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &gcs.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated", "MD5", "CRC32C"}); err != nil {
+		return nil, err
+	}
+	it := cl.Bucket(bucket).Objects(context.Background(), query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			ylogger.Zero.Warn().Err(err).Msg("failed to list prefix")
+			return nil, err
+		}
+
+		cPath, ok := strings.CutPrefix(attrs.Name, strings.TrimLeft(s.cnf.StoragePrefix, "/"))
+		if !ok {
+			ylogger.Zero.Debug().Str("path", attrs.Name).Msg("skipping file")
+			continue
+		}
+		if !strings.HasPrefix(cPath, "/") {
+			cPath = "/" + cPath
+		}
+		metas = append(metas, gcsObjectInfo(cPath, attrs))
+	}
+
+	return metas, nil
+}
+
+// ListFailedMultipartUploads implements StorageInteractor. Unfinished
+// resumable upload sessions are not listable and expire in a week.
+func (s *GCSStorageInteractor) ListFailedMultipartUploads(string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+// AbortMultipartUpload implements StorageInteractor, see ListFailedMultipartUploads.
+func (s *GCSStorageInteractor) AbortMultipartUpload(string, string, string) error {
+	return nil
+}
+
+func (s *GCSStorageInteractor) DeleteObject(bucket, key string) error {
+	if !strings.HasPrefix(key, s.cnf.StoragePrefix) {
+		key = path.Join(s.cnf.StoragePrefix, key)
+	}
+	key = strings.TrimLeft(key, "/")
+
+	obj, err := s.object(bucket, key)
+	if err != nil {
+		return err
+	}
+	err = obj.Delete(context.Background())
+	if err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+		ylogger.Zero.Err(err).Msg("failed to delete old object")
+		return err
+	}
+	ylogger.Zero.Debug().Str("bucket", bucket).Str("path", key).Msg("deleted object")
+	return nil
+}
+
+// rewrite copies object inside GCS. Client continues rewrite calls of large
+// objects until copy is done.
+func (s *GCSStorageInteractor) rewrite(from, to, fromStorageBucket, toStorageBucket string) error {
+	ylogger.Zero.Debug().Str("to", to).Str("from", from).Str("bucket-from", fromStorageBucket).Msg("requesting rewrite")
+
+	/* destination bucket credentials must be able to read source */
+	cl, err := s.client(toStorageBucket)
+	if err != nil {
+		return err
+	}
+	src := cl.Bucket(fromStorageBucket).Object(from)
+	dst := cl.Bucket(toStorageBucket).Object(to)
+	if _, err := dst.CopierFrom(src).Run(context.Background()); err != nil {
+		return err
+	}
+
+	ylogger.Zero.Debug().Str("path-from", from).Str("path-to", to).Msg("copied object")
+	return nil
+}
+
+func (s *GCSStorageInteractor) MoveObject(bucket string, from string, to string) error {
+	if from == to {
+		return nil
+	}
+	if err := s.rewrite(s.objectPath(from), s.objectPath(to), bucket, bucket); err != nil {
+		return err
+	}
+	return s.DeleteObject(bucket, from)
+}
+
+func (s *GCSStorageInteractor) CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket string) error {
+	return s.rewrite(
+		strings.TrimLeft(path.Join(fromStoragePrefix, from), "/"),
+		s.objectPath(to),
+		fromStorageBucket,
+		toStorageBucket)
+}
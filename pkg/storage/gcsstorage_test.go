@@ -0,0 +1,143 @@
+package storage_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+// fakeGCSStorage returns gcs storage backed by in-process fake-gcs-server.
+func fakeGCSStorage(t *testing.T, buckets ...string) storage.StorageInteractor {
+	srv, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme: "http",
+		Host:   "127.0.0.1",
+		/* reads go to the endpoint host */
+		PublicHost: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	t.Cleanup(srv.Stop)
+
+	for _, bucket := range buckets {
+		srv.CreateBucket(bucket)
+	}
+
+	s, err := storage.NewStorage(&config.Storage{
+		StorageType:     "gcs",
+		StorageEndpoint: srv.URL(),
+		StorageBucket:   buckets[0],
+		StoragePrefix:   "prefix/",
+		TablespaceMap: map[string]string{
+			"pg_default": buckets[0],
+			"ts1":        buckets[1],
+		},
+	}, "")
+	require.NoError(t, err)
+	return s
+}
+
+func TestGCSStorage(t *testing.T) {
+	bucket := "yproxy-test"
+	tsBucket := bucket + "-ts1"
+	s := fakeGCSStorage(t, bucket, tsBucket)
+
+	/* three resumable upload chunks, last one partial */
+	content := bytes.Repeat([]byte("0123456789"), 60*1024)
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader(content), []settings.StorageSettings{
+		{Name: message.MultipartChunkSize, Value: "262144"},
+	}))
+
+	t.Run("ranged read", func(t *testing.T) {
+		r, err := s.CatFileFromStorage("seg/obj", 300000, nil)
+		require.NoError(t, err)
+		defer func() { _ = r.Close() }()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, content[300000:], data)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		objs, err := s.ListPath("seg/", false, nil)
+		require.NoError(t, err)
+		require.Len(t, objs, 1)
+		require.Equal(t, "/seg/obj", objs[0].Path)
+		require.Equal(t, int64(len(content)), objs[0].Size)
+		require.False(t, objs[0].LastMod.IsZero())
+		require.NotEmpty(t, objs[0].ETag)
+	})
+
+	t.Run("tablespace routing", func(t *testing.T) {
+		setts := []settings.StorageSettings{{Name: message.TableSpaceSetting, Value: "ts1"}}
+		require.NoError(t, s.PutFileToDest("seg/ts", bytes.NewReader([]byte("ts content")), setts))
+
+		objs, err := s.ListBucketPath(tsBucket, "seg/", false)
+		require.NoError(t, err)
+		require.Len(t, objs, 1)
+		require.Equal(t, "/seg/ts", objs[0].Path)
+
+		objs, err = s.ListPath("seg/", false, nil)
+		require.NoError(t, err)
+		require.Len(t, objs, 1)
+
+		require.NoError(t, s.DeleteObject(tsBucket, "seg/ts"))
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		require.NoError(t, s.PatchFile("seg/obj", bytes.NewReader([]byte("abc")), 10))
+
+		r, err := s.CatFileFromStorage("seg/obj", 0, nil)
+		require.NoError(t, err)
+		defer func() { _ = r.Close() }()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		expected := append([]byte{}, content...)
+		copy(expected[10:], "abc")
+		require.Equal(t, expected, data)
+	})
+
+	t.Run("copy and move", func(t *testing.T) {
+		require.NoError(t, s.CopyObject("seg/obj", "seg/copy", "prefix/", bucket, bucket))
+		require.NoError(t, s.MoveObject(bucket, "seg/copy", "seg/moved"))
+
+		objs, err := s.ListPath("seg/", false, nil)
+		require.NoError(t, err)
+		paths := []string{}
+		for _, o := range objs {
+			paths = append(paths, o.Path)
+		}
+		require.ElementsMatch(t, []string{"/seg/obj", "/seg/moved"}, paths)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteObject(bucket, "seg/obj"))
+		require.NoError(t, s.DeleteObject(bucket, "seg/moved"))
+		/* deleting missing object is not an error */
+		require.NoError(t, s.DeleteObject(bucket, "seg/moved"))
+
+		objs, err := s.ListPath("seg/", false, nil)
+		require.NoError(t, err)
+		require.Empty(t, objs)
+	})
+}
+
+func TestGCSStorageKeepsCredentialMap(t *testing.T) {
+	cnf := &config.Storage{
+		StorageType:   "gcs",
+		StorageBucket: "default",
+		CredentialMap: map[string]config.StorageCredentials{
+			"other": {GCSCredentialsFile: "/etc/other.json"},
+		},
+	}
+	storage.NewGCSStorageInteractor(cnf)
+	require.Equal(t, map[string]config.StorageCredentials{
+		"other": {GCSCredentialsFile: "/etc/other.json"},
+	}, cnf.CredentialMap)
+}
@@ -0,0 +1,70 @@
+package storage_test
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+// testStorage counts requests reaching underlying storage and fails uploads
+// while broken is set.
+type testStorage struct {
+	storage.StorageInteractor
+	cats   atomic.Int64
+	stats  atomic.Int64
+	lists  atomic.Int64
+	broken atomic.Bool
+}
+
+func (s *testStorage) CatFileFromStorage(name string, offset int64, setts []settings.StorageSettings) (io.ReadCloser, error) {
+	s.cats.Add(1)
+	return s.StorageInteractor.CatFileFromStorage(name, offset, setts)
+}
+
+func (s *testStorage) StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	s.stats.Add(1)
+	return s.StorageInteractor.(storage.StorageStater).StatObject(name, setts)
+}
+
+func (s *testStorage) ListBucketPath(bucket, prefix string, useCache bool) ([]*object.ObjectInfo, error) {
+	s.lists.Add(1)
+	return s.StorageInteractor.ListBucketPath(bucket, prefix, useCache)
+}
+
+func (s *testStorage) PutFileToDest(name string, r io.Reader, setts []settings.StorageSettings) error {
+	if s.broken.Load() {
+		return fmt.Errorf("storage is unavailable")
+	}
+	return s.StorageInteractor.PutFileToDest(name, r, setts)
+}
+
+// newFSTestStorage returns fs storage keeping objects in dir, new temporary
+// one if empty, and its config. Bucket is named "bucket".
+func newFSTestStorage(t *testing.T, dir string) (*testStorage, *config.Storage) {
+	t.Helper()
+	if dir == "" {
+		dir = t.TempDir()
+	}
+	cnf := &config.Storage{StorageType: "fs", StorageBucket: "bucket", StoragePrefix: dir + "/"}
+	s, err := storage.NewStorage(cnf, "")
+	require.NoError(t, err)
+	return &testStorage{StorageInteractor: s}, cnf
+}
+
+func catAll(t *testing.T, s storage.StorageReader, name string) string {
+	t.Helper()
+	r, err := s.CatFileFromStorage(name, 0, nil)
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
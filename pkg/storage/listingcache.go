@@ -0,0 +1,458 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/tablespace"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+var (
+	listingObjects  = []byte("objects")
+	listingPrefixes = []byte("prefixes")
+
+	listingCachesMu sync.Mutex
+	listingCaches   = map[string]*ListingCache{}
+)
+
+/* how long other process waits for database lock before listing uncached */
+const listingCacheLockTimeout = time.Second
+
+// ListingCache stores bucket listings in embedded database. Every storage
+// bucket has objects keyed by path and refresh time of each listed prefix.
+// Prefix is served from cache if it or any of its parents was refreshed
+// within TTL. Database file is locked by process which opened it.
+type ListingCache struct {
+	db  *bolt.DB
+	ttl atomic.Int64
+}
+
+// moveLegacyListingCache renames JSON listing cache of older versions
+// kept at the same path, so database can be created there.
+func moveLegacyListingCache(dbPath string) error {
+	f, err := os.Open(dbPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	first := make([]byte, 1)
+	_, err = f.Read(first)
+	_ = f.Close()
+	if errors.Is(err, io.EOF) || (err == nil && first[0] != '{') {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	legacyPath := dbPath + ".legacy"
+	ylogger.Zero.Warn().Str("path", dbPath).Str("moved to", legacyPath).Msg("moving aside listing cache of older version")
+	return os.Rename(dbPath, legacyPath)
+}
+
+// OpenListingCache returns listing cache of database file, shared within process.
+func OpenListingCache(dbPath string, ttl time.Duration) (*ListingCache, error) {
+	listingCachesMu.Lock()
+	defer listingCachesMu.Unlock()
+
+	if c, ok := listingCaches[dbPath]; ok {
+		/* TTL may be changed by config reload */
+		c.ttl.Store(int64(ttl))
+		return c, nil
+	}
+	if err := moveLegacyListingCache(dbPath); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: listingCacheLockTimeout})
+	if err != nil {
+		return nil, err
+	}
+	c := &ListingCache{db: db}
+	c.ttl.Store(int64(ttl))
+	listingCaches[dbPath] = c
+	return c, nil
+}
+
+// listingPrefix normalizes prefix to object key form. Storages match
+// prefixes as strings, so trailing slash is kept: "seg1" covers "seg10/",
+// "seg1/" does not.
+func listingPrefix(prefix string) string {
+	return "/" + strings.TrimLeft(prefix, "/")
+}
+
+func encodeTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+func decodeTime(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
+
+// covered returns latest refresh time of prefixes covering p, that is
+// refreshed prefixes which are string prefixes of p.
+func covered(prefixes *bolt.Bucket, p string) (time.Time, bool) {
+	var latest time.Time
+	found := false
+	for i := len(p); i > 0; i-- {
+		v := prefixes.Get([]byte(p[:i]))
+		if v == nil {
+			continue
+		}
+		if t := decodeTime(v); !found || t.After(latest) {
+			latest = t
+		}
+		found = true
+	}
+	return latest, found
+}
+
+func keysWithPrefix(b *bolt.Bucket, prefix string) [][]byte {
+	keys := [][]byte{}
+	c := b.Cursor()
+	for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+	return keys
+}
+
+// Lookup returns cached listing of prefix, if it is fresh.
+func (c *ListingCache) Lookup(id, prefix string) ([]*object.ObjectInfo, bool) {
+	prefix = listingPrefix(prefix)
+	var objs []*object.ObjectInfo
+	fresh := false
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(id))
+		if root == nil {
+			return nil
+		}
+		refreshed, ok := covered(root.Bucket(listingPrefixes), prefix)
+		if !ok || time.Since(refreshed) > time.Duration(c.ttl.Load()) {
+			return nil
+		}
+		fresh = true
+
+		objs = []*object.ObjectInfo{}
+		cur := root.Bucket(listingObjects).Cursor()
+		for k, v := cur.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = cur.Next() {
+			o := &object.ObjectInfo{}
+			if err := json.Unmarshal(v, o); err != nil {
+				return err
+			}
+			objs = append(objs, o)
+		}
+		return nil
+	})
+	if err != nil {
+		ylogger.Zero.Warn().Err(err).Str("storage", id).Msg("failed to read listing cache")
+		return nil, false
+	}
+	return objs, fresh
+}
+
+// Refresh replaces cached objects under prefix with fresh listing.
+func (c *ListingCache) Refresh(id, prefix string, objs []*object.ObjectInfo) error {
+	prefix = listingPrefix(prefix)
+	return c.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		objects, err := root.CreateBucketIfNotExists(listingObjects)
+		if err != nil {
+			return err
+		}
+		prefixes, err := root.CreateBucketIfNotExists(listingPrefixes)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keysWithPrefix(objects, prefix) {
+			if err := objects.Delete(k); err != nil {
+				return err
+			}
+		}
+		for _, o := range objs {
+			v, err := json.Marshal(o)
+			if err != nil {
+				return err
+			}
+			if err := objects.Put([]byte(o.Path), v); err != nil {
+				return err
+			}
+		}
+
+		/* nested prefixes are covered by this refresh now */
+		for _, k := range keysWithPrefix(prefixes, prefix) {
+			if err := prefixes.Delete(k); err != nil {
+				return err
+			}
+		}
+		return prefixes.Put([]byte(prefix), encodeTime(time.Now()))
+	})
+}
+
+func (c *ListingCache) update(id string, f func(objects, prefixes *bolt.Bucket) error) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(id))
+		if root == nil {
+			/* nothing cached for storage */
+			return nil
+		}
+		return f(root.Bucket(listingObjects), root.Bucket(listingPrefixes))
+	})
+}
+
+// Upsert records object created or changed by yproxy. Objects outside
+// of cached prefixes are not recorded.
+func (c *ListingCache) Upsert(id string, o *object.ObjectInfo) error {
+	return c.update(id, func(objects, prefixes *bolt.Bucket) error {
+		if _, ok := covered(prefixes, o.Path); !ok {
+			return nil
+		}
+		v, err := json.Marshal(o)
+		if err != nil {
+			return err
+		}
+		return objects.Put([]byte(o.Path), v)
+	})
+}
+
+// Remove forgets deleted object.
+func (c *ListingCache) Remove(id, p string) error {
+	return c.update(id, func(objects, _ *bolt.Bucket) error {
+		return objects.Delete([]byte(p))
+	})
+}
+
+// Move renames cached object.
+func (c *ListingCache) Move(id, from, to string) error {
+	return c.update(id, func(objects, prefixes *bolt.Bucket) error {
+		v := objects.Get([]byte(from))
+		if v == nil {
+			return nil
+		}
+		o := &object.ObjectInfo{}
+		if err := json.Unmarshal(v, o); err != nil {
+			return err
+		}
+		if err := objects.Delete([]byte(from)); err != nil {
+			return err
+		}
+		if _, ok := covered(prefixes, to); !ok {
+			return nil
+		}
+		o.Path = to
+		o.LastMod = time.Now()
+		v, err := json.Marshal(o)
+		if err != nil {
+			return err
+		}
+		return objects.Put([]byte(to), v)
+	})
+}
+
+// Invalidate marks prefixes covering object as stale, so they are
+// listed from storage next time.
+func (c *ListingCache) Invalidate(id, p string) error {
+	return c.update(id, func(_, prefixes *bolt.Bucket) error {
+		for i := len(p); i > 0; i-- {
+			if err := prefixes.Delete([]byte(p[:i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// ListingCachedStorageInteractor serves listings requested with useCache
+// from ListingCache and keeps it updated on changes made through it.
+type ListingCachedStorageInteractor struct {
+	StorageInteractor
+
+	cache *ListingCache
+	cnf   *config.Storage
+
+	TSToBucketMap map[string]string
+}
+
+var _ StorageInteractor = &ListingCachedStorageInteractor{}
+
+type listingCachedStaterStorage struct {
+	*ListingCachedStorageInteractor
+	stater StorageStater
+}
+
+// StatObject implements StorageStater.
+func (s *listingCachedStaterStorage) StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	return s.stater.StatObject(name, setts)
+}
+
+// NewListingCachedStorageInteractor returns storage serving listings from
+// cache. Storages able to stat objects stay so.
+func NewListingCachedStorageInteractor(s StorageInteractor, cache *ListingCache, cnf *config.Storage) StorageInteractor {
+	ls := &ListingCachedStorageInteractor{
+		StorageInteractor: s,
+		cache:             cache,
+		cnf:               cnf,
+		TSToBucketMap:     buildBucketMapFromCnf(cnf),
+	}
+	if st, ok := s.(StorageStater); ok {
+		return &listingCachedStaterStorage{ListingCachedStorageInteractor: ls, stater: st}
+	}
+	return ls
+}
+
+func (s *ListingCachedStorageInteractor) id(bucket string) string {
+	id, _ := url.JoinPath(s.cnf.StorageEndpoint, bucket, s.cnf.StoragePrefix)
+	return id
+}
+
+func (s *ListingCachedStorageInteractor) bucketFor(setts []settings.StorageSettings) string {
+	return s.TSToBucketMap[ResolveStorageSetting(setts, message.TableSpaceSetting, tablespace.DefaultTableSpace)]
+}
+
+func objectKey(name string) string {
+	return "/" + strings.TrimLeft(name, "/")
+}
+
+func (s *ListingCachedStorageInteractor) ListPath(prefix string, useCache bool, setts []settings.StorageSettings) ([]*object.ObjectInfo, error) {
+	bucket := s.bucketFor(setts)
+	if !useCache || bucket == "" {
+		return s.StorageInteractor.ListPath(prefix, false, setts)
+	}
+	return s.ListBucketPath(bucket, prefix, true)
+}
+
+func (s *ListingCachedStorageInteractor) ListBucketPath(bucket, prefix string, useCache bool) ([]*object.ObjectInfo, error) {
+	if !useCache {
+		return s.StorageInteractor.ListBucketPath(bucket, prefix, false)
+	}
+	if objs, ok := s.cache.Lookup(s.id(bucket), prefix); ok {
+		ylogger.Zero.Debug().Str("bucket", bucket).Str("prefix", prefix).Int("objects", len(objs)).Msg("listing served from cache")
+		return objs, nil
+	}
+
+	objs, err := s.StorageInteractor.ListBucketPath(bucket, prefix, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cache.Refresh(s.id(bucket), prefix, objs); err != nil {
+		ylogger.Zero.Warn().Err(err).Msg("failed to put objects in cache")
+	}
+	return objs, nil
+}
+
+// refreshObject records actual object state, if storage is able to report
+// it. Otherwise written object is recorded, if known, or its prefixes are
+// listed from storage next time.
+func (s *ListingCachedStorageInteractor) refreshObject(bucket, name string, setts []settings.StorageSettings, written *object.ObjectInfo) {
+	id := s.id(bucket)
+	info, err := written, error(nil)
+	if stater, ok := s.StorageInteractor.(StorageStater); ok {
+		var stated *object.ObjectInfo
+		if stated, err = stater.StatObject(name, setts); err == nil {
+			info = stated
+		} else {
+			ylogger.Zero.Debug().Err(err).Str("name", name).Msg("failed to stat object for listing cache")
+		}
+	}
+	if info != nil {
+		err = s.cache.Upsert(id, info)
+	} else {
+		err = s.cache.Invalidate(id, objectKey(name))
+	}
+	if err != nil {
+		ylogger.Zero.Warn().Err(err).Str("name", name).Msg("failed to update listing cache")
+	}
+}
+
+func (s *ListingCachedStorageInteractor) PutFileToDest(name string, r io.Reader, setts []settings.StorageSettings) error {
+	cr := &countingReader{r: r}
+	if err := s.StorageInteractor.PutFileToDest(name, cr, setts); err != nil {
+		return err
+	}
+	s.refreshObject(s.bucketFor(setts), name, setts, &object.ObjectInfo{
+		Path:    objectKey(name),
+		Size:    cr.n,
+		LastMod: time.Now(),
+	})
+	return nil
+}
+
+func (s *ListingCachedStorageInteractor) PatchFile(name string, r io.ReadSeeker, startOffset int64) error {
+	defer s.refreshObject(s.cnf.StorageBucket, name, nil, nil)
+	return s.StorageInteractor.PatchFile(name, r, startOffset)
+}
+
+func (s *ListingCachedStorageInteractor) DeleteObject(bucket, key string) error {
+	if err := s.StorageInteractor.DeleteObject(bucket, key); err != nil {
+		return err
+	}
+	if err := s.cache.Remove(s.id(bucket), objectKey(objectName(s.cnf.StoragePrefix, key))); err != nil {
+		ylogger.Zero.Warn().Err(err).Str("key", key).Msg("failed to update listing cache")
+	}
+	return nil
+}
+
+func (s *ListingCachedStorageInteractor) MoveObject(bucket string, from string, to string) error {
+	if err := s.StorageInteractor.MoveObject(bucket, from, to); err != nil {
+		return err
+	}
+	err := s.cache.Move(s.id(bucket),
+		objectKey(objectName(s.cnf.StoragePrefix, from)),
+		objectKey(objectName(s.cnf.StoragePrefix, to)))
+	if err != nil {
+		ylogger.Zero.Warn().Err(err).Str("from", from).Str("to", to).Msg("failed to update listing cache")
+	}
+	return nil
+}
+
+func (s *ListingCachedStorageInteractor) CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket string) error {
+	if err := s.StorageInteractor.CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket); err != nil {
+		return err
+	}
+	name := objectName(s.cnf.StoragePrefix, to)
+	/* resolve tablespace of destination bucket for stat */
+	setts := []settings.StorageSettings{}
+	for ts, bucket := range s.TSToBucketMap {
+		if bucket == toStorageBucket {
+			setts = append(setts, settings.StorageSettings{Name: message.TableSpaceSetting, Value: ts})
+			break
+		}
+	}
+	s.refreshObject(toStorageBucket, name, setts, nil)
+	return nil
+}
+
+// ListStaleMultipartUploads implements StaleUploadLister.
+func (s *ListingCachedStorageInteractor) ListStaleMultipartUploads(bucket string, before time.Time) (map[string]string, error) {
+	return ListStaleMultipartUploads(s.StorageInteractor, bucket, before)
+}
@@ -0,0 +1,189 @@
+package storage_test
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+func newListingTestStorage(t *testing.T, ttl time.Duration) (storage.StorageInteractor, *testStorage, string) {
+	counting, cnf := newFSTestStorage(t, "")
+
+	cache, err := storage.OpenListingCache(path.Join(t.TempDir(), "cache.db"), ttl)
+	require.NoError(t, err)
+	return storage.NewListingCachedStorageInteractor(counting, cache, cnf), counting, cnf.StoragePrefix
+}
+
+func listPaths(t *testing.T, s storage.StorageLister, prefix string) []string {
+	objs, err := s.ListPath(prefix, true, nil)
+	require.NoError(t, err)
+	paths := []string{}
+	for _, o := range objs {
+		paths = append(paths, o.Path)
+	}
+	return paths
+}
+
+func TestCache(t *testing.T) {
+	cache, err := storage.OpenListingCache(path.Join(t.TempDir(), "cache.db"), time.Hour)
+	require.NoError(t, err)
+
+	s1 := config.Storage{StorageBucket: "some_bucket_1"}
+	s2 := config.Storage{StorageBucket: "some_bucket_2"}
+
+	abcObjects := []*object.ObjectInfo{{Path: "/abc1"}, {Path: "/abc2"}}
+	allObjects := append(abcObjects, &object.ObjectInfo{Path: "/def1"})
+	require.NoError(t, cache.Refresh(s1.ID(), "", allObjects))
+	require.NoError(t, cache.Refresh(s2.ID(), "", allObjects))
+
+	objects, ok := cache.Lookup(s1.ID(), "")
+	require.True(t, ok)
+	require.Equal(t, allObjects, objects)
+
+	objects, ok = cache.Lookup(s2.ID(), "abc")
+	require.True(t, ok)
+	require.Equal(t, abcObjects, objects)
+
+	_, ok = cache.Lookup("other", "")
+	require.False(t, ok)
+}
+
+func TestListingCachePartialRefresh(t *testing.T) {
+	cache, err := storage.OpenListingCache(path.Join(t.TempDir(), "cache.db"), time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, cache.Refresh("s", "seg1", []*object.ObjectInfo{{Path: "/seg1/a"}, {Path: "/seg1/b"}}))
+	require.NoError(t, cache.Refresh("s", "seg2", []*object.ObjectInfo{{Path: "/seg2/a"}}))
+
+	/* refresh of one prefix keeps others */
+	require.NoError(t, cache.Refresh("s", "seg1/", []*object.ObjectInfo{{Path: "/seg1/c"}}))
+	objects, ok := cache.Lookup("s", "seg1")
+	require.True(t, ok)
+	require.Equal(t, []*object.ObjectInfo{{Path: "/seg1/c"}}, objects)
+	objects, ok = cache.Lookup("s", "seg2")
+	require.True(t, ok)
+	require.Equal(t, []*object.ObjectInfo{{Path: "/seg2/a"}}, objects)
+
+	/* nested prefix is served by parent listing */
+	_, ok = cache.Lookup("s", "seg1/c")
+	require.True(t, ok)
+	_, ok = cache.Lookup("s", "")
+	require.False(t, ok)
+}
+
+func TestListingCacheSiblingPrefixes(t *testing.T) {
+	cache, err := storage.OpenListingCache(path.Join(t.TempDir(), "cache.db"), time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, cache.Refresh("s", "seg10/", []*object.ObjectInfo{{Path: "/seg10/a"}}))
+
+	/* refresh of seg1/ keeps objects and freshness of seg10/ */
+	require.NoError(t, cache.Refresh("s", "seg1/", []*object.ObjectInfo{{Path: "/seg1/a"}}))
+	objects, ok := cache.Lookup("s", "seg10/")
+	require.True(t, ok)
+	require.Equal(t, []*object.ObjectInfo{{Path: "/seg10/a"}}, objects)
+	objects, ok = cache.Lookup("s", "seg1/")
+	require.True(t, ok)
+	require.Equal(t, []*object.ObjectInfo{{Path: "/seg1/a"}}, objects)
+
+	/* seg1 is string prefix of seg10/, its listing is not cached */
+	_, ok = cache.Lookup("s", "seg1")
+	require.False(t, ok)
+
+	require.NoError(t, cache.Refresh("s", "/seg1", []*object.ObjectInfo{{Path: "/seg1/b"}, {Path: "/seg10/b"}}))
+	objects, ok = cache.Lookup("s", "seg10/")
+	require.True(t, ok)
+	require.Equal(t, []*object.ObjectInfo{{Path: "/seg10/b"}}, objects)
+	_, ok = cache.Lookup("s", "seg2/")
+	require.False(t, ok)
+}
+
+func TestListingCacheTTL(t *testing.T) {
+	s, counting, _ := newListingTestStorage(t, 50*time.Millisecond)
+
+	require.Empty(t, listPaths(t, s, "seg/"))
+	require.Empty(t, listPaths(t, s, "seg/"))
+	require.Equal(t, int64(1), counting.lists.Load())
+
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, listPaths(t, s, "seg/"))
+	require.Equal(t, int64(2), counting.lists.Load())
+
+	/* uncached listing always reaches storage */
+	_, err := s.ListBucketPath("bucket", "seg/", false)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), counting.lists.Load())
+}
+
+func TestListingCacheUpdates(t *testing.T) {
+	s, counting, dataDir := newListingTestStorage(t, time.Hour)
+
+	require.NoError(t, s.PutFileToDest("seg/old", bytes.NewReader([]byte("old")), nil))
+	require.Equal(t, []string{"/seg/old"}, listPaths(t, s, "seg/"))
+
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("data")), nil))
+	objs, err := s.ListPath("seg/", true, nil)
+	require.NoError(t, err)
+	require.Len(t, objs, 2)
+	require.Equal(t, "/seg/obj", objs[0].Path)
+	require.Equal(t, int64(4), objs[0].Size)
+
+	require.NoError(t, s.MoveObject("bucket", "seg/obj", "seg/moved"))
+	require.Equal(t, []string{"/seg/moved", "/seg/old"}, listPaths(t, s, "seg/"))
+
+	require.NoError(t, s.DeleteObject("bucket", "seg/old"))
+	require.Equal(t, []string{"/seg/moved"}, listPaths(t, s, "seg/"))
+
+	require.NoError(t, s.CopyObject("seg/moved", "seg/copy", dataDir, "bucket", "bucket"))
+	require.Equal(t, []string{"/seg/copy", "/seg/moved"}, listPaths(t, s, "seg/"))
+
+	/* all listings after the first one are served from cache */
+	require.Equal(t, int64(1), counting.lists.Load())
+}
+
+func TestListingCacheRecordsMetadata(t *testing.T) {
+	fsStorage, cnf := newFSTestStorage(t, "")
+	blocks, err := storage.NewBlockCache(t.TempDir(), 1024, 4)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = blocks.Close() })
+	listings, err := storage.OpenListingCache(path.Join(t.TempDir(), "cache.db"), time.Hour)
+	require.NoError(t, err)
+
+	/* stat of underlying storage is reachable through both caches */
+	s := storage.NewListingCachedStorageInteractor(storage.NewCachedStorageInteractor(fsStorage, blocks, cnf.StoragePrefix), listings, cnf)
+	stater, ok := s.(storage.StorageStater)
+	require.True(t, ok)
+
+	require.Empty(t, listPaths(t, s, "seg/"))
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("data")), nil))
+
+	objs, err := s.ListPath("seg/", true, nil)
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+	info, err := stater.StatObject("seg/obj", nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, objs[0].ETag)
+	require.Equal(t, info.ETag, objs[0].ETag)
+	require.Equal(t, info.Size, objs[0].Size)
+}
+
+func TestListingCacheLegacyFile(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "cache.json")
+	require.NoError(t, os.WriteFile(dbPath, []byte(`{"storage":{"objects":[]}}`), 0600))
+
+	cache, err := storage.OpenListingCache(dbPath, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, cache.Refresh("s", "seg", []*object.ObjectInfo{{Path: "/seg/a"}}))
+
+	legacy, err := os.ReadFile(dbPath + ".legacy")
+	require.NoError(t, err)
+	require.Equal(t, `{"storage":{"objects":[]}}`, string(legacy))
+}
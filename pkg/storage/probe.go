@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/yezzey-gp/yproxy/config"
+)
+
+/* listed by probe, expected to be empty or missing */
+const probePrefix = "yproxy-config-check-probe/"
+
+// Probe checks that every bucket of storage is reachable with configured
+// credentials by listing a prefix in it. Nothing is written, local tier and
+// caches are not opened: tiered storage is probed through its remote tier.
+func Probe(cnf *config.Storage, storageName string) error {
+	probeCnf := *cnf
+	probeCnf.BlockCachePath = ""
+	if probeCnf.StorageType == "tiered" {
+		probeCnf.StorageType = cnf.TierRemoteStorageType
+		if probeCnf.StorageType == "" {
+			probeCnf.StorageType = config.DefaultStorageType
+		}
+	}
+	s, err := newStorage(&probeCnf, storageName)
+	if err != nil {
+		return err
+	}
+
+	buckets := s.ListBuckets()
+	slices.Sort(buckets)
+	for _, bucket := range slices.Compact(buckets) {
+		if _, err := s.ListBucketPath(bucket, probePrefix, false); err != nil {
+			return fmt.Errorf("bucket %q: %w", bucket, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// RangeRetrier is implemented by object readers fetching object by parallel
+// range requests. RetryRange refetches the failed range starting at offset
+// only, ranges fetched ahead of it are kept.
+type RangeRetrier interface {
+	RetryRange(offset int64) error
+}
+
+/* fetches object bytes [start, end) */
+type rangeFetcher func(ctx context.Context, start, end int64) ([]byte, error)
+
+type objectRange struct {
+	start, end int64
+
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// rangeReader reads object by ranges fetched in parallel and returns them
+// in order. At most streams ranges are buffered.
+type rangeReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	fetch    rangeFetcher
+	size     int64
+	partSize int64
+	streams  int
+
+	/* next range to fetch and ranges being fetched, in order */
+	next   int64
+	ranges []*objectRange
+	pos    int64
+}
+
+var _ RangeRetrier = &rangeReader{}
+
+// newRangeReader starts fetching object of size from offset. release is
+// called when all fetches are done after reader is closed.
+func newRangeReader(fetch rangeFetcher, offset, size, partSize int64, streams int, release func()) *rangeReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &rangeReader{
+		ctx:      ctx,
+		cancel:   cancel,
+		fetch:    fetch,
+		size:     size,
+		partSize: partSize,
+		streams:  streams,
+		next:     offset,
+		pos:      offset,
+	}
+	r.fill()
+	go func() {
+		<-ctx.Done()
+		r.wg.Wait()
+		release()
+	}()
+	return r
+}
+
+func (r *rangeReader) start(rng *objectRange) {
+	rng.done = make(chan struct{})
+	rng.data, rng.err = nil, nil
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(rng.done)
+		rng.data, rng.err = r.fetch(r.ctx, rng.start, rng.end)
+		if rng.err == nil && int64(len(rng.data)) != rng.end-rng.start {
+			rng.err = fmt.Errorf("range %d-%d: got %d bytes", rng.start, rng.end, len(rng.data))
+		}
+	}()
+}
+
+func (r *rangeReader) fill() {
+	for len(r.ranges) < r.streams && r.next < r.size {
+		rng := &objectRange{start: r.next, end: min(r.next+r.partSize, r.size)}
+		r.next = rng.end
+		r.start(rng)
+		r.ranges = append(r.ranges, rng)
+	}
+}
+
+// Read implements io.Reader.
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if len(r.ranges) == 0 {
+		return 0, io.EOF
+	}
+	rng := r.ranges[0]
+	select {
+	case <-rng.done:
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
+	if rng.err != nil {
+		return 0, rng.err
+	}
+
+	n := copy(p, rng.data[r.pos-rng.start:])
+	r.pos += int64(n)
+	if r.pos == rng.end {
+		r.ranges = r.ranges[1:]
+		r.fill()
+	}
+	return n, nil
+}
+
+// RetryRange implements RangeRetrier.
+func (r *rangeReader) RetryRange(offset int64) error {
+	if r.ctx.Err() != nil {
+		return r.ctx.Err()
+	}
+	if len(r.ranges) == 0 || offset != r.pos || r.pos != r.ranges[0].start {
+		return fmt.Errorf("no failed range at offset %d", offset)
+	}
+	rng := r.ranges[0]
+	select {
+	case <-rng.done:
+	default:
+		return fmt.Errorf("range at offset %d is being fetched", offset)
+	}
+	if rng.err == nil {
+		return fmt.Errorf("range at offset %d did not fail", offset)
+	}
+	r.start(rng)
+	return nil
+}
+
+// Close implements io.Closer.
+func (r *rangeReader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -3,10 +3,9 @@ package storage
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"path"
 	"strconv"
 	"strings"
@@ -14,6 +13,7 @@ import (
 	"time"
 
 	"github.com/yezzey-gp/aws-sdk-go/aws"
+	"github.com/yezzey-gp/aws-sdk-go/aws/awserr"
 	"github.com/yezzey-gp/aws-sdk-go/service/s3"
 	"github.com/yezzey-gp/aws-sdk-go/service/s3/s3manager"
 	"github.com/yezzey-gp/yproxy/config"
@@ -85,6 +85,14 @@ func (s *S3StorageInteractor) CatFileFromStorage(name string, offset int64, sett
 		ylogger.Zero.Err(err).Msg("failed to acquire s3 session")
 		return nil, err
 	}
+
+	if s.cnf.DownloadConcurrency > 1 {
+		r, ok, err := s.catFileByRanges(sess, bucket, objectPath, offset)
+		if err != nil || ok {
+			return r, err
+		}
+	}
+
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(objectPath),
@@ -100,7 +108,112 @@ func (s *S3StorageInteractor) CatFileFromStorage(name string, offset int64, sett
 		objLen = float64(*object.ContentLength)
 	}
 	metrics.StoreLatencyAndSizeInfo("S3_GET", objLen, float64(getTime))
-	return object.Body, err
+	if err != nil {
+		return object.Body, err
+	}
+	return &objectInfoReader{ReadCloser: object.Body, info: s3ObjectInfo(name, object)}, nil
+}
+
+// s3ObjectInfo returns metadata of object from response to range request,
+// nil if it does not tell object size.
+func s3ObjectInfo(name string, out *s3.GetObjectOutput) *object.ObjectInfo {
+	/* Content-Range: bytes <start>-<end>/<size> */
+	_, size, ok := strings.Cut(aws.StringValue(out.ContentRange), "/")
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &object.ObjectInfo{
+		Path:    "/" + strings.TrimLeft(name, "/"),
+		Size:    n,
+		LastMod: aws.TimeValue(out.LastModified),
+		ETag:    aws.StringValue(out.ETag),
+	}
+}
+
+// catFileByRanges reads object from offset by parallel range requests.
+// It reports false when object is too small or no storage concurrency
+// slots are free, then object is read by single request.
+func (s *S3StorageInteractor) catFileByRanges(sess *s3.S3, bucket, objectPath string, offset int64) (io.ReadCloser, bool, error) {
+	partSize := s.cnf.DownloadPartSize
+	if partSize <= 0 {
+		partSize = config.DefaultDownloadPartSize
+	}
+
+	head, err := sess.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectPath),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	size := aws.Int64Value(head.ContentLength)
+	if size-offset <= partSize {
+		return nil, false, nil
+	}
+
+	extra, release := s.pool.AcquireStreams(s.cnf.DownloadConcurrency - 1)
+	if extra == 0 {
+		release()
+		return nil, false, nil
+	}
+	ylogger.Zero.Debug().Str("key", objectPath).Int64("offset", offset).Int("streams", extra+1).Msg("requesting external storage by ranges")
+
+	fetch := func(ctx context.Context, start, end int64) ([]byte, error) {
+		timeStart := time.Now()
+		out, err := sess.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(objectPath),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+			/* object replaced while read must not be mixed with old one */
+			IfMatch: head.ETag,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = out.Body.Close() }()
+		data, err := io.ReadAll(out.Body)
+		metrics.StoreLatencyAndSizeInfo("S3_GET", float64(len(data)), float64(time.Since(timeStart).Nanoseconds()))
+		return data, err
+	}
+	return newRangeReader(fetch, offset, size, partSize, extra+1, release), true, nil
+}
+
+// StatObject implements StorageStater.
+func (s *S3StorageInteractor) StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error) {
+	objectPath := strings.TrimLeft(path.Join(s.cnf.StoragePrefix, name), "/")
+	tableSpace := ResolveStorageSetting(setts, message.TableSpaceSetting, tablespace.DefaultTableSpace)
+
+	bucket, ok := s.TSToBucketMap[tableSpace]
+	if !ok {
+		return nil, fmt.Errorf("failed to match tablespace %s to s3 bucket", tableSpace)
+	}
+
+	cr, err := s.getCredentials(bucket)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := s.pool.GetSession(context.TODO(), &cr)
+	if err != nil {
+		ylogger.Zero.Err(err).Msg("failed to acquire s3 session")
+		return nil, err
+	}
+	out, err := sess.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectPath),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &object.ObjectInfo{
+		Path:    "/" + strings.TrimLeft(name, "/"),
+		Size:    aws.Int64Value(out.ContentLength),
+		LastMod: aws.TimeValue(out.LastModified),
+		ETag:    aws.StringValue(out.ETag),
+	}, nil
 }
 
 func (s *S3StorageInteractor) PutFileToDest(name string, r io.Reader, settings []settings.StorageSettings) error {
@@ -141,6 +254,12 @@ func (s *S3StorageInteractor) PutFileToDest(name string, r io.Reader, settings [
 		uploader.PartSize = int64(multipartChunkSize)
 		uploader.Concurrency = 1
 	})
+	if multipartUpload && s.cnf.UploadConcurrency > 1 {
+		/* uploader buffers part per stream, so memory is bounded by concurrency */
+		extra, release := s.pool.AcquireStreams(s.cnf.UploadConcurrency - 1)
+		defer release()
+		up.Concurrency += extra
+	}
 	putLen := int(multipartChunkSize)
 	if multipartUpload {
 		s.multipartUploads.Store(objectPath, true)
@@ -199,18 +318,15 @@ func (s *S3StorageInteractor) PatchFile(name string, r io.ReadSeeker, startOffse
 	ylogger.Zero.Debug().Str("key", objectPath).Str("bucket",
 		s.cnf.StorageBucket).Msg("modifying file in external storage")
 
+	/* patch is an extension of S3 API, others reject it */
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && (aerr.Code() == "NotImplemented" || aerr.Code() == "MethodNotAllowed") {
+		return fmt.Errorf("%w: %v", ErrPatchNotSupported, err)
+	}
 	return err
 }
 
-func (s *S3StorageInteractor) ListPath(prefix string, useCache bool, settings []settings.StorageSettings) ([]*object.ObjectInfo, error) {
-	if useCache {
-		objectMetas, err := readCache(*s.cnf, prefix)
-		if err == nil {
-			return objectMetas, nil
-		}
-		ylogger.Zero.Debug().Msg("cache was not found, listing from source bucket")
-	}
-
+func (s *S3StorageInteractor) ListPath(prefix string, _ bool, settings []settings.StorageSettings) ([]*object.ObjectInfo, error) {
 	tableSpace := ResolveStorageSetting(settings, message.TableSpaceSetting, tablespace.DefaultTableSpace)
 
 	bucket, ok := s.TSToBucketMap[tableSpace]
@@ -220,10 +336,10 @@ func (s *S3StorageInteractor) ListPath(prefix string, useCache bool, settings []
 		return nil, err
 	}
 
-	return s.ListBucketPath(bucket, prefix, useCache)
+	return s.ListBucketPath(bucket, prefix, false)
 }
 
-func (s *S3StorageInteractor) ListBucketPath(bucket, prefix string, useCache bool) ([]*object.ObjectInfo, error) {
+func (s *S3StorageInteractor) ListBucketPath(bucket, prefix string, _ bool) ([]*object.ObjectInfo, error) {
 
 	cr, err := s.getCredentials(bucket)
 	if err != nil {
@@ -272,6 +388,7 @@ func (s *S3StorageInteractor) ListBucketPath(bucket, prefix string, useCache boo
 				Path:    cPath,
 				Size:    *obj.Size,
 				LastMod: *obj.LastModified,
+				ETag:    aws.StringValue(obj.ETag),
 			})
 		}
 
@@ -282,13 +399,6 @@ func (s *S3StorageInteractor) ListBucketPath(bucket, prefix string, useCache boo
 		continuationToken = out.NextContinuationToken
 	}
 
-	if useCache {
-		err = putInCache(s.cnf.ID(), metas)
-		if err != nil {
-			ylogger.Zero.Warn().Err(err).Msg("failed to put objects in cache")
-		}
-	}
-
 	return metas, nil
 }
 
@@ -358,6 +468,10 @@ func (s *S3StorageInteractor) SScopyObject(from, to, fromStoragePrefix, fromStor
 
 	from = path.Join(fromStorageBucket, from)
 
+	if sourceObject.ContentLength != nil && *sourceObject.ContentLength > maxSingleCopySize {
+		return s.multipartCopyObject(sess, from, to, toStorageBucket, *sourceObject.ContentLength, sourceObject.StorageClass)
+	}
+
 	ylogger.Zero.Debug().Str("to", to).Str("from", from).Msg("requesting server-side copy")
 
 	inp := s3.CopyObjectInput{
@@ -377,6 +491,73 @@ func (s *S3StorageInteractor) SScopyObject(from, to, fromStoragePrefix, fromStor
 	return nil
 }
 
+const (
+	/* S3 does not allow CopyObject for objects larger than 5 GB */
+	maxSingleCopySize = 5 * 1024 * 1024 * 1024
+	minCopyPartSize   = 512 * 1024 * 1024
+	maxCopyPartsCount = 10000
+)
+
+// multipartCopyObject copies large object by ranges with UploadPartCopy.
+// Source must be already prefixed with bucket name.
+func (s *S3StorageInteractor) multipartCopyObject(sess *s3.S3, from, to, toStorageBucket string, size int64, storageClass *string) error {
+	partSize := max(int64(minCopyPartSize), (size+maxCopyPartsCount-1)/maxCopyPartsCount)
+
+	ylogger.Zero.Debug().Str("to", to).Str("from", from).Int64("size", size).Int64("part size", partSize).Msg("requesting multipart server-side copy")
+
+	upload, err := sess.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(toStorageBucket),
+		Key:          aws.String(to),
+		StorageClass: storageClass,
+	})
+	if err != nil {
+		return err
+	}
+	s.multipartUploads.Store(to, true)
+	defer s.multipartUploads.Delete(to)
+
+	parts := make([]*s3.CompletedPart, 0, (size+partSize-1)/partSize)
+	for off, num := int64(0), int64(1); off < size; off, num = off+partSize, num+1 {
+		out, err := sess.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          aws.String(toStorageBucket),
+			Key:             aws.String(to),
+			CopySource:      aws.String(from),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", off, min(off+partSize, size)-1)),
+			PartNumber:      aws.Int64(num),
+			UploadId:        upload.UploadId,
+		})
+		if err != nil {
+			ylogger.Zero.Error().Str("path", to).Int64("part", num).Err(err).Msg("failed to copy object part")
+			if _, abortErr := sess.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(toStorageBucket),
+				Key:      aws.String(to),
+				UploadId: upload.UploadId,
+			}); abortErr != nil {
+				ylogger.Zero.Warn().Str("path", to).Err(abortErr).Msg("failed to abort multipart copy")
+			}
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       out.CopyPartResult.ETag,
+			PartNumber: aws.Int64(num),
+		})
+	}
+
+	_, err = sess.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(toStorageBucket),
+		Key:      aws.String(to),
+		UploadId: upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ylogger.Zero.Debug().Str("path-from", from).Str("path-to", to).Int("parts", len(parts)).Msg("copied object with multipart copy")
+	return nil
+}
+
 func (s *S3StorageInteractor) MoveObject(bucket string, from string, to string) error {
 	if from == to {
 		return nil
@@ -410,7 +591,7 @@ func (s *S3StorageInteractor) AbortMultipartUpload(bucket, key, uploadId string)
 	return err
 }
 
-func (s *S3StorageInteractor) ListFailedMultipartUploads(bucket string) (map[string]string, error) {
+func (s *S3StorageInteractor) listMultipartUploads(bucket string) ([]*s3.MultipartUpload, error) {
 	cr, err := s.getCredentials(bucket)
 	if err != nil {
 		return nil, err
@@ -439,100 +620,28 @@ func (s *S3StorageInteractor) ListFailedMultipartUploads(bucket string) (map[str
 
 		keyMarker = out.NextKeyMarker
 	}
-
-	out := make(map[string]string)
-	for _, upload := range uploads {
-		if _, ok := s.multipartUploads.Load(*upload.Key); !ok {
-			out[*upload.Key] = *upload.UploadId
-		}
-	}
-	return out, nil
+	return uploads, nil
 }
 
-type cacheEntry struct {
-	Objects []*object.ObjectInfo `json:"objects"`
-	Time    time.Time            `json:"time"`
-}
-
-func putInCache(storageId string, objs []*object.ObjectInfo) error {
-	cachePath := config.InstanceConfig().ProxyCnf.BucketCachePath
-	if cachePath == "" {
-		return fmt.Errorf("cache path is not specified")
-	}
-
-	f, err := os.OpenFile(cachePath, os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = f.Close() }()
-	content, err := io.ReadAll(f)
-	if err != nil {
-		return err
-	}
-
-	cache := map[string]cacheEntry{}
-	if len(content) != 0 {
-		if err := json.Unmarshal(content, &cache); err != nil {
-			return err
-		}
-	}
-
-	cache[storageId] = cacheEntry{
-		Objects: objs,
-		Time:    time.Now(),
-	}
-
-	content, err = json.Marshal(cache)
-	if err != nil {
-		return err
-	}
-	err = f.Truncate(0)
-	if err != nil {
-		return err
-	}
-	_, err = f.Seek(0, 0)
-	if err != nil {
-		return err
-	}
-	_, err = f.Write(content)
-	return err
+func (s *S3StorageInteractor) ListFailedMultipartUploads(bucket string) (map[string]string, error) {
+	return s.ListStaleMultipartUploads(bucket, time.Time{})
 }
 
-func readCache(cfg config.Storage, prefix string) ([]*object.ObjectInfo, error) {
-	prefix = path.Join("/", cfg.StoragePrefix, prefix)
-	cachePath := config.InstanceConfig().ProxyCnf.BucketCachePath
-	if cachePath == "" {
-		return nil, fmt.Errorf("cache path is not specified")
-	}
-
-	f, err := os.Open(cachePath)
+// ListStaleMultipartUploads implements StaleUploadLister.
+func (s *S3StorageInteractor) ListStaleMultipartUploads(bucket string, before time.Time) (map[string]string, error) {
+	uploads, err := s.listMultipartUploads(bucket)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = f.Close() }()
-	content, err := io.ReadAll(f)
-	if err != nil {
-		return nil, err
-	}
-	objs := map[string]cacheEntry{}
-	if err := json.Unmarshal(content, &objs); err != nil {
-		return nil, err
-	}
-
-	storageFiles, exists := objs[cfg.ID()]
-	if !exists {
-		return nil, fmt.Errorf("no cache for storage %s", cfg.ID())
-	}
-	if storageFiles.Time.Before(time.Now().Add(-24 * time.Hour)) {
-		return nil, fmt.Errorf("cache for storage %s has expired", cfg.ID())
-	}
 
-	res := make([]*object.ObjectInfo, 0, len(objs))
-	for _, obj := range storageFiles.Objects {
-		if strings.HasPrefix(obj.Path, prefix) {
-			res = append(res, obj)
+	out := make(map[string]string)
+	for _, upload := range uploads {
+		if !before.IsZero() && !aws.TimeValue(upload.Initiated).Before(before) {
+			continue
+		}
+		if _, ok := s.multipartUploads.Load(*upload.Key); !ok {
+			out[*upload.Key] = *upload.UploadId
 		}
 	}
-
-	return res, err
+	return out, nil
 }
@@ -1,40 +1,151 @@
-package storage
+package storage_test
 
 import (
-	"os"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	"github.com/yezzey-gp/yproxy/config"
-	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
 )
 
-func TestCache(t *testing.T) {
-	assert := assert.New(t)
+// rangeS3Server serves single object, failing first request of range
+// starting at failAt.
+type rangeS3Server struct {
+	content []byte
+	failAt  int64
+
+	mu     sync.Mutex
+	ranges []string
+	failed bool
+}
+
+func (s *rangeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/bucket/prefix/obj" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", `"etag"`)
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+		return
+	}
 
-	f, err := os.CreateTemp("", "*")
-	assert.NoError(err)
-	defer func() { _ = os.Remove(f.Name()) }()
-	defer func() { _ = f.Close() }()
+	var start, end int64
+	rng := strings.TrimPrefix(r.Header.Get("Range"), "bytes=")
+	if _, err := fmt.Sscanf(rng, "%d-%d", &start, &end); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.ranges = append(s.ranges, rng)
+	fail := start == s.failAt && !s.failed
+	s.failed = s.failed || fail
+	s.mu.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("If-Match") != `"etag"` {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.content)))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	_, _ = w.Write(s.content[start : end+1])
+}
 
-	config.InstanceConfig().ProxyCnf.BucketCachePath = f.Name()
+func TestS3ParallelRangeRead(t *testing.T) {
+	srv := &rangeS3Server{content: bytes.Repeat([]byte("0123456789"), 10), failAt: 45}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
 
-	s1 := config.Storage{StorageBucket: "some_bucket_1"}
-	s2 := config.Storage{StorageBucket: "some_bucket_2"}
+	s, err := storage.NewStorage(&config.Storage{
+		StorageType:         "s3",
+		StorageEndpoint:     ts.URL,
+		StorageRegion:       "us-east-1",
+		StorageBucket:       "bucket",
+		StoragePrefix:       "prefix/",
+		AccessKeyId:         "key",
+		SecretAccessKey:     "secret",
+		StorageConcurrency:  10,
+		DownloadConcurrency: 3,
+		DownloadPartSize:    20,
+	}, "")
+	require.NoError(t, err)
+
+	r, err := s.CatFileFromStorage("obj", 5, nil)
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+
+	/* read up to failed range */
+	data := make([]byte, 40)
+	_, err = io.ReadFull(r, data)
+	require.NoError(t, err)
+	_, err = r.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	rr, ok := r.(storage.RangeRetrier)
+	require.True(t, ok)
+	require.Error(t, rr.RetryRange(0))
+	require.NoError(t, rr.RetryRange(45))
+
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, srv.content[5:], append(data, rest...))
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	/* only failed range is fetched again */
+	assert.ElementsMatch(t, []string{"5-24", "25-44", "45-64", "65-84", "85-99", "45-64"}, srv.ranges)
+}
 
-	abcObjects := []*object.ObjectInfo{{Path: "/abc1"}, {Path: "/abc2"}}
-	allObjects := append(abcObjects, &object.ObjectInfo{Path: "/def1"})
-	err = putInCache(s1.ID(), allObjects)
-	assert.NoError(err)
+func TestS3StaleMultipartUploads(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["uploads"]; !ok || r.URL.Path != "/bucket" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<ListMultipartUploadsResult>
+  <Bucket>bucket</Bucket>
+  <IsTruncated>false</IsTruncated>
+  <Upload><Key>prefix/old</Key><UploadId>u1</UploadId><Initiated>2020-01-01T00:00:00.000Z</Initiated></Upload>
+  <Upload><Key>prefix/new</Key><UploadId>u2</UploadId><Initiated>2030-01-01T00:00:00.000Z</Initiated></Upload>
+</ListMultipartUploadsResult>`)
+	}))
+	defer ts.Close()
 
-	err = putInCache(s2.ID(), allObjects)
-	assert.NoError(err)
+	s, err := storage.NewStorage(&config.Storage{
+		StorageType:        "s3",
+		StorageEndpoint:    ts.URL,
+		StorageRegion:      "us-east-1",
+		StorageBucket:      "bucket",
+		StoragePrefix:      "prefix/",
+		AccessKeyId:        "key",
+		SecretAccessKey:    "secret",
+		StorageConcurrency: 10,
+	}, "")
+	require.NoError(t, err)
 
-	objects, err := readCache(s1, "")
-	assert.NoError(err)
-	assert.Equal(allObjects, objects)
+	uploads, err := storage.ListStaleMultipartUploads(s, "bucket", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"prefix/old": "u1"}, uploads)
 
-	objects, err = readCache(s2, "abc")
-	assert.NoError(err)
-	assert.Equal(abcObjects, objects)
+	uploads, err = s.ListFailedMultipartUploads("bucket")
+	require.NoError(t, err)
+	assert.Len(t, uploads, 2)
 }
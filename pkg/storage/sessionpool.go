@@ -30,6 +30,10 @@ import (
 type SessionPool interface {
 	GetSession(ctx context.Context, cr *config.StorageCredentials) (*s3.S3, error)
 	StorageUsedConcurrency() int
+	// AcquireStreams reserves slots of storage concurrency for up to n extra
+	// streams of parallel transfer, as many as are free now. Returned
+	// function releases them.
+	AcquireStreams(n int) (int, func())
 }
 
 type S3SessionPool struct {
@@ -44,6 +48,19 @@ func (sp *S3SessionPool) StorageUsedConcurrency() int {
 	return int(sp.usedConnections.Load())
 }
 
+// AcquireStreams implements SessionPool.
+func (sp *S3SessionPool) AcquireStreams(n int) (int, func()) {
+	reserved := 0
+	for reserved < n && sp.sem.TryAcquire(1) {
+		reserved++
+	}
+	sp.usedConnections.Add(int32(reserved))
+	return reserved, func() {
+		sp.usedConnections.Add(-int32(reserved))
+		sp.sem.Release(int64(reserved))
+	}
+}
+
 func NewSessionPool(cnf *config.Storage, poolName string) SessionPool {
 	pool := &S3SessionPool{
 		cnf: cnf,
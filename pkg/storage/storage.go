@@ -1,19 +1,26 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/yezzey-gp/yproxy/config"
 	"github.com/yezzey-gp/yproxy/pkg/object"
 	"github.com/yezzey-gp/yproxy/pkg/settings"
 	"github.com/yezzey-gp/yproxy/pkg/tablespace"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
 )
 
 type StorageReader interface {
 	CatFileFromStorage(name string, offset int64, setts []settings.StorageSettings) (io.ReadCloser, error)
 }
 
+// ErrPatchNotSupported is returned by PatchFile of storages unable to
+// modify objects in place.
+var ErrPatchNotSupported = errors.New("storage can not patch objects in place")
+
 type StorageWriter interface {
 	PutFileToDest(name string, r io.Reader, settings []settings.StorageSettings) error
 	PatchFile(name string, r io.ReadSeeker, startOffset int64) error
@@ -35,6 +42,45 @@ type StorageCopier interface {
 	CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket string) error
 }
 
+// StorageStater is implemented by storages able to return object
+// metadata without reading it.
+type StorageStater interface {
+	StatObject(name string, setts []settings.StorageSettings) (*object.ObjectInfo, error)
+}
+
+// ObjectInfoReader is implemented by object readers which learned object
+// metadata while opening it.
+type ObjectInfoReader interface {
+	// ObjectInfo returns metadata of whole object, nil if unknown.
+	ObjectInfo() *object.ObjectInfo
+}
+
+type objectInfoReader struct {
+	io.ReadCloser
+	info *object.ObjectInfo
+}
+
+func (r *objectInfoReader) ObjectInfo() *object.ObjectInfo {
+	return r.info
+}
+
+// StaleUploadLister is implemented by storages able to tell when
+// unfinished multipart uploads were started.
+type StaleUploadLister interface {
+	// ListStaleMultipartUploads returns key to upload id of unfinished
+	// uploads to bucket started before given time and not in progress.
+	ListStaleMultipartUploads(bucket string, before time.Time) (map[string]string, error)
+}
+
+// ListStaleMultipartUploads returns stale uploads of storage, none when
+// storage can not tell their age.
+func ListStaleMultipartUploads(s StorageInteractor, bucket string, before time.Time) (map[string]string, error) {
+	if l, ok := s.(StaleUploadLister); ok {
+		return l.ListStaleMultipartUploads(bucket, before)
+	}
+	return nil, nil
+}
+
 //go:generate mockgen -destination=pkg/mock/storage.go -package=mock
 type StorageInteractor interface {
 	StorageReader
@@ -48,6 +94,67 @@ type StorageInteractor interface {
 }
 
 func NewStorage(cnf *config.Storage, storageName string) (StorageInteractor, error) {
+	return NewInstanceStorage(cnf, config.InstanceConfig(), storageName)
+}
+
+// NewInstanceStorage is NewStorage taking listing cache and checksum settings
+// from instance config, which is not installed yet on reload.
+func NewInstanceStorage(cnf *config.Storage, instanceCnf *config.Instance, storageName string) (StorageInteractor, error) {
+	s, err := newListingCachedStorage(cnf, &instanceCnf.ProxyCnf, storageName)
+	if err != nil {
+		return nil, err
+	}
+	return NewChecksumStorageInteractor(s, cnf, &instanceCnf.ChecksumCnf), nil
+}
+
+// newListingCachedStorage returns storage wrapped with listing cache, if configured.
+func newListingCachedStorage(cnf *config.Storage, proxy *config.Proxy, storageName string) (StorageInteractor, error) {
+	s, err := newCachedStorage(cnf, storageName)
+	if err != nil {
+		return nil, err
+	}
+
+	cachePath := proxy.BucketCachePath
+	if cachePath == "" {
+		return s, nil
+	}
+	ttl := proxy.BucketCacheTTL
+	if ttl <= 0 {
+		ttl = config.DefaultBucketCacheTTL
+	}
+	cache, err := OpenListingCache(cachePath, ttl)
+	if err != nil {
+		ylogger.Zero.Warn().Err(err).Msg("listing cache is unavailable, listing directly from storage")
+		return s, nil
+	}
+	return NewListingCachedStorageInteractor(s, cache, cnf), nil
+}
+
+// newCachedStorage returns storage wrapped with block cache, if configured.
+func newCachedStorage(cnf *config.Storage, storageName string) (StorageInteractor, error) {
+	s, err := newStorage(cnf, storageName)
+	/* tiered storage caches its remote tier */
+	if err != nil || cnf.BlockCachePath == "" || cnf.StorageType == "tiered" {
+		return s, err
+	}
+
+	size := cnf.BlockCacheSize
+	if size <= 0 {
+		size = config.DefaultBlockCacheSize
+	}
+	blockSize := cnf.BlockCacheBlockSize
+	if blockSize <= 0 {
+		blockSize = config.DefaultBlockCacheBlockSize
+	}
+	cache, err := openSharedBlockCache(cnf.BlockCachePath, size, blockSize)
+	if err != nil {
+		ylogger.Zero.Warn().Err(err).Msg("block cache is unavailable, reading directly from storage")
+		return s, nil
+	}
+	return NewCachedStorageInteractor(s, cache, cnf.StoragePrefix), nil
+}
+
+func newStorage(cnf *config.Storage, storageName string) (StorageInteractor, error) {
 	switch cnf.StorageType {
 	case "fs":
 		return &FileStorageInteractor{
@@ -60,6 +167,12 @@ func NewStorage(cnf *config.Storage, storageName string) (StorageInteractor, err
 			TSToBucketMap: buildBucketMapFromCnf(cnf),
 			credentialMap: buildCredMapFromCnf(cnf),
 		}, nil
+	case "azblob":
+		return NewAzBlobStorageInteractor(cnf)
+	case "gcs":
+		return NewGCSStorageInteractor(cnf), nil
+	case "tiered":
+		return newTieredStorage(cnf, storageName)
 	default:
 		return nil, fmt.Errorf("wrong storage type %s", cnf.StorageType)
 	}
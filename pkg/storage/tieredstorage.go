@@ -0,0 +1,919 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/metrics"
+	"github.com/yezzey-gp/yproxy/pkg/object"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/tablespace"
+	"github.com/yezzey-gp/yproxy/pkg/ylogger"
+)
+
+/*
+ * Local tier layout:
+ *   objects/<bucket>/<object>  objects, both pending and already destaged
+ *   queue/<hash>.json          destage queue, one entry per pending object
+ *   staging/                   writes in progress
+ *   lock                       held by process owning the tier
+ *
+ * Objects of bucket with empty name live under "_", which is not a valid
+ * bucket name of any remote storage.
+ */
+const (
+	tierDataDir        = "objects"
+	tierQueueDir       = "queue"
+	tierStagingDir     = "staging"
+	tierEmptyBucketDir = "_"
+
+	tierMinBackoff = time.Second
+	tierMaxBackoff = time.Minute
+
+	tierNameLocks = 64
+)
+
+type tierQueueEntry struct {
+	/* local tier key */
+	Key      string                     `json:"key"`
+	Name     string                     `json:"name"`
+	Bucket   string                     `json:"bucket,omitempty"`
+	Settings []settings.StorageSettings `json:"settings"`
+}
+
+// tierKey returns key of object in local tier, which is also its path
+// relative to data directory.
+func tierKey(bucket, name string) string {
+	if bucket == "" {
+		bucket = tierEmptyBucketDir
+	}
+	return path.Join(bucket, name)
+}
+
+type tierObject struct {
+	size  int64
+	mtime time.Time
+	atime time.Time
+
+	/* nil when object is already destaged */
+	pending *tierQueueEntry
+}
+
+// TieredStorageInteractor acknowledges writes as soon as they are durable
+// in local directory and uploads them to remote storage in background.
+// Reads are served locally while object is present there. Destaged objects
+// are evicted in LRU order when local tier exceeds its capacity.
+type TieredStorageInteractor struct {
+	cnf *config.Storage
+
+	root   string
+	local  *FileStorageInteractor
+	remote StorageInteractor
+
+	TSToBucketMap map[string]string
+
+	/* serialize changes of single object between writers and destager, by tier key */
+	nameLocks [tierNameLocks]sync.Mutex
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	objects  map[string]*tierObject
+	pending  []string
+	queued   map[string]bool
+	inflight map[string]bool
+
+	localBytes   int64
+	pendingBytes int64
+	pendingCount int
+
+	stagingSeq atomic.Uint64
+
+	/*
+	 * Only one process may own local tier. Others (e.g. COPY reading
+	 * source cluster) see it read-only and write through to remote.
+	 */
+	lock     *os.File
+	readOnly bool
+
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+var _ StorageInteractor = &TieredStorageInteractor{}
+
+func newTieredStorage(cnf *config.Storage, storageName string) (StorageInteractor, error) {
+	if cnf.TierLocalPath == "" {
+		return nil, fmt.Errorf("tier_local_path must be configured for tiered storage")
+	}
+	remoteCnf := *cnf
+	remoteCnf.StorageType = cnf.TierRemoteStorageType
+	if remoteCnf.StorageType == "" {
+		remoteCnf.StorageType = config.DefaultStorageType
+	}
+	if remoteCnf.StorageType == "tiered" {
+		return nil, fmt.Errorf("tiered storage can not be remote tier of itself")
+	}
+	remote, err := newCachedStorage(&remoteCnf, storageName)
+	if err != nil {
+		return nil, err
+	}
+	return NewTieredStorageInteractor(cnf, remote)
+}
+
+func NewTieredStorageInteractor(cnf *config.Storage, remote StorageInteractor) (*TieredStorageInteractor, error) {
+	root := filepath.Clean(cnf.TierLocalPath)
+	for _, dir := range []string{tierDataDir, tierQueueDir, tierStagingDir} {
+		if err := os.MkdirAll(path.Join(root, dir), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &TieredStorageInteractor{
+		cnf:           cnf,
+		root:          root,
+		local:         &FileStorageInteractor{cnf: &config.Storage{StoragePrefix: root + "/"}},
+		remote:        remote,
+		TSToBucketMap: buildBucketMapFromCnf(cnf),
+		objects:       map[string]*tierObject{},
+		queued:        map[string]bool{},
+		inflight:      map[string]bool{},
+		done:          make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	lock, err := lockDir(root)
+	if err != nil {
+		ylogger.Zero.Warn().Err(err).Str("path", root).Msg("local tier is owned by another process, using it read-only")
+		s.readOnly = true
+	} else {
+		s.lock = lock
+		/* unfinished writes were never acknowledged */
+		if err := os.RemoveAll(path.Join(root, tierStagingDir)); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(path.Join(root, tierStagingDir), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.load(); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	if !s.readOnly {
+		workers := cnf.TierDestageWorkers
+		if workers <= 0 {
+			workers = config.DefaultTierDestageWorkers
+		}
+		for range workers {
+			s.wg.Add(1)
+			go s.destageLoop()
+		}
+		s.evict()
+	}
+	return s, nil
+}
+
+// load restores local tier state, pending objects are queued for destage again.
+func (s *TieredStorageInteractor) load() error {
+	dataRoot := path.Join(s.root, tierDataDir)
+	err := filepath.WalkDir(dataRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		key, err := filepath.Rel(dataRoot, p)
+		if err != nil {
+			return err
+		}
+		s.objects[key] = &tierObject{size: info.Size(), mtime: info.ModTime(), atime: info.ModTime()}
+		s.localBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(path.Join(s.root, tierQueueDir))
+	if err != nil {
+		return err
+	}
+	for _, de := range entries {
+		p := path.Join(s.root, tierQueueDir, de.Name())
+		if !strings.HasSuffix(de.Name(), ".json") {
+			if !s.readOnly {
+				_ = os.Remove(p)
+			}
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		e := &tierQueueEntry{}
+		if err := json.Unmarshal(data, e); err != nil {
+			return fmt.Errorf("corrupted destage queue entry %s: %w", p, err)
+		}
+		obj, ok := s.objects[e.Key]
+		if !ok {
+			/* object was not renamed in place before crash, write was not acknowledged */
+			ylogger.Zero.Warn().Str("name", e.Name).Str("bucket", e.Bucket).Msg("dropping destage queue entry without local object")
+			if !s.readOnly {
+				_ = os.Remove(p)
+			}
+			continue
+		}
+		obj.pending = e
+		s.pendingBytes += obj.size
+		s.pendingCount++
+		s.enqueueLocked(e.Key)
+	}
+
+	ylogger.Zero.Info().
+		Str("path", s.root).
+		Int("objects", len(s.objects)).
+		Int("pending", s.pendingCount).
+		Int64("pending bytes", s.pendingBytes).
+		Msg("loaded local tier")
+	s.updateGaugesLocked()
+	return nil
+}
+
+// Close stops destage workers. Pending objects are kept in queue.
+func (s *TieredStorageInteractor) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	if s.lock != nil {
+		return s.lock.Close()
+	}
+	return nil
+}
+
+func (s *TieredStorageInteractor) nameLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &s.nameLocks[h.Sum32()%tierNameLocks]
+}
+
+func (s *TieredStorageInteractor) dataPath(key string) string {
+	return path.Join(tierDataDir, key)
+}
+
+func (s *TieredStorageInteractor) queuePath(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return path.Join(s.root, tierQueueDir, hex.EncodeToString(h[:])+".json")
+}
+
+// bucketFor returns bucket of object with given settings, false if its
+// tablespace is not mapped to any bucket.
+func (s *TieredStorageInteractor) bucketFor(setts []settings.StorageSettings) (string, bool) {
+	bucket, ok := s.TSToBucketMap[ResolveStorageSetting(setts, message.TableSpaceSetting, tablespace.DefaultTableSpace)]
+	return bucket, ok
+}
+
+func syncPath(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *TieredStorageInteractor) writeQueueEntry(e *tierQueueEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	p := s.queuePath(e.Key)
+	tmp := p + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return err
+	}
+	return syncPath(path.Dir(p))
+}
+
+func (s *TieredStorageInteractor) enqueueLocked(key string) {
+	if s.queued[key] {
+		return
+	}
+	s.queued[key] = true
+	s.pending = append(s.pending, key)
+	s.cond.Broadcast()
+}
+
+func (s *TieredStorageInteractor) updateGaugesLocked() {
+	if s.readOnly {
+		return
+	}
+	metrics.TierPendingObjects.Set(float64(s.pendingCount))
+	metrics.TierPendingBytes.Set(float64(s.pendingBytes))
+	metrics.TierLocalBytes.Set(float64(s.localBytes))
+}
+
+// forgetLocked removes object from accounting, files are left intact.
+func (s *TieredStorageInteractor) forgetLocked(key string) *tierObject {
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil
+	}
+	delete(s.objects, key)
+	s.localBytes -= obj.size
+	if obj.pending != nil {
+		s.pendingBytes -= obj.size
+		s.pendingCount--
+	}
+	return obj
+}
+
+// drop removes local copy of object. Returns true if object was in local tier.
+func (s *TieredStorageInteractor) drop(key string) bool {
+	if s.readOnly {
+		return false
+	}
+	l := s.nameLock(key)
+	l.Lock()
+	defer l.Unlock()
+
+	s.mu.Lock()
+	obj := s.forgetLocked(key)
+	s.updateGaugesLocked()
+	s.mu.Unlock()
+	if obj == nil {
+		return false
+	}
+
+	if obj.pending != nil {
+		if err := os.Remove(s.queuePath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			ylogger.Zero.Warn().Err(err).Str("key", key).Msg("failed to remove destage queue entry")
+		}
+	}
+	if err := s.local.DeleteObject("", s.dataPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		ylogger.Zero.Warn().Err(err).Str("key", key).Msg("failed to remove object from local tier")
+	}
+	return true
+}
+
+// waitInflight waits until object upload in progress (if any) finishes,
+// so remote object may be changed without racing with destager.
+func (s *TieredStorageInteractor) waitInflight(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for slices.ContainsFunc(keys, func(key string) bool { return s.inflight[key] }) {
+		s.cond.Wait()
+	}
+}
+
+func (s *TieredStorageInteractor) CatFileFromStorage(name string, offset int64, setts []settings.StorageSettings) (io.ReadCloser, error) {
+	name = strings.TrimLeft(name, "/")
+	bucket, ok := s.bucketFor(setts)
+	key := tierKey(bucket, name)
+
+	s.mu.Lock()
+	obj, local := s.objects[key]
+	if ok && local {
+		obj.atime = time.Now()
+	}
+	s.mu.Unlock()
+
+	if ok && local {
+		r, err := s.local.CatFileFromStorage(s.dataPath(key), offset, nil)
+		if err == nil {
+			return r, nil
+		}
+		if r != nil {
+			_ = r.Close()
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		/* evicted concurrently */
+	}
+	return s.remote.CatFileFromStorage(name, offset, setts)
+}
+
+func (s *TieredStorageInteractor) PutFileToDest(name string, r io.Reader, setts []settings.StorageSettings) error {
+	name = strings.TrimLeft(name, "/")
+	bucket, ok := s.bucketFor(setts)
+	if !ok {
+		/* remote tier reports unknown tablespace */
+		return s.remote.PutFileToDest(name, r, setts)
+	}
+	key := tierKey(bucket, name)
+
+	s.mu.Lock()
+	full := s.cnf.TierCapacity > 0 && s.pendingBytes >= s.cnf.TierCapacity
+	s.mu.Unlock()
+	if s.readOnly || full {
+		/* destager can not keep up, write through */
+		ylogger.Zero.Debug().Str("name", name).Bool("read-only", s.readOnly).Msg("writing through local tier")
+		s.drop(key)
+		s.waitInflight(key)
+		return s.remote.PutFileToDest(name, r, setts)
+	}
+
+	staging := path.Join(tierStagingDir, fmt.Sprintf("%d", s.stagingSeq.Add(1)))
+	if err := s.local.PutFileToDest(staging, r, nil); err != nil {
+		_ = s.local.DeleteObject("", staging)
+		return err
+	}
+	if err := syncPath(path.Join(s.root, staging)); err != nil {
+		_ = s.local.DeleteObject("", staging)
+		return err
+	}
+	info, err := os.Stat(path.Join(s.root, staging))
+	if err != nil {
+		_ = s.local.DeleteObject("", staging)
+		return err
+	}
+
+	l := s.nameLock(key)
+	l.Lock()
+
+	e := &tierQueueEntry{Key: key, Name: name, Bucket: bucket, Settings: setts}
+	/* queue entry goes first, so object renamed in place is never lost for destager */
+	if err := s.writeQueueEntry(e); err != nil {
+		l.Unlock()
+		_ = s.local.DeleteObject("", staging)
+		return err
+	}
+	if err := s.local.MoveObject("", staging, s.dataPath(key)); err != nil {
+		l.Unlock()
+		_ = s.local.DeleteObject("", staging)
+		return err
+	}
+	if err := syncPath(path.Dir(path.Join(s.root, s.dataPath(key)))); err != nil {
+		l.Unlock()
+		return err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.forgetLocked(key)
+	s.objects[key] = &tierObject{size: info.Size(), mtime: now, atime: now, pending: e}
+	s.localBytes += info.Size()
+	s.pendingBytes += info.Size()
+	s.pendingCount++
+	s.enqueueLocked(key)
+	s.updateGaugesLocked()
+	s.mu.Unlock()
+	l.Unlock()
+
+	ylogger.Zero.Debug().Str("name", name).Str("bucket", bucket).Int64("size", info.Size()).Msg("object landed in local tier")
+
+	s.evict()
+	return nil
+}
+
+// PatchFile patches pending object locally, otherwise remote object is patched.
+// Like remote storages, it patches objects of default bucket.
+func (s *TieredStorageInteractor) PatchFile(name string, r io.ReadSeeker, startOffset int64) error {
+	name = strings.TrimLeft(name, "/")
+	key := tierKey(s.remote.DefaultBucket(), name)
+
+	if !s.readOnly {
+		l := s.nameLock(key)
+		l.Lock()
+		s.mu.Lock()
+		obj, ok := s.objects[key]
+		pending := ok && obj.pending != nil
+		s.mu.Unlock()
+
+		if pending {
+			defer l.Unlock()
+			if err := s.local.PatchFile(s.dataPath(key), r, startOffset); err != nil {
+				return err
+			}
+			info, err := os.Stat(path.Join(s.root, s.dataPath(key)))
+			if err != nil {
+				return err
+			}
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			e := &tierQueueEntry{Key: key, Name: name, Bucket: obj.pending.Bucket, Settings: obj.pending.Settings}
+			s.forgetLocked(key)
+			s.objects[key] = &tierObject{size: info.Size(), mtime: time.Now(), atime: time.Now(), pending: e}
+			s.localBytes += info.Size()
+			s.pendingBytes += info.Size()
+			s.pendingCount++
+			s.enqueueLocked(key)
+			s.updateGaugesLocked()
+			return nil
+		}
+		l.Unlock()
+	}
+
+	s.drop(key)
+	s.waitInflight(key)
+	return s.remote.PatchFile(name, r, startOffset)
+}
+
+func (s *TieredStorageInteractor) pendingMetas(bucket, prefix string) []*object.ObjectInfo {
+	prefix = strings.TrimLeft(prefix, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metas := []*object.ObjectInfo{}
+	for _, obj := range s.objects {
+		if obj.pending == nil || obj.pending.Bucket != bucket || !strings.HasPrefix(obj.pending.Name, prefix) {
+			continue
+		}
+		metas = append(metas, &object.ObjectInfo{
+			Path:    "/" + obj.pending.Name,
+			Size:    obj.size,
+			LastMod: obj.mtime,
+		})
+	}
+	return metas
+}
+
+// mergePending adds objects not destaged yet to remote listing.
+func mergePending(objs, pending []*object.ObjectInfo) []*object.ObjectInfo {
+	if len(pending) == 0 {
+		return objs
+	}
+	idx := make(map[string]int, len(objs))
+	for i, o := range objs {
+		idx[o.Path] = i
+	}
+	for _, o := range pending {
+		if i, ok := idx[o.Path]; ok {
+			objs[i] = o
+			continue
+		}
+		objs = append(objs, o)
+	}
+	return objs
+}
+
+func (s *TieredStorageInteractor) ListPath(prefix string, useCache bool, setts []settings.StorageSettings) ([]*object.ObjectInfo, error) {
+	objs, err := s.remote.ListPath(prefix, useCache, setts)
+	if err != nil {
+		return nil, err
+	}
+	bucket, _ := s.bucketFor(setts)
+	return mergePending(objs, s.pendingMetas(bucket, prefix)), nil
+}
+
+func (s *TieredStorageInteractor) ListBucketPath(bucket, prefix string, useCache bool) ([]*object.ObjectInfo, error) {
+	objs, err := s.remote.ListBucketPath(bucket, prefix, useCache)
+	if err != nil {
+		return nil, err
+	}
+	return mergePending(objs, s.pendingMetas(bucket, prefix)), nil
+}
+
+func (s *TieredStorageInteractor) ListFailedMultipartUploads(bucket string) (map[string]string, error) {
+	return s.remote.ListFailedMultipartUploads(bucket)
+}
+
+func (s *TieredStorageInteractor) AbortMultipartUpload(bucket, key, uploadId string) error {
+	return s.remote.AbortMultipartUpload(bucket, key, uploadId)
+}
+
+// ListStaleMultipartUploads implements StaleUploadLister.
+func (s *TieredStorageInteractor) ListStaleMultipartUploads(bucket string, before time.Time) (map[string]string, error) {
+	return ListStaleMultipartUploads(s.remote, bucket, before)
+}
+
+func (s *TieredStorageInteractor) DeleteObject(bucket, key string) error {
+	k := tierKey(bucket, objectName(s.cnf.StoragePrefix, key))
+	local := s.drop(k)
+	s.waitInflight(k)
+
+	err := s.remote.DeleteObject(bucket, key)
+	if local && errors.Is(err, fs.ErrNotExist) {
+		/* object was not destaged yet */
+		return nil
+	}
+	return err
+}
+
+// movePending renames pending object in local tier from key to key of
+// object toName. Returns false if object is not pending, then it is moved
+// in remote storage.
+func (s *TieredStorageInteractor) movePending(from, to, toName string) (bool, error) {
+	lf, lt := s.nameLock(from), s.nameLock(to)
+	if lf == lt {
+		lf.Lock()
+		defer lf.Unlock()
+	} else {
+		/* lock in fixed order to avoid deadlock with concurrent move */
+		first, second := lf, lt
+		if from > to {
+			first, second = lt, lf
+		}
+		first.Lock()
+		defer first.Unlock()
+		second.Lock()
+		defer second.Unlock()
+	}
+
+	s.mu.Lock()
+	obj, ok := s.objects[from]
+	s.mu.Unlock()
+	if !ok || obj.pending == nil {
+		return false, nil
+	}
+
+	e := &tierQueueEntry{Key: to, Name: toName, Bucket: obj.pending.Bucket, Settings: obj.pending.Settings}
+	if err := s.writeQueueEntry(e); err != nil {
+		return false, err
+	}
+	if err := s.local.MoveObject("", s.dataPath(from), s.dataPath(to)); err != nil {
+		return false, err
+	}
+	if err := os.Remove(s.queuePath(from)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forgetLocked(from)
+	s.forgetLocked(to)
+	s.objects[to] = &tierObject{size: obj.size, mtime: obj.mtime, atime: time.Now(), pending: e}
+	s.localBytes += obj.size
+	s.pendingBytes += obj.size
+	s.pendingCount++
+	s.enqueueLocked(to)
+	s.updateGaugesLocked()
+	return true, nil
+}
+
+func (s *TieredStorageInteractor) MoveObject(bucket string, from string, to string) error {
+	fromName, toName := objectName(s.cnf.StoragePrefix, from), objectName(s.cnf.StoragePrefix, to)
+	if fromName == toName {
+		return nil
+	}
+	fromKey, toKey := tierKey(bucket, fromName), tierKey(bucket, toName)
+
+	if !s.readOnly {
+		moved, err := s.movePending(fromKey, toKey, toName)
+		if err != nil {
+			return err
+		}
+		if moved {
+			s.waitInflight(fromKey)
+			/* remote may still hold older version of object */
+			if err := s.remote.DeleteObject(bucket, from); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				ylogger.Zero.Warn().Err(err).Str("path", from).Msg("failed to delete moved object from remote tier")
+			}
+			return nil
+		}
+	}
+
+	s.drop(fromKey)
+	s.drop(toKey)
+	s.waitInflight(fromKey, toKey)
+	return s.remote.MoveObject(bucket, from, to)
+}
+
+func (s *TieredStorageInteractor) CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket string) error {
+	toKey := tierKey(toStorageBucket, objectName(s.cnf.StoragePrefix, to))
+	s.drop(toKey)
+	s.waitInflight(toKey)
+	return s.remote.CopyObject(from, to, fromStoragePrefix, fromStorageBucket, toStorageBucket)
+}
+
+// ListBuckets implements StorageInteractor.
+func (s *TieredStorageInteractor) ListBuckets() []string {
+	return s.remote.ListBuckets()
+}
+
+// DefaultBucket implements StorageInteractor.
+func (s *TieredStorageInteractor) DefaultBucket() string {
+	return s.remote.DefaultBucket()
+}
+
+// next returns pending object which is not being uploaded by other worker.
+func (s *TieredStorageInteractor) next() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.closed {
+			return "", false
+		}
+		for i, key := range s.pending {
+			if s.inflight[key] {
+				continue
+			}
+			s.pending = slices.Delete(s.pending, i, i+1)
+			delete(s.queued, key)
+			s.inflight[key] = true
+			return key, true
+		}
+		s.cond.Wait()
+	}
+}
+
+func (s *TieredStorageInteractor) destageLoop() {
+	defer s.wg.Done()
+	for {
+		key, ok := s.next()
+		if !ok {
+			return
+		}
+		s.destage(key)
+
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.cond.Broadcast()
+		s.mu.Unlock()
+
+		s.evict()
+	}
+}
+
+func (s *TieredStorageInteractor) openPending(key string) (*tierQueueEntry, io.ReadCloser, int64, error) {
+	l := s.nameLock(key)
+	l.Lock()
+	defer l.Unlock()
+
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok || obj.pending == nil {
+		return nil, nil, 0, nil
+	}
+	r, err := s.local.CatFileFromStorage(s.dataPath(key), 0, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return obj.pending, r, obj.size, nil
+}
+
+// destage uploads object to remote storage, retrying until it succeeds,
+// object is dropped from local tier or storage is closed.
+func (s *TieredStorageInteractor) destage(key string) {
+	backoff := tierMinBackoff
+	for {
+		e, r, size, err := s.openPending(key)
+		if err != nil {
+			ylogger.Zero.Error().Err(err).Str("key", key).Msg("failed to open object for destage")
+			return
+		}
+		if e == nil {
+			return
+		}
+
+		timeStart := time.Now()
+		err = s.remote.PutFileToDest(e.Name, r, e.Settings)
+		_ = r.Close()
+		if err == nil {
+			metrics.StoreLatencyAndSizeInfo("TIER_DESTAGE", float64(size), float64(time.Since(timeStart).Nanoseconds()))
+			s.complete(key, e)
+			return
+		}
+
+		metrics.TierDestageErrors.Inc()
+		ylogger.Zero.Warn().Err(err).Str("key", key).Dur("backoff", backoff).Msg("failed to destage object, retrying")
+		select {
+		case <-s.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, tierMaxBackoff)
+	}
+}
+
+func (s *TieredStorageInteractor) complete(key string, e *tierQueueEntry) {
+	l := s.nameLock(key)
+	l.Lock()
+
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	destaged := ok && obj.pending == e
+	if destaged {
+		obj.pending = nil
+		s.pendingBytes -= obj.size
+		s.pendingCount--
+		s.updateGaugesLocked()
+	}
+	s.mu.Unlock()
+
+	if destaged {
+		if err := os.Remove(s.queuePath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			ylogger.Zero.Warn().Err(err).Str("key", key).Msg("failed to remove destage queue entry")
+		}
+	}
+	l.Unlock()
+
+	if !ok {
+		/* object was deleted or moved during upload */
+		if err := s.remote.DeleteObject(e.Bucket, e.Name); err != nil {
+			ylogger.Zero.Warn().Err(err).Str("key", key).Msg("failed to delete stale destaged object")
+		}
+		return
+	}
+	ylogger.Zero.Debug().Str("key", key).Msg("destaged object")
+}
+
+// evict removes least recently used destaged objects until local tier
+// fits its capacity. Pending objects are never evicted.
+func (s *TieredStorageInteractor) evict() {
+	if s.readOnly || s.cnf.TierCapacity <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	excess := s.localBytes - s.cnf.TierCapacity
+	if excess <= 0 {
+		s.mu.Unlock()
+		return
+	}
+	type candidate struct {
+		key   string
+		size  int64
+		atime time.Time
+	}
+	candidates := []candidate{}
+	for key, obj := range s.objects {
+		if obj.pending == nil {
+			candidates = append(candidates, candidate{key, obj.size, obj.atime})
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(candidates, func(a, b candidate) int { return a.atime.Compare(b.atime) })
+	for _, c := range candidates {
+		if excess <= 0 {
+			break
+		}
+		if s.evictObject(c.key) {
+			excess -= c.size
+		}
+	}
+}
+
+func (s *TieredStorageInteractor) evictObject(key string) bool {
+	l := s.nameLock(key)
+	l.Lock()
+	defer l.Unlock()
+
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	if !ok || obj.pending != nil {
+		s.mu.Unlock()
+		return false
+	}
+	s.forgetLocked(key)
+	s.updateGaugesLocked()
+	s.mu.Unlock()
+
+	if err := s.local.DeleteObject("", s.dataPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		ylogger.Zero.Warn().Err(err).Str("key", key).Msg("failed to evict object from local tier")
+	}
+	metrics.TierEvictions.Inc()
+	ylogger.Zero.Debug().Str("key", key).Int64("size", obj.size).Msg("evicted object from local tier")
+	return true
+}
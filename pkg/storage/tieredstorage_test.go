@@ -0,0 +1,143 @@
+package storage_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
+)
+
+func newTieredTestStorage(t *testing.T, localDir, remoteDir string, capacity int64) (*storage.TieredStorageInteractor, *testStorage) {
+	remote, _ := newFSTestStorage(t, remoteDir)
+
+	s, err := storage.NewTieredStorageInteractor(&config.Storage{
+		StorageType:   "tiered",
+		TierLocalPath: localDir,
+		TierCapacity:  capacity,
+	}, remote)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s, remote
+}
+
+func TestTieredStorageResume(t *testing.T) {
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+
+	s, remote := newTieredTestStorage(t, localDir, remoteDir, 0)
+	remote.broken.Store(true)
+
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("hot data")), nil))
+
+	/* acknowledged object is readable and listed before destage */
+	require.Equal(t, "hot data", catAll(t, s, "seg/obj"))
+	objs, err := s.ListPath("seg/", false, nil)
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+	require.Equal(t, "/seg/obj", objs[0].Path)
+	require.Equal(t, int64(len("hot data")), objs[0].Size)
+
+	require.NoError(t, s.Close())
+	_, err = os.Stat(path.Join(remoteDir, "seg/obj"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	/* restart resumes pending upload */
+	_, _ = newTieredTestStorage(t, localDir, remoteDir, 0)
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(path.Join(remoteDir, "seg/obj"))
+		return err == nil && string(data) == "hot data"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(path.Join(localDir, "queue"))
+		return err == nil && len(entries) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTieredStorageEviction(t *testing.T) {
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+
+	s, _ := newTieredTestStorage(t, localDir, remoteDir, 25)
+
+	for i := range 3 {
+		require.NoError(t, s.PutFileToDest(fmt.Sprintf("seg/obj%d", i), bytes.NewReader([]byte(fmt.Sprintf("content %d", i))), nil))
+		/* distinct access times */
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	/* least recently used object is evicted once destaged */
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path.Join(localDir, "objects", "_", "seg/obj0"))
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err := os.Stat(path.Join(localDir, "objects", "_", "seg/obj2"))
+	require.NoError(t, err)
+
+	/* evicted object is served from remote tier */
+	require.Equal(t, "content 0", catAll(t, s, "seg/obj0"))
+}
+
+func TestTieredStorageDeletePending(t *testing.T) {
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+
+	s, remote := newTieredTestStorage(t, localDir, remoteDir, 0)
+	remote.broken.Store(true)
+
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("hot data")), nil))
+	require.NoError(t, s.MoveObject("", "seg/obj", "seg/moved"))
+	require.Equal(t, "hot data", catAll(t, s, "seg/moved"))
+
+	require.NoError(t, s.DeleteObject("", "seg/moved"))
+
+	objs, err := s.ListPath("seg/", false, nil)
+	require.NoError(t, err)
+	require.Empty(t, objs)
+
+	entries, err := os.ReadDir(path.Join(localDir, "queue"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestTieredStorageBuckets(t *testing.T) {
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+
+	remote, _ := newFSTestStorage(t, remoteDir)
+	remote.broken.Store(true)
+
+	s, err := storage.NewTieredStorageInteractor(&config.Storage{
+		StorageType:   "tiered",
+		TierLocalPath: localDir,
+		TablespaceMap: map[string]string{"ts1": "bucket1"},
+	}, remote)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	ts1 := []settings.StorageSettings{{Name: message.TableSpaceSetting, Value: "ts1"}}
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("default")), nil))
+	require.NoError(t, s.PutFileToDest("seg/obj", bytes.NewReader([]byte("tablespace")), ts1))
+
+	/* same name in different buckets are different objects */
+	require.Equal(t, "default", catAll(t, s, "seg/obj"))
+	r, err := s.CatFileFromStorage("seg/obj", 0, ts1)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	_ = r.Close()
+	require.Equal(t, "tablespace", string(data))
+
+	objs, err := s.ListBucketPath("bucket1", "seg/", false)
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+	require.Equal(t, int64(len("tablespace")), objs[0].Size)
+
+	require.NoError(t, s.DeleteObject("bucket1", "seg/obj"))
+	require.Equal(t, "default", catAll(t, s, "seg/obj"))
+}
@@ -1,6 +1,13 @@
 package storage
 
-import "github.com/yezzey-gp/yproxy/pkg/settings"
+import (
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+)
 
 func ResolveStorageSetting(settings []settings.StorageSettings, name, defaultVal string) string {
 
@@ -12,3 +19,29 @@ func ResolveStorageSetting(settings []settings.StorageSettings, name, defaultVal
 
 	return defaultVal
 }
+
+// objectName maps storage key, which may include storage prefix, to object name.
+func objectName(storagePrefix, key string) string {
+	key = strings.TrimLeft(key, "/")
+	if storagePrefix != "" {
+		key = strings.TrimPrefix(key, strings.TrimLeft(storagePrefix, "/"))
+	}
+	return strings.TrimLeft(key, "/")
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// lockDir takes exclusive lock of directory owned by single process.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(path.Join(dir, "lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -44,7 +44,9 @@ type server struct {
 	ln       net.Listener
 }
 
-func newServer(t *testing.T) *server {
+// newServer serves requests with fs storage, optionally wrapped, e.g. to
+// emulate storage features.
+func newServer(t *testing.T, wraps ...func(storage.StorageInteractor) storage.StorageInteractor) *server {
 	t.Helper()
 
 	root := filepath.Join(t.TempDir(), "storage") + string(os.PathSeparator)
@@ -62,6 +64,9 @@ func newServer(t *testing.T) *server {
 		StorageBucket: "test-bucket",
 	}, "yezzey")
 	require.NoError(t, err)
+	for _, wrap := range wraps {
+		st = wrap(st)
+	}
 
 	ln, err := net.Listen("unix", sockPath)
 	require.NoError(t, err)
@@ -110,6 +115,18 @@ func readMessage(t *testing.T, conn net.Conn) wireMessage {
 		m := &message.ObjectInfoMessage{}
 		m.Decode(body)
 		return m
+	case message.MessageTypeCopyData:
+		m := &message.CopyDataMessage{}
+		m.Decode(body)
+		return m
+	case message.MessageTypePutComplete:
+		m := &message.PutCompleteMessage{}
+		m.Decode(body)
+		return m
+	case message.MessageTypeServerInfo:
+		m := &message.ServerInfoMessage{}
+		m.Decode(body)
+		return m
 	case message.MessageTypeError:
 		m := &message.ErrorMessage{}
 		m.Decode(body)
@@ -1,12 +1,22 @@
 package xproto
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/yezzey-gp/yproxy/config"
+	"github.com/yezzey-gp/yproxy/pkg"
+	"github.com/yezzey-gp/yproxy/pkg/checksum"
+	"github.com/yezzey-gp/yproxy/pkg/codec"
 	"github.com/yezzey-gp/yproxy/pkg/message"
+	"github.com/yezzey-gp/yproxy/pkg/settings"
+	"github.com/yezzey-gp/yproxy/pkg/storage"
 )
 
 func copyData(b []byte) *message.CopyDataMessage {
@@ -123,3 +133,304 @@ func TestUnsupportedMessageType(t *testing.T) {
 		}},
 	}})
 }
+
+// noPatchStorage emulates storage unable to patch objects in place.
+type noPatchStorage struct {
+	storage.StorageInteractor
+}
+
+func (*noPatchStorage) PatchFile(string, io.ReadSeeker, int64) error {
+	return storage.ErrPatchNotSupported
+}
+
+func putAndPatch(t *testing.T, s *server, payload []byte, offset uint64, patch []byte) {
+	t.Helper()
+
+	protoTestRunner(t, s, []MessageGroup{
+		{
+			Name: "put",
+			Request: []wireMessage{
+				message.NewPutMessage("patch.bin", false),
+				copyData(payload),
+				message.NewCopyDoneMessage(),
+			},
+			Response: []wireMessage{message.NewReadyForQueryMessage()},
+		},
+		{
+			Name: "patch",
+			Request: []wireMessage{
+				message.NewPatchMessage("patch.bin", offset, false),
+				copyData(patch[:2]),
+				copyData(patch[2:]),
+				message.NewCopyDoneMessage(),
+			},
+			Response: []wireMessage{message.NewReadyForQueryMessage()},
+		},
+	})
+}
+
+func catAll(t *testing.T, s *server, name string, n int) []byte {
+	t.Helper()
+
+	conn := s.dial(t)
+	defer func() { _ = conn.Close() }()
+
+	_, err := conn.Write(message.NewCatMessage(name, false, 0).Encode())
+	require.NoError(t, err)
+	return readRaw(t, conn, n)
+}
+
+func TestPatch(t *testing.T) {
+	for name, s := range map[string]*server{
+		"in_place": newServer(t),
+		"rewrite": newServer(t, func(st storage.StorageInteractor) storage.StorageInteractor {
+			return &noPatchStorage{st}
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			putAndPatch(t, s, []byte("the quick brown fox jumps over the lazy dog"), 10, []byte("BROWN"))
+			assert.Equal(t, []byte("the quick BROWN fox jumps over the lazy dog"), catAll(t, s, "patch.bin", 43))
+
+			/* patch at end extends object */
+			putAndPatch(t, s, []byte("abc"), 3, []byte("defgh"))
+			assert.Equal(t, []byte("abcdefgh"), catAll(t, s, "patch.bin", 8))
+		})
+	}
+}
+
+func TestPatchBeyondEnd(t *testing.T) {
+	for name, s := range map[string]*server{
+		"in_place": newServer(t),
+		"rewrite": newServer(t, func(st storage.StorageInteractor) storage.StorageInteractor {
+			return &noPatchStorage{st}
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			conn := s.dial(t)
+			defer func() { _ = conn.Close() }()
+
+			for _, req := range []wireMessage{
+				message.NewPutMessage("short.bin", false),
+				copyData([]byte("abc")),
+				message.NewCopyDoneMessage(),
+			} {
+				_, err := conn.Write(req.Encode())
+				require.NoError(t, err)
+			}
+			assert.Equal(t, message.NewReadyForQueryMessage(), readMessage(t, conn))
+
+			conn = s.dial(t)
+			defer func() { _ = conn.Close() }()
+			for _, req := range []wireMessage{
+				message.NewPatchMessage("short.bin", 10, false),
+				copyData([]byte("xyz")),
+				message.NewCopyDoneMessage(),
+			} {
+				_, err := conn.Write(req.Encode())
+				require.NoError(t, err)
+			}
+			msg, ok := readMessage(t, conn).(*message.ErrorMessage)
+			require.True(t, ok)
+			assert.Contains(t, msg.Error, "beyond end")
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := newServer(t)
+	conn := s.dial(t)
+	defer func() { _ = conn.Close() }()
+
+	send := func(reqs ...wireMessage) {
+		t.Helper()
+		for _, req := range reqs {
+			_, err := conn.Write(req.Encode())
+			require.NoError(t, err)
+		}
+	}
+
+	send(message.NewSessionMessage())
+	assert.Equal(t, message.NewReadyForQueryMessage(), readMessage(t, conn))
+
+	for _, name := range []string{"a.bin", "b.bin"} {
+		send(message.NewPutMessage(name, false), copyData([]byte("content of "+name)), message.NewCopyDoneMessage())
+		assert.Equal(t, message.NewReadyForQueryMessage(), readMessage(t, conn))
+	}
+
+	/* object content is framed in session */
+	send(message.NewCatMessage("b.bin", false, 3))
+	var content []byte
+	for {
+		msg := readMessage(t, conn)
+		if _, ok := msg.(*message.ReadyForQueryMessage); ok {
+			break
+		}
+		data, ok := msg.(*message.CopyDataMessage)
+		require.True(t, ok, "unexpected message %T", msg)
+		content = append(content, data.Data...)
+	}
+	assert.Equal(t, []byte("tent of b.bin"), content)
+
+	send(message.NewListMessage("/"))
+	meta, ok := readMessage(t, conn).(*message.ObjectInfoMessage)
+	require.True(t, ok)
+	assert.Len(t, meta.Content, 2)
+	assert.Equal(t, message.NewReadyForQueryMessage(), readMessage(t, conn))
+
+	send(message.NewTerminateMessage())
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestSessionRejectsGool(t *testing.T) {
+	s := newServer(t)
+	conn := s.dial(t)
+	defer func() { _ = conn.Close() }()
+
+	for _, req := range []wireMessage{message.NewSessionMessage(), message.NewGoolMessage("x")} {
+		_, err := conn.Write(req.Encode())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, message.NewReadyForQueryMessage(), readMessage(t, conn))
+	msg, ok := readMessage(t, conn).(*message.ErrorMessage)
+	require.True(t, ok)
+	assert.Contains(t, msg.Error, "not allowed in session")
+}
+
+func TestStartup(t *testing.T) {
+	s := newServer(t)
+
+	caps := message.CapabilityServerSideCopy | message.CapabilityPatch | message.CapabilitySession | message.CapabilityCompression
+	protoTestRunner(t, s, []MessageGroup{
+		{
+			Name:     "server_info",
+			Request:  []wireMessage{message.NewStartupMessage(message.ProtocolVersion+1, message.CapabilityPatch)},
+			Response: []wireMessage{message.NewServerInfoMessage(message.ProtocolVersion, caps, pkg.YproxyVersionRevision)},
+		},
+		{
+			Name: "then_session",
+			Request: []wireMessage{
+				message.NewStartupMessage(message.ProtocolVersion, message.CapabilitySession),
+				message.NewSessionMessage(),
+				message.NewListMessage("/"),
+				message.NewTerminateMessage(),
+			},
+			Response: []wireMessage{
+				message.NewServerInfoMessage(message.ProtocolVersion, caps, pkg.YproxyVersionRevision),
+				message.NewReadyForQueryMessage(),
+				message.NewReadyForQueryMessage(),
+			},
+		},
+	})
+
+	for name, req := range map[string]*message.StartupMessage{
+		"missing_capability": message.NewStartupMessage(message.ProtocolVersion, message.CapabilityKEK),
+		"unknown_capability": message.NewStartupMessage(message.ProtocolVersion, 1<<40),
+		"old_version":        message.NewStartupMessage(0, 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			conn := s.dial(t)
+			defer func() { _ = conn.Close() }()
+
+			_, err := conn.Write(req.Encode())
+			require.NoError(t, err)
+			msg, ok := readMessage(t, conn).(*message.ErrorMessage)
+			require.True(t, ok)
+			assert.Equal(t, "failed to negotiate protocol", msg.Message)
+		})
+	}
+}
+
+func TestPutCompressed(t *testing.T) {
+	s := newServer(t)
+
+	payload := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 1000))
+	compression := []settings.StorageSettings{{Name: message.CompressionSetting, Value: "zstd"}}
+
+	protoTestRunner(t, s, []MessageGroup{
+		{
+			Name: "put",
+			Request: []wireMessage{
+				message.NewPutMessageV2("compressed.bin", false, compression),
+				copyData(payload),
+				message.NewCopyDoneMessage(),
+			},
+			Response: []wireMessage{message.NewReadyForQueryMessage()},
+		},
+		{
+			Name: "patch_rejected",
+			Request: []wireMessage{
+				message.NewPatchMessage("compressed.bin", 0, false),
+				copyData([]byte("x")),
+				message.NewCopyDoneMessage(),
+			},
+			Response: []wireMessage{message.NewErrorMessage(fmt.Errorf("patching of objects compressed with zstd is not supported"), "failed to patch object")},
+		},
+	})
+
+	stored, err := s.st.CatFileFromStorage("compressed.bin", 0, nil)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(stored)
+	require.NoError(t, err)
+	_ = stored.Close()
+	c, ok := codec.ParseHeader(raw)
+	require.True(t, ok)
+	assert.Equal(t, codec.Zstd, c)
+	assert.Less(t, len(raw), len(payload)/10)
+
+	assert.Equal(t, payload, catAll(t, s, "compressed.bin", len(payload)))
+
+	conn := s.dial(t)
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write(message.NewCatMessage("compressed.bin", false, 100).Encode())
+	require.NoError(t, err)
+	assert.Equal(t, payload[100:], readRaw(t, conn, len(payload)-100))
+}
+
+func TestPutUnknownCompression(t *testing.T) {
+	s := newServer(t)
+
+	conn := s.dial(t)
+	defer func() { _ = conn.Close() }()
+	_, err := conn.Write(message.NewPutMessageV2("x.bin", false, []settings.StorageSettings{{Name: message.CompressionSetting, Value: "brotli"}}).Encode())
+	require.NoError(t, err)
+	msg, ok := readMessage(t, conn).(*message.ErrorMessage)
+	require.True(t, ok)
+	assert.Equal(t, "failed to select compression", msg.Message)
+}
+
+func TestPutChecksum(t *testing.T) {
+	prev := config.InstanceConfig().ChecksumCnf
+	t.Cleanup(func() { config.InstanceConfig().ChecksumCnf = prev })
+	config.InstanceConfig().ChecksumCnf = config.Checksum{Algorithm: config.ChecksumSHA256}
+
+	s := newServer(t)
+
+	payload := []byte("the quick brown fox jumps over the lazy dog")
+	digest := sha256.Sum256(payload)
+
+	protoTestRunner(t, s, []MessageGroup{{
+		Name: "put",
+		Request: []wireMessage{
+			message.NewPutMessageV3("summed.bin", false, nil),
+			copyData(payload),
+			message.NewCopyDoneMessage(),
+		},
+		Response: []wireMessage{
+			&message.PutCompleteMessage{KeyVersion: 1, ChecksumAlgorithm: byte(checksum.SHA256), Checksum: digest[:]},
+			message.NewReadyForQueryMessage(),
+		},
+	}})
+
+	stored, err := checksum.Get(s.st, "summed.bin", nil)
+	require.NoError(t, err)
+	assert.Equal(t, digest[:], stored.Sum.Value)
+	assert.False(t, stored.Encrypted)
+	assert.Equal(t, payload, catAll(t, s, "summed.bin", len(payload)))
+
+	/* patched content no longer matches stored checksum */
+	putAndPatch(t, s, payload, 10, []byte("BROWN"))
+	_, err = checksum.Get(s.st, "patch.bin", nil)
+	assert.Error(t, err)
+	assert.Equal(t, []byte("the quick BROWN fox jumps over the lazy dog"), catAll(t, s, "patch.bin", len(payload)))
+}